@@ -0,0 +1,103 @@
+// db/archive.go
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// DefaultArchiveRetention は放送済み番組をアーカイブに保持する期間のデフォルト値
+const DefaultArchiveRetention = 30 * 24 * time.Hour
+
+// programTableColumns は programs と program_archive に共通するカラム
+// 旧バージョンから移行したDBではカラムの並びが異なるため、コピー時は明示的に列挙する
+const programTableColumns = `id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch,
+	seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt, isFree, source,
+	title, titleFlags, episodeNumber, subtitle, extended, people, video, audios, attributes`
+
+// programsWithArchiveSQL は programs とアーカイブをまとめて検索するための副問い合わせ
+// programs という別名を付けるので、programs テーブルを参照する条件をそのまま使える
+const programsWithArchiveSQL = `(SELECT ` + programTableColumns + ` FROM programs
+	UNION ALL SELECT ` + programTableColumns + ` FROM program_archive) AS programs`
+
+// archiveEndedPrograms は終了時刻が before より前の番組をアーカイブに移す
+func archiveEndedPrograms(tx *sql.Tx, before int64) (int64, error) {
+	now := time.Now().UnixMilli()
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO program_archive (`+programTableColumns+`, archivedAt)
+		SELECT `+programTableColumns+`, ? FROM programs WHERE startAt + duration < ?`, now, before); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(`DELETE FROM programs WHERE startAt + duration < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ArchiveEndedPrograms は終了時刻が before より前の番組を programs からアーカイブに移す
+func ArchiveEndedPrograms(db *sql.DB, before int64) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	archived, err := archiveEndedPrograms(tx, before)
+	if err != nil {
+		tx.Rollback()
+		models.Log.Error("ArchiveEndedPrograms: Failed to archive programs: %v", err)
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return archived, nil
+}
+
+// PurgeArchivedPrograms は終了時刻が before より前の番組をアーカイブから削除する
+func PurgeArchivedPrograms(db *sql.DB, before int64) (int64, error) {
+	result, err := db.Exec(`DELETE FROM program_archive WHERE startAt + duration < ?`, before)
+	if err != nil {
+		models.Log.Error("PurgeArchivedPrograms: Failed to purge archive: %v", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// removeProgram は Mirakurun から削除された番組を programs から取り除く
+// 放送済みの番組はアーカイブに移し、放送前に取り消された番組はそのまま削除する
+func removeProgram(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := removeProgramTx(tx, id, "", time.Now().UnixMilli()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeProgramTx はトランザクション内で removeProgram と同じ処理を行う
+// source を指定した場合は、その取得元（または取得元が不明）の番組のみを対象にし、
+// 別の取得元からも受信している番組が消えないようにする
+func removeProgramTx(tx *sql.Tx, id int64, source string, now int64) error {
+	cond := `id = ?`
+	args := []interface{}{id}
+	if source != "" {
+		cond += ` AND (source = ? OR source IS NULL)`
+		args = append(args, source)
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO program_archive (`+programTableColumns+`, archivedAt)
+		SELECT `+programTableColumns+`, ? FROM programs WHERE `+cond+` AND startAt + duration <= ?`,
+		append(append([]interface{}{now}, args...), now)...); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM programs WHERE `+cond, args...)
+	return err
+}
+
+// getArchivedProgramByID はアーカイブから番組を取得する
+func getArchivedProgramByID(db *sql.DB, id int64) (*models.Program, error) {
+	return scanProgram(db.QueryRow(`SELECT `+programColumns+` FROM program_archive WHERE id = ?`, id))
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestProgramArchive(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	insert := func(id int64, startAt time.Time) {
+		if _, err := db.Exec(`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch)
+			VALUES (?, 101, 4, ?, 1800000, 'news', 'desc', 'news', 'desc')`, id, startAt.UnixMilli()); err != nil {
+			t.Fatalf("Failed to insert program: %v", err)
+		}
+	}
+	insert(1, now.Add(-40*24*time.Hour)) // 保持期間を過ぎた番組
+	insert(2, now.Add(-2*time.Hour))     // 放送済み
+	insert(3, now.Add(-10*time.Minute))  // 放送中
+	insert(4, now.Add(time.Hour))        // 放送前
+
+	archived, err := ArchiveEndedPrograms(db, now.UnixMilli())
+	if err != nil || archived != 2 {
+		t.Fatalf("Expected 2 archived programs, got %d (%v)", archived, err)
+	}
+	purged, err := PurgeArchivedPrograms(db, now.Add(-DefaultArchiveRetention).UnixMilli())
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected 1 purged program, got %d (%v)", purged, err)
+	}
+
+	// 通常の検索には放送済みの番組は含まれない
+	programs, _ := SearchPrograms(db, "news", 0, 0, 0, 0)
+	if len(programs) != 2 {
+		t.Errorf("Expected 2 current programs, got %d", len(programs))
+	}
+	programs, err = SearchProgramsWithOptions(db, SearchOptions{Query: "news", IncludePast: true})
+	if err != nil || len(programs) != 3 || programs[0].ID != 2 {
+		t.Errorf("Expected programs 2, 3 and 4 with includePast, got %+v (%v)", programs, err)
+	}
+
+	// アーカイブの番組も ID で取得できる
+	if p, err := GetProgramByID(db, 2); err != nil || p.Name != "news" {
+		t.Errorf("Expected archived program 2, got %+v (%v)", p, err)
+	}
+	if _, err := GetProgramByID(db, 1); err == nil {
+		t.Errorf("Expected purged program 1 to be gone")
+	}
+
+	// Mirakurun から削除された番組は、放送済みならアーカイブに残る
+	if _, err := db.Exec(`UPDATE programs SET duration = 60000 WHERE id = 3`); err != nil {
+		t.Fatalf("Failed to update program: %v", err)
+	}
+	if err := removeProgram(db, 3); err != nil {
+		t.Fatalf("removeProgram failed: %v", err)
+	}
+	if err := removeProgram(db, 4); err != nil {
+		t.Fatalf("removeProgram failed: %v", err)
+	}
+	if _, err := GetProgramByID(db, 3); err != nil {
+		t.Errorf("Expected ended program 3 to be archived on remove: %v", err)
+	}
+	if _, err := GetProgramByID(db, 4); err == nil {
+		t.Errorf("Expected cancelled program 4 to be deleted")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fuba/iepg-server/events"
 	"github.com/fuba/iepg-server/models"
 	"github.com/google/uuid"
 )
@@ -42,11 +43,17 @@ func CreateKeywordRule(db *sql.DB, rule *models.KeywordRule) error {
 	genresJSON, _ := json.Marshal(rule.Genres)
 	serviceIDsJSON, _ := json.Marshal(rule.ServiceIDs)
 	excludeWordsJSON, _ := json.Marshal(rule.ExcludeWords)
+	flagsJSON, excludeFlagsJSON := titleFilterJSON(rule.TitleFilter)
+	attributesJSON, _ := json.Marshal(rule.Attributes)
+	excludeAttributesJSON, _ := json.Marshal(rule.ExcludeAttributes)
 
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO keyword_rules (ruleId, keywords, genres, serviceIds, excludeWords)
-		VALUES (?, ?, ?, ?, ?)
-	`, rule.RuleID, string(keywordsJSON), string(genresJSON), string(serviceIDsJSON), string(excludeWordsJSON))
+		INSERT OR REPLACE INTO keyword_rules (ruleId, keywords, genres, serviceIds, excludeWords, groupId,
+			flags, excludeFlags, minEpisode, attributes, excludeAttributes, schedule)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.RuleID, string(keywordsJSON), string(genresJSON), string(serviceIDsJSON), string(excludeWordsJSON),
+		rule.GroupID, flagsJSON, excludeFlagsJSON, rule.MinEpisode, string(attributesJSON), string(excludeAttributesJSON),
+		scheduleFilterJSON(rule.ScheduleFilter))
 	
 	if err != nil {
 		models.Log.Error("CreateKeywordRule: Failed to create keyword rule: %v", err)
@@ -59,10 +66,12 @@ func CreateKeywordRule(db *sql.DB, rule *models.KeywordRule) error {
 
 // CreateSeriesRule creates a series rule for an auto reservation rule
 func CreateSeriesRule(db *sql.DB, rule *models.SeriesRule) error {
+	flagsJSON, excludeFlagsJSON := titleFilterJSON(rule.TitleFilter)
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO series_rules (ruleId, seriesId, programName, serviceId)
-		VALUES (?, ?, ?, ?)
-	`, rule.RuleID, rule.SeriesID, rule.ProgramName, rule.ServiceID)
+		INSERT OR REPLACE INTO series_rules (ruleId, seriesId, programName, serviceId, flags, excludeFlags, minEpisode, schedule)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.RuleID, rule.SeriesID, rule.ProgramName, rule.ServiceID, flagsJSON, excludeFlagsJSON, rule.MinEpisode,
+		scheduleFilterJSON(rule.ScheduleFilter))
 	
 	if err != nil {
 		models.Log.Error("CreateSeriesRule: Failed to create series rule: %v", err)
@@ -73,6 +82,25 @@ func CreateSeriesRule(db *sql.DB, rule *models.SeriesRule) error {
 	return nil
 }
 
+// CreatePersonRule creates a person rule for an auto reservation rule
+func CreatePersonRule(db *sql.DB, rule *models.PersonRule) error {
+	serviceIDsJSON, _ := json.Marshal(rule.ServiceIDs)
+	flagsJSON, excludeFlagsJSON := titleFilterJSON(rule.TitleFilter)
+
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO person_rules (ruleId, person, role, serviceIds, groupId, flags, excludeFlags, minEpisode, schedule)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.RuleID, models.NormalizePersonName(rule.Person), rule.Role, string(serviceIDsJSON), rule.GroupID,
+		flagsJSON, excludeFlagsJSON, rule.MinEpisode, scheduleFilterJSON(rule.ScheduleFilter))
+	if err != nil {
+		models.Log.Error("CreatePersonRule: Failed to create person rule: %v", err)
+		return err
+	}
+
+	models.Log.Info("CreatePersonRule: Created person rule for rule %s", rule.RuleID)
+	return nil
+}
+
 // GetAutoReservationRules retrieves all auto reservation rules
 func GetAutoReservationRules(db *sql.DB) ([]models.AutoReservationRuleWithDetails, error) {
 	rows, err := db.Query(`
@@ -119,6 +147,13 @@ func GetAutoReservationRules(db *sql.DB) ([]models.AutoReservationRuleWithDetail
 			} else {
 				rule.SeriesRule = seriesRule
 			}
+		case "person":
+			personRule, err := getPersonRule(db, rule.ID)
+			if err != nil {
+				models.Log.Error("GetAutoReservationRules: Failed to load person rule for %s: %v", rule.ID, err)
+			} else {
+				rule.PersonRule = personRule
+			}
 		}
 
 		rules = append(rules, rule)
@@ -169,6 +204,13 @@ func GetAutoReservationRuleByID(db *sql.DB, id string) (*models.AutoReservationR
 		} else {
 			rule.SeriesRule = seriesRule
 		}
+	case "person":
+		personRule, err := getPersonRule(db, rule.ID)
+		if err != nil {
+			models.Log.Error("GetAutoReservationRuleByID: Failed to load person rule: %v", err)
+		} else {
+			rule.PersonRule = personRule
+		}
 	}
 
 	return &rule, nil
@@ -199,7 +241,8 @@ func UpdateAutoReservationRule(db *sql.DB, rule *models.AutoReservationRule) err
 	return nil
 }
 
-// DeleteAutoReservationRule deletes an auto reservation rule and its related data
+// DeleteAutoReservationRule deletes an auto reservation rule and its related data.
+// It returns sql.ErrNoRows if the rule does not exist
 func DeleteAutoReservationRule(db *sql.DB, id string) error {
 	result, err := db.Exec("DELETE FROM auto_reservation_rules WHERE id = ?", id)
 	if err != nil {
@@ -209,7 +252,7 @@ func DeleteAutoReservationRule(db *sql.DB, id string) error {
 	
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		return fmt.Errorf("rule not found: %s", id)
+		return sql.ErrNoRows
 	}
 
 	models.Log.Info("DeleteAutoReservationRule: Deleted rule %s", id)
@@ -263,6 +306,13 @@ func GetEnabledAutoReservationRules(db *sql.DB) ([]models.AutoReservationRuleWit
 			} else {
 				rule.SeriesRule = seriesRule
 			}
+		case "person":
+			personRule, err := getPersonRule(db, rule.ID)
+			if err != nil {
+				models.Log.Error("GetEnabledAutoReservationRules: Failed to load person rule for %s: %v", rule.ID, err)
+			} else {
+				rule.PersonRule = personRule
+			}
 		}
 
 		rules = append(rules, rule)
@@ -275,17 +325,23 @@ func GetEnabledAutoReservationRules(db *sql.DB) ([]models.AutoReservationRuleWit
 // getKeywordRule is a helper function to retrieve keyword rule details
 func getKeywordRule(db *sql.DB, ruleID string) (*models.KeywordRule, error) {
 	var keywordsJSON, genresJSON, serviceIDsJSON, excludeWordsJSON string
+	var groupID, flagsJSON, excludeFlagsJSON, attributesJSON, excludeAttributesJSON, scheduleJSON sql.NullString
+	var minEpisode sql.NullInt64
 	
 	err := db.QueryRow(`
-		SELECT keywords, genres, serviceIds, excludeWords
+		SELECT keywords, genres, serviceIds, excludeWords, groupId, flags, excludeFlags, minEpisode,
+			attributes, excludeAttributes, schedule
 		FROM keyword_rules WHERE ruleId = ?
-	`, ruleID).Scan(&keywordsJSON, &genresJSON, &serviceIDsJSON, &excludeWordsJSON)
+	`, ruleID).Scan(&keywordsJSON, &genresJSON, &serviceIDsJSON, &excludeWordsJSON, &groupID,
+		&flagsJSON, &excludeFlagsJSON, &minEpisode, &attributesJSON, &excludeAttributesJSON, &scheduleJSON)
 	
 	if err != nil {
 		return nil, err
 	}
 	
-	rule := &models.KeywordRule{RuleID: ruleID}
+	rule := &models.KeywordRule{RuleID: ruleID, GroupID: groupID.String}
+	rule.TitleFilter = parseTitleFilter(flagsJSON, excludeFlagsJSON, minEpisode)
+	rule.ScheduleFilter = parseScheduleFilter(scheduleJSON)
 	
 	// Parse JSON strings back to slices
 	if keywordsJSON != "" {
@@ -300,6 +356,12 @@ func getKeywordRule(db *sql.DB, ruleID string) (*models.KeywordRule, error) {
 	if excludeWordsJSON != "" {
 		json.Unmarshal([]byte(excludeWordsJSON), &rule.ExcludeWords)
 	}
+	if attributesJSON.String != "" {
+		json.Unmarshal([]byte(attributesJSON.String), &rule.Attributes)
+	}
+	if excludeAttributesJSON.String != "" {
+		json.Unmarshal([]byte(excludeAttributesJSON.String), &rule.ExcludeAttributes)
+	}
 	
 	return rule, nil
 }
@@ -307,19 +369,83 @@ func getKeywordRule(db *sql.DB, ruleID string) (*models.KeywordRule, error) {
 // getSeriesRule is a helper function to retrieve series rule details
 func getSeriesRule(db *sql.DB, ruleID string) (*models.SeriesRule, error) {
 	rule := &models.SeriesRule{RuleID: ruleID}
+	var flagsJSON, excludeFlagsJSON, scheduleJSON sql.NullString
+	var minEpisode sql.NullInt64
 	
 	err := db.QueryRow(`
-		SELECT seriesId, programName, serviceId
+		SELECT seriesId, programName, serviceId, flags, excludeFlags, minEpisode, schedule
 		FROM series_rules WHERE ruleId = ?
-	`, ruleID).Scan(&rule.SeriesID, &rule.ProgramName, &rule.ServiceID)
+	`, ruleID).Scan(&rule.SeriesID, &rule.ProgramName, &rule.ServiceID, &flagsJSON, &excludeFlagsJSON, &minEpisode,
+		&scheduleJSON)
 	
 	if err != nil {
 		return nil, err
 	}
+	rule.TitleFilter = parseTitleFilter(flagsJSON, excludeFlagsJSON, minEpisode)
+	rule.ScheduleFilter = parseScheduleFilter(scheduleJSON)
 	
 	return rule, nil
 }
 
+// getPersonRule is a helper function to retrieve person rule details
+func getPersonRule(db *sql.DB, ruleID string) (*models.PersonRule, error) {
+	var role, serviceIDsJSON, groupID, flagsJSON, excludeFlagsJSON, scheduleJSON sql.NullString
+	var minEpisode sql.NullInt64
+	rule := &models.PersonRule{RuleID: ruleID}
+
+	err := db.QueryRow(`
+		SELECT person, role, serviceIds, groupId, flags, excludeFlags, minEpisode, schedule
+		FROM person_rules WHERE ruleId = ?
+	`, ruleID).Scan(&rule.Person, &role, &serviceIDsJSON, &groupID, &flagsJSON, &excludeFlagsJSON, &minEpisode,
+		&scheduleJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Role = role.String
+	rule.GroupID = groupID.String
+	rule.TitleFilter = parseTitleFilter(flagsJSON, excludeFlagsJSON, minEpisode)
+	rule.ScheduleFilter = parseScheduleFilter(scheduleJSON)
+	if serviceIDsJSON.String != "" {
+		json.Unmarshal([]byte(serviceIDsJSON.String), &rule.ServiceIDs)
+	}
+	return rule, nil
+}
+
+// titleFilterJSON converts the flag lists of a title filter to JSON strings for storage
+func titleFilterJSON(f models.TitleFilter) (flags, excludeFlags string) {
+	flagsJSON, _ := json.Marshal(f.Flags)
+	excludeFlagsJSON, _ := json.Marshal(f.ExcludeFlags)
+	return string(flagsJSON), string(excludeFlagsJSON)
+}
+
+// parseTitleFilter restores a title filter stored by titleFilterJSON
+func parseTitleFilter(flagsJSON, excludeFlagsJSON sql.NullString, minEpisode sql.NullInt64) models.TitleFilter {
+	f := models.TitleFilter{MinEpisode: int(minEpisode.Int64)}
+	if flagsJSON.String != "" {
+		json.Unmarshal([]byte(flagsJSON.String), &f.Flags)
+	}
+	if excludeFlagsJSON.String != "" {
+		json.Unmarshal([]byte(excludeFlagsJSON.String), &f.ExcludeFlags)
+	}
+	return f
+}
+
+// scheduleFilterJSON converts a schedule filter to JSON for storage
+func scheduleFilterJSON(f models.ScheduleFilter) string {
+	scheduleJSON, _ := json.Marshal(f)
+	return string(scheduleJSON)
+}
+
+// parseScheduleFilter restores a schedule filter stored by scheduleFilterJSON
+func parseScheduleFilter(scheduleJSON sql.NullString) models.ScheduleFilter {
+	var f models.ScheduleFilter
+	if scheduleJSON.String != "" {
+		json.Unmarshal([]byte(scheduleJSON.String), &f)
+	}
+	return f
+}
+
 // CreateAutoReservationLog creates a log entry for auto reservation processing
 func CreateAutoReservationLog(db *sql.DB, log *models.AutoReservationLog) error {
 	if log.ID == "" {
@@ -337,6 +463,8 @@ func CreateAutoReservationLog(db *sql.DB, log *models.AutoReservationLog) error
 		return err
 	}
 
+	events.Publish(events.ResourceAutoReservationLog, "create", log)
+
 	return nil
 }
 
@@ -390,4 +518,18 @@ func GetAutoReservationLogs(db *sql.DB, ruleID string, limit int) ([]models.Auto
 	}
 
 	return logs, nil
-}
\ No newline at end of file
+}
+
+// GetLastAutoReservationLogTime returns the time of the latest log entry for the rule (zero if none)
+func GetLastAutoReservationLogTime(db *sql.DB, ruleID string) (time.Time, error) {
+	var last sql.NullInt64
+	err := db.QueryRow("SELECT MAX(createdAt) FROM auto_reservation_logs WHERE ruleId = ?", ruleID).Scan(&last)
+	if err != nil {
+		models.Log.Error("GetLastAutoReservationLogTime: Query failed: %v", err)
+		return time.Time{}, err
+	}
+	if !last.Valid {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(last.Int64), nil
+}
@@ -4,6 +4,7 @@ package db
 import (
 	"database/sql"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -521,4 +522,100 @@ func TestGetAutoReservationLogs(t *testing.T) {
 	if len(limitedLogs) != 1 {
 		t.Errorf("Expected 1 limited log, got %d", len(limitedLogs))
 	}
-}
\ No newline at end of file
+}
+
+func TestRuleTitleFilter(t *testing.T) {
+	db := setupAutoReservationTestDB(t)
+
+	rule := &models.AutoReservationRule{Type: "series", Name: "Series without reruns", Enabled: true}
+	if err := CreateAutoReservationRule(db, rule); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	seriesRule := &models.SeriesRule{
+		RuleID:      rule.ID,
+		SeriesID:    "12345",
+		TitleFilter: models.TitleFilter{ExcludeFlags: []string{"再"}, MinEpisode: 1},
+		ScheduleFilter: models.ScheduleFilter{StartTime: "24:00", EndTime: "29:00", Weekdays: []time.Weekday{time.Monday},
+			MinDuration: 20, ChannelType: "GR", ValidUntil: 1767193200000},
+	}
+	if err := CreateSeriesRule(db, seriesRule); err != nil {
+		t.Fatalf("Failed to create series rule: %v", err)
+	}
+
+	keyword := &models.AutoReservationRule{Type: "keyword", Name: "New anime", Enabled: true}
+	if err := CreateAutoReservationRule(db, keyword); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	keywordRule := &models.KeywordRule{
+		RuleID:      keyword.ID,
+		Keywords:    []string{"アニメ"},
+		TitleFilter: models.TitleFilter{Flags: []string{"新"}},
+		AttributeFilter: models.AttributeFilter{
+			Attributes:        []string{"5.1", "bilingual"},
+			ExcludeAttributes: []string{"sd"},
+		},
+	}
+	if err := CreateKeywordRule(db, keywordRule); err != nil {
+		t.Fatalf("Failed to create keyword rule: %v", err)
+	}
+
+	got, err := GetAutoReservationRuleByID(db, rule.ID)
+	if err != nil || got.SeriesRule == nil {
+		t.Fatalf("Failed to get series rule: %v", err)
+	}
+	if len(got.SeriesRule.ExcludeFlags) != 1 || got.SeriesRule.ExcludeFlags[0] != "再" || got.SeriesRule.MinEpisode != 1 {
+		t.Errorf("Unexpected series title filter: %+v", got.SeriesRule.TitleFilter)
+	}
+	if !reflect.DeepEqual(got.SeriesRule.ScheduleFilter, seriesRule.ScheduleFilter) {
+		t.Errorf("Unexpected series schedule filter: %+v", got.SeriesRule.ScheduleFilter)
+	}
+
+	got, err = GetAutoReservationRuleByID(db, keyword.ID)
+	if err != nil || got.KeywordRule == nil {
+		t.Fatalf("Failed to get keyword rule: %v", err)
+	}
+	if len(got.KeywordRule.Flags) != 1 || got.KeywordRule.Flags[0] != "新" || got.KeywordRule.MinEpisode != 0 {
+		t.Errorf("Unexpected keyword title filter: %+v", got.KeywordRule.TitleFilter)
+	}
+	if !reflect.DeepEqual(got.KeywordRule.AttributeFilter, keywordRule.AttributeFilter) {
+		t.Errorf("Unexpected keyword attribute filter: %+v", got.KeywordRule.AttributeFilter)
+	}
+	if !reflect.DeepEqual(got.KeywordRule.ScheduleFilter, models.ScheduleFilter{}) {
+		t.Errorf("Expected no keyword schedule filter, got %+v", got.KeywordRule.ScheduleFilter)
+	}
+}
+
+func TestCreatePersonRule(t *testing.T) {
+	db := setupAutoReservationTestDB(t)
+
+	rule := &models.AutoReservationRule{Type: "person", Name: "Director", Enabled: true}
+	if err := CreateAutoReservationRule(db, rule); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	personRule := &models.PersonRule{
+		RuleID:      rule.ID,
+		Person:      "山田　太郎",
+		Role:        "監督",
+		ServiceIDs:  []int64{1024},
+		TitleFilter: models.TitleFilter{ExcludeFlags: []string{"再"}},
+	}
+	if err := CreatePersonRule(db, personRule); err != nil {
+		t.Fatalf("Failed to create person rule: %v", err)
+	}
+
+	// Saving again replaces the rule instead of adding another row
+	personRule.Role = "演出"
+	if err := CreatePersonRule(db, personRule); err != nil {
+		t.Fatalf("Failed to update person rule: %v", err)
+	}
+
+	rules, err := GetEnabledAutoReservationRules(db)
+	if err != nil || len(rules) != 1 || rules[0].PersonRule == nil {
+		t.Fatalf("Failed to get person rule: %v %+v", err, rules)
+	}
+	got := rules[0].PersonRule
+	if got.Person != "山田太郎" || got.Role != "演出" || len(got.ServiceIDs) != 1 || got.ServiceIDs[0] != 1024 ||
+		len(got.ExcludeFlags) != 1 || got.ExcludeFlags[0] != "再" {
+		t.Errorf("Unexpected person rule: %+v", got)
+	}
+}
@@ -0,0 +1,245 @@
+// db/channel_group.go
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrChannelGroupNotFound is returned when the requested channel group does not exist
+	ErrChannelGroupNotFound = errors.New("channel group not found")
+	// ErrChannelGroupProtected is returned when deleting the built-in favorites group
+	ErrChannelGroupProtected = errors.New("the favorites group cannot be deleted")
+)
+
+// CreateChannelGroup creates a new channel group with its services
+func CreateChannelGroup(db *sql.DB, group *models.ChannelGroup) error {
+	if group.ID == "" {
+		group.ID = uuid.New().String()
+	}
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO channel_groups (id, name, position, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?)`,
+		group.ID, group.Name, group.Position, group.CreatedAt.UnixMilli(), group.UpdatedAt.UnixMilli())
+	if err == nil {
+		err = saveChannelGroupServices(tx, group)
+	}
+	if err != nil {
+		tx.Rollback()
+		models.Log.Error("CreateChannelGroup: Failed to create group: %v", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	models.Log.Info("CreateChannelGroup: Created group %s (%s) with %d services", group.ID, group.Name, len(group.Services))
+	return nil
+}
+
+// UpdateChannelGroup replaces the name, position and services of a channel group
+func UpdateChannelGroup(db *sql.DB, group *models.ChannelGroup) error {
+	group.UpdatedAt = time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE channel_groups SET name = ?, position = ?, updatedAt = ? WHERE id = ?`,
+		group.Name, group.Position, group.UpdatedAt.UnixMilli(), group.ID)
+	if err != nil {
+		tx.Rollback()
+		models.Log.Error("UpdateChannelGroup: Update failed: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		tx.Rollback()
+		return ErrChannelGroupNotFound
+	}
+	if err := saveChannelGroupServices(tx, group); err != nil {
+		tx.Rollback()
+		models.Log.Error("UpdateChannelGroup: Failed to save services: %v", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	models.Log.Info("UpdateChannelGroup: Updated group %s", group.ID)
+	return nil
+}
+
+// saveChannelGroupServices replaces the services of a group, keeping the slice order as the position
+func saveChannelGroupServices(tx *sql.Tx, group *models.ChannelGroup) error {
+	if _, err := tx.Exec(`DELETE FROM channel_group_services WHERE groupId = ?`, group.ID); err != nil {
+		return err
+	}
+	for i, m := range group.Services {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO channel_group_services (groupId, networkId, serviceId, position)
+			VALUES (?, ?, ?, ?)`, group.ID, m.NetworkID, m.ServiceID, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteChannelGroup deletes a channel group. The favorites group cannot be deleted
+func DeleteChannelGroup(db *sql.DB, id string) error {
+	if id == models.FavoritesGroupID {
+		return ErrChannelGroupProtected
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM channel_group_services WHERE groupId = ?`, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	result, err := tx.Exec(`DELETE FROM channel_groups WHERE id = ?`, id)
+	if err != nil {
+		tx.Rollback()
+		models.Log.Error("DeleteChannelGroup: Delete failed: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		tx.Rollback()
+		return ErrChannelGroupNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	models.Log.Info("DeleteChannelGroup: Deleted group %s", id)
+	return nil
+}
+
+// GetChannelGroups retrieves all channel groups in display order
+func GetChannelGroups(db *sql.DB) ([]models.ChannelGroup, error) {
+	rows, err := db.Query(`SELECT id, name, position, createdAt, updatedAt FROM channel_groups
+		ORDER BY position, createdAt`)
+	if err != nil {
+		models.Log.Error("GetChannelGroups: Query failed: %v", err)
+		return nil, err
+	}
+
+	var groups []models.ChannelGroup
+	for rows.Next() {
+		var g models.ChannelGroup
+		var createdAt, updatedAt int64
+		if err := rows.Scan(&g.ID, &g.Name, &g.Position, &createdAt, &updatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		g.CreatedAt = time.UnixMilli(createdAt)
+		g.UpdatedAt = time.UnixMilli(updatedAt)
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// グループのサービスを読み込む（行の走査中に別クエリを発行しないよう分けて実行）
+	for i := range groups {
+		services, err := getChannelGroupServices(db, groups[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		groups[i].Services = services
+	}
+	return groups, nil
+}
+
+// GetChannelGroup retrieves a channel group by its ID, or by its name when no ID matches
+func GetChannelGroup(db *sql.DB, idOrName string) (*models.ChannelGroup, error) {
+	var g models.ChannelGroup
+	var createdAt, updatedAt int64
+	err := db.QueryRow(`SELECT id, name, position, createdAt, updatedAt FROM channel_groups
+		WHERE id = ? OR name = ? ORDER BY id = ? DESC, position LIMIT 1`, idOrName, idOrName, idOrName).Scan(
+		&g.ID, &g.Name, &g.Position, &createdAt, &updatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrChannelGroupNotFound
+		}
+		models.Log.Error("GetChannelGroup: Query failed: %v", err)
+		return nil, err
+	}
+	g.CreatedAt = time.UnixMilli(createdAt)
+	g.UpdatedAt = time.UnixMilli(updatedAt)
+
+	g.Services, err = getChannelGroupServices(db, g.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// getChannelGroupServices retrieves the services of a group in group order
+func getChannelGroupServices(db *sql.DB, groupID string) ([]models.ChannelGroupMember, error) {
+	rows, err := db.Query(`SELECT networkId, serviceId FROM channel_group_services
+		WHERE groupId = ? ORDER BY position`, groupID)
+	if err != nil {
+		models.Log.Error("getChannelGroupServices: Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []models.ChannelGroupMember{}
+	for rows.Next() {
+		var m models.ChannelGroupMember
+		if err := rows.Scan(&m.NetworkID, &m.ServiceID); err != nil {
+			return nil, err
+		}
+		services = append(services, m)
+	}
+	return services, rows.Err()
+}
+
+// AddChannelGroupService appends a service to the end of a group. It is a no-op when the service is already a member
+func AddChannelGroupService(db *sql.DB, groupID string, member models.ChannelGroupMember) error {
+	group, err := GetChannelGroup(db, groupID)
+	if err != nil {
+		return err
+	}
+	if group.ID != groupID {
+		return ErrChannelGroupNotFound
+	}
+	for _, m := range group.Services {
+		if m == member {
+			return nil
+		}
+	}
+	group.Services = append(group.Services, member)
+	return UpdateChannelGroup(db, group)
+}
+
+// RemoveChannelGroupService removes a service from a group
+func RemoveChannelGroupService(db *sql.DB, groupID string, member models.ChannelGroupMember) error {
+	result, err := db.Exec(`DELETE FROM channel_group_services WHERE groupId = ? AND networkId = ? AND serviceId = ?`,
+		groupID, member.NetworkID, member.ServiceID)
+	if err != nil {
+		models.Log.Error("RemoveChannelGroupService: Delete failed: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// channelGroupCondition は programs の行が指定グループに属することを表す条件（引数はグループID）
+const channelGroupCondition = `EXISTS (SELECT 1 FROM channel_group_services g
+	WHERE g.groupId = ? AND g.serviceId = programs.serviceId
+	AND (g.networkId = 0 OR programs.networkId IS NULL OR g.networkId = programs.networkId))`
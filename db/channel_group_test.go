@@ -0,0 +1,105 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestChannelGroupCRUD(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	// お気に入りグループは初期状態で存在し、削除できない
+	favorites, err := GetChannelGroup(db, models.FavoritesGroupID)
+	if err != nil || len(favorites.Services) != 0 {
+		t.Fatalf("Expected an empty favorites group, got %+v, %v", favorites, err)
+	}
+	if err := DeleteChannelGroup(db, models.FavoritesGroupID); err != ErrChannelGroupProtected {
+		t.Errorf("Expected ErrChannelGroupProtected, got %v", err)
+	}
+
+	group := &models.ChannelGroup{
+		Name:     "News",
+		Position: 1,
+		Services: []models.ChannelGroupMember{{NetworkID: 32736, ServiceID: 1024}, {ServiceID: 101}},
+	}
+	if err := CreateChannelGroup(db, group); err != nil {
+		t.Fatalf("CreateChannelGroup failed: %v", err)
+	}
+
+	// 名前でも取得でき、サービスは指定した順で返る
+	loaded, err := GetChannelGroup(db, "News")
+	if err != nil || loaded.ID != group.ID {
+		t.Fatalf("GetChannelGroup by name failed: %+v, %v", loaded, err)
+	}
+	if len(loaded.Services) != 2 || loaded.Services[0].ServiceID != 1024 || loaded.Services[1].ServiceID != 101 {
+		t.Errorf("Unexpected services: %+v", loaded.Services)
+	}
+	if !loaded.Contains(32736, 1024) || loaded.Contains(32737, 1024) || !loaded.Contains(4, 101) {
+		t.Errorf("Unexpected membership for %+v", loaded.Services)
+	}
+
+	// 並び替え
+	loaded.Services = []models.ChannelGroupMember{{ServiceID: 101}, {NetworkID: 32736, ServiceID: 1024}}
+	if err := UpdateChannelGroup(db, loaded); err != nil {
+		t.Fatalf("UpdateChannelGroup failed: %v", err)
+	}
+	if err := AddChannelGroupService(db, models.FavoritesGroupID, models.ChannelGroupMember{ServiceID: 101}); err != nil {
+		t.Fatalf("AddChannelGroupService failed: %v", err)
+	}
+
+	groups, err := GetChannelGroups(db)
+	if err != nil || len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %+v, %v", groups, err)
+	}
+	if groups[0].ID != models.FavoritesGroupID || len(groups[0].Services) != 1 {
+		t.Errorf("Expected favorites first with one service, got %+v", groups[0])
+	}
+	if groups[1].Services[0].ServiceID != 101 {
+		t.Errorf("Expected the new order to be kept, got %+v", groups[1].Services)
+	}
+
+	if err := RemoveChannelGroupService(db, models.FavoritesGroupID, models.ChannelGroupMember{ServiceID: 101}); err != nil {
+		t.Errorf("RemoveChannelGroupService failed: %v", err)
+	}
+	if err := DeleteChannelGroup(db, group.ID); err != nil {
+		t.Fatalf("DeleteChannelGroup failed: %v", err)
+	}
+	if _, err := GetChannelGroup(db, group.ID); err != ErrChannelGroupNotFound {
+		t.Errorf("Expected ErrChannelGroupNotFound after delete, got %v", err)
+	}
+}
+
+func TestSearchProgramsByGroup(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	for _, p := range []struct{ id, serviceID int64 }{{1, 101}, {2, 102}, {3, 103}} {
+		if _, err := db.Exec(`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch)
+			VALUES (?, ?, 4, ?, 60, 'news', '', 'news', '')`, p.id, p.serviceID, 1000+p.id); err != nil {
+			t.Fatalf("Failed to insert program: %v", err)
+		}
+	}
+	group := &models.ChannelGroup{
+		Name:     "Main",
+		Services: []models.ChannelGroupMember{{NetworkID: 4, ServiceID: 103}, {NetworkID: 4, ServiceID: 101}},
+	}
+	if err := CreateChannelGroup(db, group); err != nil {
+		t.Fatalf("CreateChannelGroup failed: %v", err)
+	}
+
+	programs, err := SearchProgramsWithOptions(db, SearchOptions{Query: "news", GroupID: group.ID})
+	if err != nil {
+		t.Fatalf("SearchProgramsWithOptions failed: %v", err)
+	}
+	if len(programs) != 2 || programs[0].ID != 1 || programs[1].ID != 3 {
+		t.Errorf("Expected programs 1 and 3, got %+v", programs)
+	}
+}
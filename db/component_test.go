@@ -0,0 +1,100 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestSearchProgramsByAttributes(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	// Mirakurun の /api/programs と同じ形式の番組
+	startAt := time.Now().Add(time.Hour).UnixMilli()
+	raw := `[
+		{"id": 1, "serviceId": 1024, "networkId": 32736, "startAt": ` + strconv.FormatInt(startAt, 10) + `, "duration": 7200000, "name": "映画[字][二]",
+			"video": {"type": "mpeg2", "resolution": "1080i", "streamContent": 1, "componentType": 179},
+			"audios": [{"componentType": 9, "isMain": true, "samplingRate": 48000, "langs": ["jpn"]}, {"componentType": 3, "langs": ["eng"]}]},
+		{"id": 2, "serviceId": 1024, "networkId": 32736, "startAt": ` + strconv.FormatInt(startAt+1, 10) + `, "duration": 7200000, "name": "映画",
+			"video": {"type": "h.265", "resolution": "2160p"},
+			"audios": [{"componentType": 3, "langs": ["jpn"]}]},
+		{"id": 3, "serviceId": 1024, "networkId": 32736, "startAt": ` + strconv.FormatInt(startAt+2, 10) + `, "duration": 1800000, "name": "ニュース",
+			"video": {"resolution": "480i"}, "audios": [{"componentType": 1}]}
+	]`
+	var programs []models.Program
+	if err := json.Unmarshal([]byte(raw), &programs); err != nil {
+		t.Fatalf("Failed to decode programs: %v", err)
+	}
+	if err := upsertPrograms(db, programs); err != nil {
+		t.Fatalf("Failed to save programs: %v", err)
+	}
+
+	p, err := GetProgramByID(db, 1)
+	if err != nil {
+		t.Fatalf("GetProgramByID failed: %v", err)
+	}
+	expected := []string{"hd", "stereo", "5.1", "bilingual", "caption"}
+	if !reflect.DeepEqual(p.Attributes, expected) || p.Video == nil || p.Video.Resolution != "1080i" || len(p.Audios) != 2 {
+		t.Errorf("Unexpected components: attributes=%v video=%+v audios=%+v", p.Attributes, p.Video, p.Audios)
+	}
+
+	tests := []struct {
+		name     string
+		opts     SearchOptions
+		expected []int64
+	}{
+		{"5.1 and bilingual", SearchOptions{Attributes: []string{"5.1", "bilingual"}}, []int64{1}},
+		{"4K", SearchOptions{Attributes: []string{"4k"}}, []int64{2}},
+		{"not SD", SearchOptions{ExcludeAttributes: []string{"sd"}}, []int64{1, 2}},
+		{"stereo without captions", SearchOptions{Query: "映画", Attributes: []string{"stereo"}, ExcludeAttributes: []string{"caption"}}, []int64{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := SearchProgramsWithOptions(db, tt.opts)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var ids []int64
+			for _, p := range results {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestBackfillAttributes(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	// 属性を求める前のバージョンで保存された番組
+	if _, err := db.Exec(`INSERT INTO programs (id, serviceId, startAt, duration, name) VALUES (1, 1024, 9999999999999, 60, 'ドラマ[字][解]')`); err != nil {
+		t.Fatalf("Failed to insert program: %v", err)
+	}
+	if err := backfillAttributes(db, "programs"); err != nil {
+		t.Fatalf("backfillAttributes failed: %v", err)
+	}
+
+	var attributes string
+	if err := db.QueryRow(`SELECT attributes FROM programs WHERE id = 1`).Scan(&attributes); err != nil {
+		t.Fatalf("Failed to read attributes: %v", err)
+	}
+	if attributes != "[commentary][caption]" {
+		t.Errorf("Unexpected backfilled attributes: %q", attributes)
+	}
+}
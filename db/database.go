@@ -2,13 +2,11 @@
 package db
 
 import (
-	"bufio"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -18,29 +16,81 @@ import (
 	"github.com/fuba/iepg-server/models"
 )
 
+// busyTimeoutMillis はDBがロックされている場合に待つ時間（ミリ秒）
+const busyTimeoutMillis = 5000
+
+// sqliteDSN は接続ごとに WAL モード・ビジータイムアウト・外部キー制約を設定する接続文字列を返す
+// PRAGMA はプール内の接続ごとに必要なため、DSN のパラメータで指定する（指定済みの場合はそのまま使う）
+func sqliteDSN(dataSourceName string) string {
+	params := []string{"_journal_mode=WAL", fmt.Sprintf("_busy_timeout=%d", busyTimeoutMillis), "_foreign_keys=1"}
+	for _, param := range params {
+		name := param[:strings.Index(param, "=")]
+		if strings.Contains(dataSourceName, name+"=") {
+			continue
+		}
+		if strings.Contains(dataSourceName, "?") {
+			dataSourceName += "&" + param
+		} else {
+			dataSourceName += "?" + param
+		}
+	}
+	return dataSourceName
+}
+
 // InitDB は、programsテーブルと除外チャンネルテーブルを作成する。
 func InitDB(dataSourceName string) (*sql.DB, error) {
 	models.Log.Debug("InitDB: Connecting to database: %s", dataSourceName)
-	db, err := sql.Open("sqlite3", dataSourceName)
+	db, err := sql.Open("sqlite3", sqliteDSN(dataSourceName))
 	if err != nil {
 		models.Log.Error("InitDB: Failed to open database: %v", err)
 		return nil, err
 	}
 
-	// Enable foreign key constraints
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
+	models.Log.Debug("InitDB: Creating tables")
+	// programsテーブルの作成
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS programs (
+			id            INTEGER PRIMARY KEY,
+			serviceId     INTEGER,
+			networkId     INTEGER,
+			startAt       INTEGER,
+			duration      INTEGER,
+			name          TEXT,
+			description   TEXT,
+			nameForSearch TEXT,
+			descForSearch TEXT,
+			seriesId      INTEGER,
+			seriesEpisode INTEGER,
+			seriesLastEpisode INTEGER,
+			seriesName    TEXT,
+			seriesRepeat  INTEGER,
+			seriesPattern INTEGER,
+			seriesExpiresAt INTEGER,
+			isFree        INTEGER,
+			source        TEXT,
+			title         TEXT,
+			titleFlags    TEXT,
+			episodeNumber INTEGER,
+			subtitle      TEXT,
+			extended      TEXT,
+			people        TEXT,
+			video         TEXT,
+			audios        TEXT,
+			attributes    TEXT
+		);
+	`)
 	if err != nil {
-		models.Log.Error("InitDB: Failed to enable foreign keys: %v", err)
+		models.Log.Error("InitDB: Failed to create programs table: %v", err)
 		db.Close()
 		return nil, err
 	}
 
-	models.Log.Debug("InitDB: Creating tables")
-	// programsテーブルの作成
+	// 放送済み番組のアーカイブテーブルの作成（カラムは programs と同じ）
 	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS programs (
+		CREATE TABLE IF NOT EXISTS program_archive (
 			id            INTEGER PRIMARY KEY,
 			serviceId     INTEGER,
+			networkId     INTEGER,
 			startAt       INTEGER,
 			duration      INTEGER,
 			name          TEXT,
@@ -53,11 +103,23 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 			seriesName    TEXT,
 			seriesRepeat  INTEGER,
 			seriesPattern INTEGER,
-			seriesExpiresAt INTEGER
+			seriesExpiresAt INTEGER,
+			isFree        INTEGER,
+			source        TEXT,
+			title         TEXT,
+			titleFlags    TEXT,
+			episodeNumber INTEGER,
+			subtitle      TEXT,
+			extended      TEXT,
+			people        TEXT,
+			video         TEXT,
+			audios        TEXT,
+			attributes    TEXT,
+			archivedAt    INTEGER NOT NULL
 		);
 	`)
 	if err != nil {
-		models.Log.Error("InitDB: Failed to create programs table: %v", err)
+		models.Log.Error("InitDB: Failed to create program_archive table: %v", err)
 		db.Close()
 		return nil, err
 	}
@@ -65,9 +127,12 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 	// 除外チャンネルテーブルの作成
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS excluded_services (
-			serviceId     INTEGER PRIMARY KEY,
+			networkId     INTEGER NOT NULL DEFAULT 0, -- 0 はすべてのネットワークの同じサービスIDを除外
+			serviceId     INTEGER NOT NULL,
 			name          TEXT,
-			createdAt     INTEGER
+			createdAt     INTEGER,
+			expiresAt     INTEGER, -- 除外の有効期限（NULL は無期限）
+			PRIMARY KEY (networkId, serviceId)
 		);
 	`)
 	if err != nil {
@@ -76,12 +141,99 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	// 除外ルールテーブルの作成（名前の正規表現、有料チャンネル、番組の無いチャンネルなど）
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS exclusion_rules (
+			id          TEXT PRIMARY KEY,
+			name        TEXT NOT NULL,
+			type        TEXT NOT NULL,
+			pattern     TEXT,
+			channelType TEXT,
+			days        INTEGER NOT NULL DEFAULT 0,
+			enabled     BOOLEAN NOT NULL DEFAULT 1,
+			expiresAt   INTEGER,
+			createdAt   INTEGER NOT NULL,
+			updatedAt   INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create exclusion_rules table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// サービス（放送局）テーブルの作成
+	// Mirakurun に接続できない間も局情報を参照できるよう保持する
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS services (
+			networkId          INTEGER NOT NULL,
+			serviceId          INTEGER NOT NULL,
+			id                 INTEGER,
+			name               TEXT,
+			type               INTEGER,
+			logoId             INTEGER,
+			hasLogoData        INTEGER,
+			remoteControlKeyId INTEGER,
+			channelType        TEXT,
+			channelNumber      TEXT,
+			channelName        TEXT,
+			channelTsmfRelTs   INTEGER,
+			firstSeenAt        INTEGER NOT NULL,
+			lastSeenAt         INTEGER NOT NULL,
+			source             TEXT,
+			PRIMARY KEY (networkId, serviceId)
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create services table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// 局ロゴのキャッシュテーブルの作成
+	// serviceId は Mirakurun のサービスID（networkId と serviceId を合成した id）
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS service_logos (
+			serviceId   INTEGER PRIMARY KEY,
+			etag        TEXT,
+			hash        TEXT NOT NULL,
+			contentType TEXT NOT NULL,
+			data        BLOB NOT NULL,
+			fetchedAt   INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create service_logos table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// サービスごとの上書き設定テーブルの作成
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS service_overrides (
+			networkId     INTEGER NOT NULL,
+			serviceId     INTEGER NOT NULL,
+			displayName   TEXT,
+			stationCode   TEXT,
+			channelNumber TEXT,
+			sortKey       INTEGER NOT NULL DEFAULT 0,
+			updatedAt     INTEGER NOT NULL,
+			PRIMARY KEY (networkId, serviceId)
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create service_overrides table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
 	// 予約テーブルの作成
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS reservations (
 			id                TEXT PRIMARY KEY,
 			programId         INTEGER NOT NULL,
 			serviceId         INTEGER NOT NULL,
+			networkId         INTEGER,
 			name              TEXT NOT NULL,
 			startAt           INTEGER NOT NULL,
 			duration          INTEGER NOT NULL,
@@ -90,7 +242,8 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 			status            TEXT NOT NULL,
 			createdAt         INTEGER NOT NULL,
 			updatedAt         INTEGER NOT NULL,
-			error             TEXT
+			error             TEXT,
+			source            TEXT
 		);
 	`)
 	if err != nil {
@@ -126,6 +279,7 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 			genres       TEXT,
 			serviceIds   TEXT,
 			excludeWords TEXT,
+			groupId      TEXT,
 			FOREIGN KEY (ruleId) REFERENCES auto_reservation_rules(id) ON DELETE CASCADE
 		);
 	`)
@@ -151,6 +305,26 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	// 人物ルールテーブルの作成
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS person_rules (
+			ruleId       TEXT PRIMARY KEY,
+			person       TEXT NOT NULL,
+			role         TEXT,
+			serviceIds   TEXT,
+			groupId      TEXT,
+			flags        TEXT,
+			excludeFlags TEXT,
+			minEpisode   INTEGER,
+			FOREIGN KEY (ruleId) REFERENCES auto_reservation_rules(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create person_rules table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
 	// 自動予約ログテーブルの作成
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS auto_reservation_logs (
@@ -170,6 +344,209 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	// Webhook購読テーブルの作成
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id         TEXT PRIMARY KEY,
+			name       TEXT NOT NULL,
+			url        TEXT NOT NULL,
+			eventTypes TEXT,
+			template   TEXT,
+			secret     TEXT,
+			enabled    INTEGER NOT NULL DEFAULT 1,
+			createdAt  INTEGER NOT NULL,
+			updatedAt  INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create webhooks table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// Webhook配信キュー兼配信ログテーブルの作成
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id             TEXT PRIMARY KEY,
+			webhookId      TEXT NOT NULL,
+			eventType      TEXT NOT NULL,
+			subject        TEXT,
+			payload        TEXT NOT NULL,
+			status         TEXT NOT NULL,
+			attempts       INTEGER NOT NULL DEFAULT 0,
+			nextAttemptAt  INTEGER NOT NULL,
+			responseStatus INTEGER,
+			lastError      TEXT,
+			createdAt      INTEGER NOT NULL,
+			updatedAt      INTEGER NOT NULL,
+			FOREIGN KEY (webhookId) REFERENCES webhooks(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create webhook_deliveries table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// チャンネルグループテーブルの作成
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS channel_groups (
+			id        TEXT PRIMARY KEY,
+			name      TEXT NOT NULL,
+			position  INTEGER NOT NULL DEFAULT 0,
+			createdAt INTEGER NOT NULL,
+			updatedAt INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create channel_groups table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// チャンネルグループに属するサービスのテーブルの作成（position はグループ内の並び順）
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS channel_group_services (
+			groupId   TEXT NOT NULL,
+			networkId INTEGER NOT NULL DEFAULT 0,
+			serviceId INTEGER NOT NULL,
+			position  INTEGER NOT NULL,
+			PRIMARY KEY (groupId, networkId, serviceId),
+			FOREIGN KEY (groupId) REFERENCES channel_groups(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create channel_group_services table: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// お気に入りグループは常に存在させる
+	now := time.Now().UnixMilli()
+	_, err = db.Exec(`INSERT OR IGNORE INTO channel_groups (id, name, position, createdAt, updatedAt)
+		VALUES (?, ?, 0, ?, ?)`, models.FavoritesGroupID, "お気に入り", now, now)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create favorites group: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// 旧バージョンで作成されたテーブルをサービスの複合キーに移行
+	if err := migrateServiceIdentity(db); err != nil {
+		models.Log.Error("InitDB: Failed to migrate service identity: %v", err)
+		db.Close()
+		return nil, err
+	}
+
+	// 旧バージョンで作成されたテーブルに追加されたカラムを補う
+	if err := addColumnIfMissing(db, "keyword_rules", "groupId", "TEXT"); err != nil {
+		models.Log.Error("InitDB: Failed to add keyword_rules.groupId: %v", err)
+		db.Close()
+		return nil, err
+	}
+	// 自動予約ルールの番組名の記号・話数フィルタ
+	for _, table := range []string{"keyword_rules", "series_rules"} {
+		for _, column := range []struct{ name, definition string }{
+			{"flags", "TEXT"}, {"excludeFlags", "TEXT"}, {"minEpisode", "INTEGER"},
+		} {
+			if err := addColumnIfMissing(db, table, column.name, column.definition); err != nil {
+				models.Log.Error("InitDB: Failed to add %s.%s: %v", table, column.name, err)
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+	for _, column := range []string{"attributes", "excludeAttributes"} {
+		if err := addColumnIfMissing(db, "keyword_rules", column, "TEXT"); err != nil {
+			models.Log.Error("InitDB: Failed to add keyword_rules.%s: %v", column, err)
+			db.Close()
+			return nil, err
+		}
+	}
+	// 自動予約ルールの放送時間帯・曜日・長さ・放送種別・有効期間（ScheduleFilter の JSON）
+	for _, table := range []string{"keyword_rules", "series_rules", "person_rules"} {
+		if err := addColumnIfMissing(db, table, "schedule", "TEXT"); err != nil {
+			models.Log.Error("InitDB: Failed to add %s.schedule: %v", table, err)
+			db.Close()
+			return nil, err
+		}
+	}
+	// 人物ルールは ruleId を主キーにして1ルール1行にする
+	if err := migratePersonRuleKey(db); err != nil {
+		models.Log.Error("InitDB: Failed to migrate person_rules: %v", err)
+		db.Close()
+		return nil, err
+	}
+	if err := addColumnIfMissing(db, "excluded_services", "expiresAt", "INTEGER"); err != nil {
+		models.Log.Error("InitDB: Failed to add excluded_services.expiresAt: %v", err)
+		db.Close()
+		return nil, err
+	}
+	if err := addColumnIfMissing(db, "programs", "isFree", "INTEGER"); err != nil {
+		models.Log.Error("InitDB: Failed to add programs.isFree: %v", err)
+		db.Close()
+		return nil, err
+	}
+	// 番組・サービス・予約の取得元（Mirakurun）の名前
+	for _, table := range []string{"programs", "program_archive", "services", "reservations"} {
+		if err := addColumnIfMissing(db, table, "source", "TEXT"); err != nil {
+			models.Log.Error("InitDB: Failed to add %s.source: %v", table, err)
+			db.Close()
+			return nil, err
+		}
+	}
+
+	// 番組名を解析した記号・話数・サブタイトル
+	for _, table := range []string{"programs", "program_archive"} {
+		for _, column := range []struct{ name, definition string }{
+			{"title", "TEXT"}, {"titleFlags", "TEXT"}, {"episodeNumber", "INTEGER"}, {"subtitle", "TEXT"},
+		} {
+			if err := addColumnIfMissing(db, table, column.name, column.definition); err != nil {
+				models.Log.Error("InitDB: Failed to add %s.%s: %v", table, column.name, err)
+				db.Close()
+				return nil, err
+			}
+		}
+		// 旧バージョンは "(2019)" のような年を話数としていたため解析し直す
+		if _, err := db.Exec(`UPDATE ` + table + ` SET title = NULL WHERE episodeNumber >= 1000`); err != nil {
+			models.Log.Error("InitDB: Failed to reset parsed titles in %s: %v", table, err)
+			db.Close()
+			return nil, err
+		}
+		if err := backfillParsedTitles(db, table); err != nil {
+			models.Log.Error("InitDB: Failed to parse titles in %s: %v", table, err)
+			db.Close()
+			return nil, err
+		}
+	}
+
+	// 番組の拡張情報（JSON）と、そこから取り出した人物（"|出演:名前|監督:名前|" の形式）
+	for _, table := range []string{"programs", "program_archive"} {
+		for _, column := range []string{"extended", "people"} {
+			if err := addColumnIfMissing(db, table, column, "TEXT"); err != nil {
+				models.Log.Error("InitDB: Failed to add %s.%s: %v", table, column, err)
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+
+	// 映像・音声コンポーネント（JSON）と、そこから求めた属性（"[hd][stereo][caption]" の形式）
+	for _, table := range []string{"programs", "program_archive"} {
+		for _, column := range []string{"video", "audios", "attributes"} {
+			if err := addColumnIfMissing(db, table, column, "TEXT"); err != nil {
+				models.Log.Error("InitDB: Failed to add %s.%s: %v", table, column, err)
+				db.Close()
+				return nil, err
+			}
+		}
+		if err := backfillAttributes(db, table); err != nil {
+			models.Log.Error("InitDB: Failed to set attributes in %s: %v", table, err)
+			db.Close()
+			return nil, err
+		}
+	}
+
 	// インデックスの作成
 	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_reservations_programId ON reservations(programId);`)
 	if err != nil {
@@ -191,6 +568,27 @@ func InitDB(dataSourceName string) (*sql.DB, error) {
 		models.Log.Error("InitDB: Failed to create index on auto_reservation_logs.ruleId: %v", err)
 	}
 
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries(status, nextAttemptAt);`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create index on webhook_deliveries.status: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhookId ON webhook_deliveries(webhookId);`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create index on webhook_deliveries.webhookId: %v", err)
+	}
+
+	// チャンネルごとの「現在・次」番組検索用
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_program_archive_startAt ON program_archive(startAt);`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create index on program_archive startAt: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_programs_serviceId_startAt ON programs(serviceId, startAt);`)
+	if err != nil {
+		models.Log.Error("InitDB: Failed to create index on programs(serviceId, startAt): %v", err)
+	}
+
 	models.Log.Debug("InitDB: Database initialization completed successfully")
 	return db, nil
 }
@@ -204,184 +602,69 @@ type ProgramEvent struct {
 }
 
 // StartStreamFetcher は Mirakurun の getProgramStream API を購読し、
-// resourceがprogramのイベントを受信して DB に INSERT OR REPLACE する。
-func StartStreamFetcher(ctx context.Context, db *sql.DB, apiURL string) {
-	models.Log.Debug("StartStreamFetcher: Starting stream fetcher with URL: %s", apiURL)
-
-	for {
-		err := func() error {
-			models.Log.Debug("StreamFetcher: Creating new request to Mirakurun")
-			req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-			if err != nil {
-				models.Log.Error("StreamFetcher: Failed to create request: %v", err)
-				return err
-			}
-
-			models.Log.Debug("StreamFetcher: Sending request to Mirakurun")
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				models.Log.Error("StreamFetcher: Request failed: %v", err)
-				return err
-			}
-			defer resp.Body.Close()
-
-			models.Log.Info("StreamFetcher: Connected to Mirakurun stream, status: %s", resp.Status)
-
-			// JSONストリームからデータを読み込むための設定
-			reader := bufio.NewReader(resp.Body)
-
-			// 最初の'['を読み飛ばす
-			firstChar, err := reader.ReadByte()
-			if err != nil {
-				models.Log.Error("StreamFetcher: Failed to read first byte: %v", err)
-				return err
-			}
-
-			if firstChar != '[' {
-				models.Log.Debug("StreamFetcher: First character is not '[', it's: %c", firstChar)
-				// '['でなければバッファに戻す
-				reader.UnreadByte()
-			} else {
-				models.Log.Debug("StreamFetcher: Found opening bracket '[' at stream start")
-			}
-
-			eventCount := 0
-
-			// JSONオブジェクトを行単位で処理
-			for {
-				// 行を読み取る
-				line, err := reader.ReadString('\n')
-				if err != nil {
-					if err == io.EOF {
-						models.Log.Info("StreamFetcher: End of stream reached")
-						break
-					}
-					models.Log.Error("StreamFetcher: Error reading line: %v", err)
-					return err
-				}
-
-				// トリミングして先頭のカンマを除去
-				line = strings.TrimSpace(line)
-				if line == "" {
-					continue
-				}
-
-				// カンマで始まっていれば除去
-				if strings.HasPrefix(line, ",") {
-					line = strings.TrimPrefix(line, ",")
-				}
-
-				// 終了ブラケットをスキップ
-				if line == "]" {
-					models.Log.Debug("StreamFetcher: Found closing bracket ']'")
-					continue
-				}
-
-				// 空であれば除去
-				if line == "" {
-					models.Log.Debug("StreamFetcher: Empty line, skipping")
-					continue
-				}
-
-				models.Log.Debug("StreamFetcher: Received JSON line: %s", line)
-
-				// JSONをパース
-				var event ProgramEvent
-				if err := json.Unmarshal([]byte(line), &event); err != nil {
-					models.Log.Error("StreamFetcher: JSON unmarshal error: %v, line: %s", err, line)
-					continue
-				}
-
-				// プログラムイベントの処理
-				if event.Resource == "program" {
-					eventCount++
-					p := event.Data
-
-					models.Log.Debug("StreamFetcher: Processing program event: ID=%d, Name=%s, Type=%s",
-						p.ID, p.Name, event.Type)
-
-					// event.Type が 'remove' の場合は削除する
-					if event.Type == "remove" {
-						// プログラムをDBから削除
-						_, err = db.Exec(`DELETE FROM programs WHERE id = ?`, p.ID)
-						if err != nil {
-							models.Log.Error("StreamFetcher: DB delete error: %v", err)
-						} else {
-							models.Log.Debug("StreamFetcher: Program deleted from database: ID=%d", p.ID)
-						}
-					} else {
-						// 検索用に名前と説明を正規化
-						p.NameForSearch = models.NormalizeForSearch(p.Name)
-						p.DescForSearch = models.NormalizeForSearch(p.Description)
-						
-						// programs テーブルへ INSERT OR REPLACE
-						var seriesId, seriesEpisode, seriesLastEpisode, seriesRepeat, seriesPattern interface{}
-						var seriesName interface{}
-						var seriesExpiresAt interface{}
-						
-						if p.Series != nil {
-							seriesId = p.Series.ID
-							seriesEpisode = p.Series.Episode
-							seriesLastEpisode = p.Series.LastEpisode
-							seriesName = p.Series.Name
-							seriesRepeat = p.Series.Repeat
-							seriesPattern = p.Series.Pattern
-							seriesExpiresAt = p.Series.ExpiresAt
-						}
-						
-						_, err = db.Exec(`
-							INSERT OR REPLACE INTO programs
-								(id, serviceId, startAt, duration, name, description, nameForSearch, descForSearch,
-								 seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt)
-							VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
-						`, p.ID, p.ServiceID, p.StartAt, p.Duration, p.Name, p.Description, p.NameForSearch, p.DescForSearch,
-						   seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt)
-
-						if err != nil {
-							models.Log.Error("StreamFetcher: DB insert error: %v", err)
-						} else {
-							models.Log.Debug("StreamFetcher: Program inserted into database: ID=%d", p.ID)
-						}
-					}
+// resourceがprogramのイベントを受信して ProgramWriter 経由で DB に INSERT OR REPLACE する。
+// 切断時は ctx が終了するまでバックオフしながら再接続する
+func StartStreamFetcher(ctx context.Context, writer *ProgramWriter, source models.MirakurunSource) {
+	apiURL := mirakurunAPIURL(source.URL, "events/stream?resource=program")
+	models.Log.Debug("StartStreamFetcher: Starting stream fetcher with URL: %s (source %s)", apiURL, source.Name)
+
+	stream := NewMirakurunStream("program", source.Name, apiURL)
+	stream.Run(ctx, programEventHandler(ctx, writer, source.Name))
+}
 
-					if eventCount%100 == 0 {
-						models.Log.Info("StreamFetcher: Processed %d program events", eventCount)
-					}
-				} else {
-					models.Log.Debug("StreamFetcher: Skipping non-program event: %s", event.Resource)
-				}
-			}
+// programEventHandler は取得元 source の番組イベントを ProgramWriter に渡す処理を返す
+// ストリームの購読とキャプチャの再生で共通に使う
+func programEventHandler(ctx context.Context, writer *ProgramWriter, source string) func(raw json.RawMessage) error {
+	eventCount := 0
+	return func(raw json.RawMessage) error {
+		var event ProgramEvent
+		if err := json.Unmarshal(raw, &event); err != nil {
+			models.Log.Error("StreamFetcher: JSON unmarshal error: %v, event: %s", err, raw)
+			return nil
+		}
 
-			models.Log.Info("StreamFetcher: Stream connection closed, processed %d events", eventCount)
+		if event.Resource != "program" {
+			models.Log.Debug("StreamFetcher: Skipping non-program event: %s", event.Resource)
 			return nil
-		}()
+		}
 
-		if err != nil {
-			models.Log.Error("StreamFetcher: Connection error: %v", err)
+		eventCount++
+		p := event.Data
+		p.Source = source
+		models.Log.Debug("StreamFetcher: Processing program event: ID=%d, Name=%s, Type=%s",
+			p.ID, p.Name, event.Type)
+
+		// 書き込みは ProgramWriter がまとめて行う
+		if err := writer.Enqueue(ctx, event.Type, p); err != nil {
+			return err
 		}
 
-		// 接続が切れたら一定間隔後に再接続（無限リトライ）
-		retryDelay := 5 * time.Second
-		models.Log.Info("StreamFetcher: Retrying connection in %v", retryDelay)
-		time.Sleep(retryDelay)
+		if eventCount%100 == 0 {
+			models.Log.Info("StreamFetcher: Processed %d program events from %s", eventCount, source)
+		}
+		return nil
 	}
 }
 
-// StartCleanupRoutine は定期的に放送終了した番組をDBから削除する
-func StartCleanupRoutine(db *sql.DB) {
-	models.Log.Debug("StartCleanupRoutine: Starting cleanup routine")
+// StartCleanupRoutine は定期的に放送終了した番組をアーカイブに移し、保持期間を過ぎたものを削除する
+func StartCleanupRoutine(db *sql.DB, retention time.Duration) {
+	models.Log.Debug("StartCleanupRoutine: Starting cleanup routine (archive retention: %v)", retention)
 
 	for {
-		now := time.Now().UnixMilli()
-		models.Log.Debug("CleanupRoutine: Running cleanup for programs ended before %d", now)
+		now := time.Now()
+		models.Log.Debug("CleanupRoutine: Archiving programs ended before %d", now.UnixMilli())
 
-		result, err := db.Exec("DELETE FROM programs WHERE startAt + duration < ?", now)
-		if err != nil {
-			models.Log.Error("CleanupRoutine: Cleanup error: %v", err)
+		if archived, err := ArchiveEndedPrograms(db, now.UnixMilli()); err != nil {
+			models.Log.Error("CleanupRoutine: Archive error: %v", err)
 		} else {
-			if rowsAffected, err := result.RowsAffected(); err == nil {
-				models.Log.Info("CleanupRoutine: Deleted %d expired programs", rowsAffected)
-			}
+			models.Log.Info("CleanupRoutine: Archived %d ended programs", archived)
+		}
+
+		// 保持期間を過ぎた番組をアーカイブから削除
+		if purged, err := PurgeArchivedPrograms(db, now.Add(-retention).UnixMilli()); err != nil {
+			models.Log.Error("CleanupRoutine: Purge error: %v", err)
+		} else {
+			models.Log.Info("CleanupRoutine: Deleted %d archived programs older than %v", purged, retention)
 		}
 
 		sleepDuration := 30 * time.Minute
@@ -392,8 +675,69 @@ func StartCleanupRoutine(db *sql.DB) {
 
 // SearchPrograms は検索条件に一致する番組を取得する共通関数
 func SearchPrograms(db *sql.DB, q string, serviceId, startFrom, startTo int64, channelType int) ([]models.Program, error) {
-	models.Log.Debug("SearchPrograms: Query=%s, ServiceId=%d, StartFrom=%d, StartTo=%d, ChannelType=%d",
-		q, serviceId, startFrom, startTo, channelType)
+	return SearchProgramsWithOptions(db, SearchOptions{
+		Query:       q,
+		ServiceID:   serviceId,
+		StartFrom:   startFrom,
+		StartTo:     startTo,
+		ChannelType: channelType,
+	})
+}
+
+// SearchOptions は番組検索の条件
+type SearchOptions struct {
+	Query       string // 検索キーワード（AND、"フレーズ"、-除外語、person:人物名、-person:人物名）
+	ServiceID   int64  // サービスID（指定時は放送種別より優先）。Mirakurun のサービスID（networkId * 100000 + serviceId）も指定できる
+	NetworkID   int64  // ServiceID のネットワークID（0はすべてのネットワーク）
+	StartFrom   int64  // 開始時刻の下限（Unixミリ秒）
+	StartTo     int64  // 開始時刻の上限（Unixミリ秒）
+	ChannelType int    // 放送種別（1: GR, 2: BS, 3: CS）
+	GroupID     string // チャンネルグループID
+	IncludePast bool   // 放送済み番組のアーカイブも検索する
+
+	// 番組名から解析した記号・話数による絞り込み
+	Flags        []string // すべての記号を含む番組のみ（"新" など）
+	ExcludeFlags []string // いずれかの記号を含む番組を除く（"再" など）
+	MinEpisode   int      // 話数がこの値以上の番組のみ（0は指定なし）
+
+	// 映像・音声・字幕の属性による絞り込み（"hd"、"5.1"、"bilingual"、"caption" など）
+	Attributes        []string // すべての属性を持つ番組のみ
+	ExcludeAttributes []string // いずれかの属性を持つ番組を除く
+
+	// 拡張情報から取り出した人物による絞り込み（名前の完全一致）
+	People        []string // すべての人物が出演・担当する番組のみ
+	ExcludePeople []string // いずれかの人物が出演・担当する番組を除く
+}
+
+// personOperatorPattern は検索キーワード中の person:人物名（"person:佐藤 健" のように引用符で空白を含められる）
+var personOperatorPattern = regexp.MustCompile(`(?:^|\s)(-?)person:(?:"([^"]*)"|(\S+))`)
+
+// extractPersonOperators は検索キーワードから person: の指定を取り出し、残りのキーワードを返す
+func extractPersonOperators(q string) (rest string, people, excludePeople []string) {
+	rest = personOperatorPattern.ReplaceAllStringFunc(q, func(m string) string {
+		sub := personOperatorPattern.FindStringSubmatch(m)
+		name := models.NormalizePersonName(sub[2] + sub[3])
+		if name == "" {
+			return " "
+		}
+		if sub[1] == "-" {
+			excludePeople = append(excludePeople, name)
+		} else {
+			people = append(people, name)
+		}
+		return " "
+	})
+	return strings.TrimSpace(rest), people, excludePeople
+}
+
+// SearchProgramsWithOptions は検索条件に一致する番組を開始時刻順に返す
+func SearchProgramsWithOptions(db *sql.DB, opts SearchOptions) ([]models.Program, error) {
+	q, serviceId, startFrom, startTo, channelType := opts.Query, opts.ServiceID, opts.StartFrom, opts.StartTo, opts.ChannelType
+	q, people, excludePeople := extractPersonOperators(q)
+	people = append(people, opts.People...)
+	excludePeople = append(excludePeople, opts.ExcludePeople...)
+	models.Log.Debug("SearchPrograms: Query=%s, ServiceId=%d, StartFrom=%d, StartTo=%d, ChannelType=%d, Group=%s",
+		q, serviceId, startFrom, startTo, channelType, opts.GroupID)
 
 	var args []interface{}
 	var query string
@@ -401,6 +745,12 @@ func SearchPrograms(db *sql.DB, q string, serviceId, startFrom, startTo int64, c
 	// 放送種別でフィルタリングするためのサービスID一覧
 	var serviceIDs []int64
 
+	// 検索対象のテーブル（アーカイブを含める場合は programs と合わせた副問い合わせ）
+	source := "programs"
+	if opts.IncludePast {
+		source = programsWithArchiveSQL
+	}
+
 	if q != "" {
 		// クエリを解析して正負の検索条件に分ける
 		positiveTermsMap := make(map[string]bool) // 正の検索語の重複を防ぐためのマップ
@@ -531,9 +881,8 @@ func SearchPrograms(db *sql.DB, q string, serviceId, startFrom, startTo int64, c
 
 		// クエリの基本部分を構築
 		query = `
-			SELECT id, serviceId, startAt, duration, name, description,
-				   seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt
-			FROM programs
+			SELECT ` + programColumns + `
+			FROM ` + source + `
 			WHERE 1=1
 		`
 
@@ -587,45 +936,34 @@ func SearchPrograms(db *sql.DB, q string, serviceId, startFrom, startTo int64, c
 		models.Log.Debug("SearchPrograms: Added negative search conditions: %v", negativeTerms)
 		models.Log.Debug("SearchPrograms: Query after all search conditions: %s", query)
 	} else {
-		query = `SELECT id, serviceId, startAt, duration, name, description,
-				 seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt
-				 FROM programs`
+		query = `SELECT ` + programColumns + ` FROM ` + source
 		models.Log.Debug("SearchPrograms: Using regular query without search terms")
 	}
 
 	// 除外チャンネルのリストを取得
-	excludedServiceIds := make(map[int64]bool)
-	rows, err := db.Query("SELECT serviceId FROM excluded_services")
-	if err != nil {
-		models.Log.Error("SearchPrograms: Failed to get excluded services: %v", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var serviceId int64
-			if err := rows.Scan(&serviceId); err != nil {
-				models.Log.Error("SearchPrograms: Failed to scan excluded service: %v", err)
-				continue
-			}
-			excludedServiceIds[serviceId] = true
-		}
-	}
-	models.Log.Debug("SearchPrograms: Loaded %d excluded services", len(excludedServiceIds))
+	excluded := loadExcludedServiceSet(db)
+	models.Log.Debug("SearchPrograms: Loaded %d excluded services", len(excluded.keys))
 
 	// 放送種別でフィルタリング
 	if channelType > 0 && channelType <= 3 {
-		// 指定された放送種別に該当するサービスIDのリストを取得
+		// 指定された放送種別に該当するサービスの一覧を取得
 		services := models.ServiceMapInstance.GetAll()
 		models.Log.Debug("SearchPrograms: Filtering by channelType %d, total services: %d", channelType, len(services))
 		
 		// サービスリストの内容をデバッグ出力
 		for _, service := range services {
-			models.Log.Debug("ServiceMap contains: ServiceID=%d, Name=%s, Type=%d", 
-				service.ServiceID, service.Name, service.Type)
+			models.Log.Debug("ServiceMap contains: NetworkID=%d, ServiceID=%d, Name=%s, Type=%d", 
+				service.NetworkID, service.ServiceID, service.Name, service.Type)
 		}
 		
+		var serviceConditions []string
+		excludedOfType := 0
 		for _, service := range services {
-			// 除外リストにあるサービスIDはスキップ
-			if excludedServiceIds[service.ServiceID] {
+			// 除外リストにあるサービスはスキップ
+			if excluded.Contains(service.NetworkID, service.ServiceID) {
+				if service.Type == channelType {
+					excludedOfType++
+				}
 				models.Log.Debug("SearchPrograms: Skipping excluded service: %d (%s)", 
 					service.ServiceID, service.Name)
 				continue
@@ -633,41 +971,99 @@ func SearchPrograms(db *sql.DB, q string, serviceId, startFrom, startTo int64, c
 			
 			if service.Type == channelType {
 				serviceIDs = append(serviceIDs, service.ServiceID)
-				models.Log.Debug("SearchPrograms: Added service to filter: ServiceID=%d, Name=%s, Type=%d", 
-					service.ServiceID, service.Name, service.Type)
+				if service.NetworkID != 0 {
+					// ネットワークID不明の旧データはサービスIDのみで照合する
+					serviceConditions = append(serviceConditions, "(serviceId = ? AND (networkId = ? OR networkId IS NULL))")
+					args = append(args, service.ServiceID, service.NetworkID)
+				} else {
+					serviceConditions = append(serviceConditions, "serviceId = ?")
+					args = append(args, service.ServiceID)
+				}
+				models.Log.Debug("SearchPrograms: Added service to filter: NetworkID=%d, ServiceID=%d, Name=%s, Type=%d", 
+					service.NetworkID, service.ServiceID, service.Name, service.Type)
 			}
 		}
 		
-		if len(serviceIDs) > 0 {
-			placeholders := make([]string, len(serviceIDs))
-			for i := range serviceIDs {
-				placeholders[i] = "?"
-				args = append(args, serviceIDs[i])
-			}
-			conditions = append(conditions, "serviceId IN ("+strings.Join(placeholders, ",")+")") 
+		if len(serviceConditions) > 0 {
+			conditions = append(conditions, "("+strings.Join(serviceConditions, " OR ")+")") 
 			models.Log.Debug("SearchPrograms: Adding channelType condition for type %d with %d services", 
 				channelType, len(serviceIDs))
+		} else if excludedOfType > 0 {
+			// 該当する放送種別のサービスがすべて除外されている
+			conditions = append(conditions, "0 = 1")
+			models.Log.Debug("SearchPrograms: All %d services of channel type %d are excluded", excludedOfType, channelType)
 		} else {
 			models.Log.Debug("SearchPrograms: No services found for channel type: %d, services may not be loaded yet", channelType)
 		}
 	} else if serviceId == 0 {
 		// 特定のサービスIDが指定されていない場合は、除外チャンネルを反映
-		if len(excludedServiceIds) > 0 {
-			excludedIds := make([]string, 0, len(excludedServiceIds))
-			for id := range excludedServiceIds {
-				excludedIds = append(excludedIds, "?")
-				args = append(args, id)
-			}
-			conditions = append(conditions, fmt.Sprintf("serviceId NOT IN (%s)", strings.Join(excludedIds, ",")))
-			models.Log.Debug("SearchPrograms: Adding exclusion condition for %d services", len(excludedServiceIds))
+		if len(excluded.keys) > 0 {
+			condition, conditionArgs := excluded.condition()
+			conditions = append(conditions, condition)
+			args = append(args, conditionArgs...)
+			models.Log.Debug("SearchPrograms: Adding exclusion condition for %d services", len(excluded.keys))
 		}
 	}
 	
 	if serviceId != 0 {
 		// 特定のサービスIDが指定されていれば、放送種別より優先
-		conditions = append(conditions, "serviceId = ?")
-		args = append(args, serviceId)
-		models.Log.Debug("SearchPrograms: Adding serviceId condition: %d", serviceId)
+		// 異なるネットワークの同じサービスIDを区別するため、ネットワークIDが分かる場合は組で照合する
+		key := models.ServiceKeyFromID(serviceId)
+		if opts.NetworkID != 0 {
+			key.NetworkID = opts.NetworkID
+		}
+		if key.NetworkID != 0 {
+			conditions = append(conditions, "(serviceId = ? AND (networkId IS NULL OR networkId = ?))")
+			args = append(args, key.ServiceID, key.NetworkID)
+		} else {
+			conditions = append(conditions, "serviceId = ?")
+			args = append(args, key.ServiceID)
+		}
+		models.Log.Debug("SearchPrograms: Adding service condition: %d/%d", key.NetworkID, key.ServiceID)
+	}
+	if opts.GroupID != "" {
+		conditions = append(conditions, channelGroupCondition)
+		args = append(args, opts.GroupID)
+		models.Log.Debug("SearchPrograms: Adding group condition: %s", opts.GroupID)
+	}
+	for _, flag := range opts.Flags {
+		conditions = append(conditions, `titleFlags LIKE ? ESCAPE '\'`)
+		args = append(args, "%["+escapeLike(flag)+"]%")
+	}
+	for _, flag := range opts.ExcludeFlags {
+		conditions = append(conditions, `(titleFlags IS NULL OR titleFlags NOT LIKE ? ESCAPE '\')`)
+		args = append(args, "%["+escapeLike(flag)+"]%")
+	}
+	if opts.MinEpisode > 0 {
+		conditions = append(conditions, "episodeNumber >= ?")
+		args = append(args, opts.MinEpisode)
+	}
+	if len(opts.Flags) > 0 || len(opts.ExcludeFlags) > 0 || opts.MinEpisode > 0 {
+		models.Log.Debug("SearchPrograms: Adding title conditions: flags=%v, excludeFlags=%v, minEpisode=%d",
+			opts.Flags, opts.ExcludeFlags, opts.MinEpisode)
+	}
+	for _, attr := range opts.Attributes {
+		conditions = append(conditions, `attributes LIKE ? ESCAPE '\'`)
+		args = append(args, "%["+escapeLike(attr)+"]%")
+	}
+	for _, attr := range opts.ExcludeAttributes {
+		conditions = append(conditions, `(attributes IS NULL OR attributes NOT LIKE ? ESCAPE '\')`)
+		args = append(args, "%["+escapeLike(attr)+"]%")
+	}
+	if len(opts.Attributes) > 0 || len(opts.ExcludeAttributes) > 0 {
+		models.Log.Debug("SearchPrograms: Adding attribute conditions: attributes=%v, excludeAttributes=%v",
+			opts.Attributes, opts.ExcludeAttributes)
+	}
+	for _, name := range people {
+		conditions = append(conditions, `people LIKE ? ESCAPE '\'`)
+		args = append(args, "%:"+escapeLike(models.NormalizePersonName(name))+"|%")
+	}
+	for _, name := range excludePeople {
+		conditions = append(conditions, `(people IS NULL OR people NOT LIKE ? ESCAPE '\')`)
+		args = append(args, "%:"+escapeLike(models.NormalizePersonName(name))+"|%")
+	}
+	if len(people) > 0 || len(excludePeople) > 0 {
+		models.Log.Debug("SearchPrograms: Adding person conditions: people=%v, excludePeople=%v", people, excludePeople)
 	}
 	if startFrom != 0 {
 		conditions = append(conditions, "startAt >= ?")
@@ -691,7 +1087,7 @@ func SearchPrograms(db *sql.DB, q string, serviceId, startFrom, startTo int64, c
 
 	models.Log.Debug("SearchPrograms: Final query: %s, Args: %v", query, args)
 
-	rows, err = db.Query(query, args...)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		models.Log.Error("SearchPrograms: Query error: %v", err)
 		return nil, err
@@ -702,31 +1098,13 @@ func SearchPrograms(db *sql.DB, q string, serviceId, startFrom, startTo int64, c
 	count := 0
 
 	for rows.Next() {
-		var p models.Program
-		var seriesId, seriesEpisode, seriesLastEpisode, seriesRepeat, seriesPattern sql.NullInt64
-		var seriesName sql.NullString
-		var seriesExpiresAt sql.NullInt64
-		
-		if err := rows.Scan(&p.ID, &p.ServiceID, &p.StartAt, &p.Duration, &p.Name, &p.Description,
-			&seriesId, &seriesEpisode, &seriesLastEpisode, &seriesName, &seriesRepeat, &seriesPattern, &seriesExpiresAt); err != nil {
+		p, err := scanProgram(rows)
+		if err != nil {
 			models.Log.Error("SearchPrograms: Scan error: %v", err)
 			return nil, err
 		}
 		
-		// Build series information if available
-		if seriesId.Valid {
-			p.Series = &models.Series{
-				ID:          int(seriesId.Int64),
-				Episode:     int(seriesEpisode.Int64),
-				LastEpisode: int(seriesLastEpisode.Int64),
-				Name:        seriesName.String,
-				Repeat:      int(seriesRepeat.Int64),
-				Pattern:     int(seriesPattern.Int64),
-				ExpiresAt:   seriesExpiresAt.Int64,
-			}
-		}
-		
-		programs = append(programs, p)
+		programs = append(programs, *p)
 		count++
 
 		models.Log.Debug("SearchPrograms: Found program: ID=%d, Name=%s", p.ID, p.Name)
@@ -740,17 +1118,11 @@ func SearchPrograms(db *sql.DB, q string, serviceId, startFrom, startTo int64, c
 func GetProgramByID(db *sql.DB, id int64) (*models.Program, error) {
 	models.Log.Debug("GetProgramByID: Looking up program with ID: %d", id)
 
-	var p models.Program
-	var seriesId, seriesEpisode, seriesLastEpisode, seriesRepeat, seriesPattern sql.NullInt64
-	var seriesName sql.NullString
-	var seriesExpiresAt sql.NullInt64
-	
-	err := db.QueryRow(`SELECT id, serviceId, startAt, duration, name, description,
-						seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt 
-						FROM programs WHERE id = ?`, id).
-		Scan(&p.ID, &p.ServiceID, &p.StartAt, &p.Duration, &p.Name, &p.Description,
-			 &seriesId, &seriesEpisode, &seriesLastEpisode, &seriesName, &seriesRepeat, &seriesPattern, &seriesExpiresAt)
-
+	p, err := scanProgram(db.QueryRow(`SELECT `+programColumns+` FROM programs WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		// 放送済みの番組はアーカイブから取得する
+		p, err = getArchivedProgramByID(db, id)
+	}
 	if err != nil {
 		if err == sql.ErrNoRows {
 			models.Log.Info("GetProgramByID: Program not found with ID: %d", id)
@@ -760,6 +1132,82 @@ func GetProgramByID(db *sql.DB, id int64) (*models.Program, error) {
 		return nil, err
 	}
 
+	models.Log.Debug("GetProgramByID: Found program: ID=%d, Name=%s, StartAt=%d",
+		p.ID, p.Name, p.StartAt)
+	return p, nil
+}
+
+// programColumns は番組を取得する際のSELECT対象カラム（scanProgramと順序を揃える）
+const programColumns = `id, serviceId, networkId, startAt, duration, name, description,
+	seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt, isFree, source,
+	title, titleFlags, episodeNumber, subtitle, extended, people, video, audios, attributes`
+
+// rowScanner は *sql.Row と *sql.Rows の共通インターフェース
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanProgram は programColumns の順で取得した行を Program に変換する
+func scanProgram(row rowScanner) (*models.Program, error) {
+	var p models.Program
+	var seriesId, seriesEpisode, seriesLastEpisode, seriesRepeat, seriesPattern sql.NullInt64
+	var seriesName sql.NullString
+	var seriesExpiresAt sql.NullInt64
+	var networkID sql.NullInt64
+	var isFree sql.NullBool
+	var source sql.NullString
+	var title, titleFlags, subtitle sql.NullString
+	var episodeNumber sql.NullInt64
+	var extended, people, video, audios, attributes sql.NullString
+
+	if err := row.Scan(&p.ID, &p.ServiceID, &networkID, &p.StartAt, &p.Duration, &p.Name, &p.Description,
+		&seriesId, &seriesEpisode, &seriesLastEpisode, &seriesName, &seriesRepeat, &seriesPattern, &seriesExpiresAt,
+		&isFree, &source, &title, &titleFlags, &episodeNumber, &subtitle, &extended, &people, &video, &audios, &attributes); err != nil {
+		return nil, err
+	}
+	if extended.String != "" {
+		if err := json.Unmarshal([]byte(extended.String), &p.Extended); err != nil {
+			models.Log.Error("scanProgram: Failed to parse extended of program %d: %v", p.ID, err)
+		}
+	}
+	// 出演者・スタッフは保存時に拡張情報から取り出した people を使う
+	for _, c := range splitPeople(people.String) {
+		if models.IsCastRole(c.Role) {
+			p.Cast = append(p.Cast, c)
+		} else {
+			p.Staff = append(p.Staff, c)
+		}
+	}
+	if video.String != "" {
+		if err := json.Unmarshal([]byte(video.String), &p.Video); err != nil {
+			models.Log.Error("scanProgram: Failed to parse video of program %d: %v", p.ID, err)
+		}
+	}
+	if audios.String != "" {
+		if err := json.Unmarshal([]byte(audios.String), &p.Audios); err != nil {
+			models.Log.Error("scanProgram: Failed to parse audios of program %d: %v", p.ID, err)
+		}
+	}
+	if attributes.Valid {
+		p.Attributes = splitTitleFlags(attributes.String)
+	} else {
+		p.ApplyAttributes()
+	}
+	p.NetworkID = networkID.Int64
+	p.Source = source.String
+	if title.Valid {
+		p.Title = title.String
+		p.Flags = splitTitleFlags(titleFlags.String)
+		p.EpisodeNumber = int(episodeNumber.Int64)
+		p.Subtitle = subtitle.String
+	} else {
+		// 番組名を解析する前に保存された番組
+		p.ApplyParsedTitle()
+	}
+	if isFree.Valid {
+		p.IsFree = &isFree.Bool
+	}
+
 	// Build series information if available
 	if seriesId.Valid {
 		p.Series = &models.Series{
@@ -773,8 +1221,6 @@ func GetProgramByID(db *sql.DB, id int64) (*models.Program, error) {
 		}
 	}
 
-	models.Log.Debug("GetProgramByID: Found program: ID=%d, Name=%s, StartAt=%d",
-		p.ID, p.Name, p.StartAt)
 	return &p, nil
 }
 
@@ -800,25 +1246,12 @@ func GetFilteredServices(db *sql.DB, allowedTypes []int, excludedTypes []int) []
 		excludeMap[t] = true
 	}
 	
-	// 除外チャンネルのマップを作成
-	excludedServiceIds := make(map[int64]bool)
+	// 除外チャンネルのセットを作成
+	excluded := excludedServiceSet{}
 	if db != nil {
-		rows, err := db.Query("SELECT serviceId FROM excluded_services")
-		if err != nil {
-			models.Log.Error("GetFilteredServices: Failed to get excluded services: %v", err)
-		} else {
-			defer rows.Close()
-			for rows.Next() {
-				var serviceId int64
-				if err := rows.Scan(&serviceId); err != nil {
-					models.Log.Error("GetFilteredServices: Failed to scan excluded service: %v", err)
-					continue
-				}
-				excludedServiceIds[serviceId] = true
-			}
-		}
+		excluded = loadExcludedServiceSet(db)
 	}
-	models.Log.Debug("GetFilteredServices: Loaded %d excluded services", len(excludedServiceIds))
+	models.Log.Debug("GetFilteredServices: Loaded %d excluded services", len(excluded.keys))
 	
 	// allowedTypesが空の場合、デフォルトで1,2,3を許可
 	var allowMap map[int]bool
@@ -836,8 +1269,8 @@ func GetFilteredServices(db *sql.DB, allowedTypes []int, excludedTypes []int) []
 			continue
 		}
 		
-		// 除外サービスIDリストにあるサービスはスキップ
-		if excludedServiceIds[service.ServiceID] {
+		// 除外リストにあるサービスはスキップ
+		if excluded.Contains(service.NetworkID, service.ServiceID) {
 			models.Log.Debug("GetFilteredServices: Skipping excluded service: %d (%s)", service.ServiceID, service.Name)
 			continue
 		}
@@ -860,11 +1293,95 @@ func GetFilteredServices(db *sql.DB, allowedTypes []int, excludedTypes []int) []
 	return filteredServices
 }
 
+// excludedServiceSet は除外チャンネルの集合
+// 有効期限内の除外チャンネルと、有効な除外ルールに一致するサービスを含む
+type excludedServiceSet struct {
+	keys       map[models.ServiceKey]bool
+	serviceIDs map[int64]bool
+}
+
+// add は除外するサービスを追加する（networkID が0の場合はすべてのネットワーク）
+func (s excludedServiceSet) add(key models.ServiceKey) {
+	s.keys[key] = true
+	s.serviceIDs[key.ServiceID] = true
+}
+
+// Contains はサービスが除外されているかを返す
+// networkID が0（不明）の場合は、いずれかのネットワークで除外されていれば除外とみなす
+func (s excludedServiceSet) Contains(networkID, serviceID int64) bool {
+	if networkID == 0 {
+		return s.serviceIDs[serviceID]
+	}
+	return s.keys[models.ServiceKey{ServiceID: serviceID}] ||
+		s.keys[models.ServiceKey{NetworkID: networkID, ServiceID: serviceID}]
+}
+
+// condition は programs の行が除外チャンネルに該当しないことを表す条件と引数を返す
+// 除外設定の networkId が0の場合、または番組のネットワークIDが不明の場合はサービスIDのみで照合する
+func (s excludedServiceSet) condition() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for key := range s.keys {
+		if key.NetworkID == 0 {
+			conditions = append(conditions, "serviceId = ?")
+			args = append(args, key.ServiceID)
+		} else {
+			conditions = append(conditions, "(serviceId = ? AND (networkId IS NULL OR networkId = ?))")
+			args = append(args, key.ServiceID, key.NetworkID)
+		}
+	}
+	return "NOT (" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// loadExcludedServiceSet は除外チャンネルの集合を読み込む
+func loadExcludedServiceSet(db *sql.DB) excludedServiceSet {
+	set := excludedServiceSet{
+		keys:       make(map[models.ServiceKey]bool),
+		serviceIDs: make(map[int64]bool),
+	}
+	now := time.Now().UnixMilli()
+
+	rows, err := db.Query(`SELECT networkId, serviceId FROM excluded_services
+		WHERE expiresAt IS NULL OR expiresAt = 0 OR expiresAt > ?`, now)
+	if err != nil {
+		models.Log.Error("loadExcludedServiceSet: Failed to get excluded services: %v", err)
+		return set
+	}
+	for rows.Next() {
+		var key models.ServiceKey
+		if err := rows.Scan(&key.NetworkID, &key.ServiceID); err != nil {
+			models.Log.Error("loadExcludedServiceSet: Failed to scan excluded service: %v", err)
+			continue
+		}
+		set.add(key)
+	}
+	rows.Close()
+
+	// 除外ルールに一致するサービスを追加
+	for _, key := range matchExclusionRules(db, now) {
+		set.add(key)
+	}
+	return set
+}
+
+// ExcludedServiceFilter はサービスが除外されているかを判定する関数を返す
+// 除外チャンネルと除外ルールは呼び出し時点の内容で一度だけ読み込む
+func ExcludedServiceFilter(db *sql.DB) func(networkID, serviceID int64) bool {
+	return loadExcludedServiceSet(db).Contains
+}
+
+// IsServiceExcluded はサービスが除外チャンネルまたは除外ルールにより除外されているかを返す
+func IsServiceExcluded(db *sql.DB, networkID, serviceID int64) bool {
+	return loadExcludedServiceSet(db).Contains(networkID, serviceID)
+}
+
 // GetExcludedServices は除外チャンネルの一覧を取得する
 func GetExcludedServices(db *sql.DB) ([]models.ExcludedService, error) {
 	models.Log.Debug("GetExcludedServices: Retrieving excluded services")
 	
-	rows, err := db.Query("SELECT serviceId, name, createdAt FROM excluded_services")
+	// 有効期限切れの除外は含めない
+	rows, err := db.Query(`SELECT networkId, serviceId, name, createdAt, COALESCE(expiresAt, 0) FROM excluded_services
+		WHERE expiresAt IS NULL OR expiresAt = 0 OR expiresAt > ?`, time.Now().UnixMilli())
 	if err != nil {
 		models.Log.Error("GetExcludedServices: Failed to query: %v", err)
 		return nil, err
@@ -874,15 +1391,14 @@ func GetExcludedServices(db *sql.DB) ([]models.ExcludedService, error) {
 	var services []models.ExcludedService
 	for rows.Next() {
 		var s models.ExcludedService
-		if err := rows.Scan(&s.ServiceID, &s.Name, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&s.NetworkID, &s.ServiceID, &s.Name, &s.CreatedAt, &s.ExpiresAt); err != nil {
 			models.Log.Error("GetExcludedServices: Failed to scan: %v", err)
 			return nil, err
 		}
 		
 		// サービスマップから追加情報を取得
-		if service, ok := models.ServiceMapInstance.Get(s.ServiceID); ok {
+		if service, ok := models.ServiceMapInstance.Lookup(s.NetworkID, s.ServiceID); ok {
 			s.Type = service.Type
-			s.NetworkID = service.NetworkID
 			s.RemoteControlKeyID = service.RemoteControlKeyID
 			s.ChannelType = service.ChannelType
 			s.ChannelNumber = service.ChannelNumber
@@ -895,7 +1411,7 @@ func GetExcludedServices(db *sql.DB) ([]models.ExcludedService, error) {
 			models.Log.Debug("GetExcludedServices: Enhanced service info for %d: Type=%d, RCKey=%d, ChannelType=%s", 
 				s.ServiceID, s.Type, s.RemoteControlKeyID, s.ChannelType)
 		} else {
-			models.Log.Debug("GetExcludedServices: No service info found in ServiceMap for ID %d", s.ServiceID)
+			models.Log.Debug("GetExcludedServices: No service info found in ServiceMap for ID %d/%d", s.NetworkID, s.ServiceID)
 		}
 		
 		services = append(services, s)
@@ -906,9 +1422,12 @@ func GetExcludedServices(db *sql.DB) ([]models.ExcludedService, error) {
 		return nil, err
 	}
 	
-	// サービスIDでソート
+	// ネットワークID、サービスIDの順でソート
 	sort.Slice(services, func(i, j int) bool {
-		return services[i].ServiceID < services[j].ServiceID
+		if services[i].ServiceID != services[j].ServiceID {
+			return services[i].ServiceID < services[j].ServiceID
+		}
+		return services[i].NetworkID < services[j].NetworkID
 	})
 	
 	models.Log.Info("GetExcludedServices: Retrieved and enhanced %d excluded services", len(services))
@@ -916,27 +1435,43 @@ func GetExcludedServices(db *sql.DB) ([]models.ExcludedService, error) {
 }
 
 // AddExcludedService は除外チャンネルを追加する
-func AddExcludedService(db *sql.DB, serviceId int64, name string) error {
-	models.Log.Debug("AddExcludedService: Adding service %d (%s) to excluded list", serviceId, name)
+// networkId が0の場合はすべてのネットワークの同じサービスIDを除外する
+func AddExcludedService(db *sql.DB, networkId, serviceId int64, name string) error {
+	return AddExcludedServiceUntil(db, networkId, serviceId, name, 0)
+}
+
+// AddExcludedServiceUntil は有効期限付きで除外チャンネルを追加する
+// expiresAt（Unixミリ秒）を過ぎると除外は無効になる。0の場合は無期限
+func AddExcludedServiceUntil(db *sql.DB, networkId, serviceId int64, name string, expiresAt int64) error {
+	models.Log.Debug("AddExcludedService: Adding service %d/%d (%s) to excluded list until %d", networkId, serviceId, name, expiresAt)
 	
 	_, err := db.Exec(
-		"INSERT OR REPLACE INTO excluded_services (serviceId, name, createdAt) VALUES (?, ?, ?)",
-		serviceId, name, time.Now().UnixMilli(),
+		"INSERT OR REPLACE INTO excluded_services (networkId, serviceId, name, createdAt, expiresAt) VALUES (?, ?, ?, ?, ?)",
+		networkId, serviceId, name, time.Now().UnixMilli(), nullableInt64(expiresAt),
 	)
 	if err != nil {
 		models.Log.Error("AddExcludedService: Failed to insert: %v", err)
 		return err
 	}
 	
-	models.Log.Info("AddExcludedService: Service %d (%s) added to excluded list", serviceId, name)
+	models.Log.Info("AddExcludedService: Service %d/%d (%s) added to excluded list", networkId, serviceId, name)
 	return nil
 }
 
 // RemoveExcludedService は除外チャンネルを削除する
-func RemoveExcludedService(db *sql.DB, serviceId int64) error {
-	models.Log.Debug("RemoveExcludedService: Removing service %d from excluded list", serviceId)
+// networkId が0の場合はすべてのネットワークの同じサービスIDの除外を解除する
+func RemoveExcludedService(db *sql.DB, networkId, serviceId int64) error {
+	models.Log.Debug("RemoveExcludedService: Removing service %d/%d from excluded list", networkId, serviceId)
+	
+	query := "DELETE FROM excluded_services WHERE serviceId = ?"
+	args := []interface{}{serviceId}
+	if networkId != 0 {
+		// 全ネットワーク指定（0）の除外も解除しないと除外されたままになる
+		query += " AND (networkId = ? OR networkId = 0)"
+		args = append(args, networkId)
+	}
 	
-	res, err := db.Exec("DELETE FROM excluded_services WHERE serviceId = ?", serviceId)
+	res, err := db.Exec(query, args...)
 	if err != nil {
 		models.Log.Error("RemoveExcludedService: Failed to delete: %v", err)
 		return err
@@ -947,139 +1482,78 @@ func RemoveExcludedService(db *sql.DB, serviceId int64) error {
 	return nil
 }
 
-// InitProgramsFromAPI は、Mirakurunの/api/programsエンドポイントから
-// すべての番組情報を取得し、データベースを初期化する
-func InitProgramsFromAPI(ctx context.Context, db *sql.DB, mirakurunBaseURL string) error {
-	apiURL := mirakurunBaseURL
-	if !strings.HasPrefix(apiURL, "http") {
-		apiURL = "http://" + apiURL
-	}
-	
-	// URLが/apiで終わっていたら/programsを追加
-	if strings.HasSuffix(apiURL, "/api") {
-		apiURL += "/programs"
-	} else if !strings.HasSuffix(apiURL, "/programs") {
-		// URLが/api/programsで終わっていなければ調整
-		if !strings.HasSuffix(apiURL, "/") {
-			apiURL += "/"
-		}
-		if !strings.Contains(apiURL, "/api/") {
-			apiURL += "api/programs"
-		} else if !strings.Contains(apiURL, "/programs") {
-			apiURL += "programs"
-		}
-	}
-	
-	models.Log.Debug("InitProgramsFromAPI: Constructed URL: %s from base URL: %s", apiURL, mirakurunBaseURL)
-
-	models.Log.Info("InitProgramsFromAPI: Fetching all programs from: %s", apiURL)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-	if err != nil {
-		models.Log.Error("InitProgramsFromAPI: Failed to create request: %v", err)
-		return err
+// nullableInt64 は0をNULLとして保存するための値を返す
+func nullableInt64(v int64) interface{} {
+	if v == 0 {
+		return nil
 	}
+	return v
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		models.Log.Error("InitProgramsFromAPI: Request failed: %v", err)
-		return err
+// nullableString は空文字列をNULLとして保存するための値を返す
+func nullableString(v string) interface{} {
+	if v == "" {
+		return nil
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		models.Log.Error("InitProgramsFromAPI: API returned non-OK status: %s", resp.Status)
-		return err
-	}
-
-	models.Log.Info("InitProgramsFromAPI: Connected to Mirakurun API, status: %s", resp.Status)
+	return v
+}
 
-	// レスポンス全体をメモリに読み込む
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		models.Log.Error("InitProgramsFromAPI: Failed to read response body: %v", err)
-		return err
+// nullableBool は nil をNULLとして保存するための値を返す
+func nullableBool(v *bool) interface{} {
+	if v == nil {
+		return nil
 	}
+	return *v
+}
 
-	// JSONをパース
-	var programs []models.Program
-	if err := json.Unmarshal(bodyBytes, &programs); err != nil {
-		models.Log.Error("InitProgramsFromAPI: JSON unmarshal error: %v", err)
-		return err
-	}
-
-	models.Log.Info("InitProgramsFromAPI: Successfully parsed %d programs", len(programs))
-
-	// トランザクションを開始
-	tx, err := db.Begin()
-	if err != nil {
-		models.Log.Error("InitProgramsFromAPI: Failed to begin transaction: %v", err)
-		return err
+// joinTitleFlags は番組名の記号を "[新][字]" の形式で保存する文字列にする
+// 記号ごとに角括弧で囲むので、LIKE '%[新]%' で検索できる（番組の属性も同じ形式で保存する）
+func joinTitleFlags(flags []string) string {
+	var b strings.Builder
+	for _, f := range flags {
+		b.WriteString("[" + f + "]")
 	}
+	return b.String()
+}
 
-	// 既存のテーブルを空にする
-	_, err = tx.Exec("DELETE FROM programs")
-	if err != nil {
-		tx.Rollback()
-		models.Log.Error("InitProgramsFromAPI: Failed to clear programs table: %v", err)
-		return err
+// joinPeople は番組の人物を "|出演:佐藤健|監督・演出:山田太郎|" の形式で保存する文字列にする
+// 人物ごとに区切るので、LIKE '%:佐藤健|%' で名前が完全に一致する番組を検索できる
+func joinPeople(credits []models.Credit) string {
+	if len(credits) == 0 {
+		return ""
 	}
-
-	models.Log.Debug("InitProgramsFromAPI: Cleared existing tables")
-
-	// バッチインサートのためのステートメント準備
-	stmtPrograms, err := tx.Prepare(`
-		INSERT INTO programs (id, serviceId, startAt, duration, name, description, nameForSearch, descForSearch,
-							  seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
-	`)
-	if err != nil {
-		tx.Rollback()
-		models.Log.Error("InitProgramsFromAPI: Failed to prepare programs insert statement: %v", err)
-		return err
+	var b strings.Builder
+	b.WriteString("|")
+	for _, c := range credits {
+		b.WriteString(c.Role + ":" + c.Name + "|")
 	}
-	defer stmtPrograms.Close()
+	return b.String()
+}
 
-	// すべてのプログラムを挿入
-	for i, p := range programs {
-		// 検索用に名前と説明を正規化
-		p.NameForSearch = models.NormalizeForSearch(p.Name)
-		p.DescForSearch = models.NormalizeForSearch(p.Description)
-		
-		// Series information
-		var seriesId, seriesEpisode, seriesLastEpisode, seriesRepeat, seriesPattern interface{}
-		var seriesName interface{}
-		var seriesExpiresAt interface{}
-		
-		if p.Series != nil {
-			seriesId = p.Series.ID
-			seriesEpisode = p.Series.Episode
-			seriesLastEpisode = p.Series.LastEpisode
-			seriesName = p.Series.Name
-			seriesRepeat = p.Series.Repeat
-			seriesPattern = p.Series.Pattern
-			seriesExpiresAt = p.Series.ExpiresAt
-		}
-		
-		_, err = stmtPrograms.Exec(p.ID, p.ServiceID, p.StartAt, p.Duration, p.Name, p.Description, p.NameForSearch, p.DescForSearch,
-								  seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt)
-		if err != nil {
-			tx.Rollback()
-			models.Log.Error("InitProgramsFromAPI: Failed to insert program %d: %v", p.ID, err)
-			return err
-		}
+// escapeLike は LIKE のパターンで s を文字どおりに照合するよう % と _ をエスケープする
+// ESCAPE '\' と組み合わせて使う
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
 
-		if (i+1)%500 == 0 || i+1 == len(programs) {
-			models.Log.Info("InitProgramsFromAPI: Inserted %d/%d programs", i+1, len(programs))
+// splitPeople は joinPeople で保存した文字列を人物の一覧に戻す
+func splitPeople(s string) []models.Credit {
+	var credits []models.Credit
+	for _, item := range strings.Split(s, "|") {
+		if i := strings.LastIndex(item, ":"); i >= 0 && i < len(item)-1 {
+			credits = append(credits, models.Credit{Role: item[:i], Name: item[i+1:]})
 		}
 	}
+	return credits
+}
 
-	// トランザクションをコミット
-	if err := tx.Commit(); err != nil {
-		models.Log.Error("InitProgramsFromAPI: Failed to commit transaction: %v", err)
-		return err
+// splitTitleFlags は joinTitleFlags で保存した文字列を記号の一覧に戻す
+func splitTitleFlags(s string) []string {
+	var flags []string
+	for _, f := range strings.Split(s, "]") {
+		if f = strings.TrimPrefix(f, "["); f != "" {
+			flags = append(flags, f)
+		}
 	}
-
-	models.Log.Info("InitProgramsFromAPI: Successfully initialized database with %d programs", len(programs))
-	return nil
-}
\ No newline at end of file
+	return flags
+}
@@ -1,7 +1,11 @@
 package db
 
 import (
+	"context"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/fuba/iepg-server/models"
@@ -230,4 +234,85 @@ func TestSearchPrograms(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestSearchProgramsByNetwork(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	// BS と CS で同じサービスIDが使われている
+	startAt := time.Now().Add(time.Hour).UnixMilli()
+	programs := []models.Program{
+		{ID: 1, ServiceID: 101, NetworkID: 4, StartAt: startAt, Duration: 1800000, Name: "BSの番組"},
+		{ID: 2, ServiceID: 101, NetworkID: 6, StartAt: startAt + 1, Duration: 1800000, Name: "CSの番組"},
+	}
+	if err := upsertPrograms(db, programs); err != nil {
+		t.Fatalf("Failed to save programs: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     SearchOptions
+		expected []int64
+	}{
+		{"service ID only", SearchOptions{ServiceID: 101}, []int64{1, 2}},
+		{"network ID", SearchOptions{ServiceID: 101, NetworkID: 6}, []int64{2}},
+		{"Mirakurun service ID", SearchOptions{ServiceID: 400101}, []int64{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := SearchProgramsWithOptions(db, tt.opts)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var ids []int64
+			for _, p := range results {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestInitDBEnablesWALAndBusyTimeout(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(filepath.Join(t.TempDir(), "programs.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	var busyTimeout int
+	if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Errorf("Expected WAL journal mode, got %q (%v)", journalMode, err)
+	}
+	if err := db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout); err != nil || busyTimeout != busyTimeoutMillis {
+		t.Errorf("Expected busy timeout %d, got %d (%v)", busyTimeoutMillis, busyTimeout, err)
+	}
+
+	// 外部キー制約はプール内のすべての接続で有効
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		defer conn.Close()
+		var foreignKeys int
+		if err := conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+			t.Errorf("Expected foreign keys on connection %d, got %d (%v)", i, foreignKeys, err)
+		}
+	}
+
+	// 指定済みのパラメータは上書きしない
+	if dsn := sqliteDSN("file:test.db?_busy_timeout=100"); dsn != "file:test.db?_busy_timeout=100&_journal_mode=WAL&_foreign_keys=1" {
+		t.Errorf("Unexpected DSN: %s", dsn)
+	}
+}
@@ -18,7 +18,7 @@ func TestAddAndRemoveExcludedService(t *testing.T) {
 	// Add a service to the excluded list
 	serviceID := int64(12345)
 	name := "Test Service"
-	if err := AddExcludedService(db, serviceID, name); err != nil {
+	if err := AddExcludedService(db, 0, serviceID, name); err != nil {
 		t.Fatalf("AddExcludedService returned error: %v", err)
 	}
 
@@ -34,7 +34,7 @@ func TestAddAndRemoveExcludedService(t *testing.T) {
 	}
 
 	// Remove the service
-	if err := RemoveExcludedService(db, serviceID); err != nil {
+	if err := RemoveExcludedService(db, 0, serviceID); err != nil {
 		t.Fatalf("RemoveExcludedService returned error: %v", err)
 	}
 
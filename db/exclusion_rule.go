@@ -0,0 +1,248 @@
+// db/exclusion_rule.go
+package db
+
+import (
+	"database/sql"
+	"regexp"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+	"github.com/google/uuid"
+)
+
+const exclusionRuleColumns = `id, name, type, pattern, channelType, days, enabled, expiresAt, createdAt, updatedAt`
+
+// scanExclusionRule は exclusionRuleColumns の順で取得した行を ExclusionRule に変換する
+func scanExclusionRule(row rowScanner) (*models.ExclusionRule, error) {
+	var r models.ExclusionRule
+	var pattern, channelType sql.NullString
+	var expiresAt sql.NullInt64
+
+	if err := row.Scan(&r.ID, &r.Name, &r.Type, &pattern, &channelType, &r.Days, &r.Enabled,
+		&expiresAt, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	r.Pattern = pattern.String
+	r.ChannelType = channelType.String
+	r.ExpiresAt = expiresAt.Int64
+	return &r, nil
+}
+
+// CreateExclusionRule は除外ルールを作成する
+func CreateExclusionRule(db *sql.DB, rule *models.ExclusionRule) error {
+	if rule.ID == "" {
+		rule.ID = uuid.New().String()
+	}
+	rule.CreatedAt = time.Now().UnixMilli()
+	rule.UpdatedAt = rule.CreatedAt
+
+	_, err := db.Exec(`INSERT INTO exclusion_rules (`+exclusionRuleColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.ID, rule.Name, rule.Type, rule.Pattern, rule.ChannelType, rule.Days, rule.Enabled,
+		nullableInt64(rule.ExpiresAt), rule.CreatedAt, rule.UpdatedAt)
+	if err != nil {
+		models.Log.Error("CreateExclusionRule: Failed to create rule: %v", err)
+		return err
+	}
+
+	models.Log.Info("CreateExclusionRule: Created rule %s (%s)", rule.ID, rule.Name)
+	return nil
+}
+
+// GetExclusionRules は除外ルールをすべて取得する
+func GetExclusionRules(db *sql.DB) ([]models.ExclusionRule, error) {
+	rows, err := db.Query(`SELECT ` + exclusionRuleColumns + ` FROM exclusion_rules ORDER BY createdAt`)
+	if err != nil {
+		models.Log.Error("GetExclusionRules: Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.ExclusionRule{}
+	for rows.Next() {
+		r, err := scanExclusionRule(rows)
+		if err != nil {
+			models.Log.Error("GetExclusionRules: Scan failed: %v", err)
+			continue
+		}
+		rules = append(rules, *r)
+	}
+	return rules, rows.Err()
+}
+
+// GetExclusionRule は除外ルールを取得する
+func GetExclusionRule(db *sql.DB, id string) (*models.ExclusionRule, error) {
+	return scanExclusionRule(db.QueryRow(`SELECT `+exclusionRuleColumns+` FROM exclusion_rules WHERE id = ?`, id))
+}
+
+// UpdateExclusionRule は除外ルールを更新する
+func UpdateExclusionRule(db *sql.DB, rule *models.ExclusionRule) error {
+	rule.UpdatedAt = time.Now().UnixMilli()
+	result, err := db.Exec(`UPDATE exclusion_rules
+		SET name = ?, type = ?, pattern = ?, channelType = ?, days = ?, enabled = ?, expiresAt = ?, updatedAt = ?
+		WHERE id = ?`,
+		rule.Name, rule.Type, rule.Pattern, rule.ChannelType, rule.Days, rule.Enabled,
+		nullableInt64(rule.ExpiresAt), rule.UpdatedAt, rule.ID)
+	if err != nil {
+		models.Log.Error("UpdateExclusionRule: Update failed: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	models.Log.Info("UpdateExclusionRule: Updated rule %s", rule.ID)
+	return nil
+}
+
+// DeleteExclusionRule は除外ルールを削除する
+func DeleteExclusionRule(db *sql.DB, id string) error {
+	result, err := db.Exec(`DELETE FROM exclusion_rules WHERE id = ?`, id)
+	if err != nil {
+		models.Log.Error("DeleteExclusionRule: Delete failed: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	models.Log.Info("DeleteExclusionRule: Deleted rule %s", id)
+	return nil
+}
+
+// GetExclusionRuleMatches は除外ルールに一致するサービスを返す（ルールの有効・無効は問わない）
+func GetExclusionRuleMatches(db *sql.DB, rule *models.ExclusionRule) []*models.Service {
+	m := newExclusionRuleMatcher(db, time.Now().UnixMilli())
+	matched := []*models.Service{}
+	for _, service := range models.ServiceMapInstance.GetAll() {
+		if m.match(rule, service) {
+			matched = append(matched, service)
+		}
+	}
+	return matched
+}
+
+// matchExclusionRules は有効な除外ルールのいずれかに一致するサービスのキーを返す
+func matchExclusionRules(db *sql.DB, now int64) []models.ServiceKey {
+	rows, err := db.Query(`SELECT `+exclusionRuleColumns+` FROM exclusion_rules
+		WHERE enabled = 1 AND (expiresAt IS NULL OR expiresAt = 0 OR expiresAt > ?)`, now)
+	if err != nil {
+		models.Log.Error("matchExclusionRules: Failed to get exclusion rules: %v", err)
+		return nil
+	}
+	var rules []*models.ExclusionRule
+	for rows.Next() {
+		r, err := scanExclusionRule(rows)
+		if err != nil {
+			models.Log.Error("matchExclusionRules: Scan failed: %v", err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	rows.Close()
+	if len(rules) == 0 {
+		return nil
+	}
+
+	m := newExclusionRuleMatcher(db, now)
+	var keys []models.ServiceKey
+	for _, service := range models.ServiceMapInstance.GetAll() {
+		for _, rule := range rules {
+			if m.match(rule, service) {
+				models.Log.Debug("matchExclusionRules: Service %d/%d (%s) excluded by rule %s",
+					service.NetworkID, service.ServiceID, service.Name, rule.Name)
+				keys = append(keys, service.Key())
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// exclusionRuleMatcher はルールの評価に必要な番組の集計結果をキャッシュする
+type exclusionRuleMatcher struct {
+	db       *sql.DB
+	now      int64
+	patterns map[string]*regexp.Regexp
+	pay      map[models.ServiceKey]bool
+	active   map[int]map[models.ServiceKey]bool // 日数ごとの番組があるサービス
+}
+
+func newExclusionRuleMatcher(db *sql.DB, now int64) *exclusionRuleMatcher {
+	return &exclusionRuleMatcher{
+		db:       db,
+		now:      now,
+		patterns: make(map[string]*regexp.Regexp),
+		active:   make(map[int]map[models.ServiceKey]bool),
+	}
+}
+
+// match はサービスがルールに一致するかを返す
+func (m *exclusionRuleMatcher) match(rule *models.ExclusionRule, service *models.Service) bool {
+	if rule.ChannelType != "" && rule.ChannelType != service.ChannelType {
+		return false
+	}
+
+	switch rule.Type {
+	case models.ExclusionRuleNameRegex:
+		re, ok := m.patterns[rule.Pattern]
+		if !ok {
+			var err error
+			if re, err = regexp.Compile(rule.Pattern); err != nil {
+				models.Log.Error("exclusionRuleMatcher: Invalid pattern in rule %s: %v", rule.ID, err)
+			}
+			m.patterns[rule.Pattern] = re
+		}
+		// 上書き設定で表示名を変えていても、元の名前でも一致させる
+		return re != nil && (re.MatchString(service.Name) ||
+			(service.OriginalName != "" && re.MatchString(service.OriginalName)))
+
+	case models.ExclusionRulePayChannel:
+		if m.pay == nil {
+			// 放送予定の番組がすべて有料（isFree=false）のサービス
+			m.pay = m.serviceKeys(`SELECT COALESCE(networkId, 0), serviceId FROM programs
+				WHERE startAt + duration > ? GROUP BY networkId, serviceId HAVING MAX(isFree) = 0`, m.now)
+		}
+		return containsServiceKey(m.pay, service)
+
+	case models.ExclusionRuleNoPrograms:
+		days := rule.Days
+		if days <= 0 {
+			days = models.DefaultNoProgramsDays
+		}
+		active, ok := m.active[days]
+		if !ok {
+			active = m.serviceKeys(`SELECT DISTINCT COALESCE(networkId, 0), serviceId FROM programs
+				WHERE startAt < ? AND startAt + duration > ?`,
+				m.now+int64(days)*24*int64(time.Hour/time.Millisecond), m.now)
+			m.active[days] = active
+		}
+		// 番組情報を取得できていない間はすべてのサービスを除外しないようにする
+		return len(active) > 0 && !containsServiceKey(active, service)
+	}
+	return false
+}
+
+// serviceKeys は (networkId, serviceId) を返すクエリの結果を集合にする
+func (m *exclusionRuleMatcher) serviceKeys(query string, args ...interface{}) map[models.ServiceKey]bool {
+	keys := make(map[models.ServiceKey]bool)
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		models.Log.Error("exclusionRuleMatcher: Query failed: %v", err)
+		return keys
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key models.ServiceKey
+		if err := rows.Scan(&key.NetworkID, &key.ServiceID); err != nil {
+			continue
+		}
+		keys[key] = true
+	}
+	return keys
+}
+
+// containsServiceKey はサービスが集合に含まれるかを返す（ネットワークID不明の番組はサービスIDのみで照合）
+func containsServiceKey(keys map[models.ServiceKey]bool, service *models.Service) bool {
+	return keys[service.Key()] || keys[models.ServiceKey{ServiceID: service.ServiceID}]
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestTimeBoxedExclusion(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now().UnixMilli()
+	if err := AddExcludedServiceUntil(db, 4, 101, "期限付き", now+60000); err != nil {
+		t.Fatalf("AddExcludedServiceUntil failed: %v", err)
+	}
+	if err := AddExcludedServiceUntil(db, 4, 102, "期限切れ", now-60000); err != nil {
+		t.Fatalf("AddExcludedServiceUntil failed: %v", err)
+	}
+
+	if !IsServiceExcluded(db, 4, 101) {
+		t.Errorf("Expected service 101 to be excluded until it expires")
+	}
+	if IsServiceExcluded(db, 4, 102) {
+		t.Errorf("Expected the expired exclusion of service 102 to be ignored")
+	}
+
+	excluded, err := GetExcludedServices(db)
+	if err != nil || len(excluded) != 1 || excluded[0].ServiceID != 101 || excluded[0].ExpiresAt != now+60000 {
+		t.Errorf("Expected only the active exclusion, got %+v (%v)", excluded, err)
+	}
+}
+
+func TestExclusionRules(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	models.ServiceMapInstance = models.NewServiceMap()
+	for _, s := range []*models.Service{
+		{NetworkID: 32736, ServiceID: 1024, Name: "地上波局", Type: 1, ChannelType: "GR"},
+		{NetworkID: 4, ServiceID: 101, Name: "BS局", Type: 2, ChannelType: "BS"},
+		{NetworkID: 7, ServiceID: 55, Name: "ショップチャンネル", Type: 3, ChannelType: "CS"},
+		{NetworkID: 7, ServiceID: 218, Name: "有料映画", Type: 3, ChannelType: "CS"},
+		{NetworkID: 7, ServiceID: 300, Name: "休止中", Type: 3, ChannelType: "CS"},
+	} {
+		models.ServiceMapInstance.Update(s)
+	}
+
+	now := time.Now()
+	insert := func(id, networkID, serviceID int64, isFree bool) {
+		if _, err := db.Exec(`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch, isFree)
+			VALUES (?, ?, ?, ?, 3600000, 'news', '', 'news', '', ?)`,
+			id, serviceID, networkID, now.Add(time.Hour).UnixMilli(), isFree); err != nil {
+			t.Fatalf("Failed to insert program: %v", err)
+		}
+	}
+	insert(1, 32736, 1024, true)
+	insert(2, 4, 101, true)
+	insert(3, 7, 55, true)
+	insert(4, 7, 218, false)
+
+	for _, rule := range []*models.ExclusionRule{
+		{Name: "通販", Type: models.ExclusionRuleNameRegex, Pattern: "ショップ|通販", ChannelType: "CS", Enabled: true},
+		{Name: "有料", Type: models.ExclusionRulePayChannel, Enabled: true},
+		{Name: "休止", Type: models.ExclusionRuleNoPrograms, Enabled: true},
+		// 無効なルールと期限切れのルールは適用されない
+		{Name: "無効", Type: models.ExclusionRuleNameRegex, Pattern: "地上波", Enabled: false},
+		{Name: "期限切れ", Type: models.ExclusionRuleNameRegex, Pattern: "BS", Enabled: true, ExpiresAt: now.Add(-time.Hour).UnixMilli()},
+	} {
+		if err := CreateExclusionRule(db, rule); err != nil {
+			t.Fatalf("CreateExclusionRule failed: %v", err)
+		}
+	}
+
+	services := GetFilteredServices(db, nil, nil)
+	names := make(map[string]bool)
+	for _, s := range services {
+		names[s.Name] = true
+	}
+	if len(services) != 2 || !names["地上波局"] || !names["BS局"] {
+		t.Errorf("Expected only 地上波局 and BS局 to remain, got %v", names)
+	}
+
+	// 自動予約エンジンと同じ条件（サービス・放送種別の指定なし）での検索
+	programs, err := SearchPrograms(db, "", 0, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("SearchPrograms failed: %v", err)
+	}
+	if len(programs) != 2 || programs[0].ID > 2 || programs[1].ID > 2 {
+		t.Errorf("Expected programs 1 and 2, got %+v", programs)
+	}
+	// 放送種別で絞り込んでも除外ルールは適用される
+	programs, _ = SearchPrograms(db, "", 0, 0, 0, 3)
+	if len(programs) != 0 {
+		t.Errorf("Expected no CS programs, got %+v", programs)
+	}
+
+	// ルールの一致確認は無効なルールでも行える
+	rules, _ := GetExclusionRules(db)
+	for _, rule := range rules {
+		if rule.Name != "無効" {
+			continue
+		}
+		matches := GetExclusionRuleMatches(db, &rule)
+		if len(matches) != 1 || matches[0].ServiceID != 1024 {
+			t.Errorf("Expected the disabled rule to match 地上波局, got %+v", matches)
+		}
+	}
+}
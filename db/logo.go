@@ -0,0 +1,189 @@
+// db/logo.go
+package db
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// maxLogoSize は局ロゴとして受け付ける最大サイズ
+const maxLogoSize = 1 << 20
+
+// ErrLogoNotFound は Mirakurun にロゴデータが無い場合のエラー
+var ErrLogoNotFound = errors.New("logo not found")
+
+// GetServiceLogo はキャッシュ済みの局ロゴを取得する
+func GetServiceLogo(db *sql.DB, id int64) (*models.ServiceLogo, error) {
+	var logo models.ServiceLogo
+	var etag sql.NullString
+	err := db.QueryRow(`SELECT serviceId, etag, hash, contentType, data, fetchedAt
+		FROM service_logos WHERE serviceId = ?`, id).Scan(
+		&logo.ID, &etag, &logo.Hash, &logo.ContentType, &logo.Data, &logo.FetchedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			models.Log.Error("GetServiceLogo: Query failed for %d: %v", id, err)
+		}
+		return nil, err
+	}
+	logo.ETag = etag.String
+	return &logo, nil
+}
+
+// SaveServiceLogo は局ロゴをキャッシュに保存する
+func SaveServiceLogo(db *sql.DB, logo *models.ServiceLogo) error {
+	sum := sha1.Sum(logo.Data)
+	logo.Hash = hex.EncodeToString(sum[:])
+	_, err := db.Exec(`INSERT OR REPLACE INTO service_logos (serviceId, etag, hash, contentType, data, fetchedAt)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		logo.ID, logo.ETag, logo.Hash, logo.ContentType, logo.Data, logo.FetchedAt)
+	if err != nil {
+		models.Log.Error("SaveServiceLogo: Failed to save logo for %d: %v", logo.ID, err)
+	}
+	return err
+}
+
+// FetchServiceLogo は Mirakurun から局ロゴを取得してキャッシュする
+// キャッシュ済みの場合は ETag で再検証し、変更が無ければキャッシュをそのまま返す
+func FetchServiceLogo(ctx context.Context, db *sql.DB, mirakurunBaseURL string, id int64) (*models.ServiceLogo, error) {
+	cached, err := GetServiceLogo(db, id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	apiURL := mirakurunAPIURL(mirakurunBaseURL, fmt.Sprintf("services/%d/logo", id))
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		models.Log.Error("FetchServiceLogo: Request failed for %d: %v", id, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	now := time.Now().UnixMilli()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, fmt.Errorf("unexpected 304 response for uncached logo %d", id)
+		}
+		if _, err := db.Exec("UPDATE service_logos SET fetchedAt = ? WHERE serviceId = ?", now, id); err != nil {
+			models.Log.Error("FetchServiceLogo: Failed to update fetchedAt for %d: %v", id, err)
+		}
+		cached.FetchedAt = now
+		models.Log.Debug("FetchServiceLogo: Logo for %d not modified", id)
+		return cached, nil
+	case http.StatusNotFound, http.StatusServiceUnavailable:
+		// Mirakurun はロゴデータが未取得の場合 503 を返す
+		return nil, ErrLogoNotFound
+	default:
+		models.Log.Error("FetchServiceLogo: API returned non-OK status for %d: %s", id, resp.Status)
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLogoSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxLogoSize {
+		return nil, fmt.Errorf("logo for %d exceeds %d bytes", id, maxLogoSize)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	logo := &models.ServiceLogo{
+		ID:          id,
+		ETag:        resp.Header.Get("ETag"),
+		ContentType: contentType,
+		Data:        data,
+		FetchedAt:   now,
+	}
+	if err := SaveServiceLogo(db, logo); err != nil {
+		return nil, err
+	}
+
+	models.Log.Debug("FetchServiceLogo: Cached logo for %d (%d bytes)", id, len(data))
+	return logo, nil
+}
+
+// StartLogoFetcher は定期的にロゴデータを持つ全サービスの局ロゴを取得・再検証する
+// ロゴはサービスの取得元から取得し、取得元が分からない場合は mirakurunBaseURL から取得する
+func StartLogoFetcher(ctx context.Context, db *sql.DB, mirakurunBaseURL string) {
+	models.Log.Debug("StartLogoFetcher: Starting logo fetcher with URL: %s", mirakurunBaseURL)
+
+	// サービス情報の取得を待ってから初回のフェッチを行う
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Minute):
+	}
+	fetchLogos(ctx, db, mirakurunBaseURL)
+
+	// 以降は1日ごとに再検証
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			models.Log.Info("LogoFetcher: Context cancelled, stopping logo fetcher")
+			return
+		case <-ticker.C:
+			fetchLogos(ctx, db, mirakurunBaseURL)
+		}
+	}
+}
+
+// MirakurunURLForService は Mirakurun のサービスID id を受信した取得元のURLを返す
+// 取得元が分からない場合は fallback を返す
+func MirakurunURLForService(id int64, fallback string) string {
+	for _, service := range models.ServiceMapInstance.GetAll() {
+		if service.ID != id || service.Source == "" {
+			continue
+		}
+		if source, ok := models.LookupMirakurunSource(service.Source); ok {
+			return source.URL
+		}
+	}
+	return fallback
+}
+
+// fetchLogos はロゴデータを持つ全サービスの局ロゴを取得する
+func fetchLogos(ctx context.Context, db *sql.DB, mirakurunBaseURL string) {
+	count := 0
+	for _, service := range models.ServiceMapInstance.GetAll() {
+		if !service.HasLogoData || service.ID == 0 {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		baseURL := mirakurunBaseURL
+		if source, ok := models.LookupMirakurunSource(service.Source); ok {
+			baseURL = source.URL
+		}
+		if _, err := FetchServiceLogo(ctx, db, baseURL, service.ID); err != nil {
+			models.Log.Debug("fetchLogos: Failed to fetch logo for %d (%s): %v", service.ID, service.Name, err)
+			continue
+		}
+		count++
+	}
+	models.Log.Info("fetchLogos: Refreshed %d logos", count)
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestFetchServiceLogoRevalidates(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	png := []byte("\x89PNG\r\n\x1a\nlogo")
+	requests, notModified := 0, 0
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/services/3273601024/logo":
+			requests++
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(png)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer mirakurun.Close()
+
+	logo, err := FetchServiceLogo(context.Background(), db, mirakurun.URL, 3273601024)
+	if err != nil {
+		t.Fatalf("FetchServiceLogo failed: %v", err)
+	}
+	if string(logo.Data) != string(png) || logo.ContentType != "image/png" || logo.ETag != `"v1"` || logo.Hash == "" {
+		t.Errorf("Unexpected logo: %+v", logo)
+	}
+
+	// 2回目は ETag で再検証され、キャッシュが返る
+	logo, err = FetchServiceLogo(context.Background(), db, mirakurun.URL, 3273601024)
+	if err != nil {
+		t.Fatalf("FetchServiceLogo (revalidate) failed: %v", err)
+	}
+	if requests != 2 || notModified != 1 || string(logo.Data) != string(png) {
+		t.Errorf("Expected a 304 revalidation, got requests=%d notModified=%d", requests, notModified)
+	}
+
+	if _, err := FetchServiceLogo(context.Background(), db, mirakurun.URL, 1); err != ErrLogoNotFound {
+		t.Errorf("Expected ErrLogoNotFound for a service without logo data, got %v", err)
+	}
+}
+
+func TestServiceLogoURL(t *testing.T) {
+	service := convertToService(&mirakurunServiceResponse{ID: 3273601024, ServiceID: 1024, NetworkID: 32736, HasLogoData: true})
+	if service.LogoURL != "/services/3273601024/logo" {
+		t.Errorf("Unexpected logoUrl: %q", service.LogoURL)
+	}
+	service = convertToService(&mirakurunServiceResponse{ID: 3273601025, ServiceID: 1025, NetworkID: 32736})
+	if service.LogoURL != "" {
+		t.Errorf("Expected no logoUrl without logo data, got %q", service.LogoURL)
+	}
+
+	// 検索結果の番組にも付与されるよう ServiceMap に登録された値を確認
+	models.ServiceMapInstance = models.NewServiceMap()
+	models.ServiceMapInstance.Add(convertToService(&mirakurunServiceResponse{ID: 3273601024, ServiceID: 1024, NetworkID: 32736, HasLogoData: true}))
+	if s, ok := models.ServiceMapInstance.Get(1024); !ok || s.LogoURL == "" {
+		t.Errorf("Expected logoUrl on the stored service, got %+v", s)
+	}
+}
@@ -0,0 +1,292 @@
+// db/migrate.go
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// tableColumns はテーブルのカラム名と主キー内の位置（主キーでなければ0）を返す
+func tableColumns(db *sql.DB, table string) (map[string]int, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]int)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = pk
+	}
+	return columns, rows.Err()
+}
+
+// addColumnIfMissing はテーブルにカラムが無ければ追加する
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+	if _, ok := columns[column]; ok {
+		return nil
+	}
+	models.Log.Info("addColumnIfMissing: Adding %s to %s", column, table)
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// uniqueNetworkIDSQL は services テーブルでサービスIDが一意に決まる場合にそのネットワークIDを返す副問い合わせ
+// 複数のネットワークに同じサービスIDがある場合や未知のサービスの場合は NULL になる
+const uniqueNetworkIDSQL = `(SELECT CASE WHEN COUNT(*) = 1 THEN MAX(s.networkId) END FROM services s WHERE s.serviceId = %s.serviceId)`
+
+// legacyServiceColumns は移行前の services テーブルに存在するカラム
+const legacyServiceColumns = `id, serviceId, networkId, name, type, logoId, hasLogoData, remoteControlKeyId,
+	channelType, channelNumber, channelName, channelTsmfRelTs, firstSeenAt, lastSeenAt`
+
+// migrateServiceIdentity はサービスIDのみで識別していた旧テーブルを (networkId, serviceId) に移行する
+// 既存行のネットワークIDは保存済みのサービス情報から一意に決まる場合のみ補完する
+func migrateServiceIdentity(db *sql.DB) error {
+	// services: 主キーを (networkId, serviceId) に変更
+	columns, err := tableColumns(db, "services")
+	if err != nil {
+		return err
+	}
+	if columns["networkId"] == 0 {
+		models.Log.Info("migrateServiceIdentity: Migrating services table to (networkId, serviceId) key")
+		if err := execAll(db,
+			`ALTER TABLE services RENAME TO services_old`,
+			`CREATE TABLE services (
+				networkId          INTEGER NOT NULL,
+				serviceId          INTEGER NOT NULL,
+				id                 INTEGER,
+				name               TEXT,
+				type               INTEGER,
+				logoId             INTEGER,
+				hasLogoData        INTEGER,
+				remoteControlKeyId INTEGER,
+				channelType        TEXT,
+				channelNumber      TEXT,
+				channelName        TEXT,
+				channelTsmfRelTs   INTEGER,
+				firstSeenAt        INTEGER NOT NULL,
+				lastSeenAt         INTEGER NOT NULL,
+				PRIMARY KEY (networkId, serviceId)
+			)`,
+			`INSERT OR REPLACE INTO services (`+legacyServiceColumns+`)
+				SELECT `+legacyServiceColumns+` FROM services_old WHERE networkId IS NOT NULL`,
+			`DROP TABLE services_old`,
+		); err != nil {
+			return err
+		}
+	}
+
+	// programs: networkId カラムを追加
+	columns, err = tableColumns(db, "programs")
+	if err != nil {
+		return err
+	}
+	if _, ok := columns["networkId"]; !ok {
+		models.Log.Info("migrateServiceIdentity: Adding networkId to programs")
+		if err := execAll(db,
+			`ALTER TABLE programs ADD COLUMN networkId INTEGER`,
+			`UPDATE programs SET networkId = `+fmt.Sprintf(uniqueNetworkIDSQL, "programs"),
+		); err != nil {
+			return err
+		}
+	}
+
+	// reservations: networkId カラムを追加（番組から、なければサービス情報から補完）
+	columns, err = tableColumns(db, "reservations")
+	if err != nil {
+		return err
+	}
+	if _, ok := columns["networkId"]; !ok {
+		models.Log.Info("migrateServiceIdentity: Adding networkId to reservations")
+		if err := execAll(db,
+			`ALTER TABLE reservations ADD COLUMN networkId INTEGER`,
+			`UPDATE reservations SET networkId = COALESCE(
+				(SELECT p.networkId FROM programs p WHERE p.id = reservations.programId),
+				`+fmt.Sprintf(uniqueNetworkIDSQL, "reservations")+`)`,
+		); err != nil {
+			return err
+		}
+	}
+
+	// excluded_services: 主キーを (networkId, serviceId) に変更
+	// ネットワークIDが決まらない行は 0（すべてのネットワーク）として移行し、従来どおりの除外を維持する
+	columns, err = tableColumns(db, "excluded_services")
+	if err != nil {
+		return err
+	}
+	if _, ok := columns["networkId"]; !ok {
+		models.Log.Info("migrateServiceIdentity: Migrating excluded_services table to (networkId, serviceId) key")
+		if err := execAll(db,
+			`ALTER TABLE excluded_services RENAME TO excluded_services_old`,
+			`CREATE TABLE excluded_services (
+				networkId     INTEGER NOT NULL DEFAULT 0,
+				serviceId     INTEGER NOT NULL,
+				name          TEXT,
+				createdAt     INTEGER,
+				PRIMARY KEY (networkId, serviceId)
+			)`,
+			`INSERT INTO excluded_services (networkId, serviceId, name, createdAt)
+				SELECT COALESCE(`+fmt.Sprintf(uniqueNetworkIDSQL, "excluded_services_old")+`, 0),
+					serviceId, name, createdAt
+				FROM excluded_services_old`,
+			`DROP TABLE excluded_services_old`,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// personRuleColumns は person_rules テーブルのカラム
+const personRuleColumns = `ruleId, person, role, serviceIds, groupId, flags, excludeFlags, minEpisode, schedule`
+
+// migratePersonRuleKey は主キーの無い旧 person_rules テーブルを ruleId を主キーとするテーブルに移行する
+// 同じルールの行が重複している場合は最後に追加された行を残し、削除済みのルールの行は捨てる
+func migratePersonRuleKey(db *sql.DB) error {
+	columns, err := tableColumns(db, "person_rules")
+	if err != nil {
+		return err
+	}
+	if columns["ruleId"] != 0 {
+		return nil
+	}
+
+	models.Log.Info("migratePersonRuleKey: Migrating person_rules table to ruleId key")
+	return execAll(db,
+		`ALTER TABLE person_rules RENAME TO person_rules_old`,
+		`CREATE TABLE person_rules (
+			ruleId       TEXT PRIMARY KEY,
+			person       TEXT NOT NULL,
+			role         TEXT,
+			serviceIds   TEXT,
+			groupId      TEXT,
+			flags        TEXT,
+			excludeFlags TEXT,
+			minEpisode   INTEGER,
+			schedule     TEXT,
+			FOREIGN KEY (ruleId) REFERENCES auto_reservation_rules(id) ON DELETE CASCADE
+		)`,
+		`INSERT OR REPLACE INTO person_rules (`+personRuleColumns+`)
+			SELECT `+personRuleColumns+` FROM person_rules_old
+			WHERE ruleId IN (SELECT id FROM auto_reservation_rules)
+			ORDER BY rowid`,
+		`DROP TABLE person_rules_old`,
+	)
+}
+
+// execAll は複数の文を1つのトランザクションで実行する
+func execAll(db *sql.DB, statements ...string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			models.Log.Error("execAll: Failed to execute %q: %v", stmt, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// backfillParsedTitles は番組名を解析する前に保存された番組の記号・話数・サブタイトルを設定する
+func backfillParsedTitles(db *sql.DB, table string) error {
+	rows, err := db.Query(`SELECT id, name FROM ` + table + ` WHERE title IS NULL`)
+	if err != nil {
+		return err
+	}
+	var programs []models.Program
+	for rows.Next() {
+		var p models.Program
+		var name sql.NullString
+		if err := rows.Scan(&p.ID, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		p.Name = name.String
+		programs = append(programs, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(programs) == 0 {
+		return err
+	}
+
+	models.Log.Info("backfillParsedTitles: Parsing titles of %d programs in %s", len(programs), table)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE ` + table + ` SET title = ?, titleFlags = ?, episodeNumber = ?, subtitle = ? WHERE id = ?`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for i := range programs {
+		p := &programs[i]
+		p.ApplyParsedTitle()
+		if _, err := stmt.Exec(p.Title, joinTitleFlags(p.Flags), p.EpisodeNumber, p.Subtitle, p.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// backfillAttributes は属性を求める前に保存された番組の属性を番組名の記号から設定する
+// 旧バージョンでは映像・音声コンポーネントを保存していないため、字幕・解説・二か国語のみ分かる
+func backfillAttributes(db *sql.DB, table string) error {
+	rows, err := db.Query(`SELECT id, name FROM ` + table + ` WHERE attributes IS NULL`)
+	if err != nil {
+		return err
+	}
+	var programs []models.Program
+	for rows.Next() {
+		var p models.Program
+		var name sql.NullString
+		if err := rows.Scan(&p.ID, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		p.Name = name.String
+		programs = append(programs, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(programs) == 0 {
+		return err
+	}
+
+	models.Log.Info("backfillAttributes: Setting attributes of %d programs in %s", len(programs), table)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE ` + table + ` SET attributes = ? WHERE id = ?`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for i := range programs {
+		p := &programs[i]
+		if _, err := stmt.Exec(joinTitleFlags(p.ComputeAttributes()), p.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
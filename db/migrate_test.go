@@ -0,0 +1,192 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestMigrateServiceIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// サービスIDのみで識別していた旧スキーマのデータベースを作成
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE programs (id INTEGER PRIMARY KEY, serviceId INTEGER, startAt INTEGER, duration INTEGER,
+			name TEXT, description TEXT, nameForSearch TEXT, descForSearch TEXT,
+			seriesId INTEGER, seriesEpisode INTEGER, seriesLastEpisode INTEGER, seriesName TEXT,
+			seriesRepeat INTEGER, seriesPattern INTEGER, seriesExpiresAt INTEGER)`,
+		`CREATE TABLE excluded_services (serviceId INTEGER PRIMARY KEY, name TEXT, createdAt INTEGER)`,
+		`CREATE TABLE services (serviceId INTEGER PRIMARY KEY, id INTEGER, networkId INTEGER, name TEXT,
+			type INTEGER, logoId INTEGER, hasLogoData INTEGER, remoteControlKeyId INTEGER,
+			channelType TEXT, channelNumber TEXT, channelName TEXT, channelTsmfRelTs INTEGER,
+			firstSeenAt INTEGER NOT NULL, lastSeenAt INTEGER NOT NULL)`,
+		`CREATE TABLE reservations (id TEXT PRIMARY KEY, programId INTEGER NOT NULL, serviceId INTEGER NOT NULL,
+			name TEXT NOT NULL, startAt INTEGER NOT NULL, duration INTEGER NOT NULL, recorderUrl TEXT NOT NULL,
+			recorderProgramId TEXT NOT NULL, status TEXT NOT NULL, createdAt INTEGER NOT NULL,
+			updatedAt INTEGER NOT NULL, error TEXT)`,
+		`INSERT INTO services (serviceId, id, networkId, name, type, firstSeenAt, lastSeenAt)
+			VALUES (1024, 3273601024, 32736, '局A', 1, 1, 1)`,
+		`INSERT INTO programs (id, serviceId, startAt, duration, name) VALUES (1, 1024, 1000, 60, '番組A')`,
+		`INSERT INTO programs (id, serviceId, startAt, duration, name) VALUES (2, 2048, 1000, 60, '番組B')`,
+		`INSERT INTO excluded_services (serviceId, name, createdAt) VALUES (1024, '局A', 1)`,
+		`INSERT INTO excluded_services (serviceId, name, createdAt) VALUES (2048, '未知の局', 1)`,
+		`INSERT INTO reservations VALUES ('r1', 1, 1024, '番組A', 1000, 60, 'http://recorder', '1', 'pending', 1, 1, NULL)`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create old schema: %v", err)
+		}
+	}
+	old.Close()
+
+	db, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed on old schema: %v", err)
+	}
+
+	services, err := GetStoredServices(db)
+	if err != nil || len(services) != 1 || services[0].NetworkID != 32736 {
+		t.Fatalf("Unexpected services after migration: %+v, %v", services, err)
+	}
+
+	var networkID sql.NullInt64
+	db.QueryRow("SELECT networkId FROM programs WHERE id = 1").Scan(&networkID)
+	if networkID.Int64 != 32736 {
+		t.Errorf("Expected program 1 to be backfilled with networkId 32736, got %v", networkID)
+	}
+	db.QueryRow("SELECT networkId FROM programs WHERE id = 2").Scan(&networkID)
+	if networkID.Valid {
+		t.Errorf("Expected program 2 of an unknown service to keep a NULL networkId, got %v", networkID)
+	}
+
+	reservation, err := GetReservationByID(db, "r1")
+	if err != nil || reservation.NetworkID != 32736 {
+		t.Errorf("Unexpected reservation after migration: %+v, %v", reservation, err)
+	}
+
+	excluded, err := GetExcludedServices(db)
+	if err != nil || len(excluded) != 2 {
+		t.Fatalf("Unexpected excluded services after migration: %+v, %v", excluded, err)
+	}
+	if excluded[0].ServiceID != 1024 || excluded[0].NetworkID != 32736 {
+		t.Errorf("Expected service 1024 to be excluded on network 32736, got %+v", excluded[0])
+	}
+	if excluded[1].ServiceID != 2048 || excluded[1].NetworkID != 0 {
+		t.Errorf("Expected service 2048 to be excluded on every network, got %+v", excluded[1])
+	}
+
+	// 移行済みのデータベースを再度開いても問題ないこと
+	db.Close()
+	db, err = InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed on migrated schema: %v", err)
+	}
+	db.Close()
+}
+
+func TestExcludeServiceOnOneNetwork(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	// 同じサービスIDが2つのネットワークに存在する
+	for _, p := range []struct{ id, networkID int64 }{{1, 4}, {2, 6}} {
+		if _, err := db.Exec(`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch)
+			VALUES (?, 101, ?, 1000, 60, 'ニュース', '', ?, '')`, p.id, p.networkID, models.NormalizeForSearch("ニュース")); err != nil {
+			t.Fatalf("Failed to insert program: %v", err)
+		}
+	}
+
+	if err := AddExcludedService(db, 4, 101, "BS局"); err != nil {
+		t.Fatalf("AddExcludedService failed: %v", err)
+	}
+	programs, err := SearchPrograms(db, "ニュース", 0, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("SearchPrograms failed: %v", err)
+	}
+	if len(programs) != 1 || programs[0].NetworkID != 6 {
+		t.Errorf("Expected only the program on network 6, got %+v", programs)
+	}
+	if !IsServiceExcluded(db, 4, 101) || IsServiceExcluded(db, 6, 101) {
+		t.Errorf("Expected the exclusion to apply to network 4 only")
+	}
+
+	// networkId 0 はすべてのネットワークを除外する
+	if err := AddExcludedService(db, 0, 101, "全局"); err != nil {
+		t.Fatalf("AddExcludedService failed: %v", err)
+	}
+	programs, _ = SearchPrograms(db, "ニュース", 0, 0, 0, 0)
+	if len(programs) != 0 {
+		t.Errorf("Expected no programs after excluding every network, got %d", len(programs))
+	}
+
+	// ネットワークを指定して解除すると全ネットワーク指定の除外も解除される
+	if err := RemoveExcludedService(db, 6, 101); err != nil {
+		t.Fatalf("RemoveExcludedService failed: %v", err)
+	}
+	programs, _ = SearchPrograms(db, "ニュース", 0, 0, 0, 0)
+	if len(programs) != 1 || programs[0].NetworkID != 6 {
+		t.Errorf("Expected the program on network 6 after unexclude, got %+v", programs)
+	}
+}
+
+func TestMigratePersonRuleKey(t *testing.T) {
+	models.InitLogger("error")
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	db, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	rule := &models.AutoReservationRule{Type: "person", Name: "人物", Enabled: true, RecorderURL: "http://recorder"}
+	if err := CreateAutoReservationRule(db, rule); err != nil {
+		t.Fatalf("CreateAutoReservationRule returned error: %v", err)
+	}
+
+	// 主キーの無い旧スキーマに、同じルールの重複行と削除済みルールの行を作る
+	for _, stmt := range []string{
+		`DROP TABLE person_rules`,
+		`CREATE TABLE person_rules (ruleId TEXT NOT NULL, person TEXT NOT NULL, role TEXT, serviceIds TEXT,
+			groupId TEXT, flags TEXT, excludeFlags TEXT, minEpisode INTEGER, schedule TEXT)`,
+		`INSERT INTO person_rules (ruleId, person) VALUES ('` + rule.ID + `', '古い人物')`,
+		`INSERT INTO person_rules (ruleId, person) VALUES ('` + rule.ID + `', '新しい人物')`,
+		`INSERT INTO person_rules (ruleId, person) VALUES ('deleted-rule', '削除済み')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create old schema: %v", err)
+		}
+	}
+	db.Close()
+
+	db, err = InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed on old schema: %v", err)
+	}
+	defer db.Close()
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM person_rules`).Scan(&count)
+	if count != 1 {
+		t.Fatalf("Expected 1 person rule after migration, got %d", count)
+	}
+	got, err := getPersonRule(db, rule.ID)
+	if err != nil || got.Person != "新しい人物" {
+		t.Fatalf("Expected the latest person rule to be kept, got %+v, %v", got, err)
+	}
+
+	// 更新は同じ行を置き換える
+	if err := CreatePersonRule(db, &models.PersonRule{RuleID: rule.ID, Person: "更新後"}); err != nil {
+		t.Fatalf("CreatePersonRule returned error: %v", err)
+	}
+	db.QueryRow(`SELECT COUNT(*) FROM person_rules`).Scan(&count)
+	if got, _ := getPersonRule(db, rule.ID); count != 1 || got.Person != "更新後" {
+		t.Errorf("Expected a single updated person rule, got %d rows (%+v)", count, got)
+	}
+}
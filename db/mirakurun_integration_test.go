@@ -0,0 +1,107 @@
+// db/mirakurun_integration_test.go
+package db
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/testing/fakemirakurun"
+)
+
+// waitFor は cond が満たされるまで待つ
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMirakurunIngestionEndToEnd(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+	models.ServiceMapInstance = models.NewServiceMap()
+
+	fake := fakemirakurun.New()
+	fake.LoadFixture(fakemirakurun.DefaultFixture())
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	defer fake.Close()
+	source := models.MirakurunSource{Name: models.DefaultSourceName, URL: ts.URL + "/api"}
+
+	// 初期ロードとサービスの取得
+	if err := InitProgramsFromAPI(context.Background(), db, source); err != nil {
+		t.Fatalf("InitProgramsFromAPI failed: %v", err)
+	}
+	if progress := GetInitialLoadProgress(source.Name); progress.Received != len(fake.Programs()) {
+		t.Errorf("Expected %d programs loaded, got %+v", len(fake.Programs()), progress)
+	}
+	fetchServices(context.Background(), db, source)
+	if s, ok := models.ServiceMapInstance.Lookup(4, 101); !ok || s.ChannelType != "BS" || s.Source != source.Name {
+		t.Errorf("Expected BS service from fetchServices, got %+v", s)
+	}
+
+	// ストリームの購読
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := NewProgramWriter(db)
+	writer.flushInterval = 10 * time.Millisecond
+	go writer.Run(ctx)
+	go StartStreamFetcher(ctx, writer, source)
+	go StartServiceEventStream(ctx, db, source)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	if err := fake.WaitForSubscribers(waitCtx, "program", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.WaitForSubscribers(waitCtx, "service", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(24 * time.Hour).UnixMilli()
+	fake.AddProgram(fakemirakurun.Program{EventID: 500, ServiceID: 1024, NetworkID: 32736, StartAt: start, Duration: 1800000, Name: "特番"})
+	fake.AddProgram(fakemirakurun.Program{EventID: 500, ServiceID: 1024, NetworkID: 32736, StartAt: start, Duration: 3600000, Name: "特番（拡大版）"})
+	removedID := fakemirakurun.ProgramItemID(32737, 1032, 12)
+	if _, err := GetProgramByID(db, removedID); err != nil {
+		t.Fatalf("Expected program %d to be loaded before removal: %v", removedID, err)
+	}
+	fake.RemoveProgram(removedID)
+	fake.AddService(fakemirakurun.Service{ServiceID: 1040, NetworkID: 32738, Name: "新局", Type: 1, Channel: &fakemirakurun.Channel{Type: "GR", Channel: "25"}})
+	fake.RemoveService(4, 101)
+
+	addedID := fakemirakurun.ProgramItemID(32736, 1024, 500)
+	waitFor(t, "program update", func() bool {
+		p, err := GetProgramByID(db, addedID)
+		return err == nil && p.Name == "特番（拡大版）" && p.Duration == 3600000
+	})
+	waitFor(t, "program removal", func() bool {
+		_, err := GetProgramByID(db, removedID)
+		return err != nil
+	})
+	waitFor(t, "service events", func() bool {
+		_, added := models.ServiceMapInstance.Lookup(32738, 1040)
+		_, removed := models.ServiceMapInstance.Lookup(4, 101)
+		return added && !removed
+	})
+
+	programStreamEvents := func() int64 {
+		for _, state := range GetStreamStates() {
+			if state.URL == ts.URL+"/api/events/stream?resource=program" && state.Connected {
+				return state.Events
+			}
+		}
+		return 0
+	}
+	waitFor(t, "program stream state", func() bool { return programStreamEvents() == 3 })
+}
@@ -0,0 +1,272 @@
+// db/mirakurun_stream.go
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+const (
+	// DefaultStreamMinBackoff は再接続までの最初の待ち時間
+	DefaultStreamMinBackoff = time.Second
+	// DefaultStreamMaxBackoff は再接続までの待ち時間の上限
+	DefaultStreamMaxBackoff = 2 * time.Minute
+	// DefaultStreamStableDuration はイベントを受信しなくても正常に接続できていたとみなす接続時間
+	DefaultStreamStableDuration = 30 * time.Second
+)
+
+// jsonArrayDecoder はJSON配列の要素を1つずつ読み込む
+// Mirakurunのイベントストリームは要素の区切りや改行の位置が一定ではないため、行単位ではなくトークン単位で読む
+type jsonArrayDecoder struct {
+	dec     *json.Decoder
+	started bool
+}
+
+// newJSONArrayDecoder は r から JSON 配列を読み込むデコーダーを作成する
+func newJSONArrayDecoder(r io.Reader) *jsonArrayDecoder {
+	return &jsonArrayDecoder{dec: json.NewDecoder(r)}
+}
+
+// Next は次の要素を v に読み込む。配列の終わりに達した場合は io.EOF を返す
+// 配列が閉じられる前に入力が終わった場合は io.EOF 以外のエラーになる
+func (d *jsonArrayDecoder) Next(v interface{}) error {
+	if !d.started {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return fmt.Errorf("expected JSON array, got %v", tok)
+		}
+		d.started = true
+	}
+
+	if !d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != ']' {
+			return fmt.Errorf("expected end of JSON array, got %v", tok)
+		}
+		return io.EOF
+	}
+
+	if err := d.dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// MirakurunStream はMirakurunのイベントストリームを購読するクライアント
+// 切断時は指数バックオフ（ジッター付き）で再接続し、接続状態を記録する
+type MirakurunStream struct {
+	name        string
+	source      string
+	url         string
+	client      *http.Client
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	stableAfter time.Duration
+
+	mu    sync.Mutex
+	state models.StreamState
+}
+
+// NewMirakurunStream は取得元 source のストリームクライアントを作成し、接続状態の一覧に登録する
+func NewMirakurunStream(name, source, url string) *MirakurunStream {
+	s := &MirakurunStream{
+		name:        name,
+		source:      source,
+		url:         url,
+		client:      http.DefaultClient,
+		minBackoff:  DefaultStreamMinBackoff,
+		maxBackoff:  DefaultStreamMaxBackoff,
+		stableAfter: DefaultStreamStableDuration,
+		state:       models.StreamState{Name: name, Source: source, URL: url},
+	}
+	registerStream(s)
+	return s
+}
+
+// State はストリームの接続状態を返す
+func (s *MirakurunStream) State() models.StreamState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// updateState はストリームの接続状態を更新する
+func (s *MirakurunStream) updateState(update func(state *models.StreamState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&s.state)
+}
+
+// Run はストリームを購読し、受信したイベントごとに handle を呼び出す
+// ctx が終了するまで再接続を続ける。handle がエラーを返した場合は切断して再接続する
+func (s *MirakurunStream) Run(ctx context.Context, handle func(raw json.RawMessage) error) {
+	models.Log.Debug("MirakurunStream(%s/%s): Starting stream with URL: %s", s.source, s.name, s.url)
+
+	attempt := 0
+	for {
+		healthy, err := s.connect(ctx, handle)
+		if ctx.Err() != nil {
+			s.updateState(func(state *models.StreamState) {
+				state.Connected = false
+				state.NextRetryAt = 0
+			})
+			models.Log.Info("MirakurunStream(%s/%s): Context cancelled, stopping stream", s.source, s.name)
+			return
+		}
+
+		// 正常に接続できていた場合のみバックオフを最初からやり直す
+		// 接続直後に切断される場合は待ち時間を増やし、再接続を繰り返さないようにする
+		if healthy {
+			attempt = 0
+		}
+		delay := backoffDelay(attempt, s.minBackoff, s.maxBackoff)
+		attempt++
+
+		now := time.Now()
+		s.updateState(func(state *models.StreamState) {
+			state.Connected = false
+			state.NextRetryAt = now.Add(delay).UnixMilli()
+			if err != nil {
+				state.LastError = err.Error()
+				state.LastErrorAt = now.UnixMilli()
+			}
+		})
+		if err != nil {
+			models.Log.Error("MirakurunStream(%s/%s): Connection error: %v", s.source, s.name, err)
+		}
+		models.Log.Info("MirakurunStream(%s/%s): Retrying connection in %v", s.source, s.name, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.updateState(func(state *models.StreamState) {
+				state.NextRetryAt = 0
+			})
+			models.Log.Info("MirakurunStream(%s/%s): Context cancelled, stopping stream", s.source, s.name)
+			return
+		case <-timer.C:
+		}
+
+		s.updateState(func(state *models.StreamState) {
+			state.Reconnects++
+		})
+	}
+}
+
+// connect は1回分の接続を行い、ストリームが終わるまでイベントを処理する
+// 戻り値の healthy は接続後にイベントを1件以上受信したか、stableAfter 以上接続が続いたかを表す
+func (s *MirakurunStream) connect(ctx context.Context, handle func(raw json.RawMessage) error) (healthy bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("mirakurun returned status %s", resp.Status)
+	}
+
+	models.Log.Info("MirakurunStream(%s/%s): Connected to Mirakurun stream, status: %s", s.source, s.name, resp.Status)
+	connectedAt := time.Now()
+	s.updateState(func(state *models.StreamState) {
+		state.Connected = true
+		state.ConnectedAt = connectedAt.UnixMilli()
+		state.NextRetryAt = 0
+	})
+
+	received := false
+	decoder := newJSONArrayDecoder(resp.Body)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Next(&raw); err != nil {
+			healthy := received || time.Since(connectedAt) >= s.stableAfter
+			if err == io.EOF {
+				models.Log.Info("MirakurunStream(%s/%s): End of stream reached", s.source, s.name)
+				return healthy, nil
+			}
+			return healthy, err
+		}
+		received = true
+
+		s.updateState(func(state *models.StreamState) {
+			state.Events++
+			state.LastEventAt = time.Now().UnixMilli()
+		})
+		captureStreamEvent(s.source, s.name, raw)
+
+		if err := handle(raw); err != nil {
+			return received, err
+		}
+	}
+}
+
+// backoffDelay は attempt 回目の再接続までの待ち時間を返す
+// 待ち時間は min から倍々に増えて max で頭打ちになり、その後半分の範囲でランダムにずらす
+func backoffDelay(attempt int, min, max time.Duration) time.Duration {
+	delay := min
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+var (
+	streamsMu sync.Mutex
+	streams   []*MirakurunStream
+)
+
+// registerStream はストリームを接続状態の一覧に登録する
+func registerStream(s *MirakurunStream) {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+	streams = append(streams, s)
+}
+
+// GetStreamStates は登録されているすべてのストリームの接続状態を取得元・名前順で返す
+func GetStreamStates() []models.StreamState {
+	streamsMu.Lock()
+	defer streamsMu.Unlock()
+
+	states := make([]models.StreamState, 0, len(streams))
+	for _, s := range streams {
+		states = append(states, s.State())
+	}
+	sort.SliceStable(states, func(i, j int) bool {
+		if states[i].Source != states[j].Source {
+			return states[i].Source < states[j].Source
+		}
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestJSONArrayDecoder(t *testing.T) {
+	// Mirakurun は "[\n" に続けて "\n," 区切りでイベントを書き出す。要素が複数行にまたがっていてもよい
+	input := "[\n{\"id\":1}\n,{\"id\":2,\n\"name\":\"a,b\"}\n,\n{\"id\":3}]"
+	decoder := newJSONArrayDecoder(strings.NewReader(input))
+
+	var ids []int64
+	for {
+		var v struct {
+			ID int64 `json:"id"`
+		}
+		err := decoder.Next(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		ids = append(ids, v.ID)
+	}
+	if len(ids) != 3 || ids[2] != 3 {
+		t.Errorf("Expected ids 1, 2, 3, got %v", ids)
+	}
+
+	// 配列が閉じられる前に切断された場合
+	decoder = newJSONArrayDecoder(strings.NewReader("[{\"id\":1},"))
+	var v json.RawMessage
+	if err := decoder.Next(&v); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := decoder.Next(&v); err == nil || err == io.EOF {
+		t.Errorf("Expected truncated stream to be an error, got %v", err)
+	}
+	decoder = newJSONArrayDecoder(strings.NewReader("[{\"id\":1}\n"))
+	decoder.Next(&v)
+	if err := decoder.Next(&v); err == nil || err == io.EOF {
+		t.Errorf("Expected unterminated array to be an error, got %v", err)
+	}
+
+	if err := newJSONArrayDecoder(strings.NewReader(`{"id":1}`)).Next(&v); err == nil {
+		t.Errorf("Expected error for non-array input")
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := backoffDelay(attempt, time.Second, 8*time.Second)
+		want := time.Second << attempt
+		if want > 8*time.Second {
+			want = 8 * time.Second
+		}
+		if delay < want/2 || delay > want {
+			t.Errorf("Attempt %d: expected delay in [%v, %v], got %v", attempt, want/2, want, delay)
+		}
+	}
+}
+
+func TestMirakurunStreamReconnects(t *testing.T) {
+	models.InitLogger("error")
+
+	var requests int32
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 2:
+			// イベントを2件送って切断する
+			w.Write([]byte("[\n{\"resource\":\"program\",\"type\":\"create\"}\n,{\"resource\":\"program\",\"type\":\"update\"}\n"))
+		default:
+			// 接続したままにする
+			w.Write([]byte("[\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer mirakurun.Close()
+
+	stream := NewMirakurunStream("test", "test", mirakurun.URL)
+	stream.minBackoff = time.Millisecond
+	stream.maxBackoff = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var received int32
+	go func() {
+		stream.Run(ctx, func(raw json.RawMessage) error {
+			atomic.AddInt32(&received, 1)
+			return nil
+		})
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !(stream.State().Connected && atomic.LoadInt32(&requests) >= 3) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for reconnect, state: %+v", stream.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	state := stream.State()
+	if state.Reconnects != 2 || state.Events != 2 || state.LastEventAt == 0 || state.LastError == "" {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+	if atomic.LoadInt32(&received) != 2 {
+		t.Errorf("Expected 2 events, got %d", received)
+	}
+
+	found := false
+	for _, s := range GetStreamStates() {
+		found = found || s.Name == "test"
+	}
+	if !found {
+		t.Errorf("Expected stream to be listed in GetStreamStates")
+	}
+
+	// キャンセルすると接続中でもすぐに終了する
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Stream did not stop after cancel")
+	}
+	if stream.State().Connected {
+		t.Errorf("Expected disconnected state after cancel")
+	}
+}
+
+func TestMirakurunStreamBacksOffOnEmptyStream(t *testing.T) {
+	models.InitLogger("error")
+
+	// 接続直後にイベント無しで終わるストリームでは待ち時間をリセットしない
+	var requests int32
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte("[]"))
+	}))
+	defer mirakurun.Close()
+
+	stream := NewMirakurunStream("empty", "test", mirakurun.URL)
+	stream.minBackoff = 10 * time.Millisecond
+	stream.maxBackoff = 80 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	stream.Run(ctx, func(raw json.RawMessage) error { return nil })
+
+	// 待ち時間が 5〜10ms のままなら15回以上接続する。倍々に増えていれば数回で済む
+	if n := atomic.LoadInt32(&requests); n < 2 || n > 8 {
+		t.Errorf("Expected backoff to grow across empty streams, got %d connections", n)
+	}
+}
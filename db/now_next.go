@@ -0,0 +1,69 @@
+// db/now_next.go
+package db
+
+import (
+	"database/sql"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// GetNowAndNext は指定サービスについて、基準時刻に放送中の番組と次の番組を取得する
+// (serviceId, startAt) インデックスを利用して1サービスあたり2回の索引検索で済ませる
+// networkID が0の場合はネットワークを区別しない
+func GetNowAndNext(db *sql.DB, networkID, serviceID int64, at int64) (*models.Program, *models.Program, error) {
+	var current *models.Program
+
+	networkCond := "(? = 0 OR networkId = ? OR networkId IS NULL)"
+	p, err := scanProgram(db.QueryRow(`SELECT `+programColumns+` FROM programs
+		WHERE serviceId = ? AND `+networkCond+` AND startAt <= ? ORDER BY startAt DESC LIMIT 1`,
+		serviceID, networkID, networkID, at))
+	if err != nil && err != sql.ErrNoRows {
+		models.Log.Error("GetNowAndNext: Failed to query current program for service %d: %v", serviceID, err)
+		return nil, nil, err
+	}
+	if err == nil && p.StartAt+p.Duration > at {
+		current = p
+	}
+
+	next, err := scanProgram(db.QueryRow(`SELECT `+programColumns+` FROM programs
+		WHERE serviceId = ? AND `+networkCond+` AND startAt > ? ORDER BY startAt LIMIT 1`,
+		serviceID, networkID, networkID, at))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			models.Log.Error("GetNowAndNext: Failed to query next program for service %d: %v", serviceID, err)
+			return nil, nil, err
+		}
+		next = nil
+	}
+
+	return current, next, nil
+}
+
+// GetNowNextList は除外されていない全サービスについて放送中・次番組の一覧を返す
+// allowedTypes の扱いは GetFilteredServices と同じ
+func GetNowNextList(db *sql.DB, at int64, allowedTypes []int) ([]models.NowNext, error) {
+	models.Log.Debug("GetNowNextList: Building now/next list at %d", at)
+
+	services := GetFilteredServices(db, allowedTypes, []int{192})
+
+	result := make([]models.NowNext, 0, len(services))
+	for _, service := range services {
+		current, next, err := GetNowAndNext(db, service.NetworkID, service.ServiceID, at)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := models.NowNext{
+			Service: service,
+			Current: current,
+			Next:    next,
+		}
+		if current != nil && current.Duration > 0 {
+			entry.ElapsedPercent = float64(at-current.StartAt) * 100 / float64(current.Duration)
+		}
+		result = append(result, entry)
+	}
+
+	models.Log.Info("GetNowNextList: Built now/next entries for %d services", len(result))
+	return result, nil
+}
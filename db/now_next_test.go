@@ -0,0 +1,80 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestGetNowNextList(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	models.ServiceMapInstance = models.NewServiceMap()
+	models.ServiceMapInstance.Add(&models.Service{ServiceID: 101, Name: "局A", Type: 1})
+	models.ServiceMapInstance.Add(&models.Service{ServiceID: 102, Name: "局B", Type: 1})
+	models.ServiceMapInstance.Add(&models.Service{ServiceID: 103, Name: "除外局", Type: 1})
+
+	testPrograms := []struct {
+		id        int64
+		serviceId int64
+		startAt   int64
+		duration  int64
+	}{
+		{1, 101, 1000, 1000}, // 放送終了
+		{2, 101, 2000, 1000}, // 放送中
+		{3, 101, 3000, 1000}, // 次
+		{4, 101, 4000, 1000},
+		{5, 102, 5000, 1000}, // 局Bは放送中なし、次のみ
+		{6, 103, 2000, 1000},
+	}
+	for _, p := range testPrograms {
+		_, err := db.Exec(`INSERT INTO programs (id, serviceId, startAt, duration, name, description, nameForSearch, descForSearch)
+			VALUES (?, ?, ?, ?, 'name', 'desc', 'name', 'desc')`, p.id, p.serviceId, p.startAt, p.duration)
+		if err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+	if err := AddExcludedService(db, 0, 103, "除外局"); err != nil {
+		t.Fatalf("AddExcludedService returned error: %v", err)
+	}
+
+	entries, err := GetNowNextList(db, 2500, nil)
+	if err != nil {
+		t.Fatalf("GetNowNextList returned error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+
+	byService := make(map[int64]models.NowNext)
+	for _, e := range entries {
+		byService[e.Service.ServiceID] = e
+	}
+
+	a := byService[101]
+	if a.Current == nil || a.Current.ID != 2 {
+		t.Errorf("expected current program 2 for service 101, got %+v", a.Current)
+	}
+	if a.Next == nil || a.Next.ID != 3 {
+		t.Errorf("expected next program 3 for service 101, got %+v", a.Next)
+	}
+	if a.ElapsedPercent != 50 {
+		t.Errorf("expected elapsedPercent 50, got %v", a.ElapsedPercent)
+	}
+
+	b := byService[102]
+	if b.Current != nil {
+		t.Errorf("expected no current program for service 102, got %+v", b.Current)
+	}
+	if b.Next == nil || b.Next.ID != 5 {
+		t.Errorf("expected next program 5 for service 102, got %+v", b.Next)
+	}
+
+	if _, ok := byService[103]; ok {
+		t.Errorf("excluded service 103 should not be listed")
+	}
+}
@@ -0,0 +1,87 @@
+// db/people.go
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// GetPersonIndex は放送予定の番組に出演・担当する人物の一覧を番組数の多い順に返す
+// q を指定した場合は名前にその語を含む人物、role を指定した場合は役割にその語を含む人物のみ返す
+// limit が0以下の場合は件数を制限しない
+func GetPersonIndex(db *sql.DB, q, role string, limit int) ([]models.PersonEntry, error) {
+	q = models.NormalizePersonName(q)
+	models.Log.Debug("GetPersonIndex: q=%s, role=%s, limit=%d", q, role, limit)
+
+	query := `SELECT people FROM programs WHERE people IS NOT NULL AND people != ''`
+	var args []interface{}
+	if q != "" {
+		query += ` AND people LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(q)+"%")
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		models.Log.Error("GetPersonIndex: Query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make(map[string]*models.PersonEntry)
+	for rows.Next() {
+		var people string
+		if err := rows.Scan(&people); err != nil {
+			models.Log.Error("GetPersonIndex: Scan error: %v", err)
+			return nil, err
+		}
+		// 1つの番組で複数の役割を持つ人物も番組数は1と数える
+		counted := make(map[string]bool)
+		for _, c := range splitPeople(people) {
+			if (q != "" && !strings.Contains(c.Name, q)) || (role != "" && !strings.Contains(c.Role, role)) {
+				continue
+			}
+			entry, ok := entries[c.Name]
+			if !ok {
+				entry = &models.PersonEntry{Name: c.Name, Roles: []string{}}
+				entries[c.Name] = entry
+			}
+			if !containsString(entry.Roles, c.Role) {
+				entry.Roles = append(entry.Roles, c.Role)
+			}
+			if !counted[c.Name] {
+				counted[c.Name] = true
+				entry.Programs++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]models.PersonEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Programs != result[j].Programs {
+			return result[i].Programs > result[j].Programs
+		}
+		return result[i].Name < result[j].Name
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	models.Log.Debug("GetPersonIndex: Found %d people", len(result))
+	return result, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
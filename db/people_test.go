@@ -0,0 +1,106 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestSearchProgramsByPerson(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	startAt := time.Now().Add(time.Hour).UnixMilli()
+	programs := []models.Program{
+		{ID: 1, ServiceID: 1024, NetworkID: 32736, StartAt: startAt, Duration: 1800000, Name: "ドラマ1",
+			Extended: map[string]string{"出演者": "佐藤　健，長澤まさみ", "監督・演出": "山田太郎"}},
+		{ID: 2, ServiceID: 1024, NetworkID: 32736, StartAt: startAt + 1, Duration: 1800000, Name: "ドラマ2",
+			Extended: map[string]string{"出演者": "佐藤健二", "原作・脚本": "【脚本】佐藤健"}},
+		{ID: 3, ServiceID: 1024, NetworkID: 32736, StartAt: startAt + 2, Duration: 1800000, Name: "ニュース"},
+	}
+	if err := upsertPrograms(db, programs); err != nil {
+		t.Fatalf("Failed to save programs: %v", err)
+	}
+
+	p, err := GetProgramByID(db, 1)
+	if err != nil {
+		t.Fatalf("GetProgramByID failed: %v", err)
+	}
+	if p.Extended["出演者"] != "佐藤　健，長澤まさみ" || len(p.Cast) != 2 || len(p.Staff) != 1 {
+		t.Errorf("Unexpected credits: extended=%v cast=%v staff=%v", p.Extended, p.Cast, p.Staff)
+	}
+
+	// 読み込み時は拡張情報を解析し直さず、保存時に取り出した people を使う
+	if _, err := db.Exec(`UPDATE programs SET people = '|声:山田花子|音楽:鈴木一郎|' WHERE id = 3`); err != nil {
+		t.Fatalf("Failed to update people: %v", err)
+	}
+	news, err := GetProgramByID(db, 3)
+	if err != nil {
+		t.Fatalf("GetProgramByID failed: %v", err)
+	}
+	if len(news.Cast) != 1 || news.Cast[0].Name != "山田花子" || len(news.Staff) != 1 || news.Staff[0].Role != "音楽" {
+		t.Errorf("Expected credits from the people column, got cast=%v staff=%v", news.Cast, news.Staff)
+	}
+	if _, err := db.Exec(`UPDATE programs SET people = NULL WHERE id = 3`); err != nil {
+		t.Fatalf("Failed to reset people: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     SearchOptions
+		expected []int64
+	}{
+		{"person operator", SearchOptions{Query: "person:佐藤健"}, []int64{1, 2}},
+		{"quoted name", SearchOptions{Query: `person:"佐藤 健" ドラマ1`}, []int64{1}},
+		{"exact name", SearchOptions{Query: "person:佐藤健二"}, []int64{2}},
+		{"exclude person", SearchOptions{Query: "-person:長澤まさみ"}, []int64{2, 3}},
+		{"people option", SearchOptions{People: []string{"山田太郎", "長澤まさみ"}}, []int64{1}},
+		{"wildcards are literal", SearchOptions{Query: "person:佐藤_"}, nil},
+		{"percent is literal", SearchOptions{People: []string{"%"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := SearchProgramsWithOptions(db, tt.opts)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var ids []int64
+			for _, p := range results {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+
+	index, err := GetPersonIndex(db, "佐藤", "", 0)
+	if err != nil {
+		t.Fatalf("GetPersonIndex failed: %v", err)
+	}
+	expected := []models.PersonEntry{
+		{Name: "佐藤健", Roles: []string{"出演", "脚本"}, Programs: 2},
+		{Name: "佐藤健二", Roles: []string{"出演"}, Programs: 1},
+	}
+	if !reflect.DeepEqual(index, expected) {
+		t.Errorf("Unexpected person index: %+v", index)
+	}
+
+	if index, err := GetPersonIndex(db, "_", "", 0); err != nil || len(index) != 0 {
+		t.Errorf("Expected no people for a literal underscore, got %+v (%v)", index, err)
+	}
+
+	directors, err := GetPersonIndex(db, "", "監督", 10)
+	if err != nil {
+		t.Fatalf("GetPersonIndex failed: %v", err)
+	}
+	if len(directors) != 1 || directors[0].Name != "山田太郎" {
+		t.Errorf("Unexpected directors: %+v", directors)
+	}
+}
@@ -0,0 +1,393 @@
+// db/program_loader.go
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// InitialLoadBatchSize は初期ロードで1トランザクションにまとめて保存する番組数
+const InitialLoadBatchSize = 500
+
+// programUpsertSQL は番組を programs テーブルに INSERT OR REPLACE する
+const programUpsertSQL = `INSERT OR REPLACE INTO programs (` + programTableColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// programUpsertArgs は検索用の正規化と番組名・拡張情報・属性の解析を行い、programUpsertSQL の引数を返す
+func programUpsertArgs(p *models.Program) []interface{} {
+	p.NameForSearch = models.NormalizeForSearch(p.Name)
+	p.DescForSearch = models.NormalizeForSearch(p.Description)
+	p.ApplyParsedTitle()
+	p.ApplyCredits()
+	p.ApplyAttributes()
+
+	var extended, video, audios interface{}
+	if len(p.Extended) > 0 {
+		if b, err := json.Marshal(p.Extended); err == nil {
+			extended = string(b)
+		}
+	}
+	if p.Video != nil {
+		if b, err := json.Marshal(p.Video); err == nil {
+			video = string(b)
+		}
+	}
+	if len(p.Audios) > 0 {
+		if b, err := json.Marshal(p.Audios); err == nil {
+			audios = string(b)
+		}
+	}
+
+	var seriesId, seriesEpisode, seriesLastEpisode, seriesRepeat, seriesPattern interface{}
+	var seriesName interface{}
+	var seriesExpiresAt interface{}
+	if p.Series != nil {
+		seriesId = p.Series.ID
+		seriesEpisode = p.Series.Episode
+		seriesLastEpisode = p.Series.LastEpisode
+		seriesName = p.Series.Name
+		seriesRepeat = p.Series.Repeat
+		seriesPattern = p.Series.Pattern
+		seriesExpiresAt = p.Series.ExpiresAt
+	}
+
+	return []interface{}{p.ID, p.ServiceID, nullableInt64(p.NetworkID), p.StartAt, p.Duration, p.Name, p.Description, p.NameForSearch, p.DescForSearch,
+		seriesId, seriesEpisode, seriesLastEpisode, seriesName, seriesRepeat, seriesPattern, seriesExpiresAt, nullableBool(p.IsFree),
+		nullableString(p.Source), p.Title, joinTitleFlags(p.Flags), p.EpisodeNumber, p.Subtitle,
+		extended, nullableString(joinPeople(p.Credits())), video, audios, joinTitleFlags(p.Attributes)}
+}
+
+var (
+	initialLoadMu       sync.Mutex
+	initialLoadProgress = make(map[string]*models.InitialLoadProgress)
+)
+
+// GetInitialLoadProgress は取得元ごとの番組情報の初期ロードの進捗を返す
+func GetInitialLoadProgress(source string) models.InitialLoadProgress {
+	initialLoadMu.Lock()
+	defer initialLoadMu.Unlock()
+	if p, ok := initialLoadProgress[source]; ok {
+		return *p
+	}
+	return models.InitialLoadProgress{Source: source, State: models.InitialLoadIdle}
+}
+
+// GetAllInitialLoadProgress は稼働中のすべての取得元の初期ロードの進捗を取得元の設定順に返す
+// XMLTV の取り込みなど、Mirakurun 以外の取得元の進捗はその後に名前順で返す
+func GetAllInitialLoadProgress() []models.InitialLoadProgress {
+	sources := models.GetMirakurunSources()
+	progress := make([]models.InitialLoadProgress, 0, len(sources))
+	configured := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		progress = append(progress, GetInitialLoadProgress(source.Name))
+		configured[source.Name] = true
+	}
+
+	initialLoadMu.Lock()
+	var others []models.InitialLoadProgress
+	for name, p := range initialLoadProgress {
+		if !configured[name] {
+			others = append(others, *p)
+		}
+	}
+	initialLoadMu.Unlock()
+	sort.Slice(others, func(i, j int) bool { return others[i].Source < others[j].Source })
+	return append(progress, others...)
+}
+
+// updateInitialLoadProgress は取得元の初期ロードの進捗を更新する
+func updateInitialLoadProgress(source string, update func(p *models.InitialLoadProgress)) {
+	initialLoadMu.Lock()
+	defer initialLoadMu.Unlock()
+	p, ok := initialLoadProgress[source]
+	if !ok {
+		p = &models.InitialLoadProgress{Source: source}
+		initialLoadProgress[source] = p
+	}
+	update(p)
+	p.UpdatedAt = time.Now().UnixMilli()
+}
+
+// finishInitialLoadProgress はロードの結果を取得元の初期ロードの進捗に記録する
+func finishInitialLoadProgress(ctx context.Context, source string, err error) {
+	updateInitialLoadProgress(source, func(p *models.InitialLoadProgress) {
+		p.FinishedAt = time.Now().UnixMilli()
+		switch {
+		case err == nil:
+			p.State = models.InitialLoadCompleted
+		case ctx.Err() != nil:
+			p.State = models.InitialLoadCanceled
+			p.Error = ctx.Err().Error()
+		default:
+			p.State = models.InitialLoadFailed
+			p.Error = err.Error()
+		}
+	})
+}
+
+// programsAPIURL はMirakurunのベースURLから /api/programs のURLを組み立てる
+func programsAPIURL(mirakurunBaseURL string) string {
+	apiURL := mirakurunBaseURL
+	if !strings.HasPrefix(apiURL, "http") {
+		apiURL = "http://" + apiURL
+	}
+
+	// URLが/apiで終わっていたら/programsを追加
+	if strings.HasSuffix(apiURL, "/api") {
+		apiURL += "/programs"
+	} else if !strings.HasSuffix(apiURL, "/programs") {
+		// URLが/api/programsで終わっていなければ調整
+		if !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		if !strings.Contains(apiURL, "/api/") {
+			apiURL += "api/programs"
+		} else if !strings.Contains(apiURL, "/programs") {
+			apiURL += "programs"
+		}
+	}
+	return apiURL
+}
+
+// InitProgramsFromAPI は、Mirakurunの/api/programsエンドポイントから
+// 全番組情報を取得してDBを更新する
+// レスポンスはストリームとして読み込み、InitialLoadBatchSize件ごとに保存するので、
+// ロード中も既存の番組は検索できる。すべて受信できた場合のみ、この取得元から消えた番組を削除する
+func InitProgramsFromAPI(ctx context.Context, db *sql.DB, source models.MirakurunSource) (err error) {
+	apiURL := programsAPIURL(source.URL)
+	models.Log.Debug("InitProgramsFromAPI: Constructed URL: %s from base URL: %s (source %s)", apiURL, source.URL, source.Name)
+
+	updateInitialLoadProgress(source.Name, func(p *models.InitialLoadProgress) {
+		*p = models.InitialLoadProgress{Source: source.Name, State: models.InitialLoadRunning, StartedAt: time.Now().UnixMilli()}
+	})
+	defer func() {
+		finishInitialLoadProgress(ctx, source.Name, err)
+	}()
+
+	models.Log.Info("InitProgramsFromAPI: Fetching all programs from: %s", apiURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		models.Log.Error("InitProgramsFromAPI: Failed to create request: %v", err)
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		models.Log.Error("InitProgramsFromAPI: Request failed: %v", err)
+		return contextError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		models.Log.Error("InitProgramsFromAPI: API returned non-OK status: %s", resp.Status)
+		return fmt.Errorf("mirakurun returned status %s", resp.Status)
+	}
+
+	models.Log.Info("InitProgramsFromAPI: Connected to Mirakurun API, status: %s", resp.Status)
+
+	return loadPrograms(ctx, db, source.Name, resp.Body)
+}
+
+// loadPrograms は番組のJSON配列を読み込みながら、取得元の番組としてDBに保存する
+func loadPrograms(ctx context.Context, db *sql.DB, source string, r io.Reader) error {
+	decoder := newJSONArrayDecoder(r)
+	seen := make(map[int64]struct{})
+	batch := make([]models.Program, 0, InitialLoadBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := upsertPrograms(db, batch); err != nil {
+			return err
+		}
+		updateInitialLoadProgress(source, func(p *models.InitialLoadProgress) {
+			p.Received += len(batch)
+		})
+		models.Log.Info("InitProgramsFromAPI: Saved %d programs", len(seen))
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			models.Log.Info("InitProgramsFromAPI: Canceled after %d programs", len(seen)-len(batch))
+			return err
+		}
+		var p models.Program
+		if err := decoder.Next(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			models.Log.Error("InitProgramsFromAPI: JSON decode error: %v", err)
+			return contextError(ctx, err)
+		}
+		p.Source = source
+		seen[p.ID] = struct{}{}
+		batch = append(batch, p)
+		if len(batch) == InitialLoadBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// 空の応答で全番組を消してしまわないよう、1件も受信できなかった場合は削除しない
+	if len(seen) == 0 {
+		models.Log.Info("InitProgramsFromAPI: No programs received, keeping existing programs")
+		return nil
+	}
+
+	removed, archived, err := removeVanishedPrograms(db, source, seen, true)
+	if err != nil {
+		models.Log.Error("InitProgramsFromAPI: Failed to remove vanished programs: %v", err)
+		return err
+	}
+	updateInitialLoadProgress(source, func(p *models.InitialLoadProgress) {
+		p.Removed = int(removed)
+		p.Archived = int(archived)
+	})
+
+	models.Log.Info("InitProgramsFromAPI: Loaded %d programs (removed %d, archived %d)", len(seen), removed, archived)
+	return nil
+}
+
+// ReplacePrograms は取得元の番組を programs で置き換える
+// 初期ロードと同じくまとめて保存し、programs に無い取得元の番組は削除（放送済みの番組はアーカイブ）する
+func ReplacePrograms(db *sql.DB, source string, programs []models.Program) error {
+	seen := make(map[int64]struct{}, len(programs))
+	for start := 0; start < len(programs); start += InitialLoadBatchSize {
+		end := start + InitialLoadBatchSize
+		if end > len(programs) {
+			end = len(programs)
+		}
+		batch := programs[start:end]
+		for i := range batch {
+			batch[i].Source = source
+			seen[batch[i].ID] = struct{}{}
+		}
+		if err := upsertPrograms(db, batch); err != nil {
+			return err
+		}
+	}
+
+	removed, archived, err := removeVanishedPrograms(db, source, seen, false)
+	if err != nil {
+		return err
+	}
+	models.Log.Info("ReplacePrograms: Saved %d programs from %s (removed %d, archived %d)", len(seen), source, removed, archived)
+	return nil
+}
+
+// contextError はコンテキストがキャンセルされていればその理由を、そうでなければ err を返す
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// upsertPrograms は番組をまとめて1トランザクションで保存する
+func upsertPrograms(db *sql.DB, programs []models.Program) error {
+	tx, err := db.Begin()
+	if err != nil {
+		models.Log.Error("InitProgramsFromAPI: Failed to begin transaction: %v", err)
+		return err
+	}
+
+	stmt, err := tx.Prepare(programUpsertSQL)
+	if err != nil {
+		tx.Rollback()
+		models.Log.Error("InitProgramsFromAPI: Failed to prepare programs upsert statement: %v", err)
+		return err
+	}
+	defer stmt.Close()
+
+	for i := range programs {
+		if _, err := stmt.Exec(programUpsertArgs(&programs[i])...); err != nil {
+			tx.Rollback()
+			models.Log.Error("InitProgramsFromAPI: Failed to save program %d: %v", programs[i].ID, err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		models.Log.Error("InitProgramsFromAPI: Failed to commit transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
+// removeVanishedPrograms は放送済みの番組をアーカイブに移し、
+// 残りのうち取得元の番組で seen に含まれないもの（Mirakurunから消えた番組）を削除する
+// includeLegacy の場合は取得元が記録されていない旧バージョンの番組（Mirakurun から取得したもの）も対象にする
+func removeVanishedPrograms(db *sql.DB, source string, seen map[int64]struct{}, includeLegacy bool) (removed, archived int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if archived, err = archiveEndedPrograms(tx, time.Now().UnixMilli()); err != nil {
+		return 0, 0, err
+	}
+
+	query := `SELECT id FROM programs WHERE source = ?`
+	if includeLegacy {
+		query += ` OR source IS NULL`
+	}
+	rows, err := tx.Query(query, source)
+	if err != nil {
+		return 0, 0, err
+	}
+	var vanished []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if _, ok := seen[id]; !ok {
+			vanished = append(vanished, id)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	stmt, err := tx.Prepare(`DELETE FROM programs WHERE id = ?`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+	for _, id := range vanished {
+		if _, err = stmt.Exec(id); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return int64(len(vanished)), archived, nil
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestInitProgramsFromAPIIncremental(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	insert := func(id int64, startAt time.Time, name string) {
+		if _, err := db.Exec(`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch)
+			VALUES (?, 101, 4, ?, 1800000, ?, '', ?, '')`, id, startAt.UnixMilli(), name, name); err != nil {
+			t.Fatalf("Failed to insert program: %v", err)
+		}
+	}
+	insert(1, now.Add(time.Hour), "cancelled") // Mirakurunから消えた番組
+	insert(2, now.Add(-2*time.Hour), "ended")  // 放送済み
+	insert(3, now.Add(2*time.Hour), "before")  // 更新される番組
+
+	// バッチの境界をまたぐ件数を返す
+	count := InitialLoadBatchSize + 10
+	var body strings.Builder
+	body.WriteString("[")
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(&body, `{"id":%d,"serviceId":101,"networkId":4,"startAt":%d,"duration":1800000,"name":"Ｎｅｗｓ %d"}`,
+			i+3, now.Add(time.Duration(i+2)*time.Hour).UnixMilli(), i)
+	}
+	body.WriteString("]")
+
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/programs" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body.String()))
+	}))
+	defer mirakurun.Close()
+
+	if err := InitProgramsFromAPI(context.Background(), db, models.MirakurunSource{Name: models.DefaultSourceName, URL: mirakurun.URL + "/api"}); err != nil {
+		t.Fatalf("InitProgramsFromAPI failed: %v", err)
+	}
+
+	var total int
+	db.QueryRow(`SELECT COUNT(*) FROM programs`).Scan(&total)
+	if total != count {
+		t.Errorf("Expected %d programs, got %d", count, total)
+	}
+	if _, err := GetProgramByID(db, 1); err == nil {
+		t.Errorf("Expected vanished program 1 to be removed")
+	}
+	if p, err := GetProgramByID(db, 2); err != nil || p.Name != "ended" {
+		t.Errorf("Expected ended program 2 to be archived, got %+v (%v)", p, err)
+	}
+	if p, err := GetProgramByID(db, 3); err != nil || p.Name != "Ｎｅｗｓ 0" {
+		t.Errorf("Expected program 3 to be updated, got %+v (%v)", p, err)
+	}
+	if programs, _ := SearchPrograms(db, "news", 0, 0, 0, 0); len(programs) != count {
+		t.Errorf("Expected %d programs normalized for search, got %d", count, len(programs))
+	}
+
+	progress := GetInitialLoadProgress(models.DefaultSourceName)
+	if progress.State != models.InitialLoadCompleted || progress.Received != count ||
+		progress.Removed != 1 || progress.Archived != 1 || progress.FinishedAt == 0 {
+		t.Errorf("Unexpected progress: %+v", progress)
+	}
+}
+
+func TestInitProgramsFromAPIKeepsProgramsOnFailure(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO programs (id, serviceId, startAt, duration, name, description, nameForSearch, descForSearch)
+		VALUES (1, 101, ?, 1800000, 'news', '', 'news', '')`, time.Now().Add(time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("Failed to insert program: %v", err)
+	}
+
+	response := `[]`
+	status := http.StatusOK
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	defer mirakurun.Close()
+	source := models.MirakurunSource{Name: models.DefaultSourceName, URL: mirakurun.URL}
+
+	remaining := func() int {
+		var n int
+		db.QueryRow(`SELECT COUNT(*) FROM programs`).Scan(&n)
+		return n
+	}
+
+	// 空の応答では既存の番組を消さない
+	if err := InitProgramsFromAPI(context.Background(), db, source); err != nil {
+		t.Errorf("Expected empty response to succeed, got %v", err)
+	}
+	if remaining() != 1 {
+		t.Errorf("Expected existing program to be kept on empty response")
+	}
+
+	// 途中で途切れた応答では削除しない
+	response = `[{"id":2,"serviceId":101,"startAt":0,"duration":1,"name":"x"},{"id":3,`
+	if err := InitProgramsFromAPI(context.Background(), db, source); err == nil {
+		t.Errorf("Expected truncated response to fail")
+	}
+	if remaining() != 1 || GetInitialLoadProgress(models.DefaultSourceName).State != models.InitialLoadFailed {
+		t.Errorf("Expected programs to be kept on truncated response, got %d programs, progress %+v",
+			remaining(), GetInitialLoadProgress(models.DefaultSourceName))
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := InitProgramsFromAPI(context.Background(), db, source); err == nil {
+		t.Errorf("Expected error on non-OK status")
+	}
+
+	// キャンセル済みのコンテキストではロードしない
+	status = http.StatusOK
+	response = `[{"id":4,"serviceId":101,"startAt":0,"duration":1,"name":"x"}]`
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := InitProgramsFromAPI(ctx, db, source); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if remaining() != 1 || GetInitialLoadProgress(models.DefaultSourceName).State != models.InitialLoadCanceled {
+		t.Errorf("Expected canceled load to keep programs, got %d programs, progress %+v",
+			remaining(), GetInitialLoadProgress(models.DefaultSourceName))
+	}
+}
+
+func TestInitProgramsFromAPIKeepsOtherSources(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	startAt := time.Now().Add(time.Hour).UnixMilli()
+	responses := map[string]string{
+		"/gr/api/programs": fmt.Sprintf(`[{"id":1,"serviceId":101,"startAt":%d,"duration":1800000,"name":"gr"}]`, startAt),
+		"/bs/api/programs": fmt.Sprintf(`[{"id":2,"serviceId":201,"startAt":%d,"duration":1800000,"name":"bs"}]`, startAt),
+	}
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(responses[r.URL.Path]))
+	}))
+	defer mirakurun.Close()
+
+	gr := models.MirakurunSource{Name: "gr", URL: mirakurun.URL + "/gr/api"}
+	bs := models.MirakurunSource{Name: "bs", URL: mirakurun.URL + "/bs/api"}
+	for _, source := range []models.MirakurunSource{gr, bs} {
+		if err := InitProgramsFromAPI(context.Background(), db, source); err != nil {
+			t.Fatalf("InitProgramsFromAPI(%s) failed: %v", source.Name, err)
+		}
+	}
+
+	// 別の取得元の番組はロードしても削除されない
+	for id, source := range map[int64]string{1: "gr", 2: "bs"} {
+		p, err := GetProgramByID(db, id)
+		if err != nil {
+			t.Fatalf("Expected program %d to remain: %v", id, err)
+		}
+		if p.Source != source {
+			t.Errorf("Expected program %d to have source %s, got %q", id, source, p.Source)
+		}
+	}
+
+	// 取得元を指定した削除は、別の取得元の番組には影響しない
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := removeProgramTx(tx, 2, "gr", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("removeProgramTx failed: %v", err)
+	}
+	if err := removeProgramTx(tx, 1, "gr", time.Now().UnixMilli()); err != nil {
+		t.Fatalf("removeProgramTx failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if _, err := GetProgramByID(db, 2); err != nil {
+		t.Errorf("Expected bs program to remain after removal from gr: %v", err)
+	}
+	if _, err := GetProgramByID(db, 1); err == nil {
+		t.Error("Expected gr program to be removed")
+	}
+}
@@ -0,0 +1,198 @@
+// db/program_writer.go
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/fuba/iepg-server/events"
+	"github.com/fuba/iepg-server/models"
+)
+
+const (
+	// DefaultWriterBatchSize は1トランザクションにまとめる書き込みの最大数
+	DefaultWriterBatchSize = 200
+	// DefaultWriterFlushInterval は最初の書き込みを受け取ってからコミットするまでの最大の待ち時間
+	DefaultWriterFlushInterval = 500 * time.Millisecond
+	// DefaultWriterQueueSize は書き込みキューの容量。一杯になるとストリームの読み込みを待たせる
+	DefaultWriterQueueSize = 10000
+)
+
+// programWrite はキューに積まれる1件分の番組イベント
+type programWrite struct {
+	eventType string // "create", "update", "remove"
+	program   models.Program
+}
+
+// ProgramWriter は番組ストリームのDB書き込みを1つのゴルーチンに集約し、
+// 件数または時間で区切ってまとめて1トランザクションで書き込む
+type ProgramWriter struct {
+	db            *sql.DB
+	queue         chan programWrite
+	batchSize     int
+	flushInterval time.Duration
+
+	mu    sync.Mutex
+	stats models.ProgramWriterStats
+}
+
+// NewProgramWriter は新しいProgramWriterを作成する。書き込みは Run を呼ぶまで始まらない
+func NewProgramWriter(db *sql.DB) *ProgramWriter {
+	return &ProgramWriter{
+		db:            db,
+		queue:         make(chan programWrite, DefaultWriterQueueSize),
+		batchSize:     DefaultWriterBatchSize,
+		flushInterval: DefaultWriterFlushInterval,
+	}
+}
+
+// Enqueue は番組イベントを書き込みキューに追加する。キューが一杯の場合は空くまで待つ
+func (w *ProgramWriter) Enqueue(ctx context.Context, eventType string, p models.Program) error {
+	select {
+	case w.queue <- programWrite{eventType: eventType, program: p}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	depth := len(w.queue)
+	w.mu.Lock()
+	if depth > w.stats.MaxQueueDepth {
+		w.stats.MaxQueueDepth = depth
+	}
+	w.mu.Unlock()
+	return nil
+}
+
+// Stats は書き込みキューの状態を返す
+func (w *ProgramWriter) Stats() models.ProgramWriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.QueueDepth = len(w.queue)
+	stats.QueueCapacity = cap(w.queue)
+	return stats
+}
+
+// Run はキューから番組イベントを取り出してDBに書き込む。ctx が終了するとキューの残りを書き込んで戻る
+func (w *ProgramWriter) Run(ctx context.Context) {
+	models.Log.Debug("ProgramWriter: Starting with batch size %d, flush interval %v", w.batchSize, w.flushInterval)
+
+	batch := make([]programWrite, 0, w.batchSize)
+	timer := time.NewTimer(w.flushInterval)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() {
+		timer.Stop()
+		w.flush(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case item := <-w.queue:
+					batch = append(batch, item)
+					if len(batch) >= w.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					models.Log.Info("ProgramWriter: Stopped")
+					return
+				}
+			}
+		case item := <-w.queue:
+			if len(batch) == 0 {
+				timer.Reset(w.flushInterval)
+			}
+			batch = append(batch, item)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// flush はまとめた番組イベントを書き込み、成功した場合は変更通知を発行する
+// まとめての書き込みに失敗した場合は1件ずつ書き込み直し、失敗した番組イベントのみを捨てる
+func (w *ProgramWriter) flush(batch []programWrite) {
+	if len(batch) == 0 {
+		return
+	}
+
+	written := batch
+	if err := w.writeBatch(batch); err != nil {
+		models.Log.Error("ProgramWriter: Failed to write %d program events, retrying one by one: %v", len(batch), err)
+		written = make([]programWrite, 0, len(batch))
+		for i := range batch {
+			if err := w.writeBatch(batch[i : i+1]); err != nil {
+				models.Log.Error("ProgramWriter: Failed to %s program %d: %v", batch[i].eventType, batch[i].program.ID, err)
+				continue
+			}
+			written = append(written, batch[i])
+		}
+	}
+
+	w.mu.Lock()
+	w.stats.Failed += int64(len(batch) - len(written))
+	if len(written) > 0 {
+		w.stats.Written += int64(len(written))
+		w.stats.Batches++
+		w.stats.LastBatchSize = len(written)
+		w.stats.LastFlushAt = time.Now().UnixMilli()
+	}
+	w.mu.Unlock()
+
+	if len(written) == 0 {
+		return
+	}
+	models.Log.Debug("ProgramWriter: Wrote %d program events", len(written))
+
+	for _, item := range written {
+		if item.eventType == "remove" {
+			events.Publish(events.ResourceProgram, item.eventType, map[string]int64{"id": item.program.ID})
+		} else {
+			events.Publish(events.ResourceProgram, item.eventType, item.program)
+		}
+	}
+}
+
+// writeBatch は番組イベントを受信順に1トランザクションで書き込む
+func (w *ProgramWriter) writeBatch(batch []programWrite) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(programUpsertSQL)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UnixMilli()
+	for i := range batch {
+		item := &batch[i]
+		if item.eventType == "remove" {
+			// 放送済みの番組はアーカイブに移す
+			err = removeProgramTx(tx, item.program.ID, item.program.Source, now)
+		} else {
+			// 検索用の正規化を行って INSERT OR REPLACE
+			_, err = stmt.Exec(programUpsertArgs(&item.program)...)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/events"
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestProgramWriterBatches(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	sub, _ := events.Default.Subscribe([]string{events.ResourceProgram}, 0)
+	defer sub.Close()
+
+	writer := NewProgramWriter(db)
+	writer.batchSize = 3
+	writer.flushInterval = time.Hour // 件数でのみ区切る
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writer.Run(ctx)
+		close(done)
+	}()
+
+	startAt := time.Now().Add(time.Hour).UnixMilli()
+	for id := int64(1); id <= 4; id++ {
+		writer.Enqueue(ctx, "create", models.Program{ID: id, ServiceID: 101, StartAt: startAt, Duration: 1800000, Name: "ニュース"})
+	}
+	writer.Enqueue(ctx, "update", models.Program{ID: 1, ServiceID: 101, StartAt: startAt, Duration: 1800000, Name: "ＮＥＷＳ"})
+	writer.Enqueue(ctx, "remove", models.Program{ID: 2})
+	writer.Enqueue(ctx, "create", models.Program{ID: 5, ServiceID: 101, StartAt: startAt, Duration: 1800000, Name: "天気"})
+
+	// 終了時にキューの残りも書き込まれる
+	cancel()
+	<-done
+
+	if p, err := GetProgramByID(db, 1); err != nil || p.Name != "ＮＥＷＳ" {
+		t.Errorf("Expected updated program 1, got %+v (%v)", p, err)
+	}
+	if _, err := GetProgramByID(db, 2); err == nil {
+		t.Errorf("Expected program 2 to be removed")
+	}
+	if programs, _ := SearchPrograms(db, "news", 0, 0, 0, 0); len(programs) != 1 {
+		t.Errorf("Expected normalized program to be searchable, got %d", len(programs))
+	}
+
+	stats := writer.Stats()
+	if stats.Written != 7 || stats.Batches != 3 || stats.Failed != 0 || stats.QueueDepth != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.QueueCapacity != DefaultWriterQueueSize || stats.MaxQueueDepth == 0 {
+		t.Errorf("Unexpected queue stats: %+v", stats)
+	}
+
+	// 変更通知は受信順に発行される
+	want := []string{"create", "create", "create", "create", "update", "remove", "create"}
+	for i, eventType := range want {
+		select {
+		case e := <-sub.C:
+			if e.Type != eventType {
+				t.Errorf("Event %d: expected %s, got %s", i, eventType, e.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %d", i)
+		}
+	}
+}
+
+func TestProgramWriterFlushInterval(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	writer := NewProgramWriter(db)
+	writer.flushInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go writer.Run(ctx)
+
+	writer.Enqueue(ctx, "create", models.Program{ID: 1, ServiceID: 101, StartAt: time.Now().UnixMilli(), Duration: 1800000, Name: "news"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for writer.Stats().Written != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a partial batch to be written after the flush interval, got %+v", writer.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := GetProgramByID(db, 1); err != nil {
+		t.Errorf("Expected program 1 to be written: %v", err)
+	}
+}
+
+func TestProgramWriterRetriesFailedBatch(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	// 番組2の書き込みだけを失敗させる
+	if _, err := db.Exec(`CREATE TRIGGER reject_program BEFORE INSERT ON programs WHEN NEW.id = 2
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+
+	writer := NewProgramWriter(db)
+	startAt := time.Now().Add(time.Hour).UnixMilli()
+	var batch []programWrite
+	for id := int64(1); id <= 3; id++ {
+		batch = append(batch, programWrite{eventType: "create", program: models.Program{ID: id, ServiceID: 101, StartAt: startAt, Duration: 1800000, Name: "ニュース"}})
+	}
+	writer.flush(batch)
+
+	for _, id := range []int64{1, 3} {
+		if _, err := GetProgramByID(db, id); err != nil {
+			t.Errorf("Expected program %d to be written after retry: %v", id, err)
+		}
+	}
+	if _, err := GetProgramByID(db, 2); err == nil {
+		t.Errorf("Expected rejected program 2 not to be written")
+	}
+	if stats := writer.Stats(); stats.Written != 2 || stats.Failed != 1 || stats.LastBatchSize != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,92 @@
+// db/reservation.go
+package db
+
+import (
+	"database/sql"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// reservationColumns は予約を取得する際のSELECT対象カラム（scanReservationと順序を揃える）
+const reservationColumns = `id, programId, serviceId, networkId, name, startAt, duration,
+	recorderUrl, recorderProgramId, status, createdAt, updatedAt, error, source`
+
+// scanReservation は reservationColumns の順で取得した行を Reservation に変換する
+func scanReservation(row rowScanner) (*models.Reservation, error) {
+	var r models.Reservation
+	var errorStr, source sql.NullString
+	var networkID sql.NullInt64
+
+	if err := row.Scan(&r.ID, &r.ProgramID, &r.ServiceID, &networkID, &r.Name, &r.StartAt, &r.Duration,
+		&r.RecorderURL, &r.RecorderProgramID, &r.Status, &r.CreatedAt, &r.UpdatedAt, &errorStr, &source); err != nil {
+		return nil, err
+	}
+	r.Error = errorStr.String
+	r.Source = source.String
+	r.NetworkID = networkID.Int64
+
+	return &r, nil
+}
+
+// GetReservationByID は指定されたIDの予約を取得する
+func GetReservationByID(db *sql.DB, id string) (*models.Reservation, error) {
+	r, err := scanReservation(db.QueryRow(`SELECT `+reservationColumns+` FROM reservations WHERE id = ?`, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			models.Log.Error("GetReservationByID: Query error: %v", err)
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// InsertReservation は予約を保存する
+func InsertReservation(db *sql.DB, r *models.Reservation) error {
+	_, err := db.Exec(`
+		INSERT INTO reservations (id, programId, serviceId, networkId, name, startAt, duration, 
+			recorderUrl, recorderProgramId, status, createdAt, updatedAt, source)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.ProgramID, r.ServiceID, nullableInt64(r.NetworkID), r.Name,
+		r.StartAt, r.Duration, r.RecorderURL,
+		r.RecorderProgramID, r.Status, r.CreatedAt, r.UpdatedAt, nullableString(r.Source))
+	if err != nil {
+		models.Log.Error("InsertReservation: Failed to save reservation: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetReservations は予約の一覧を開始時刻の新しい順に取得する
+func GetReservations(db *sql.DB) ([]models.Reservation, error) {
+	rows, err := db.Query(`SELECT ` + reservationColumns + ` FROM reservations ORDER BY startAt DESC`)
+	if err != nil {
+		models.Log.Error("GetReservations: Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reservations []models.Reservation
+	for rows.Next() {
+		r, err := scanReservation(rows)
+		if err != nil {
+			models.Log.Error("GetReservations: Scan failed: %v", err)
+			continue
+		}
+		reservations = append(reservations, *r)
+	}
+
+	return reservations, nil
+}
+
+// DeleteReservation は予約を削除する。該当する予約がない場合は sql.ErrNoRows を返す
+func DeleteReservation(db *sql.DB, id string) error {
+	result, err := db.Exec("DELETE FROM reservations WHERE id = ?", id)
+	if err != nil {
+		models.Log.Error("DeleteReservation: Delete failed: %v", err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// db/search_bench_test.go
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/testing/epggen"
+)
+
+// setupBenchmarkDB は合成した1週間分の番組表を保存したデータベースを返す
+func setupBenchmarkDB(b *testing.B, services int) *sql.DB {
+	b.Helper()
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		b.Fatalf("Failed to initialize benchmark database: %v", err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	data := epggen.Generate(epggen.Config{Services: services, Start: time.Now().Truncate(time.Hour), Source: "bench"})
+	if err := SaveServices(db, data.Services); err != nil {
+		b.Fatalf("Failed to save services: %v", err)
+	}
+	for _, s := range data.Services {
+		models.ServiceMapInstance.Update(s)
+	}
+	if err := ReplacePrograms(db, "bench", data.Programs); err != nil {
+		b.Fatalf("Failed to save programs: %v", err)
+	}
+	return db
+}
+
+func BenchmarkSearchPrograms(b *testing.B) {
+	db := setupBenchmarkDB(b, 30)
+	weekAhead := time.Now().Add(7 * 24 * time.Hour).UnixMilli()
+
+	benchmarks := []struct {
+		name string
+		opts SearchOptions
+	}{
+		{"Keyword", SearchOptions{Query: "ドラマ"}},
+		{"FullWidthKeyword", SearchOptions{Query: "ＮＥＷＳ"}},
+		{"MultipleKeywords", SearchOptions{Query: "刑事 事件"}},
+		{"Phrase", SearchOptions{Query: `"雨の日の決断"`}},
+		{"Exclude", SearchOptions{Query: "アニメ -再"}},
+		{"ChannelType", SearchOptions{Query: "ニュース", ChannelType: 2}},
+		{"TimeRange", SearchOptions{StartFrom: time.Now().UnixMilli(), StartTo: weekAhead}},
+		{"IncludePast", SearchOptions{Query: "ドラマ", IncludePast: true}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := SearchProgramsWithOptions(db, bm.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetProgramByID(b *testing.B) {
+	db := setupBenchmarkDB(b, 12)
+	var ids []int64
+	rows, err := db.Query(`SELECT id FROM programs`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetProgramByID(db, ids[i%len(ids)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReplacePrograms(b *testing.B) {
+	db := setupBenchmarkDB(b, 12)
+	data := epggen.Generate(epggen.Config{Services: 12, Start: time.Now().Truncate(time.Hour), Source: "bench"})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ReplacePrograms(db, "bench", data.Programs); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(data.Programs)), "programs/op")
+}
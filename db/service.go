@@ -0,0 +1,163 @@
+// db/service.go
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+const serviceColumns = `id, serviceId, networkId, name, type, logoId, hasLogoData, remoteControlKeyId,
+	channelType, channelNumber, channelName, channelTsmfRelTs, firstSeenAt, lastSeenAt, source`
+
+// scanService は serviceColumns の順で取得した行を Service に変換する
+func scanService(row rowScanner) (*models.Service, error) {
+	var s models.Service
+	var id, networkID, logoID, remoteControlKeyID, tsmfRel sql.NullInt64
+	var hasLogoData sql.NullBool
+	var name, channelType, channelNumber, channelName, source sql.NullString
+	var serviceType sql.NullInt64
+
+	if err := row.Scan(&id, &s.ServiceID, &networkID, &name, &serviceType, &logoID, &hasLogoData,
+		&remoteControlKeyID, &channelType, &channelNumber, &channelName, &tsmfRel,
+		&s.FirstSeenAt, &s.LastSeenAt, &source); err != nil {
+		return nil, err
+	}
+
+	s.ID = id.Int64
+	s.NetworkID = networkID.Int64
+	s.Name = name.String
+	s.Type = int(serviceType.Int64)
+	s.LogoID = int(logoID.Int64)
+	s.HasLogoData = hasLogoData.Bool
+	s.RemoteControlKeyID = int(remoteControlKeyID.Int64)
+	s.ChannelType = channelType.String
+	s.ChannelNumber = channelNumber.String
+	s.ChannelName = channelName.String
+	s.ChannelTSMFRel = int(tsmfRel.Int64)
+	s.Source = source.String
+	s.SetLogoURL()
+
+	return &s, nil
+}
+
+// execer は *sql.DB と *sql.Tx の共通インターフェース
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// saveService はサービスを保存し、FirstSeenAt/LastSeenAt を設定する
+func saveService(e execer, service *models.Service, seenAt int64) error {
+	var firstSeenAt int64
+	err := e.QueryRow("SELECT firstSeenAt FROM services WHERE networkId = ? AND serviceId = ?",
+		service.NetworkID, service.ServiceID).Scan(&firstSeenAt)
+	if err == sql.ErrNoRows {
+		firstSeenAt = seenAt
+	} else if err != nil {
+		return err
+	}
+
+	_, err = e.Exec(`
+		INSERT OR REPLACE INTO services (`+serviceColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		service.ID, service.ServiceID, service.NetworkID, service.Name, service.Type, service.LogoID,
+		service.HasLogoData, service.RemoteControlKeyID, service.ChannelType, service.ChannelNumber,
+		service.ChannelName, service.ChannelTSMFRel, firstSeenAt, seenAt, nullableString(service.Source))
+	if err != nil {
+		return err
+	}
+
+	service.FirstSeenAt = firstSeenAt
+	service.LastSeenAt = seenAt
+	return nil
+}
+
+// SaveService はサービスを services テーブルに保存する
+func SaveService(db *sql.DB, service *models.Service) error {
+	if err := saveService(db, service, time.Now().UnixMilli()); err != nil {
+		models.Log.Error("SaveService: Failed to save service %d: %v", service.ServiceID, err)
+		return err
+	}
+	return nil
+}
+
+// SaveServices は複数のサービスを1つのトランザクションで保存する
+func SaveServices(db *sql.DB, services []*models.Service) error {
+	tx, err := db.Begin()
+	if err != nil {
+		models.Log.Error("SaveServices: Failed to begin transaction: %v", err)
+		return err
+	}
+
+	seenAt := time.Now().UnixMilli()
+	for _, service := range services {
+		if err := saveService(tx, service, seenAt); err != nil {
+			models.Log.Error("SaveServices: Failed to save service %d: %v", service.ServiceID, err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		models.Log.Error("SaveServices: Failed to commit transaction: %v", err)
+		return err
+	}
+	models.Log.Debug("SaveServices: Saved %d services", len(services))
+	return nil
+}
+
+// DeleteService はサービスを services テーブルから削除する
+// source を指定した場合は別の取得元のサービスを削除しない（取得元が記録されていない旧データは削除する）
+func DeleteService(db *sql.DB, networkID, serviceID int64, source string) error {
+	cond := "networkId = ? AND serviceId = ?"
+	args := []interface{}{networkID, serviceID}
+	if source != "" {
+		cond += " AND (source = ? OR source IS NULL OR source = '')"
+		args = append(args, source)
+	}
+	if _, err := db.Exec("DELETE FROM services WHERE "+cond, args...); err != nil {
+		models.Log.Error("DeleteService: Failed to delete service %d/%d: %v", networkID, serviceID, err)
+		return err
+	}
+	return nil
+}
+
+// GetStoredServices は services テーブルに保存されたサービスをすべて取得する
+func GetStoredServices(db *sql.DB) ([]*models.Service, error) {
+	rows, err := db.Query(`SELECT ` + serviceColumns + ` FROM services ORDER BY networkId, serviceId`)
+	if err != nil {
+		models.Log.Error("GetStoredServices: Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var services []*models.Service
+	for rows.Next() {
+		s, err := scanService(rows)
+		if err != nil {
+			models.Log.Error("GetStoredServices: Scan failed: %v", err)
+			continue
+		}
+		services = append(services, s)
+	}
+
+	return services, nil
+}
+
+// LoadServices は保存済みのサービスを ServiceMapInstance に読み込む
+// 起動直後に Mirakurun へ接続できなくても局情報を参照できるようにする
+func LoadServices(db *sql.DB) (int, error) {
+	services, err := GetStoredServices(db)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range services {
+		models.ServiceMapInstance.Update(s)
+	}
+
+	models.Log.Info("LoadServices: Loaded %d services from database", len(services))
+	return len(services), nil
+}
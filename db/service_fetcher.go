@@ -3,12 +3,13 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
-	"io"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/fuba/iepg-server/events"
 	"github.com/fuba/iepg-server/models"
 )
 
@@ -32,12 +33,30 @@ type channelInfo struct {
 	TSMFRel int    `json:"tsmfRelTs,omitempty"`
 }
 
-// StartServiceFetcher は定期的にMirakurunからサービス情報を取得してメモリに格納する
-func StartServiceFetcher(ctx context.Context, mirakurunBaseURL string) {
-	models.Log.Debug("StartServiceFetcher: Starting service fetcher with URL: %s", mirakurunBaseURL)
+// mirakurunAPIURL は Mirakurun のベースURLに /api/ を補ってAPIのURLを構築する
+func mirakurunAPIURL(mirakurunBaseURL, path string) string {
+	apiURL := mirakurunBaseURL
+	if !strings.HasSuffix(apiURL, "/api") && !strings.HasSuffix(apiURL, "/api/") {
+		// URLが/apiで終わっていない場合は追加
+		if !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		if !strings.Contains(apiURL, "/api/") {
+			apiURL += "api/"
+		}
+	} else if !strings.HasSuffix(apiURL, "/") {
+		// /apiで終わっていて/が無い場合は追加
+		apiURL += "/"
+	}
+	return apiURL + path
+}
+
+// StartServiceFetcher は定期的にMirakurunからサービス情報を取得してメモリとDBに格納する
+func StartServiceFetcher(ctx context.Context, db *sql.DB, source models.MirakurunSource) {
+	models.Log.Debug("StartServiceFetcher: Starting service fetcher with URL: %s (source %s)", source.URL, source.Name)
 
 	// 初回のフェッチは即時実行
-	fetchServices(ctx, mirakurunBaseURL)
+	fetchServices(ctx, db, source)
 
 	// 以降は15分ごとに実行
 	ticker := time.NewTicker(15 * time.Minute)
@@ -49,114 +68,75 @@ func StartServiceFetcher(ctx context.Context, mirakurunBaseURL string) {
 			models.Log.Info("ServiceFetcher: Context cancelled, stopping service fetcher")
 			return
 		case <-ticker.C:
-			fetchServices(ctx, mirakurunBaseURL)
+			fetchServices(ctx, db, source)
 		}
 	}
 }
 
 // StartServiceEventStream はMirakurunのサービスイベントストリームを購読する
-func StartServiceEventStream(ctx context.Context, mirakurunBaseURL string) {
+func StartServiceEventStream(ctx context.Context, db *sql.DB, source models.MirakurunSource) {
 	// サービスイベントのストリームURLを構築
-	// URLのパスが正しいことを確認
-	apiURL := mirakurunBaseURL
-	if !strings.HasSuffix(apiURL, "/api") && !strings.HasSuffix(apiURL, "/api/") {
-		// URLが/apiで終わっていない場合は追加
-		if !strings.HasSuffix(apiURL, "/") {
-			apiURL += "/"
-		}
-		if !strings.Contains(apiURL, "/api/") {
-			apiURL += "api/"
-		}
-	} else if !strings.HasSuffix(apiURL, "/") {
-		// /apiで終わっていて/が無い場合は追加
-		apiURL += "/"
-	}
-	// イベントストリームのURLを構築
-	apiURL += "events/stream?resource=service"
+	apiURL := mirakurunAPIURL(source.URL, "events/stream?resource=service")
 	models.Log.Debug("StartServiceEventStream: Starting service event stream with URL: %s", apiURL)
 
-	for {
-		err := func() error {
-			// リクエスト作成
-			req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-			if err != nil {
-				models.Log.Error("ServiceEventStream: Failed to create request: %v", err)
-				return err
-			}
+	stream := NewMirakurunStream("service", source.Name, apiURL)
+	stream.Run(ctx, serviceEventHandler(db, source.Name))
+}
 
-			// リクエスト送信
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				models.Log.Error("ServiceEventStream: Request failed: %v", err)
-				return err
-			}
-			defer resp.Body.Close()
-
-			models.Log.Info("ServiceEventStream: Connected to Mirakurun stream, status: %s", resp.Status)
-
-			// イベントストリームを解析して処理
-			decoder := json.NewDecoder(resp.Body)
-			for {
-				var event struct {
-					Resource string             `json:"resource"`
-					Type     string             `json:"type"`
-					Data     mirakurunServiceResponse `json:"data"`
-					Time     int64              `json:"time"`
-				}
-
-				if err := decoder.Decode(&event); err != nil {
-					if err == io.EOF {
-						models.Log.Info("ServiceEventStream: Stream ended")
-						break
-					}
-					models.Log.Error("ServiceEventStream: Failed to decode event: %v", err)
-					return err
-				}
-
-				// サービスイベントを処理
-				if event.Resource == "service" {
-					switch event.Type {
-					case "create", "update":
-						service := convertToService(&event.Data)
-						models.ServiceMapInstance.Update(service)
-						models.Log.Debug("ServiceEventStream: Updated service: %d - %s", service.ServiceID, service.Name)
-					case "remove":
-						models.ServiceMapInstance.Remove(event.Data.ServiceID)
-						models.Log.Debug("ServiceEventStream: Removed service: %d", event.Data.ServiceID)
-					}
-				}
-			}
+// serviceEventHandler は取得元 source のサービスイベントを保存する処理を返す
+// ストリームの購読とキャプチャの再生で共通に使う
+func serviceEventHandler(db *sql.DB, source string) func(raw json.RawMessage) error {
+	return func(raw json.RawMessage) error {
+		var event struct {
+			Resource string                   `json:"resource"`
+			Type     string                   `json:"type"`
+			Data     mirakurunServiceResponse `json:"data"`
+			Time     int64                    `json:"time"`
+		}
+		if err := json.Unmarshal(raw, &event); err != nil {
+			models.Log.Error("ServiceEventStream: Failed to decode event: %v", err)
 			return nil
-		}()
-
-		if err != nil {
-			models.Log.Error("ServiceEventStream: Error: %v", err)
 		}
 
-		// 接続が切れたら5秒後に再接続
-		time.Sleep(5 * time.Second)
+		// サービスイベントを処理
+		if event.Resource != "service" {
+			return nil
+		}
+		switch event.Type {
+		case "create", "update":
+			service := convertToService(&event.Data)
+			service.Source = source
+			SaveService(db, service)
+			models.ServiceMapInstance.Update(service)
+			models.Log.Debug("ServiceEventStream: Updated service: %d - %s", service.ServiceID, service.Name)
+			// 上書き設定を適用した値を通知する
+			if applied, ok := models.ServiceMapInstance.Lookup(service.NetworkID, service.ServiceID); ok {
+				service = applied
+			}
+			events.Publish(events.ResourceService, event.Type, service)
+		case "remove":
+			// 同じサービスを別の取得元から受信している場合、その取得元のサービスは削除しない
+			DeleteService(db, event.Data.NetworkID, event.Data.ServiceID, source)
+			if !models.ServiceMapInstance.RemoveFromSource(event.Data.NetworkID, event.Data.ServiceID, source) {
+				models.Log.Debug("ServiceEventStream: Ignored removal of service %d/%d not owned by source %s",
+					event.Data.NetworkID, event.Data.ServiceID, source)
+				return nil
+			}
+			models.Log.Debug("ServiceEventStream: Removed service: %d/%d", event.Data.NetworkID, event.Data.ServiceID)
+			events.Publish(events.ResourceService, event.Type, map[string]int64{
+				"networkId": event.Data.NetworkID,
+				"serviceId": event.Data.ServiceID,
+			})
+		}
+		return nil
 	}
 }
 
 // fetchServices はMirakurunからサービス情報を取得する
-func fetchServices(ctx context.Context, mirakurunBaseURL string) {
+func fetchServices(ctx context.Context, db *sql.DB, source models.MirakurunSource) {
 	// サービス一覧のAPIエンドポイントURL
-	apiURL := mirakurunBaseURL
-	if !strings.HasSuffix(apiURL, "/api") && !strings.HasSuffix(apiURL, "/api/") {
-		// URLが/apiで終わっていない場合は追加
-		if !strings.HasSuffix(apiURL, "/") {
-			apiURL += "/"
-		}
-		if !strings.Contains(apiURL, "/api/") {
-			apiURL += "api/"
-		}
-	} else if !strings.HasSuffix(apiURL, "/") {
-		// /apiで終わっていて/が無い場合は追加
-		apiURL += "/"
-	}
-	// サービス一覧のURLを構築
-	apiURL += "services"
-	models.Log.Debug("fetchServices: Fetching services from: %s (base URL: %s)", apiURL, mirakurunBaseURL)
+	apiURL := mirakurunAPIURL(source.URL, "services")
+	models.Log.Debug("fetchServices: Fetching services from: %s (source %s)", apiURL, source.Name)
 
 	// リクエスト作成
 	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
@@ -185,15 +165,25 @@ func fetchServices(ctx context.Context, mirakurunBaseURL string) {
 		return
 	}
 
+	converted := make([]*models.Service, 0, len(services))
+	for i := range services {
+		service := convertToService(&services[i])
+		service.Source = source.Name
+		converted = append(converted, service)
+	}
+
+	// DBに保存し、初回・最終確認日時を記録する
+	// 保存に失敗してもメモリ上のサービス情報は更新する
+	SaveServices(db, converted)
+
 	// サービス情報をグローバルマップに保存
 	count := 0
-	for _, svc := range services {
-		service := convertToService(&svc)
+	for _, service := range converted {
 		models.ServiceMapInstance.Update(service)
 		count++
 	}
 
-	models.Log.Info("fetchServices: Updated %d services", count)
+	models.Log.Info("fetchServices: Updated %d services from %s", count, source.Name)
 }
 
 // convertToService はMirakurunのレスポンスをServiceモデルに変換する
@@ -230,6 +220,8 @@ func convertToService(resp *mirakurunServiceResponse) *models.Service {
 		}
 	}
 	
+	service.SetLogoURL()
+
 	models.Log.Debug("convertToService: Converted service: ID=%d, Name=%s, Type=%d, ChannelType=%s", 
 		service.ID, service.Name, service.Type, service.ChannelType)
 
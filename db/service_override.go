@@ -0,0 +1,86 @@
+// db/service_override.go
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// GetServiceOverrides は保存されたサービスの上書き設定をすべて取得する
+func GetServiceOverrides(db *sql.DB) ([]models.ServiceOverride, error) {
+	rows, err := db.Query(`SELECT networkId, serviceId, displayName, stationCode, channelNumber, sortKey, updatedAt
+		FROM service_overrides ORDER BY networkId, serviceId`)
+	if err != nil {
+		models.Log.Error("GetServiceOverrides: Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []models.ServiceOverride{}
+	for rows.Next() {
+		var o models.ServiceOverride
+		var displayName, stationCode, channelNumber sql.NullString
+		if err := rows.Scan(&o.NetworkID, &o.ServiceID, &displayName, &stationCode, &channelNumber,
+			&o.SortKey, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		o.DisplayName = displayName.String
+		o.StationCode = stationCode.String
+		o.ChannelNumber = channelNumber.String
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// SaveServiceOverride はサービスの上書き設定を保存し、ServiceMapInstance に適用する
+func SaveServiceOverride(db *sql.DB, override *models.ServiceOverride) error {
+	override.UpdatedAt = time.Now().UnixMilli()
+	_, err := db.Exec(`INSERT OR REPLACE INTO service_overrides
+		(networkId, serviceId, displayName, stationCode, channelNumber, sortKey, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		override.NetworkID, override.ServiceID, override.DisplayName, override.StationCode,
+		override.ChannelNumber, override.SortKey, override.UpdatedAt)
+	if err != nil {
+		models.Log.Error("SaveServiceOverride: Failed to save override for %d/%d: %v",
+			override.NetworkID, override.ServiceID, err)
+		return err
+	}
+
+	models.ServiceMapInstance.SetOverride(override)
+	models.Log.Info("SaveServiceOverride: Saved override for %d/%d", override.NetworkID, override.ServiceID)
+	return nil
+}
+
+// DeleteServiceOverride はサービスの上書き設定を削除する
+// 設定が存在しない場合は sql.ErrNoRows を返す
+func DeleteServiceOverride(db *sql.DB, networkID, serviceID int64) error {
+	result, err := db.Exec("DELETE FROM service_overrides WHERE networkId = ? AND serviceId = ?", networkID, serviceID)
+	if err != nil {
+		models.Log.Error("DeleteServiceOverride: Failed to delete override for %d/%d: %v", networkID, serviceID, err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	models.ServiceMapInstance.RemoveOverride(networkID, serviceID)
+	models.Log.Info("DeleteServiceOverride: Deleted override for %d/%d", networkID, serviceID)
+	return nil
+}
+
+// LoadServiceOverrides は保存済みの上書き設定を ServiceMapInstance に読み込む
+func LoadServiceOverrides(db *sql.DB) (int, error) {
+	overrides, err := GetServiceOverrides(db)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range overrides {
+		models.ServiceMapInstance.SetOverride(&overrides[i])
+	}
+
+	models.Log.Info("LoadServiceOverrides: Loaded %d service overrides", len(overrides))
+	return len(overrides), nil
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestServiceOverrides(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	models.ServiceMapInstance = models.NewServiceMap()
+	models.ServiceMapInstance.Update(&models.Service{ID: 3273601024, ServiceID: 1024, NetworkID: 32736,
+		Name: "ＮＨＫ総合１・東京", Type: 1, RemoteControlKeyID: 1, ChannelType: "GR", ChannelNumber: "27"})
+
+	override := &models.ServiceOverride{NetworkID: 32736, ServiceID: 1024, DisplayName: "NHK総合",
+		StationCode: "NHK", SortKey: 1}
+	if err := SaveServiceOverride(db, override); err != nil {
+		t.Fatalf("SaveServiceOverride failed: %v", err)
+	}
+
+	service, _ := models.ServiceMapInstance.Lookup(32736, 1024)
+	if service.Name != "NHK総合" || service.OriginalName != "ＮＨＫ総合１・東京" || service.IEPGStationID() != "NHK" ||
+		service.ChannelNumber != "27" || service.SortKey != 1 {
+		t.Errorf("Override was not applied: %+v", service)
+	}
+
+	// Mirakurun から更新が来ても上書き設定は維持される
+	models.ServiceMapInstance.Update(&models.Service{ServiceID: 1024, NetworkID: 32736, Name: "NHK総合1・東京",
+		RemoteControlKeyID: 1})
+	service, _ = models.ServiceMapInstance.Lookup(32736, 1024)
+	if service.Name != "NHK総合" || service.OriginalName != "NHK総合1・東京" {
+		t.Errorf("Override was lost after update: %+v", service)
+	}
+
+	// 再起動を想定して空のマップに読み込む
+	models.ServiceMapInstance = models.NewServiceMap()
+	if n, err := LoadServiceOverrides(db); err != nil || n != 1 {
+		t.Fatalf("LoadServiceOverrides returned %d, %v", n, err)
+	}
+	models.ServiceMapInstance.Update(&models.Service{ServiceID: 1024, NetworkID: 32736, Name: "局A", RemoteControlKeyID: 1})
+	if service, _ := models.ServiceMapInstance.Lookup(32736, 1024); service.Name != "NHK総合" {
+		t.Errorf("Expected loaded override to be applied, got %+v", service)
+	}
+
+	if err := DeleteServiceOverride(db, 32736, 1024); err != nil {
+		t.Fatalf("DeleteServiceOverride failed: %v", err)
+	}
+	service, _ = models.ServiceMapInstance.Lookup(32736, 1024)
+	if service.Name != "局A" || service.OriginalName != "" || service.IEPGStationID() != "0001" {
+		t.Errorf("Expected the original service after delete, got %+v", service)
+	}
+	if err := DeleteServiceOverride(db, 32736, 1024); err == nil {
+		t.Errorf("Expected an error when deleting a missing override")
+	}
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestSaveAndLoadServices(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	service := &models.Service{ID: 3273601024, ServiceID: 1024, NetworkID: 32736, Name: "局A", Type: 1,
+		RemoteControlKeyID: 1, ChannelType: "GR", ChannelNumber: "27"}
+	if err := SaveService(db, service); err != nil {
+		t.Fatalf("SaveService failed: %v", err)
+	}
+	firstSeenAt := service.FirstSeenAt
+	if firstSeenAt == 0 || service.LastSeenAt != firstSeenAt {
+		t.Errorf("Expected firstSeenAt == lastSeenAt on first save, got %d/%d", service.FirstSeenAt, service.LastSeenAt)
+	}
+
+	// 再保存しても初回確認日時は維持される
+	db.Exec("UPDATE services SET firstSeenAt = 1000 WHERE serviceId = 1024")
+	service.Name = "局A（新）"
+	if err := SaveServices(db, []*models.Service{service}); err != nil {
+		t.Fatalf("SaveServices failed: %v", err)
+	}
+	if service.FirstSeenAt != 1000 || service.LastSeenAt < firstSeenAt {
+		t.Errorf("Expected firstSeenAt to be kept, got %d/%d", service.FirstSeenAt, service.LastSeenAt)
+	}
+
+	// 再起動を想定して空のマップに読み込む
+	models.ServiceMapInstance = models.NewServiceMap()
+	n, err := LoadServices(db)
+	if err != nil || n != 1 {
+		t.Fatalf("LoadServices returned %d, %v", n, err)
+	}
+	loaded, ok := models.ServiceMapInstance.Get(1024)
+	if !ok {
+		t.Fatalf("Expected service 1024 to be loaded")
+	}
+	if loaded.Name != "局A（新）" || loaded.ChannelType != "GR" || loaded.NetworkID != 32736 || loaded.FirstSeenAt != 1000 {
+		t.Errorf("Unexpected loaded service: %+v", loaded)
+	}
+
+	if err := DeleteService(db, 32736, 1024, ""); err != nil {
+		t.Fatalf("DeleteService failed: %v", err)
+	}
+	services, _ := GetStoredServices(db)
+	if len(services) != 0 {
+		t.Errorf("Expected no stored services after delete, got %d", len(services))
+	}
+}
+
+func TestFetchServicesPersists(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/services" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":400101,"serviceId":101,"networkId":4,"name":"BS局","type":1,"channel":{"type":"BS","channel":"BS01_0"}}]`))
+	}))
+	defer mirakurun.Close()
+
+	models.ServiceMapInstance = models.NewServiceMap()
+	fetchServices(context.Background(), db, models.MirakurunSource{Name: models.DefaultSourceName, URL: mirakurun.URL + "/api"})
+
+	services, err := GetStoredServices(db)
+	if err != nil || len(services) != 1 {
+		t.Fatalf("Expected 1 stored service, got %d (%v)", len(services), err)
+	}
+	if services[0].ServiceID != 101 || services[0].Type != 2 || services[0].ChannelType != "BS" {
+		t.Errorf("Unexpected stored service: %+v", services[0])
+	}
+	if svc, ok := models.ServiceMapInstance.Get(101); !ok || svc.LastSeenAt == 0 {
+		t.Errorf("Expected service map to be updated with lastSeenAt, got %+v", svc)
+	}
+}
+
+func TestServiceRemoveEventKeepsOtherSource(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+	models.ServiceMapInstance = models.NewServiceMap()
+
+	create := serviceEventHandler(db, "tunerA")
+	create([]byte(`{"resource":"service","type":"create","data":{"id":3273601024,"serviceId":1024,"networkId":32736,"name":"局A","type":1,"channel":{"type":"GR","channel":"27"}}}`))
+
+	// 別の取得元からの削除イベントでは削除しない
+	remove := `{"resource":"service","type":"remove","data":{"id":3273601024,"serviceId":1024,"networkId":32736}}`
+	serviceEventHandler(db, "tunerB")([]byte(remove))
+	if _, ok := models.ServiceMapInstance.Lookup(32736, 1024); !ok {
+		t.Errorf("Expected service to be kept after a remove event from another source")
+	}
+	if services, _ := GetStoredServices(db); len(services) != 1 {
+		t.Errorf("Expected stored service to be kept, got %d", len(services))
+	}
+
+	create([]byte(remove))
+	if _, ok := models.ServiceMapInstance.Lookup(32736, 1024); ok {
+		t.Errorf("Expected service to be removed by its own source")
+	}
+	if services, _ := GetStoredServices(db); len(services) != 0 {
+		t.Errorf("Expected stored service to be deleted, got %d", len(services))
+	}
+}
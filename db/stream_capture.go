@@ -0,0 +1,268 @@
+// db/stream_capture.go
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+const (
+	// DefaultStreamCaptureMaxBytes は1つのキャプチャファイルの大きさの上限
+	DefaultStreamCaptureMaxBytes = 64 * 1024 * 1024
+	// DefaultStreamCaptureMaxFiles は保持するキャプチャファイルの数
+	DefaultStreamCaptureMaxFiles = 10
+
+	streamCaptureFilePrefix = "stream-"
+	streamCaptureFileSuffix = ".ndjson"
+)
+
+// StreamCaptureRecord はキャプチャファイルの1行
+// Event には Mirakurun から受信したイベントをそのまま保存する
+type StreamCaptureRecord struct {
+	At     int64           `json:"at"`     // 受信日時（Unixミリ秒）
+	Source string          `json:"source"` // 取得元のMirakurunの名前
+	Stream string          `json:"stream"` // ストリームの名前（program / service）
+	Event  json.RawMessage `json:"event"`
+}
+
+// StreamCapture はイベントストリームで受信したイベントを NDJSON ファイルに追記する
+// ファイルが maxBytes を超えたら新しいファイルに切り替え、古いファイルは maxFiles 個まで残す
+type StreamCapture struct {
+	dir      string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// 最後に作成したファイルの時刻と連番（削除したファイルの連番を再利用しないため）
+	lastStamp string
+	lastSeq   int
+}
+
+// NewStreamCapture は dir にキャプチャファイルを書き込む StreamCapture を作成する
+func NewStreamCapture(dir string, maxBytes int64, maxFiles int) (*StreamCapture, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultStreamCaptureMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultStreamCaptureMaxFiles
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &StreamCapture{dir: dir, maxBytes: maxBytes, maxFiles: maxFiles}, nil
+}
+
+// Write はイベントを1行追記する
+func (c *StreamCapture) Write(source, stream string, raw json.RawMessage) error {
+	// 受信したイベントは整形されている場合があるので1行にまとめる
+	var event bytes.Buffer
+	if err := json.Compact(&event, raw); err != nil {
+		return err
+	}
+	line, err := json.Marshal(StreamCaptureRecord{
+		At:     time.Now().UnixMilli(),
+		Source: source,
+		Stream: stream,
+		Event:  event.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil || c.size+int64(len(line)) > c.maxBytes {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := c.file.Write(line)
+	c.size += int64(n)
+	return err
+}
+
+// rotate は新しいキャプチャファイルを開き、保持数を超えた古いファイルを削除する
+func (c *StreamCapture) rotate() error {
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+
+	// ファイル名の順序が作成順になるよう、同じ時刻のファイルには固定桁の連番を付ける
+	stamp := time.Now().Format("20060102-150405.000")
+	seq := 0
+	if stamp == c.lastStamp {
+		seq = c.lastSeq + 1
+	}
+	var path string
+	for ; ; seq++ {
+		path = filepath.Join(c.dir, fmt.Sprintf("%s%s-%03d%s", streamCaptureFilePrefix, stamp, seq, streamCaptureFileSuffix))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+	}
+	c.lastStamp, c.lastSeq = stamp, seq
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.file = f
+	c.size = 0
+	models.Log.Info("StreamCapture: Writing stream events to %s", path)
+
+	files, err := StreamCaptureFiles(c.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > c.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			models.Log.Error("StreamCapture: Failed to remove old capture %s: %v", files[0], err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// Close は書き込み中のファイルを閉じる
+func (c *StreamCapture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// StreamCaptureFiles は dir にあるキャプチャファイルを古い順に返す
+func StreamCaptureFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, streamCaptureFilePrefix+"*"+streamCaptureFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+var (
+	streamCaptureMu sync.RWMutex
+	streamCapture   *StreamCapture
+)
+
+// SetStreamCapture はイベントストリームのキャプチャ先を設定する（nil の場合はキャプチャしない）
+func SetStreamCapture(c *StreamCapture) {
+	streamCaptureMu.Lock()
+	defer streamCaptureMu.Unlock()
+	streamCapture = c
+}
+
+// captureStreamEvent はキャプチャが有効な場合にイベントを記録する
+// 記録に失敗してもイベントの処理は続ける
+func captureStreamEvent(source, stream string, raw json.RawMessage) {
+	streamCaptureMu.RLock()
+	c := streamCapture
+	streamCaptureMu.RUnlock()
+	if c == nil {
+		return
+	}
+	if err := c.Write(source, stream, raw); err != nil {
+		models.Log.Error("StreamCapture: Failed to capture %s/%s event: %v", source, stream, err)
+	}
+}
+
+// ReplayStreamCapture はキャプチャファイルを順に読み込み、記録されたイベントを
+// ストリームの購読と同じ取り込み処理に渡す。処理したイベントの数を返す
+// speed は再生速度の倍率で、0以下の場合は待たずに再生する
+func ReplayStreamCapture(ctx context.Context, db *sql.DB, writer *ProgramWriter, paths []string, speed float64) (int, error) {
+	handlers := make(map[string]func(raw json.RawMessage) error)
+	handlerFor := func(record *StreamCaptureRecord) func(raw json.RawMessage) error {
+		key := record.Source + "/" + record.Stream
+		if h, ok := handlers[key]; ok {
+			return h
+		}
+		var h func(raw json.RawMessage) error
+		switch record.Stream {
+		case "program":
+			h = programEventHandler(ctx, writer, record.Source)
+		case "service":
+			h = serviceEventHandler(db, record.Source)
+		}
+		handlers[key] = h
+		return h
+	}
+
+	var firstAt int64
+	var startedAt time.Time
+	replayed := 0
+	for _, path := range paths {
+		models.Log.Info("ReplayStreamCapture: Replaying %s", path)
+		f, err := os.Open(path)
+		if err != nil {
+			return replayed, err
+		}
+		r := bufio.NewReader(f)
+		for lineNo := 1; ; lineNo++ {
+			line, err := r.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				var record StreamCaptureRecord
+				if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+					// 書き込み途中で終わった行などは読み飛ばす
+					models.Log.Error("ReplayStreamCapture: Skipping invalid line %s:%d: %v", path, lineNo, jsonErr)
+				} else {
+					if replayed == 0 {
+						firstAt, startedAt = record.At, time.Now()
+					} else if speed > 0 {
+						wait := time.Until(startedAt.Add(time.Duration(float64(record.At-firstAt)/speed) * time.Millisecond))
+						if wait > 0 {
+							select {
+							case <-ctx.Done():
+								f.Close()
+								return replayed, ctx.Err()
+							case <-time.After(wait):
+							}
+						}
+					}
+					if handle := handlerFor(&record); handle != nil {
+						if err := handle(record.Event); err != nil {
+							f.Close()
+							return replayed, err
+						}
+					}
+					replayed++
+				}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				f.Close()
+				return replayed, err
+			}
+			if err := ctx.Err(); err != nil {
+				f.Close()
+				return replayed, err
+			}
+		}
+		f.Close()
+	}
+
+	models.Log.Info("ReplayStreamCapture: Replayed %d events from %d files", replayed, len(paths))
+	return replayed, nil
+}
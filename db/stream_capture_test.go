@@ -0,0 +1,228 @@
+// db/stream_capture_test.go
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestStreamCaptureRotation(t *testing.T) {
+	models.InitLogger("error")
+	dir := t.TempDir()
+	capture, err := NewStreamCapture(dir, 300, 2)
+	if err != nil {
+		t.Fatalf("NewStreamCapture failed: %v", err)
+	}
+	defer capture.Close()
+
+	// 整形されたイベントも1行にまとめて記録する
+	event := json.RawMessage("{\n  \"resource\": \"program\",\n  \"type\": \"create\",\n  \"data\": {\"id\": 1}\n}")
+	for i := 0; i < 10; i++ {
+		if err := capture.Write("gr", "program", event); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	capture.Close()
+
+	files, err := StreamCaptureFiles(dir)
+	if err != nil {
+		t.Fatalf("StreamCaptureFiles failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected old captures to be pruned to 2 files, got %v", files)
+	}
+	for _, path := range files {
+		info, _ := os.Stat(path)
+		if info.Size() > 300 {
+			t.Errorf("Expected %s to be rotated before exceeding 300 bytes, got %d", path, info.Size())
+		}
+		f, _ := os.Open(path)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record StreamCaptureRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Errorf("Invalid capture line %q: %v", scanner.Text(), err)
+			}
+			if record.Source != "gr" || record.Stream != "program" || string(record.Event) != `{"resource":"program","type":"create","data":{"id":1}}` {
+				t.Errorf("Unexpected capture record: %+v (%s)", record, record.Event)
+			}
+		}
+		f.Close()
+	}
+}
+
+func TestStreamCaptureRotationOrder(t *testing.T) {
+	models.InitLogger("error")
+	dir := t.TempDir()
+	// 1行ごとに新しいファイルに切り替わる大きさにして、同じ時刻のファイルを作る
+	capture, err := NewStreamCapture(dir, 150, 3)
+	if err != nil {
+		t.Fatalf("NewStreamCapture failed: %v", err)
+	}
+	defer capture.Close()
+
+	for i := 0; i < 6; i++ {
+		event := json.RawMessage(`{"resource":"program","type":"create","data":{"id":` + strconv.Itoa(i) + `}}`)
+		if err := capture.Write("gr", "program", event); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	capture.Close()
+
+	// 新しいファイルが残り、作成順に並ぶ
+	files, err := StreamCaptureFiles(dir)
+	if err != nil || len(files) != 3 {
+		t.Fatalf("Expected 3 capture files, got %v (%v)", files, err)
+	}
+	for i, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		var record StreamCaptureRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			t.Fatalf("Invalid capture file %s: %v", path, err)
+		}
+		want := `{"resource":"program","type":"create","data":{"id":` + strconv.Itoa(i+3) + `}}`
+		if string(record.Event) != want {
+			t.Errorf("Expected %s to hold %s, got %s", filepath.Base(path), want, record.Event)
+		}
+	}
+}
+
+func TestStreamCaptureReplay(t *testing.T) {
+	models.InitLogger("error")
+	dir := t.TempDir()
+	capture, err := NewStreamCapture(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("NewStreamCapture failed: %v", err)
+	}
+	SetStreamCapture(capture)
+	defer SetStreamCapture(nil)
+
+	startAt := time.Now().Add(time.Hour).UnixMilli()
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("resource") {
+		case "program":
+			w.Write([]byte(`[
+{"resource":"program","type":"create","data":{"id":3273601024001,"serviceId":1024,"networkId":32736,"startAt":` +
+				strconv.FormatInt(startAt, 10) + `,"duration":1800000,"name":"ニュース"}}
+`))
+		case "service":
+			w.Write([]byte(`[
+{"resource":"service","type":"create","data":{"id":3273601024,"serviceId":1024,"networkId":32736,"name":"テスト局","type":1,"channel":{"type":"GR","channel":"27"}}}
+`))
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer mirakurun.Close()
+
+	// 受信したイベントがキャプチャされる
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var received int32
+	done := make(chan struct{}, 2)
+	for _, name := range []string{"program", "service"} {
+		stream := NewMirakurunStream(name, "gr", mirakurun.URL+"/api/events/stream?resource="+name)
+		go func() {
+			stream.Run(ctx, func(raw json.RawMessage) error {
+				atomic.AddInt32(&received, 1)
+				return nil
+			})
+			done <- struct{}{}
+		}()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&received) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for events: %+v", GetStreamStates())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	<-done
+	capture.Close()
+
+	// 別のDBに再生すると、ストリームを購読した場合と同じように保存される
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+	models.ServiceMapInstance = models.NewServiceMap()
+
+	writer := NewProgramWriter(db)
+	writerCtx, stopWriter := context.WithCancel(context.Background())
+	writerDone := make(chan struct{})
+	go func() {
+		writer.Run(writerCtx)
+		close(writerDone)
+	}()
+
+	files, _ := StreamCaptureFiles(dir)
+	replayed, err := ReplayStreamCapture(context.Background(), db, writer, files, 0)
+	if err != nil {
+		t.Fatalf("ReplayStreamCapture failed: %v", err)
+	}
+	stopWriter()
+	<-writerDone
+	if replayed != 2 {
+		t.Errorf("Expected 2 replayed events, got %d", replayed)
+	}
+
+	p, err := GetProgramByID(db, 3273601024001)
+	if err != nil {
+		t.Fatalf("Expected replayed program to be saved: %v", err)
+	}
+	if p.Name != "ニュース" || p.Source != "gr" {
+		t.Errorf("Unexpected replayed program: %+v", p)
+	}
+	if s, ok := models.ServiceMapInstance.Lookup(32736, 1024); !ok || s.Name != "テスト局" || s.Source != "gr" {
+		t.Errorf("Expected replayed service to be registered, got %+v", s)
+	}
+}
+
+func TestReplayStreamCaptureSpeed(t *testing.T) {
+	models.InitLogger("error")
+	path := filepath.Join(t.TempDir(), "stream-20240101-000000.000.ndjson")
+	lines := `{"at":1000,"source":"gr","stream":"unknown","event":{}}
+not json
+{"at":1200,"source":"gr","stream":"unknown","event":{}}
+`
+	if err := os.WriteFile(path, []byte(lines), 0644); err != nil {
+		t.Fatalf("Failed to write capture: %v", err)
+	}
+
+	// 2倍速では記録時の200ミリ秒の間隔が100ミリ秒になる
+	started := time.Now()
+	replayed, err := ReplayStreamCapture(context.Background(), nil, nil, []string{path}, 2)
+	if err != nil {
+		t.Fatalf("ReplayStreamCapture failed: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected replay to take about 100ms, took %v", elapsed)
+	}
+	if replayed != 2 {
+		t.Errorf("Expected invalid line to be skipped, replayed %d", replayed)
+	}
+
+	// キャンセルすると待機中でも終了する
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ReplayStreamCapture(ctx, nil, nil, []string{path}, 0.001); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
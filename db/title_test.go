@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestSearchProgramsByTitle(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	startAt := time.Now().Add(time.Hour).UnixMilli()
+	programs := []models.Program{
+		{ID: 1, ServiceID: 1024, NetworkID: 32736, StartAt: startAt, Duration: 1800000, Name: "[新][字]アニメ「タイトル」#1「始まり」"},
+		{ID: 2, ServiceID: 1024, NetworkID: 32736, StartAt: startAt + 1, Duration: 1800000, Name: "アニメ「タイトル」#5「サブタイトル」🈞"},
+		{ID: 3, ServiceID: 1024, NetworkID: 32736, StartAt: startAt + 2, Duration: 1800000, Name: "アニメ総集編[字]"},
+	}
+	if err := upsertPrograms(db, programs); err != nil {
+		t.Fatalf("Failed to save programs: %v", err)
+	}
+
+	p, err := GetProgramByID(db, 2)
+	if err != nil {
+		t.Fatalf("GetProgramByID failed: %v", err)
+	}
+	if p.Title != "アニメ「タイトル」" || p.EpisodeNumber != 5 || p.Subtitle != "サブタイトル" || !reflect.DeepEqual(p.Flags, []string{"再"}) {
+		t.Errorf("Unexpected parsed title: %q %v #%d %q", p.Title, p.Flags, p.EpisodeNumber, p.Subtitle)
+	}
+
+	tests := []struct {
+		name     string
+		opts     SearchOptions
+		expected []int64
+	}{
+		{"only new", SearchOptions{Query: "アニメ", Flags: []string{"新"}}, []int64{1}},
+		{"skip reruns", SearchOptions{Query: "アニメ", ExcludeFlags: []string{"再"}}, []int64{1, 3}},
+		{"all flags", SearchOptions{Flags: []string{"新", "字"}}, []int64{1}},
+		{"episode", SearchOptions{MinEpisode: 2}, []int64{2}},
+		{"episode known", SearchOptions{MinEpisode: 1, ExcludeFlags: []string{"再"}}, []int64{1}},
+		// LIKE のワイルドカードは記号として扱う
+		{"wildcard flag", SearchOptions{Flags: []string{"_"}}, nil},
+		{"wildcard exclude", SearchOptions{Query: "アニメ", ExcludeFlags: []string{"%"}}, []int64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := SearchProgramsWithOptions(db, tt.opts)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var ids []int64
+			for _, p := range results {
+				ids = append(ids, p.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestBackfillParsedTitles(t *testing.T) {
+	models.InitLogger("error")
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// 番組名を解析する前のバージョンで保存された番組
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE programs (id INTEGER PRIMARY KEY, serviceId INTEGER, networkId INTEGER, startAt INTEGER,
+			duration INTEGER, name TEXT, description TEXT, nameForSearch TEXT, descForSearch TEXT,
+			seriesId INTEGER, seriesEpisode INTEGER, seriesLastEpisode INTEGER, seriesName TEXT,
+			seriesRepeat INTEGER, seriesPattern INTEGER, seriesExpiresAt INTEGER, isFree INTEGER, source TEXT)`,
+		`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name)
+			VALUES (1, 1024, 32736, 9999999999999, 60, 'ドラマ 第3話「再会」[終]')`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create old schema: %v", err)
+		}
+	}
+	old.Close()
+
+	db, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed on old schema: %v", err)
+	}
+	defer db.Close()
+
+	var title, flags string
+	var episode int
+	if err := db.QueryRow(`SELECT title, titleFlags, episodeNumber FROM programs WHERE id = 1`).Scan(&title, &flags, &episode); err != nil {
+		t.Fatalf("Failed to read backfilled title: %v", err)
+	}
+	if title != "ドラマ" || flags != "[終]" || episode != 3 {
+		t.Errorf("Unexpected backfilled title: %q %q %d", title, flags, episode)
+	}
+}
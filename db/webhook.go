@@ -0,0 +1,323 @@
+// db/webhook.go
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+	"github.com/google/uuid"
+)
+
+const webhookColumns = `id, name, url, eventTypes, template, secret, enabled, createdAt, updatedAt`
+
+// scanWebhook は webhookColumns の順で取得した行を Webhook に変換する
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var w models.Webhook
+	var eventTypesJSON, template, secret sql.NullString
+	var enabled int
+	var createdAt, updatedAt int64
+
+	if err := row.Scan(&w.ID, &w.Name, &w.URL, &eventTypesJSON, &template, &secret,
+		&enabled, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	if eventTypesJSON.String != "" {
+		json.Unmarshal([]byte(eventTypesJSON.String), &w.EventTypes)
+	}
+	w.Template = template.String
+	w.Secret = secret.String
+	w.HasSecret = w.Secret != ""
+	w.Enabled = enabled != 0
+	w.CreatedAt = time.UnixMilli(createdAt)
+	w.UpdatedAt = time.UnixMilli(updatedAt)
+
+	return &w, nil
+}
+
+// CreateWebhook creates a new webhook subscription
+func CreateWebhook(db *sql.DB, webhook *models.Webhook) error {
+	if webhook.ID == "" {
+		webhook.ID = uuid.New().String()
+	}
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = webhook.CreatedAt
+	webhook.HasSecret = webhook.Secret != ""
+
+	eventTypesJSON, _ := json.Marshal(webhook.EventTypes)
+
+	_, err := db.Exec(`
+		INSERT INTO webhooks (id, name, url, eventTypes, template, secret, enabled, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, webhook.ID, webhook.Name, webhook.URL, string(eventTypesJSON), webhook.Template, webhook.Secret,
+		webhook.Enabled, webhook.CreatedAt.UnixMilli(), webhook.UpdatedAt.UnixMilli())
+	if err != nil {
+		models.Log.Error("CreateWebhook: Failed to create webhook: %v", err)
+		return err
+	}
+
+	models.Log.Info("CreateWebhook: Created webhook %s (%s)", webhook.ID, webhook.Name)
+	return nil
+}
+
+// GetWebhooks retrieves all webhook subscriptions
+func GetWebhooks(db *sql.DB) ([]models.Webhook, error) {
+	rows, err := db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY createdAt`)
+	if err != nil {
+		models.Log.Error("GetWebhooks: Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			models.Log.Error("GetWebhooks: Scan failed: %v", err)
+			continue
+		}
+		webhooks = append(webhooks, *w)
+	}
+
+	return webhooks, nil
+}
+
+// GetWebhookByID retrieves a specific webhook subscription by ID
+func GetWebhookByID(db *sql.DB, id string) (*models.Webhook, error) {
+	w, err := scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			models.Log.Error("GetWebhookByID: Query failed: %v", err)
+		}
+		return nil, err
+	}
+	return w, nil
+}
+
+// UpdateWebhook updates an existing webhook subscription
+func UpdateWebhook(db *sql.DB, webhook *models.Webhook) error {
+	webhook.UpdatedAt = time.Now()
+	webhook.HasSecret = webhook.Secret != ""
+
+	eventTypesJSON, _ := json.Marshal(webhook.EventTypes)
+
+	result, err := db.Exec(`
+		UPDATE webhooks
+		SET name = ?, url = ?, eventTypes = ?, template = ?, secret = ?, enabled = ?, updatedAt = ?
+		WHERE id = ?
+	`, webhook.Name, webhook.URL, string(eventTypesJSON), webhook.Template, webhook.Secret,
+		webhook.Enabled, webhook.UpdatedAt.UnixMilli(), webhook.ID)
+	if err != nil {
+		models.Log.Error("UpdateWebhook: Update failed: %v", err)
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook not found: %s", webhook.ID)
+	}
+
+	models.Log.Info("UpdateWebhook: Updated webhook %s", webhook.ID)
+	return nil
+}
+
+// DeleteWebhook deletes a webhook subscription and its delivery log
+func DeleteWebhook(db *sql.DB, id string) error {
+	result, err := db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		models.Log.Error("DeleteWebhook: Delete failed: %v", err)
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook not found: %s", id)
+	}
+
+	models.Log.Info("DeleteWebhook: Deleted webhook %s", id)
+	return nil
+}
+
+// GetWebhooksForEvent retrieves enabled webhooks subscribed to the given event type
+func GetWebhooksForEvent(db *sql.DB, eventType string) ([]models.Webhook, error) {
+	webhooks, err := GetWebhooks(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []models.Webhook
+	for _, w := range webhooks {
+		if w.Enabled && w.Subscribes(eventType) {
+			matched = append(matched, w)
+		}
+	}
+	return matched, nil
+}
+
+const webhookDeliveryColumns = `id, webhookId, eventType, subject, payload, status, attempts,
+	nextAttemptAt, responseStatus, lastError, createdAt, updatedAt`
+
+// scanWebhookDelivery は webhookDeliveryColumns の順で取得した行を WebhookDelivery に変換する
+func scanWebhookDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var subject, lastError sql.NullString
+	var responseStatus sql.NullInt64
+	var nextAttemptAt, createdAt, updatedAt int64
+
+	if err := row.Scan(&d.ID, &d.WebhookID, &d.EventType, &subject, &d.Payload, &d.Status, &d.Attempts,
+		&nextAttemptAt, &responseStatus, &lastError, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	d.Subject = subject.String
+	d.LastError = lastError.String
+	d.ResponseStatus = int(responseStatus.Int64)
+	d.NextAttemptAt = time.UnixMilli(nextAttemptAt)
+	d.CreatedAt = time.UnixMilli(createdAt)
+	d.UpdatedAt = time.UnixMilli(updatedAt)
+
+	return &d, nil
+}
+
+// CreateWebhookDelivery enqueues a delivery for later dispatch
+func CreateWebhookDelivery(db *sql.DB, d *models.WebhookDelivery) error {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = d.CreatedAt
+	if d.Status == "" {
+		d.Status = models.WebhookDeliveryPending
+	}
+	if d.NextAttemptAt.IsZero() {
+		d.NextAttemptAt = d.CreatedAt
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO webhook_deliveries (id, webhookId, eventType, subject, payload, status, attempts,
+			nextAttemptAt, responseStatus, lastError, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.ID, d.WebhookID, d.EventType, d.Subject, d.Payload, d.Status, d.Attempts,
+		d.NextAttemptAt.UnixMilli(), d.ResponseStatus, d.LastError, d.CreatedAt.UnixMilli(), d.UpdatedAt.UnixMilli())
+	if err != nil {
+		models.Log.Error("CreateWebhookDelivery: Failed to create delivery: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateWebhookDelivery stores the result of a delivery attempt
+func UpdateWebhookDelivery(db *sql.DB, d *models.WebhookDelivery) error {
+	d.UpdatedAt = time.Now()
+
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, nextAttemptAt = ?, responseStatus = ?, lastError = ?, updatedAt = ?
+		WHERE id = ?
+	`, d.Status, d.Attempts, d.NextAttemptAt.UnixMilli(), d.ResponseStatus, d.LastError, d.UpdatedAt.UnixMilli(), d.ID)
+	if err != nil {
+		models.Log.Error("UpdateWebhookDelivery: Update failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetDueWebhookDeliveries retrieves pending deliveries whose next attempt time has come
+func GetDueWebhookDeliveries(db *sql.DB, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := db.Query(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? AND nextAttemptAt <= ?
+		ORDER BY nextAttemptAt LIMIT ?`, models.WebhookDeliveryPending, now.UnixMilli(), limit)
+	if err != nil {
+		models.Log.Error("GetDueWebhookDeliveries: Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			models.Log.Error("GetDueWebhookDeliveries: Scan failed: %v", err)
+			continue
+		}
+		deliveries = append(deliveries, *d)
+	}
+
+	return deliveries, nil
+}
+
+// ClaimWebhookDelivery atomically marks a pending delivery as sending.
+// It returns false when the delivery was already claimed by another worker.
+func ClaimWebhookDelivery(db *sql.DB, id string) (bool, error) {
+	result, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, updatedAt = ? WHERE id = ? AND status = ?`,
+		models.WebhookDeliverySending, time.Now().UnixMilli(), id, models.WebhookDeliveryPending)
+	if err != nil {
+		models.Log.Error("ClaimWebhookDelivery: Update failed: %v", err)
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ResetSendingWebhookDeliveries returns deliveries left in the sending state by a stopped worker to the queue
+func ResetSendingWebhookDeliveries(db *sql.DB) error {
+	result, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, updatedAt = ? WHERE status = ?`,
+		models.WebhookDeliveryPending, time.Now().UnixMilli(), models.WebhookDeliverySending)
+	if err != nil {
+		models.Log.Error("ResetSendingWebhookDeliveries: Update failed: %v", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		models.Log.Info("ResetSendingWebhookDeliveries: Requeued %d interrupted deliveries", n)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries retrieves the delivery log of a webhook, newest first
+func GetWebhookDeliveries(db *sql.DB, webhookID string, limit int) ([]models.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE webhookId = ? ORDER BY createdAt DESC`
+	args := []interface{}{webhookID}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		models.Log.Error("GetWebhookDeliveries: Query failed: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			models.Log.Error("GetWebhookDeliveries: Scan failed: %v", err)
+			continue
+		}
+		deliveries = append(deliveries, *d)
+	}
+
+	return deliveries, nil
+}
+
+// HasRecentWebhookDelivery checks whether an event for the subject was already enqueued since the given time
+func HasRecentWebhookDelivery(db *sql.DB, eventType, subject string, since time.Time) bool {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries WHERE eventType = ? AND subject = ? AND createdAt >= ?`,
+		eventType, subject, since.UnixMilli()).Scan(&count)
+	if err != nil {
+		models.Log.Error("HasRecentWebhookDelivery: Query failed: %v", err)
+		return false
+	}
+	return count > 0
+}
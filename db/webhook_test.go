@@ -0,0 +1,103 @@
+// db/webhook_test.go
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestWebhookCRUD(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	webhook := &models.Webhook{
+		Name:       "slack",
+		URL:        "http://localhost:9999/hook",
+		EventTypes: []string{models.WebhookEventReservationFailed},
+		Secret:     "secret",
+		Enabled:    true,
+	}
+	if err := CreateWebhook(db, webhook); err != nil {
+		t.Fatalf("CreateWebhook returned error: %v", err)
+	}
+
+	got, err := GetWebhookByID(db, webhook.ID)
+	if err != nil {
+		t.Fatalf("GetWebhookByID returned error: %v", err)
+	}
+	if got.Name != "slack" || !got.HasSecret || len(got.EventTypes) != 1 {
+		t.Errorf("unexpected webhook: %+v", got)
+	}
+
+	matched, _ := GetWebhooksForEvent(db, models.WebhookEventReservationFailed)
+	if len(matched) != 1 {
+		t.Errorf("expected webhook to match reservation.failed, got %d", len(matched))
+	}
+	matched, _ = GetWebhooksForEvent(db, models.WebhookEventRuleIdle)
+	if len(matched) != 0 {
+		t.Errorf("expected webhook not to match rule.idle, got %d", len(matched))
+	}
+
+	got.Enabled = false
+	if err := UpdateWebhook(db, got); err != nil {
+		t.Fatalf("UpdateWebhook returned error: %v", err)
+	}
+	matched, _ = GetWebhooksForEvent(db, models.WebhookEventReservationFailed)
+	if len(matched) != 0 {
+		t.Errorf("disabled webhook should not match, got %d", len(matched))
+	}
+
+	// 配信キューの登録と取得
+	delivery := &models.WebhookDelivery{WebhookID: webhook.ID, EventType: models.WebhookEventReservationFailed, Payload: "{}"}
+	if err := CreateWebhookDelivery(db, delivery); err != nil {
+		t.Fatalf("CreateWebhookDelivery returned error: %v", err)
+	}
+	due, _ := GetDueWebhookDeliveries(db, time.Now(), 10)
+	if len(due) != 1 {
+		t.Fatalf("expected 1 due delivery, got %d", len(due))
+	}
+	due[0].NextAttemptAt = time.Now().Add(time.Hour)
+	due[0].Attempts = 1
+	if err := UpdateWebhookDelivery(db, &due[0]); err != nil {
+		t.Fatalf("UpdateWebhookDelivery returned error: %v", err)
+	}
+	due, _ = GetDueWebhookDeliveries(db, time.Now(), 10)
+	if len(due) != 0 {
+		t.Errorf("expected no due deliveries after rescheduling, got %d", len(due))
+	}
+
+	// 送信前の取得は1回だけ成功し、中断された送信は再びキューに戻る
+	claimed := &models.WebhookDelivery{WebhookID: webhook.ID, EventType: models.WebhookEventReservationFailed, Payload: "{}"}
+	if err := CreateWebhookDelivery(db, claimed); err != nil {
+		t.Fatalf("CreateWebhookDelivery returned error: %v", err)
+	}
+	if ok, err := ClaimWebhookDelivery(db, claimed.ID); err != nil || !ok {
+		t.Fatalf("first claim should succeed, got %v, %v", ok, err)
+	}
+	if ok, _ := ClaimWebhookDelivery(db, claimed.ID); ok {
+		t.Errorf("second claim should fail")
+	}
+	if due, _ := GetDueWebhookDeliveries(db, time.Now(), 10); len(due) != 0 {
+		t.Errorf("claimed delivery should not be due, got %d", len(due))
+	}
+	if err := ResetSendingWebhookDeliveries(db); err != nil {
+		t.Fatalf("ResetSendingWebhookDeliveries returned error: %v", err)
+	}
+	if due, _ := GetDueWebhookDeliveries(db, time.Now(), 10); len(due) != 1 || due[0].ID != claimed.ID {
+		t.Errorf("expected interrupted delivery to be due again, got %+v", due)
+	}
+
+	// Webhookを削除すると配信ログも削除される
+	if err := DeleteWebhook(db, webhook.ID); err != nil {
+		t.Fatalf("DeleteWebhook returned error: %v", err)
+	}
+	deliveries, _ := GetWebhookDeliveries(db, webhook.ID, 10)
+	if len(deliveries) != 0 {
+		t.Errorf("expected deliveries to be deleted with webhook, got %d", len(deliveries))
+	}
+}
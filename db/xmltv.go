@@ -0,0 +1,401 @@
+// db/xmltv.go
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// XMLTVNetworkID は対応表にも既存のサービスにも見つからない XMLTV のチャンネルに割り当てるネットワークID
+// 実在の放送のネットワークIDと重ならないよう最大値を使う
+const XMLTVNetworkID = 0xFFFF
+
+// DefaultXMLTVWatchInterval は XMLTV ファイルの更新を確認する間隔
+const DefaultXMLTVWatchInterval = time.Minute
+
+// XMLTVImportOptions は XMLTV の取り込み設定
+type XMLTVImportOptions struct {
+	// ChannelMap は XMLTV のチャンネルIDとサービスの対応表
+	// 対応表に無いチャンネルは、表示名が同じ既存のサービスに割り当て、
+	// それも無ければ XMLTVNetworkID のサービスを作成する
+	ChannelMap map[string]models.XMLTVChannelMapping
+}
+
+// xmltvText は言語属性付きのテキスト要素
+type xmltvText struct {
+	Lang  string `xml:"lang,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmltvChannel struct {
+	ID           string      `xml:"id,attr"`
+	DisplayNames []xmltvText `xml:"display-name"`
+}
+
+type xmltvProgramme struct {
+	Start     string      `xml:"start,attr"`
+	Stop      string      `xml:"stop,attr"`
+	Channel   string      `xml:"channel,attr"`
+	Titles    []xmltvText `xml:"title"`
+	SubTitles []xmltvText `xml:"sub-title"`
+	Descs     []xmltvText `xml:"desc"`
+}
+
+// pickXMLTVText は日本語のテキストを優先して1つ選ぶ
+func pickXMLTVText(texts []xmltvText) string {
+	for _, t := range texts {
+		if strings.HasPrefix(strings.ToLower(t.Lang), "ja") && strings.TrimSpace(t.Value) != "" {
+			return strings.TrimSpace(t.Value)
+		}
+	}
+	for _, t := range texts {
+		if v := strings.TrimSpace(t.Value); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseXMLTVTime は XMLTV の日時（"20240101120000 +0900" 形式）を解析する
+// 秒以下は省略でき、タイムゾーンが無い場合はローカル時刻とみなす
+func parseXMLTVTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	digits, zone, _ := strings.Cut(s, " ")
+	if len(digits) < 8 || len(digits) > 14 {
+		return time.Time{}, fmt.Errorf("invalid xmltv time: %q", s)
+	}
+	digits += strings.Repeat("0", 14-len(digits))
+
+	loc := time.Local
+	if zone = strings.TrimSpace(zone); zone != "" {
+		z, err := time.Parse("-0700", zone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid xmltv time zone: %q", s)
+		}
+		loc = z.Location()
+	}
+	return time.ParseInLocation("20060102150405", digits, loc)
+}
+
+// xmltvChannelResolver は XMLTV のチャンネルをサービスに割り当てる
+type xmltvChannelResolver struct {
+	opts     XMLTVImportOptions
+	channels map[string]xmltvChannel
+	resolved map[string]*models.Service
+	assigned map[models.ServiceKey]string // 今回の取り込みで割り当てたサービスとチャンネルID
+	created  []*models.Service            // 新しく作成したサービス
+}
+
+func newXMLTVChannelResolver(opts XMLTVImportOptions) *xmltvChannelResolver {
+	return &xmltvChannelResolver{
+		opts:     opts,
+		channels: make(map[string]xmltvChannel),
+		resolved: make(map[string]*models.Service),
+		assigned: make(map[models.ServiceKey]string),
+	}
+}
+
+// resolve はチャンネルIDに対応するサービスを返す
+func (r *xmltvChannelResolver) resolve(channelID string) *models.Service {
+	if s, ok := r.resolved[channelID]; ok {
+		return s
+	}
+
+	channel := r.channels[channelID]
+	name := pickXMLTVText(channel.DisplayNames)
+	if name == "" {
+		name = channelID
+	}
+
+	var service *models.Service
+	if m, ok := r.opts.ChannelMap[channelID]; ok {
+		if existing, ok := models.ServiceMapInstance.Original(m.NetworkID, m.ServiceID); ok {
+			service = existing
+		} else {
+			service = r.newService(models.ServiceKey{NetworkID: m.NetworkID, ServiceID: m.ServiceID}, name, m.ChannelType)
+		}
+	} else if existing := findServiceByName(channel.DisplayNames); existing != nil {
+		service = existing
+	} else {
+		service = r.newService(r.syntheticKey(channelID), name, "")
+	}
+
+	r.resolved[channelID] = service
+	r.assigned[service.Key()] = channelID
+	return service
+}
+
+// newService は XMLTV のチャンネルからサービスを作成する
+func (r *xmltvChannelResolver) newService(key models.ServiceKey, name, channelType string) *models.Service {
+	if channelType == "" {
+		channelType = "GR"
+	}
+	service := &models.Service{
+		ID:          key.MirakurunServiceID(),
+		ServiceID:   key.ServiceID,
+		NetworkID:   key.NetworkID,
+		Name:        name,
+		Type:        models.ChannelTypeNumber(channelType),
+		ChannelType: channelType,
+		Source:      models.XMLTVSourceName,
+	}
+	r.created = append(r.created, service)
+	return service
+}
+
+// syntheticKey はチャンネルIDのハッシュから XMLTVNetworkID のサービスキーを決める
+// 取り込みのたびに同じサービスIDになるので、予約や除外設定が引き継がれる
+func (r *xmltvChannelResolver) syntheticKey(channelID string) models.ServiceKey {
+	h := fnv.New32a()
+	h.Write([]byte(channelID))
+	serviceID := int64(h.Sum32()%99999) + 1
+	for {
+		key := models.ServiceKey{NetworkID: XMLTVNetworkID, ServiceID: serviceID}
+		if _, used := r.assigned[key]; !used {
+			return key
+		}
+		serviceID = serviceID%99999 + 1
+	}
+}
+
+// findServiceByName は表示名が一致する既存のサービスを返す
+// 以前の取り込みで作成したサービスより、Mirakurun から取得したサービスを優先する
+func findServiceByName(names []xmltvText) *models.Service {
+	wanted := make(map[string]bool)
+	for _, n := range names {
+		if v := models.NormalizeForSearch(strings.TrimSpace(n.Value)); v != "" {
+			wanted[v] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	var found *models.Service
+	for _, s := range models.ServiceMapInstance.GetAll() {
+		original, ok := models.ServiceMapInstance.Original(s.NetworkID, s.ServiceID)
+		if !ok {
+			continue
+		}
+		if !wanted[models.NormalizeForSearch(s.Name)] && !wanted[models.NormalizeForSearch(original.Name)] {
+			continue
+		}
+		if original.Source != models.XMLTVSourceName {
+			return original
+		}
+		if found == nil {
+			found = original
+		}
+	}
+	return found
+}
+
+// XMLTVProgramIDBase は XMLTV の番組IDに加える値
+// Mirakurun の番組ID（最大でも 65535 * 10^10 程度）と重ならず、JavaScript の数値でも正確に扱える範囲にする
+const XMLTVProgramIDBase int64 = 1_000_000_000_000_000
+
+// xmltvProgram は XMLTV の番組をサービスの番組に変換する
+// 番組IDは XMLTVProgramIDBase + サービスID * 100000 + イベントID とし、
+// イベントIDの代わりに開始時刻（分）を使う
+func xmltvProgram(p *xmltvProgramme, service *models.Service) (*models.Program, error) {
+	start, err := parseXMLTVTime(p.Start)
+	if err != nil {
+		return nil, err
+	}
+	if p.Stop == "" {
+		return nil, fmt.Errorf("programme on %s at %s has no stop time", p.Channel, p.Start)
+	}
+	stop, err := parseXMLTVTime(p.Stop)
+	if err != nil {
+		return nil, err
+	}
+	if !stop.After(start) {
+		return nil, fmt.Errorf("programme on %s at %s ends before it starts", p.Channel, p.Start)
+	}
+
+	name := pickXMLTVText(p.Titles)
+	if name == "" {
+		return nil, fmt.Errorf("programme on %s at %s has no title", p.Channel, p.Start)
+	}
+	description := pickXMLTVText(p.Descs)
+	if subTitle := pickXMLTVText(p.SubTitles); subTitle != "" {
+		description = strings.TrimSpace(subTitle + "\n" + description)
+	}
+
+	startAt := start.UnixMilli()
+	eventID := (startAt / int64(time.Minute/time.Millisecond)) % 100000
+	return &models.Program{
+		ID:          XMLTVProgramIDBase + service.Key().MirakurunServiceID()*100000 + eventID,
+		ServiceID:   service.ServiceID,
+		NetworkID:   service.NetworkID,
+		StartAt:     startAt,
+		Duration:    stop.UnixMilli() - startAt,
+		Name:        name,
+		Description: description,
+		Source:      models.XMLTVSourceName,
+	}, nil
+}
+
+// ImportXMLTVFile は XMLTV ファイルを読み込んで番組を取り込む
+func ImportXMLTVFile(ctx context.Context, db *sql.DB, path string, opts XMLTVImportOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		models.Log.Error("ImportXMLTV: Failed to open %s: %v", path, err)
+		return err
+	}
+	defer f.Close()
+	models.Log.Info("ImportXMLTV: Importing programs from %s", path)
+	return ImportXMLTV(ctx, db, f, opts)
+}
+
+// ImportXMLTV は XMLTV の番組表を読み込み、チャンネルをサービスに割り当てて番組を保存する
+// 初期ロードと同じく InitialLoadBatchSize 件ごとに保存し、すべて読み込めた場合のみ
+// XMLTV から消えた番組を削除する。進捗は取得元 "xmltv" の初期ロードの進捗として記録する
+func ImportXMLTV(ctx context.Context, db *sql.DB, r io.Reader, opts XMLTVImportOptions) (err error) {
+	source := models.XMLTVSourceName
+	updateInitialLoadProgress(source, func(p *models.InitialLoadProgress) {
+		*p = models.InitialLoadProgress{Source: source, State: models.InitialLoadRunning, StartedAt: time.Now().UnixMilli()}
+	})
+	defer func() {
+		finishInitialLoadProgress(ctx, source, err)
+	}()
+
+	resolver := newXMLTVChannelResolver(opts)
+	seen := make(map[int64]struct{})
+	skipped := 0
+	batch := make([]models.Program, 0, InitialLoadBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := upsertPrograms(db, batch); err != nil {
+			return err
+		}
+		updateInitialLoadProgress(source, func(p *models.InitialLoadProgress) {
+			p.Received += len(batch)
+		})
+		batch = batch[:0]
+		return nil
+	}
+
+	decoder := xml.NewDecoder(r)
+	// 文字コードの宣言は UTF-8 のみ対応し、それ以外はそのまま読み込む
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			models.Log.Error("ImportXMLTV: XML decode error: %v", err)
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "channel":
+			var c xmltvChannel
+			if err := decoder.DecodeElement(&c, &start); err != nil {
+				return err
+			}
+			resolver.channels[c.ID] = c
+		case "programme":
+			var xp xmltvProgramme
+			if err := decoder.DecodeElement(&xp, &start); err != nil {
+				return err
+			}
+			p, err := xmltvProgram(&xp, resolver.resolve(xp.Channel))
+			if err != nil {
+				models.Log.Debug("ImportXMLTV: Skipping programme: %v", err)
+				skipped++
+				continue
+			}
+			seen[p.ID] = struct{}{}
+			batch = append(batch, *p)
+			if len(batch) == InitialLoadBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	// 新しく作成したサービスを保存し、局情報として参照できるようにする
+	if len(resolver.created) > 0 {
+		if err := SaveServices(db, resolver.created); err != nil {
+			return err
+		}
+		for _, s := range resolver.created {
+			models.ServiceMapInstance.Update(s)
+		}
+	}
+
+	if len(seen) == 0 {
+		models.Log.Info("ImportXMLTV: No programs imported (skipped %d), keeping existing programs", skipped)
+		return nil
+	}
+
+	removed, archived, err := removeVanishedPrograms(db, source, seen, false)
+	if err != nil {
+		models.Log.Error("ImportXMLTV: Failed to remove vanished programs: %v", err)
+		return err
+	}
+	updateInitialLoadProgress(source, func(p *models.InitialLoadProgress) {
+		p.Removed = int(removed)
+		p.Archived = int(archived)
+	})
+
+	models.Log.Info("ImportXMLTV: Imported %d programs on %d channels (skipped %d, removed %d, archived %d, new services %d)",
+		len(seen), len(resolver.resolved), skipped, removed, archived, len(resolver.created))
+	return nil
+}
+
+// StartXMLTVWatcher は XMLTV ファイルを interval ごとに確認し、更新されていれば取り込む
+// 取り込みに失敗した場合（書き込み途中のファイルなど）は次の確認時に再度取り込む
+func StartXMLTVWatcher(ctx context.Context, db *sql.DB, path string, opts XMLTVImportOptions, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultXMLTVWatchInterval
+	}
+	models.Log.Info("StartXMLTVWatcher: Watching %s every %v", path, interval)
+
+	var lastModTime time.Time
+	lastSize := int64(-1)
+	for {
+		if info, err := os.Stat(path); err != nil {
+			models.Log.Error("StartXMLTVWatcher: Failed to stat %s: %v", path, err)
+		} else if !info.ModTime().Equal(lastModTime) || info.Size() != lastSize {
+			if err := ImportXMLTVFile(ctx, db, path, opts); err != nil {
+				models.Log.Error("StartXMLTVWatcher: Failed to import %s: %v", path, err)
+			} else {
+				lastModTime = info.ModTime()
+				lastSize = info.Size()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			models.Log.Info("StartXMLTVWatcher: Stopped")
+			return
+		case <-time.After(interval):
+		}
+	}
+}
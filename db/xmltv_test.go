@@ -0,0 +1,192 @@
+// db/xmltv_test.go
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestParseXMLTVTime(t *testing.T) {
+	got, err := parseXMLTVTime("20240101213000 +0900")
+	if err != nil {
+		t.Fatalf("parseXMLTVTime failed: %v", err)
+	}
+	if want := time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// 秒が省略されていても解析できる
+	if got, err := parseXMLTVTime("202401012130 +0900"); err != nil || got.Unix() != time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC).Unix() {
+		t.Errorf("Expected short form to parse, got %v (%v)", got, err)
+	}
+
+	for _, s := range []string{"", "2024", "20240101213000 JST"} {
+		if _, err := parseXMLTVTime(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func xmltvTimestamp(t time.Time) string {
+	return t.UTC().Format("20060102150405") + " +0000"
+}
+
+func TestImportXMLTV(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	models.ServiceMapInstance = models.NewServiceMap()
+	// Mirakurun から取得済みのサービスは表示名で割り当てる
+	models.ServiceMapInstance.Update(&models.Service{ID: 400101, NetworkID: 4, ServiceID: 101, Name: "ＢＳ１", Type: 2, ChannelType: "BS"})
+
+	// 消えるはずの以前の取り込み結果
+	if _, err := db.Exec(`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch, source)
+		VALUES (1, 101, 4, ?, 1800000, 'old', '', 'old', '', 'xmltv')`, time.Now().Add(time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("Failed to insert program: %v", err)
+	}
+
+	start := time.Now().Add(time.Hour).Truncate(time.Minute)
+	doc := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<tv>
+  <channel id="bs1.example"><display-name>BS1</display-name></channel>
+  <channel id="gr1.example"><display-name>Ｇ局</display-name></channel>
+  <channel id="local.example"><display-name lang="en">Local</display-name><display-name lang="ja">ローカル局</display-name></channel>
+  <programme start="%[1]s" stop="%[2]s" channel="bs1.example">
+    <title lang="en">News</title><title lang="ja">ニュース</title>
+    <sub-title>第1回</sub-title>
+    <desc lang="ja">今日のできごと</desc>
+  </programme>
+  <programme start="%[1]s" stop="%[2]s" channel="gr1.example"><title>ドラマ</title></programme>
+  <programme start="%[1]s" stop="%[2]s" channel="local.example"><title>天気</title></programme>
+  <programme start="%[1]s" channel="local.example"><title>終了時刻なし</title></programme>
+</tv>`, xmltvTimestamp(start), xmltvTimestamp(start.Add(30*time.Minute)))
+
+	opts := XMLTVImportOptions{ChannelMap: map[string]models.XMLTVChannelMapping{
+		"gr1.example": {NetworkID: 32736, ServiceID: 1024, ChannelType: "GR"},
+	}}
+	if err := ImportXMLTV(context.Background(), db, strings.NewReader(doc), opts); err != nil {
+		t.Fatalf("ImportXMLTV failed: %v", err)
+	}
+
+	programs, err := SearchPrograms(db, "", 0, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("SearchPrograms failed: %v", err)
+	}
+	byName := make(map[string]models.Program)
+	for _, p := range programs {
+		byName[p.Name] = p
+	}
+	if len(byName) != 3 {
+		t.Fatalf("Expected 3 imported programs, got %+v", programs)
+	}
+
+	news := byName["ニュース"]
+	if news.NetworkID != 4 || news.ServiceID != 101 {
+		t.Errorf("Expected news to be assigned to existing BS service, got %d/%d", news.NetworkID, news.ServiceID)
+	}
+	if news.Description != "第1回\n今日のできごと" || news.Duration != 1800000 || news.StartAt != start.UnixMilli() {
+		t.Errorf("Unexpected news program: %+v", news)
+	}
+	if news.ID < XMLTVProgramIDBase || (news.ID-XMLTVProgramIDBase)/100000 != 400101 {
+		t.Errorf("Expected program ID to be derived from the service, got %d", news.ID)
+	}
+
+	if drama := byName["ドラマ"]; drama.NetworkID != 32736 || drama.ServiceID != 1024 {
+		t.Errorf("Expected drama to be assigned by channel map, got %d/%d", drama.NetworkID, drama.ServiceID)
+	}
+	weather := byName["天気"]
+	if weather.NetworkID != XMLTVNetworkID {
+		t.Errorf("Expected unknown channel to get a synthetic service, got %d/%d", weather.NetworkID, weather.ServiceID)
+	}
+	if s, ok := models.ServiceMapInstance.Lookup(weather.NetworkID, weather.ServiceID); !ok || s.Name != "ローカル局" || s.Source != models.XMLTVSourceName {
+		t.Errorf("Expected synthetic service to be registered, got %+v", s)
+	}
+
+	// 正規化した番組名で検索できる
+	if found, err := SearchPrograms(db, "ﾆｭｰｽ", 0, 0, 0, 0); err != nil || len(found) != 1 {
+		t.Errorf("Expected news to be found by half-width query, got %d (%v)", len(found), err)
+	}
+	if found, err := SearchPrograms(db, "ニュース", 0, 0, 0, 2); err != nil || len(found) != 1 {
+		t.Errorf("Expected news to be found by channel type, got %d (%v)", len(found), err)
+	}
+
+	if _, err := GetProgramByID(db, 1); err == nil {
+		t.Error("Expected program missing from the XMLTV file to be removed")
+	}
+
+	// 再取り込みしても同じサービス・番組IDになる
+	if err := ImportXMLTV(context.Background(), db, strings.NewReader(doc), opts); err != nil {
+		t.Fatalf("Second ImportXMLTV failed: %v", err)
+	}
+	again, _ := SearchPrograms(db, "天気", 0, 0, 0, 0)
+	if len(again) != 1 || again[0].ID != weather.ID {
+		t.Errorf("Expected re-import to keep program ID %d, got %+v", weather.ID, again)
+	}
+
+	progress := GetInitialLoadProgress(models.XMLTVSourceName)
+	if progress.State != models.InitialLoadCompleted || progress.Received != 3 {
+		t.Errorf("Unexpected import progress: %+v", progress)
+	}
+}
+
+func TestImportXMLTVKeepsProgramsOnError(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch, source)
+		VALUES (1, 101, 4, ?, 1800000, 'old', '', 'old', '', 'xmltv')`, time.Now().Add(time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("Failed to insert program: %v", err)
+	}
+
+	// 書き込み途中のファイル
+	if err := ImportXMLTV(context.Background(), db, strings.NewReader(`<tv><channel id="a"><display-name>A</disp`), XMLTVImportOptions{}); err == nil {
+		t.Error("Expected truncated XMLTV to fail")
+	}
+	if _, err := GetProgramByID(db, 1); err != nil {
+		t.Errorf("Expected existing program to remain: %v", err)
+	}
+	if progress := GetInitialLoadProgress(models.XMLTVSourceName); progress.State != models.InitialLoadFailed {
+		t.Errorf("Expected failed progress, got %+v", progress)
+	}
+}
+
+func TestImportXMLTVKeepsLegacyPrograms(t *testing.T) {
+	models.InitLogger("error")
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+	models.ServiceMapInstance = models.NewServiceMap()
+
+	// 取得元が記録されていない旧バージョンの Mirakurun の番組は XMLTV の取り込みで削除しない
+	if _, err := db.Exec(`INSERT INTO programs (id, serviceId, networkId, startAt, duration, name, description, nameForSearch, descForSearch)
+		VALUES (1, 101, 4, ?, 1800000, 'legacy', '', 'legacy', '')`, time.Now().Add(time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("Failed to insert program: %v", err)
+	}
+
+	start := time.Now().Add(time.Hour).Truncate(time.Minute)
+	doc := fmt.Sprintf(`<tv><channel id="local.example"><display-name>ローカル局</display-name></channel>
+<programme start="%s" stop="%s" channel="local.example"><title>天気</title></programme></tv>`,
+		xmltvTimestamp(start), xmltvTimestamp(start.Add(30*time.Minute)))
+	if err := ImportXMLTV(context.Background(), db, strings.NewReader(doc), XMLTVImportOptions{}); err != nil {
+		t.Fatalf("ImportXMLTV failed: %v", err)
+	}
+
+	if _, err := GetProgramByID(db, 1); err != nil {
+		t.Errorf("Expected legacy program without source to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build demo
+
+// demo.go
+// --demo で使う合成データの生成。テスト用のパッケージを本番のバイナリに含めないよう、
+// demo ビルドタグを指定した場合（go run -tags demo . --demo）のみビルドする
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/testing/epggen"
+)
+
+// demoAvailable は --demo を使えるビルドかどうか
+const demoAvailable = true
+
+// demoSourceName は --demo で生成した番組・サービスの取得元の名前
+const demoSourceName = "demo"
+
+// seedDemoData は合成した番組表（サービスと現在から1週間分の番組）を保存する
+// DEMO_SERVICES でサービス数を変更できる
+func seedDemoData(dbConn *sql.DB) error {
+	cfg := epggen.Config{Source: demoSourceName}
+	if servicesStr := os.Getenv("DEMO_SERVICES"); servicesStr != "" {
+		n, err := strconv.Atoi(servicesStr)
+		if err != nil || n <= 0 {
+			models.Log.Error("Invalid DEMO_SERVICES: %s, using default", servicesStr)
+		} else {
+			cfg.Services = n
+		}
+	}
+	data := epggen.Generate(cfg)
+	if err := db.SaveServices(dbConn, data.Services); err != nil {
+		return err
+	}
+	for _, s := range data.Services {
+		models.ServiceMapInstance.Update(s)
+	}
+	if err := db.ReplacePrograms(dbConn, demoSourceName, data.Programs); err != nil {
+		return err
+	}
+	models.Log.Info("Seeded demo data: %d services, %d programs", len(data.Services), len(data.Programs))
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !demo
+
+// demo_disabled.go
+// demo ビルドタグを指定しない場合の --demo（合成データの生成を含めない）
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// demoAvailable は --demo を使えるビルドかどうか
+const demoAvailable = false
+
+// seedDemoData は demo ビルドタグ無しでは使えないことを返す
+func seedDemoData(dbConn *sql.DB) error {
+	return errors.New("--demo requires a build with -tags demo (go run -tags demo . --demo)")
+}
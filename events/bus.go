@@ -0,0 +1,196 @@
+// events/bus.go
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// イベントのリソース種別
+const (
+	ResourceProgram            = "program"
+	ResourceService            = "service"
+	ResourceReservation        = "reservation"
+	ResourceAutoReservationLog = "autoReservationLog"
+	// ResourceStream はストリーム自体の制御用イベント（再同期要求など）
+	ResourceStream = "stream"
+)
+
+// Event はクライアントへ配信する変更通知
+type Event struct {
+	ID       uint64      `json:"id"`       // 単調増加のイベントID（再開トークンとして使用）。再同期要求は0
+	Resource string      `json:"resource"` // "program", "service", "reservation", "autoReservationLog"
+	Type     string      `json:"type"`     // "create", "update", "remove" など
+	Data     interface{} `json:"data"`
+	Time     int64       `json:"time"`
+}
+
+// 再同期要求（"stream/resync" イベント）の理由
+const (
+	ResyncExpired   = "expired"   // 再開トークンが保持範囲より古い
+	ResyncRestarted = "restarted" // 再開トークンが再起動前のプロセスのもの
+	ResyncOverflow  = "overflow"  // 受信が追いつかずイベントを破棄した
+)
+
+// Subscription は購読者ごとの受信チャネルを保持する
+type Subscription struct {
+	C          chan Event
+	resources  map[string]bool
+	bus        *Bus
+	overflowed bool // 再同期要求を送り、購読者が受信し終えるまでイベントを破棄している
+}
+
+// Matches は購読者のリソースフィルタにイベントが一致するかを返す
+func (s *Subscription) Matches(e Event) bool {
+	if e.Resource == ResourceStream || len(s.resources) == 0 {
+		return true
+	}
+	return s.resources[e.Resource]
+}
+
+// Close は購読を解除する
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus はプロセス内のイベント配信ハブ
+// 直近のイベントをリングバッファに保持し、再開トークン以降のイベントを再送できる
+type Bus struct {
+	mu          sync.Mutex
+	epoch       uint64
+	nextID      uint64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]bool
+}
+
+// NewBus は新しいBusを作成する
+// イベントIDは作成時刻（Unixミリ秒 * 1000）の続きから振るため、再起動前のIDと重ならず、
+// 再起動前の再開トークンを見分けられる（JavaScript の数値でも正確に扱える範囲に収まる）
+func NewBus(historySize int) *Bus {
+	epoch := uint64(time.Now().UnixMilli()) * 1000
+	return &Bus{
+		epoch:       epoch,
+		nextID:      epoch + 1,
+		historySize: historySize,
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// resyncEvent は取りこぼしがあったことを示す "stream/resync" イベントを返す
+func resyncEvent(reason string, oldestID uint64) Event {
+	return Event{
+		Resource: ResourceStream,
+		Type:     "resync",
+		Data:     map[string]interface{}{"reason": reason, "oldestId": oldestID},
+		Time:     time.Now().UnixMilli(),
+	}
+}
+
+// Publish はイベントを発行する
+// 受信が追いつかない購読者にはイベントの代わりに再同期要求を送り、受信し終えるまでイベントを破棄する
+func (b *Bus) Publish(resource, eventType string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e := Event{
+		ID:       b.nextID,
+		Resource: resource,
+		Type:     eventType,
+		Data:     data,
+		Time:     time.Now().UnixMilli(),
+	}
+	b.nextID++
+
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		if !sub.Matches(e) {
+			continue
+		}
+		// 送信は Publish のみがロック中に行うので、空きを確認してから送っても詰まらない
+		if sub.overflowed {
+			if len(sub.C) > 0 {
+				continue
+			}
+			sub.overflowed = false
+		}
+		if len(sub.C) < cap(sub.C)-1 {
+			sub.C <- e
+			continue
+		}
+		// 最後の1枠に再同期要求を入れ、購読者に一覧の再取得を促す
+		sub.overflowed = true
+		sub.C <- resyncEvent(ResyncOverflow, e.ID)
+		models.Log.Error("EventBus: Subscriber buffer full, dropping events from %d (%s/%s) until it catches up", e.ID, e.Resource, e.Type)
+	}
+}
+
+// Subscribe は購読を開始する
+// resources が空の場合はすべてのリソースを購読する
+// since が0より大きい場合、そのID以降の保持済みイベントを先に返す
+// 保持範囲より古いIDや再起動前のプロセスのIDが指定された場合は、取りこぼしを示す "stream/resync" イベントを先頭に含める
+func (b *Bus) Subscribe(resources []string, since uint64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{
+		C:         make(chan Event, 256),
+		resources: make(map[string]bool),
+		bus:       b,
+	}
+	for _, r := range resources {
+		if r != "" {
+			sub.resources[r] = true
+		}
+	}
+	b.subscribers[sub] = true
+
+	var backlog []Event
+	if since > 0 {
+		oldest := b.nextID
+		if len(b.history) > 0 {
+			oldest = b.history[0].ID
+		}
+		switch {
+		case since < b.epoch || since >= b.nextID:
+			backlog = append(backlog, resyncEvent(ResyncRestarted, oldest))
+		case oldest > since+1:
+			backlog = append(backlog, resyncEvent(ResyncExpired, oldest))
+		}
+		for _, e := range b.history {
+			if e.ID > since && sub.Matches(e) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	models.Log.Debug("EventBus: New subscriber (resources=%v, since=%d, backlog=%d)", resources, since, len(backlog))
+	return sub, backlog
+}
+
+// LastID は最後に発行したイベントのIDを返す
+func (b *Bus) LastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextID - 1
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, sub)
+}
+
+// Default はアプリケーション全体で使用するBusのインスタンス
+var Default = NewBus(1000)
+
+// Publish はDefaultバスにイベントを発行する
+func Publish(resource, eventType string, data interface{}) {
+	Default.Publish(resource, eventType, data)
+}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func init() {
+	// テスト用にロガーを初期化
+	models.InitLogger("debug")
+}
+
+func TestBusPublishSubscribe(t *testing.T) {
+	bus := NewBus(10)
+
+	all, _ := bus.Subscribe(nil, 0)
+	defer all.Close()
+	reservationsOnly, _ := bus.Subscribe([]string{ResourceReservation}, 0)
+	defer reservationsOnly.Close()
+
+	last := bus.LastID()
+	bus.Publish(ResourceProgram, "create", 1)
+	bus.Publish(ResourceReservation, "update", 2)
+
+	if got := len(all.C); got != 2 {
+		t.Fatalf("expected 2 events for unfiltered subscriber, got %d", got)
+	}
+	if got := len(reservationsOnly.C); got != 1 {
+		t.Fatalf("expected 1 event for filtered subscriber, got %d", got)
+	}
+	e := <-reservationsOnly.C
+	if e.Resource != ResourceReservation || e.ID != last+2 {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestBusResume(t *testing.T) {
+	bus := NewBus(3)
+	base := bus.LastID()
+	for i := 0; i < 5; i++ {
+		bus.Publish(ResourceProgram, "update", i)
+	}
+
+	// 保持範囲内からの再開
+	sub, backlog := bus.Subscribe(nil, base+3)
+	sub.Close()
+	if len(backlog) != 2 || backlog[0].ID != base+4 || backlog[1].ID != base+5 {
+		t.Errorf("unexpected backlog when resuming from 3: %+v", backlog)
+	}
+
+	// 保持範囲外からの再開は resync を先頭に含む
+	sub, backlog = bus.Subscribe(nil, base+1)
+	sub.Close()
+	if len(backlog) != 4 || backlog[0].Resource != ResourceStream || backlog[0].Type != "resync" {
+		t.Errorf("expected resync followed by 3 events, got %+v", backlog)
+	}
+	if reason := backlog[0].Data.(map[string]interface{})["reason"]; reason != ResyncExpired {
+		t.Errorf("expected resync reason %s, got %v", ResyncExpired, reason)
+	}
+}
+
+func TestBusResumeAfterRestart(t *testing.T) {
+	// 再起動前のプロセスの再開トークン
+	old := NewBus(10)
+	old.Publish(ResourceProgram, "update", 1)
+	staleID := old.LastID()
+
+	time.Sleep(2 * time.Millisecond)
+	bus := NewBus(10)
+	if bus.LastID() <= staleID {
+		t.Fatalf("expected event IDs to continue after a restart, got %d <= %d", bus.LastID(), staleID)
+	}
+	for _, since := range []uint64{1, bus.LastID() + 10} {
+		sub, backlog := bus.Subscribe(nil, since)
+		sub.Close()
+		if len(backlog) != 1 || backlog[0].Type != "resync" ||
+			backlog[0].Data.(map[string]interface{})["reason"] != ResyncRestarted {
+			t.Errorf("expected restarted resync for since=%d, got %+v", since, backlog)
+		}
+	}
+
+	// 現在のプロセスの最新IDからの再開では resync を送らない
+	sub, backlog := bus.Subscribe(nil, bus.LastID())
+	sub.Close()
+	if len(backlog) != 0 {
+		t.Errorf("expected no backlog when resuming from the latest ID, got %+v", backlog)
+	}
+}
+
+func TestBusOverflowSendsResync(t *testing.T) {
+	bus := NewBus(10)
+	sub, _ := bus.Subscribe(nil, 0)
+	defer sub.Close()
+
+	size := cap(sub.C)
+	for i := 0; i < size+10; i++ {
+		bus.Publish(ResourceProgram, "update", i)
+	}
+
+	// 最後の1枠は再同期要求になり、それ以降のイベントは破棄される
+	if got := len(sub.C); got != size {
+		t.Fatalf("expected a full buffer, got %d", got)
+	}
+	var last Event
+	for len(sub.C) > 0 {
+		last = <-sub.C
+	}
+	if last.Resource != ResourceStream || last.Type != "resync" ||
+		last.Data.(map[string]interface{})["reason"] != ResyncOverflow {
+		t.Fatalf("expected overflow resync at the end of the buffer, got %+v", last)
+	}
+
+	// 受信し終えた後は再び配信される
+	bus.Publish(ResourceProgram, "update", "after")
+	select {
+	case e := <-sub.C:
+		if e.Data != "after" {
+			t.Errorf("unexpected event after resync: %+v", e)
+		}
+	default:
+		t.Errorf("expected events to be delivered after the subscriber caught up")
+	}
+}
@@ -7,6 +7,7 @@ toolchain go1.23.8
 require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
 	github.com/mattn/go-sqlite3 v1.14.27
 	golang.org/x/text v0.23.0
 )
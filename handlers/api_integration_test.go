@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
 	"github.com/fuba/iepg-server/db"
@@ -250,17 +251,13 @@ func TestExcludeUnexcludeAPIIntegration(t *testing.T) {
 func TestRealServiceExcludeUnexclude(t *testing.T) {
 	models.InitLogger("debug")
 
-	// 実際のデータベースを使用したテスト
-	dbPath := "./test_programs.db"
+	// ファイルのデータベースを使用したテスト（テスト後に自動で削除される）
+	dbPath := filepath.Join(t.TempDir(), "test_programs.db")
 	dbConn, err := db.InitDB(dbPath)
 	if err != nil {
 		t.Fatalf("failed to initialize database: %v", err)
 	}
 	defer dbConn.Close()
-	defer func() {
-		// テスト後にテストDBを削除
-		// os.Remove(dbPath)
-	}()
 
 	// 実際のサーバーにHTTPリクエストを送信してテスト
 	t.Run("Test with real API calls", func(t *testing.T) {
@@ -4,23 +4,186 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fuba/iepg-server/db"
 	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/services"
 )
 
 // CreateAutoReservationRuleRequest represents the request payload for creating an auto reservation rule
 type CreateAutoReservationRuleRequest struct {
-	Type        string                   `json:"type"`        // "keyword" or "series"
-	Name        string                   `json:"name"`
+	Type        string                   `json:"type" required:"true" enum:"keyword,series,person"` // "keyword", "series" or "person"
+	Name        string                   `json:"name" required:"true"`
 	Enabled     bool                     `json:"enabled"`
 	Priority    int                      `json:"priority"`
-	RecorderURL string                   `json:"recorderUrl"`
-	KeywordRule *models.KeywordRule      `json:"keywordRule,omitempty"`
-	SeriesRule  *models.SeriesRule       `json:"seriesRule,omitempty"`
+	RecorderURL string                   `json:"recorderUrl" required:"true"`
+	KeywordRule *models.KeywordRule      `json:"keywordRule,omitempty" desc:"Required for keyword rules"`
+	SeriesRule  *models.SeriesRule       `json:"seriesRule,omitempty" desc:"Required for series rules"`
+	PersonRule  *models.PersonRule       `json:"personRule,omitempty" desc:"Required for person rules"`
+}
+
+// validate checks the rule request and returns a user-facing error message.
+// requireDetails demands the keyword/series/person specific data, which is optional on update.
+// A channel group given by name is resolved to its ID.
+func (req *CreateAutoReservationRuleRequest) validate(database *sql.DB, requireDetails bool) string {
+	if req.Name == "" {
+		return "Name is required"
+	}
+	if req.Type != "keyword" && req.Type != "series" && req.Type != "person" {
+		return "Type must be 'keyword', 'series' or 'person'"
+	}
+	if req.RecorderURL == "" {
+		return "RecorderURL is required"
+	}
+	if req.Type == "keyword" && req.KeywordRule != nil && req.KeywordRule.GroupID != "" {
+		group, err := db.GetChannelGroup(database, req.KeywordRule.GroupID)
+		if err != nil {
+			return "Unknown group: " + req.KeywordRule.GroupID
+		}
+		req.KeywordRule.GroupID = group.ID
+	}
+	if req.Type == "keyword" && req.KeywordRule != nil {
+		for _, attr := range append(append([]string{}, req.KeywordRule.Attributes...), req.KeywordRule.ExcludeAttributes...) {
+			if !models.IsProgramAttribute(attr) {
+				return "Unknown attribute: " + attr
+			}
+		}
+	}
+	if req.Type == "person" && req.PersonRule != nil && req.PersonRule.GroupID != "" {
+		group, err := db.GetChannelGroup(database, req.PersonRule.GroupID)
+		if err != nil {
+			return "Unknown group: " + req.PersonRule.GroupID
+		}
+		req.PersonRule.GroupID = group.ID
+	}
+	if err := req.scheduleFilter().Validate(); err != nil {
+		return "Invalid schedule: " + err.Error()
+	}
+	if !requireDetails {
+		return ""
+	}
+
+	// Validate rule-specific data
+	if req.Type == "keyword" {
+		if req.KeywordRule == nil {
+			return "KeywordRule is required for keyword type"
+		}
+		if len(req.KeywordRule.Keywords) == 0 {
+			return "At least one keyword is required"
+		}
+	} else if req.Type == "series" {
+		if req.SeriesRule == nil {
+			return "SeriesRule is required for series type"
+		}
+		if req.SeriesRule.SeriesID == "" {
+			return "SeriesID is required"
+		}
+	} else if req.Type == "person" {
+		if req.PersonRule == nil {
+			return "PersonRule is required for person type"
+		}
+		if models.NormalizePersonName(req.PersonRule.Person) == "" {
+			return "Person is required"
+		}
+	}
+	return ""
+}
+
+// scheduleFilter returns the airtime/weekday/duration conditions given for the rule type
+func (req *CreateAutoReservationRuleRequest) scheduleFilter() models.ScheduleFilter {
+	switch {
+	case req.Type == "keyword" && req.KeywordRule != nil:
+		return req.KeywordRule.ScheduleFilter
+	case req.Type == "series" && req.SeriesRule != nil:
+		return req.SeriesRule.ScheduleFilter
+	case req.Type == "person" && req.PersonRule != nil:
+		return req.PersonRule.ScheduleFilter
+	}
+	return models.ScheduleFilter{}
+}
+
+// saveRuleDetails stores the keyword/series/person specific data of the rule
+func saveRuleDetails(database *sql.DB, ruleID string, req *CreateAutoReservationRuleRequest) error {
+	if req.Type == "keyword" && req.KeywordRule != nil {
+		req.KeywordRule.RuleID = ruleID
+		if err := db.CreateKeywordRule(database, req.KeywordRule); err != nil {
+			return fmt.Errorf("failed to save keyword rule: %w", err)
+		}
+	} else if req.Type == "series" && req.SeriesRule != nil {
+		req.SeriesRule.RuleID = ruleID
+		if err := db.CreateSeriesRule(database, req.SeriesRule); err != nil {
+			return fmt.Errorf("failed to save series rule: %w", err)
+		}
+	} else if req.Type == "person" && req.PersonRule != nil {
+		req.PersonRule.RuleID = ruleID
+		if err := db.CreatePersonRule(database, req.PersonRule); err != nil {
+			return fmt.Errorf("failed to save person rule: %w", err)
+		}
+	}
+	return nil
+}
+
+// createAutoReservationRule creates a validated rule with its details and returns the stored rule
+func createAutoReservationRule(database *sql.DB, req *CreateAutoReservationRuleRequest) (*models.AutoReservationRuleWithDetails, error) {
+	// Create main rule
+	rule := &models.AutoReservationRule{
+		Type:        req.Type,
+		Name:        req.Name,
+		Enabled:     req.Enabled,
+		Priority:    req.Priority,
+		RecorderURL: req.RecorderURL,
+	}
+
+	if err := db.CreateAutoReservationRule(database, rule); err != nil {
+		return nil, fmt.Errorf("failed to create rule: %w", err)
+	}
+
+	// Create rule-specific data
+	if err := saveRuleDetails(database, rule.ID, req); err != nil {
+		// Try to cleanup the main rule
+		db.DeleteAutoReservationRule(database, rule.ID)
+		return nil, err
+	}
+
+	models.Log.Info("createAutoReservationRule: Created rule %s (%s)", rule.ID, rule.Name)
+	return db.GetAutoReservationRuleByID(database, rule.ID)
+}
+
+// updateAutoReservationRule updates a validated rule. It returns sql.ErrNoRows if the rule does not exist
+func updateAutoReservationRule(database *sql.DB, id string, req *CreateAutoReservationRuleRequest) (*models.AutoReservationRuleWithDetails, error) {
+	// Check if rule exists
+	existingRule, err := db.GetAutoReservationRuleByID(database, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update main rule
+	rule := &models.AutoReservationRule{
+		ID:          id,
+		Type:        req.Type,
+		Name:        req.Name,
+		Enabled:     req.Enabled,
+		Priority:    req.Priority,
+		RecorderURL: req.RecorderURL,
+		CreatedAt:   existingRule.CreatedAt, // Keep original creation time
+	}
+
+	if err := db.UpdateAutoReservationRule(database, rule); err != nil {
+		return nil, fmt.Errorf("failed to update rule: %w", err)
+	}
+
+	// Update rule-specific data
+	if err := saveRuleDetails(database, id, req); err != nil {
+		return nil, err
+	}
+
+	models.Log.Info("updateAutoReservationRule: Updated rule %s", id)
+	return db.GetAutoReservationRuleByID(database, id)
 }
 
 // HandleCreateAutoReservationRule handles POST /auto-reservations/rules
@@ -35,90 +198,21 @@ func HandleCreateAutoReservationRule(database *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Validate request
-		if req.Name == "" {
-			http.Error(w, "Name is required", http.StatusBadRequest)
-			return
-		}
-		if req.Type != "keyword" && req.Type != "series" {
-			http.Error(w, "Type must be 'keyword' or 'series'", http.StatusBadRequest)
-			return
-		}
-		if req.RecorderURL == "" {
-			http.Error(w, "RecorderURL is required", http.StatusBadRequest)
-			return
-		}
-
-		// Validate rule-specific data
-		if req.Type == "keyword" {
-			if req.KeywordRule == nil {
-				http.Error(w, "KeywordRule is required for keyword type", http.StatusBadRequest)
-				return
-			}
-			if len(req.KeywordRule.Keywords) == 0 {
-				http.Error(w, "At least one keyword is required", http.StatusBadRequest)
-				return
-			}
-		} else if req.Type == "series" {
-			if req.SeriesRule == nil {
-				http.Error(w, "SeriesRule is required for series type", http.StatusBadRequest)
-				return
-			}
-			if req.SeriesRule.SeriesID == "" {
-				http.Error(w, "SeriesID is required", http.StatusBadRequest)
-				return
-			}
-		}
-
-		// Create main rule
-		rule := &models.AutoReservationRule{
-			Type:        req.Type,
-			Name:        req.Name,
-			Enabled:     req.Enabled,
-			Priority:    req.Priority,
-			RecorderURL: req.RecorderURL,
-		}
-
-		if err := db.CreateAutoReservationRule(database, rule); err != nil {
-			models.Log.Error("HandleCreateAutoReservationRule: Failed to create rule: %v", err)
-			http.Error(w, "Failed to create rule", http.StatusInternalServerError)
+		if msg := req.validate(database, true); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
 			return
 		}
 
-		// Create rule-specific data
-		if req.Type == "keyword" && req.KeywordRule != nil {
-			req.KeywordRule.RuleID = rule.ID
-			if err := db.CreateKeywordRule(database, req.KeywordRule); err != nil {
-				models.Log.Error("HandleCreateAutoReservationRule: Failed to create keyword rule: %v", err)
-				// Try to cleanup the main rule
-				db.DeleteAutoReservationRule(database, rule.ID)
-				http.Error(w, "Failed to create keyword rule", http.StatusInternalServerError)
-				return
-			}
-		} else if req.Type == "series" && req.SeriesRule != nil {
-			req.SeriesRule.RuleID = rule.ID
-			if err := db.CreateSeriesRule(database, req.SeriesRule); err != nil {
-				models.Log.Error("HandleCreateAutoReservationRule: Failed to create series rule: %v", err)
-				// Try to cleanup the main rule
-				db.DeleteAutoReservationRule(database, rule.ID)
-				http.Error(w, "Failed to create series rule", http.StatusInternalServerError)
-				return
-			}
-		}
-
-		// Return created rule with details
-		createdRule, err := db.GetAutoReservationRuleByID(database, rule.ID)
+		createdRule, err := createAutoReservationRule(database, &req)
 		if err != nil {
-			models.Log.Error("HandleCreateAutoReservationRule: Failed to retrieve created rule: %v", err)
-			http.Error(w, "Rule created but failed to retrieve", http.StatusInternalServerError)
+			models.Log.Error("HandleCreateAutoReservationRule: %v", err)
+			http.Error(w, "Failed to create rule", http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(createdRule)
-
-		models.Log.Info("HandleCreateAutoReservationRule: Created rule %s (%s)", rule.ID, rule.Name)
 	}
 }
 
@@ -190,78 +284,24 @@ func HandleUpdateAutoReservationRule(database *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Validate request
-		if req.Name == "" {
-			http.Error(w, "Name is required", http.StatusBadRequest)
-			return
-		}
-		if req.Type != "keyword" && req.Type != "series" {
-			http.Error(w, "Type must be 'keyword' or 'series'", http.StatusBadRequest)
-			return
-		}
-		if req.RecorderURL == "" {
-			http.Error(w, "RecorderURL is required", http.StatusBadRequest)
+		if msg := req.validate(database, false); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
 			return
 		}
 
-		// Check if rule exists
-		existingRule, err := db.GetAutoReservationRuleByID(database, id)
+		updatedRule, err := updateAutoReservationRule(database, id, &req)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				http.Error(w, "Rule not found", http.StatusNotFound)
 				return
 			}
-			models.Log.Error("HandleUpdateAutoReservationRule: Failed to get existing rule: %v", err)
-			http.Error(w, "Failed to get rule", http.StatusInternalServerError)
-			return
-		}
-
-		// Update main rule
-		rule := &models.AutoReservationRule{
-			ID:          id,
-			Type:        req.Type,
-			Name:        req.Name,
-			Enabled:     req.Enabled,
-			Priority:    req.Priority,
-			RecorderURL: req.RecorderURL,
-			CreatedAt:   existingRule.CreatedAt, // Keep original creation time
-		}
-
-		if err := db.UpdateAutoReservationRule(database, rule); err != nil {
-			models.Log.Error("HandleUpdateAutoReservationRule: Failed to update rule: %v", err)
+			models.Log.Error("HandleUpdateAutoReservationRule: %v", err)
 			http.Error(w, "Failed to update rule", http.StatusInternalServerError)
 			return
 		}
 
-		// Update rule-specific data
-		if req.Type == "keyword" && req.KeywordRule != nil {
-			req.KeywordRule.RuleID = id
-			if err := db.CreateKeywordRule(database, req.KeywordRule); err != nil {
-				models.Log.Error("HandleUpdateAutoReservationRule: Failed to update keyword rule: %v", err)
-				http.Error(w, "Failed to update keyword rule", http.StatusInternalServerError)
-				return
-			}
-		} else if req.Type == "series" && req.SeriesRule != nil {
-			req.SeriesRule.RuleID = id
-			if err := db.CreateSeriesRule(database, req.SeriesRule); err != nil {
-				models.Log.Error("HandleUpdateAutoReservationRule: Failed to update series rule: %v", err)
-				http.Error(w, "Failed to update series rule", http.StatusInternalServerError)
-				return
-			}
-		}
-
-		// Return updated rule with details
-		updatedRule, err := db.GetAutoReservationRuleByID(database, id)
-		if err != nil {
-			models.Log.Error("HandleUpdateAutoReservationRule: Failed to retrieve updated rule: %v", err)
-			http.Error(w, "Rule updated but failed to retrieve", http.StatusInternalServerError)
-			return
-		}
-
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(updatedRule)
-
-		models.Log.Info("HandleUpdateAutoReservationRule: Updated rule %s", id)
 	}
 }
 
@@ -279,7 +319,7 @@ func HandleDeleteAutoReservationRule(database *sql.DB) http.HandlerFunc {
 		models.Log.Debug("HandleDeleteAutoReservationRule: Processing request for ID: %s", id)
 
 		if err := db.DeleteAutoReservationRule(database, id); err != nil {
-			if strings.Contains(err.Error(), "not found") {
+			if err == sql.ErrNoRows {
 				http.Error(w, "Rule not found", http.StatusNotFound)
 				return
 			}
@@ -293,6 +333,93 @@ func HandleDeleteAutoReservationRule(database *sql.DB) http.HandlerFunc {
 	}
 }
 
+// Preview horizon defaults and limit, in hours from now
+const (
+	defaultPreviewHours = 24 // same window as a processing pass of the engine
+	maxPreviewHours     = 7 * 24
+)
+
+// PreviewAutoReservationRuleRequest represents the request payload for previewing a rule without saving it
+type PreviewAutoReservationRuleRequest struct {
+	CreateAutoReservationRuleRequest
+	ID    string `json:"id,omitempty" desc:"Existing rule whose processing history is taken into account"`
+	Hours int    `json:"hours,omitempty" desc:"Horizon in hours from now (default 24, max 168)"`
+}
+
+// HandlePreviewAutoReservationRule handles POST /auto-reservations/rules/preview
+func HandlePreviewAutoReservationRule(database *sql.DB, engine *services.AutoReservationEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		models.Log.Debug("HandlePreviewAutoReservationRule: Processing request")
+
+		var req PreviewAutoReservationRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			models.Log.Error("HandlePreviewAutoReservationRule: Invalid JSON: %v", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		// Name and recorder are not needed to evaluate the rule
+		if req.Name == "" {
+			req.Name = "preview"
+		}
+		if req.RecorderURL == "" {
+			req.RecorderURL = engine.RecorderURL()
+		}
+		if msg := req.validate(database, true); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		hours := req.Hours
+		if hours == 0 {
+			hours = defaultPreviewHours
+		}
+		if hours < 0 || hours > maxPreviewHours {
+			http.Error(w, fmt.Sprintf("Hours must be between 1 and %d", maxPreviewHours), http.StatusBadRequest)
+			return
+		}
+
+		if req.ID != "" {
+			if _, err := db.GetAutoReservationRuleByID(database, req.ID); err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, "Rule not found", http.StatusNotFound)
+					return
+				}
+				models.Log.Error("HandlePreviewAutoReservationRule: Failed to get rule: %v", err)
+				http.Error(w, "Failed to get rule", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		rule := models.AutoReservationRuleWithDetails{
+			AutoReservationRule: models.AutoReservationRule{
+				ID:          req.ID,
+				Type:        req.Type,
+				Name:        req.Name,
+				Enabled:     req.Enabled,
+				Priority:    req.Priority,
+				RecorderURL: req.RecorderURL,
+			},
+			KeywordRule: req.KeywordRule,
+			SeriesRule:  req.SeriesRule,
+			PersonRule:  req.PersonRule,
+		}
+
+		now := time.Now()
+		preview, err := engine.PreviewRule(rule, now, now.Add(time.Duration(hours)*time.Hour))
+		if err != nil {
+			models.Log.Error("HandlePreviewAutoReservationRule: %v", err)
+			http.Error(w, "Failed to preview rule", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preview)
+
+		models.Log.Debug("HandlePreviewAutoReservationRule: %d candidates, %d matched", len(preview.Programs), preview.Matched)
+	}
+}
+
 // HandleGetAutoReservationLogs handles GET /auto-reservations/logs
 func HandleGetAutoReservationLogs(database *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -8,10 +8,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/fuba/iepg-server/db"
 	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/services"
 )
 
 func init() {
@@ -138,6 +140,58 @@ func TestHandleCreateAutoReservationRule_Series(t *testing.T) {
 	}
 }
 
+func TestHandleCreateAutoReservationRule_Person(t *testing.T) {
+	database := setupHandlerTestDB(t)
+	defer database.Close()
+
+	request := CreateAutoReservationRuleRequest{
+		Type:        "person",
+		Name:        "Test Person Rule",
+		Enabled:     true,
+		RecorderURL: "http://localhost:37569",
+		PersonRule: &models.PersonRule{
+			Person: "山田　太郎",
+			Role:   "監督",
+		},
+	}
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/auto-reservations/rules", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	handler := HandleCreateAutoReservationRule(database)
+	handler(recorder, req)
+
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, recorder.Code, recorder.Body.String())
+	}
+
+	var response models.AutoReservationRuleWithDetails
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.Type != "person" || response.PersonRule == nil {
+		t.Fatalf("Expected a person rule, got %+v", response)
+	}
+	if response.PersonRule.Person != "山田太郎" || response.PersonRule.Role != "監督" {
+		t.Errorf("Unexpected person rule: %+v", response.PersonRule)
+	}
+
+	// A person rule without a name is rejected
+	request.PersonRule = &models.PersonRule{Person: "　"}
+	jsonData, _ = json.Marshal(request)
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("POST", "/auto-reservations/rules", bytes.NewReader(jsonData)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an empty person, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
 func TestHandleCreateAutoReservationRule_Validation(t *testing.T) {
 	database := setupHandlerTestDB(t)
 	defer database.Close()
@@ -195,6 +249,34 @@ func TestHandleCreateAutoReservationRule_Validation(t *testing.T) {
 			},
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name: "Time window without end",
+			request: CreateAutoReservationRuleRequest{
+				Type:        "keyword",
+				Name:        "Test Rule",
+				Enabled:     true,
+				RecorderURL: "http://localhost:37569",
+				KeywordRule: &models.KeywordRule{
+					Keywords:       []string{"anime"},
+					ScheduleFilter: models.ScheduleFilter{StartTime: "25:30"},
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Late-night time window",
+			request: CreateAutoReservationRuleRequest{
+				Type:        "keyword",
+				Name:        "Test Rule",
+				Enabled:     true,
+				RecorderURL: "http://localhost:37569",
+				KeywordRule: &models.KeywordRule{
+					Keywords:       []string{"anime"},
+					ScheduleFilter: models.ScheduleFilter{StartTime: "24:00", EndTime: "29:00", MinDuration: 20},
+				},
+			},
+			expectedStatus: http.StatusCreated,
+		},
 	}
 
 	for _, tt := range tests {
@@ -380,4 +462,78 @@ func TestHandleGetAutoReservationLogs(t *testing.T) {
 	if len(response) != 1 {
 		t.Errorf("Expected 1 filtered log, got %d", len(response))
 	}
-}
\ No newline at end of file
+}
+
+func TestHandlePreviewAutoReservationRule(t *testing.T) {
+	database := setupHandlerTestDB(t)
+	defer database.Close()
+
+	start := time.Now().Add(time.Hour).UnixMilli()
+	programs := []models.Program{
+		{ID: 1, ServiceID: 1032, Name: "Great Anime", StartAt: start, Duration: 1800000},
+		{ID: 2, ServiceID: 1040, Name: "Great Anime", StartAt: start, Duration: 1800000},
+		{ID: 3, ServiceID: 1032, Name: "Late Anime", StartAt: time.Now().Add(48 * time.Hour).UnixMilli(), Duration: 1800000},
+	}
+	if err := db.ReplacePrograms(database, "test", programs); err != nil {
+		t.Fatalf("Failed to save programs: %v", err)
+	}
+
+	engine := services.NewAutoReservationEngine(database, "http://localhost:37569")
+	handler := HandlePreviewAutoReservationRule(database, engine)
+
+	preview := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest("POST", "/auto-reservations/rules/preview", bytes.NewBufferString(body)))
+		return recorder
+	}
+
+	// Name and recorderUrl may be omitted; programs beyond the default 24 hours are not checked
+	recorder := preview(`{"type": "keyword", "keywordRule": {"keywords": ["anime"], "serviceIds": [1032]}}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	var response models.AutoReservationPreview
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(response.Programs) != 2 || response.Matched != 1 {
+		t.Fatalf("Expected 2 candidates and 1 match, got %+v", response)
+	}
+	for _, item := range response.Programs {
+		if item.Program.ID == 2 && item.Status != models.PreviewStatusExcluded {
+			t.Errorf("Expected program on another channel to be excluded, got %s", item.Status)
+		}
+	}
+
+	// A longer horizon includes later programs
+	recorder = preview(`{"type": "keyword", "hours": 72, "keywordRule": {"keywords": ["anime"], "serviceIds": [1032]}}`)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.Matched != 2 {
+		t.Errorf("Expected 2 matches within 72 hours, got %d", response.Matched)
+	}
+
+	// Nothing is saved
+	rules, err := db.GetAutoReservationRules(database)
+	if err != nil {
+		t.Fatalf("Failed to get rules: %v", err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("Expected preview not to save the rule, got %d rules", len(rules))
+	}
+
+	for _, tc := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{"missing keywords", `{"type": "keyword", "keywordRule": {"keywords": []}}`, http.StatusBadRequest},
+		{"horizon too long", `{"type": "keyword", "hours": 1000, "keywordRule": {"keywords": ["anime"]}}`, http.StatusBadRequest},
+		{"unknown rule", `{"type": "keyword", "id": "missing", "keywordRule": {"keywords": ["anime"]}}`, http.StatusNotFound},
+	} {
+		if recorder := preview(tc.body); recorder.Code != tc.code {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.code, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// handlers/channel_group.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// ChannelGroupRequest represents the request payload for creating or updating a channel group
+type ChannelGroupRequest struct {
+	Name     string                      `json:"name"`
+	Position int                         `json:"position"`
+	Services []models.ChannelGroupMember `json:"services"` // グループ内の並び順で指定
+}
+
+// validate checks the channel group request and returns a user-facing error message
+func (req *ChannelGroupRequest) validate() string {
+	if req.Name == "" {
+		return "Name is required"
+	}
+	seen := make(map[models.ChannelGroupMember]bool)
+	for _, m := range req.Services {
+		if m.ServiceID == 0 {
+			return "serviceId is required for every service"
+		}
+		if seen[m] {
+			return "Duplicate service: " + strconv.FormatInt(m.ServiceID, 10)
+		}
+		seen[m] = true
+	}
+	return ""
+}
+
+// filterServicesByGroup returns the services that belong to the group, in group order
+func filterServicesByGroup(services []*models.Service, group *models.ChannelGroup) []*models.Service {
+	filtered := make([]*models.Service, 0, len(group.Services))
+	for _, s := range services {
+		if group.Contains(s.NetworkID, s.ServiceID) {
+			filtered = append(filtered, s)
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return group.IndexOf(filtered[i].NetworkID, filtered[i].ServiceID) <
+			group.IndexOf(filtered[j].NetworkID, filtered[j].ServiceID)
+	})
+	return filtered
+}
+
+// resolveGroupParam resolves the group= query parameter. It returns nil when the parameter is absent
+// and writes a 400 response when the group does not exist
+func resolveGroupParam(w http.ResponseWriter, r *http.Request, database *sql.DB) (*models.ChannelGroup, bool) {
+	name := r.URL.Query().Get("group")
+	if name == "" {
+		return nil, true
+	}
+	group, err := db.GetChannelGroup(database, name)
+	if err != nil {
+		if err == db.ErrChannelGroupNotFound {
+			http.Error(w, "Unknown group: "+name, http.StatusBadRequest)
+			return nil, false
+		}
+		http.Error(w, "Failed to get channel group", http.StatusInternalServerError)
+		return nil, false
+	}
+	return group, true
+}
+
+// writeChannelGroupError maps channel group storage errors to HTTP responses
+func writeChannelGroupError(w http.ResponseWriter, err error, message string) {
+	switch err {
+	case db.ErrChannelGroupNotFound, sql.ErrNoRows:
+		http.Error(w, "Channel group not found", http.StatusNotFound)
+	case db.ErrChannelGroupProtected:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
+
+// HandleCreateChannelGroup handles POST /channel-groups
+func HandleCreateChannelGroup(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChannelGroupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			models.Log.Error("HandleCreateChannelGroup: Invalid JSON: %v", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		group := &models.ChannelGroup{
+			Name:     req.Name,
+			Position: req.Position,
+			Services: req.Services,
+		}
+		if group.Services == nil {
+			group.Services = []models.ChannelGroupMember{}
+		}
+		if err := db.CreateChannelGroup(database, group); err != nil {
+			http.Error(w, "Failed to create channel group", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+// HandleGetChannelGroups handles GET /channel-groups
+func HandleGetChannelGroups(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groups, err := db.GetChannelGroups(database)
+		if err != nil {
+			http.Error(w, "Failed to get channel groups", http.StatusInternalServerError)
+			return
+		}
+		if groups == nil {
+			groups = []models.ChannelGroup{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	}
+}
+
+// HandleGetChannelGroup handles GET /channel-groups/{id}
+func HandleGetChannelGroup(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group, err := db.GetChannelGroup(database, mux.Vars(r)["id"])
+		if err != nil {
+			writeChannelGroupError(w, err, "Failed to get channel group")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+// HandleUpdateChannelGroup handles PUT /channel-groups/{id}
+func HandleUpdateChannelGroup(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var req ChannelGroupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			models.Log.Error("HandleUpdateChannelGroup: Invalid JSON: %v", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		existing, err := db.GetChannelGroup(database, id)
+		if err != nil || existing.ID != id {
+			writeChannelGroupError(w, db.ErrChannelGroupNotFound, "")
+			return
+		}
+		existing.Name = req.Name
+		existing.Position = req.Position
+		existing.Services = req.Services
+		if existing.Services == nil {
+			existing.Services = []models.ChannelGroupMember{}
+		}
+
+		if err := db.UpdateChannelGroup(database, existing); err != nil {
+			writeChannelGroupError(w, err, "Failed to update channel group")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(existing)
+	}
+}
+
+// HandleDeleteChannelGroup handles DELETE /channel-groups/{id}
+func HandleDeleteChannelGroup(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := db.DeleteChannelGroup(database, mux.Vars(r)["id"]); err != nil {
+			writeChannelGroupError(w, err, "Failed to delete channel group")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleAddChannelGroupService handles POST /channel-groups/{id}/services
+// サービスをグループの末尾に追加する（お気に入りへの追加など）
+func HandleAddChannelGroupService(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var member models.ChannelGroupMember
+		if err := json.NewDecoder(r.Body).Decode(&member); err != nil || member.ServiceID == 0 {
+			http.Error(w, "serviceId is required", http.StatusBadRequest)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if err := db.AddChannelGroupService(database, id, member); err != nil {
+			writeChannelGroupError(w, err, "Failed to add service to channel group")
+			return
+		}
+		group, err := db.GetChannelGroup(database, id)
+		if err != nil {
+			writeChannelGroupError(w, err, "Failed to get channel group")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(group)
+	}
+}
+
+// HandleRemoveChannelGroupService handles DELETE /channel-groups/{id}/services/{serviceId}?networkId=
+func HandleRemoveChannelGroupService(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var member models.ChannelGroupMember
+		var err error
+		member.ServiceID, err = strconv.ParseInt(mux.Vars(r)["serviceId"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid serviceId", http.StatusBadRequest)
+			return
+		}
+		if networkIDStr := r.URL.Query().Get("networkId"); networkIDStr != "" {
+			member.NetworkID, err = strconv.ParseInt(networkIDStr, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid networkId", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := db.RemoveChannelGroupService(database, mux.Vars(r)["id"], member); err != nil {
+			writeChannelGroupError(w, err, "Failed to remove service from channel group")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestChannelGroupHandlers(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/channel-groups", HandleCreateChannelGroup(database)).Methods("POST")
+	router.HandleFunc("/channel-groups/{id}", HandleDeleteChannelGroup(database)).Methods("DELETE")
+	router.HandleFunc("/channel-groups/{id}/services", HandleAddChannelGroupService(database)).Methods("POST")
+	router.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		HandleSimpleSearch(w, r, database)
+	}).Methods("GET")
+
+	// サービスIDの重複は拒否する
+	body, _ := json.Marshal(ChannelGroupRequest{Name: "Dup", Services: []models.ChannelGroupMember{{ServiceID: 1}, {ServiceID: 1}}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/channel-groups", bytes.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for duplicate services, got %d", w.Code)
+	}
+
+	body, _ = json.Marshal(ChannelGroupRequest{Name: "Other", Services: []models.ChannelGroupMember{{ServiceID: 9999}}})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/channel-groups", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// お気に入りに追加したサービスの番組だけが検索される
+	search := func(group string) (int, []models.Program) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/search?q=test&group="+group, nil))
+		var programs []models.Program
+		json.Unmarshal(w.Body.Bytes(), &programs)
+		return w.Code, programs
+	}
+	if code, programs := search("favorites"); code != http.StatusOK || len(programs) != 0 {
+		t.Errorf("Expected no programs for an empty favorites group, got %d %+v", code, programs)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/channel-groups/favorites/services", bytes.NewBufferString(`{"serviceId": 1234}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 when adding to favorites, got %d", w.Code)
+	}
+	if code, programs := search("favorites"); code != http.StatusOK || len(programs) != 1 || programs[0].ID != 12345 {
+		t.Errorf("Expected program 12345 in favorites, got %d %+v", code, programs)
+	}
+	if _, programs := search("Other"); len(programs) != 0 {
+		t.Errorf("Expected no programs for another group, got %+v", programs)
+	}
+	if code, _ := search("missing"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown group, got %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/channel-groups/favorites", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when deleting favorites, got %d", w.Code)
+	}
+}
@@ -43,7 +43,7 @@ func TestDebugServiceMap(t *testing.T) {
 
 	// サービスを除外
 	t.Log("=== Adding to excluded list ===")
-	if err := db.AddExcludedService(dbConn, testService.ServiceID, testService.Name); err != nil {
+	if err := db.AddExcludedService(dbConn, testService.NetworkID, testService.ServiceID, testService.Name); err != nil {
 		t.Fatalf("Failed to add excluded service: %v", err)
 	}
 
@@ -62,7 +62,7 @@ func TestDebugServiceMap(t *testing.T) {
 
 	// 除外解除
 	t.Log("=== Removing from excluded list ===")
-	if err := db.RemoveExcludedService(dbConn, testService.ServiceID); err != nil {
+	if err := db.RemoveExcludedService(dbConn, testService.NetworkID, testService.ServiceID); err != nil {
 		t.Fatalf("Failed to remove excluded service: %v", err)
 	}
 
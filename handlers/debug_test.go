@@ -31,7 +31,7 @@ func TestDebugExcludedServices(t *testing.T) {
 
 	// 1. サービスを除外
 	t.Log("=== Adding service to excluded list ===")
-	if err := db.AddExcludedService(dbConn, testService.ServiceID, testService.Name); err != nil {
+	if err := db.AddExcludedService(dbConn, testService.NetworkID, testService.ServiceID, testService.Name); err != nil {
 		t.Fatalf("Failed to add excluded service: %v", err)
 	}
 
@@ -57,7 +57,7 @@ func TestDebugExcludedServices(t *testing.T) {
 
 	// 3. サービスの除外を解除
 	t.Log("=== Removing service from excluded list ===")
-	if err := db.RemoveExcludedService(dbConn, testService.ServiceID); err != nil {
+	if err := db.RemoveExcludedService(dbConn, testService.NetworkID, testService.ServiceID); err != nil {
 		t.Fatalf("Failed to remove excluded service: %v", err)
 	}
 
@@ -0,0 +1,202 @@
+// handlers/events.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/fuba/iepg-server/events"
+	"github.com/fuba/iepg-server/models"
+)
+
+// eventKeepAliveInterval はイベントが無い間に接続維持用の通知を送る間隔
+var eventKeepAliveInterval = 30 * time.Second
+
+// parseEventSubscription はクエリ・ヘッダーから購読対象のリソースと再開トークンを取得する
+// resources: カンマ区切りのリソース名（省略時はすべて）
+// since または Last-Event-ID ヘッダー: このID以降のイベントから再開する
+func parseEventSubscription(r *http.Request) ([]string, uint64, error) {
+	var resources []string
+	if resourcesStr := r.URL.Query().Get("resources"); resourcesStr != "" {
+		for _, res := range strings.Split(resourcesStr, ",") {
+			res = strings.TrimSpace(res)
+			switch res {
+			case "":
+				continue
+			case events.ResourceProgram, events.ResourceService, events.ResourceReservation, events.ResourceAutoReservationLog:
+				resources = append(resources, res)
+			default:
+				return nil, 0, fmt.Errorf("unknown resource: %s", res)
+			}
+		}
+	}
+
+	sinceStr := r.URL.Query().Get("since")
+	if sinceStr == "" {
+		sinceStr = r.Header.Get("Last-Event-ID")
+	}
+	var since uint64
+	if sinceStr != "" {
+		parsed, err := strconv.ParseUint(sinceStr, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid since: %s", sinceStr)
+		}
+		since = parsed
+	}
+
+	return resources, since, nil
+}
+
+// HandleEventStream は /events エンドポイント（Server-Sent Events）のハンドラー
+func HandleEventStream(w http.ResponseWriter, r *http.Request) {
+	models.Log.Debug("HandleEventStream: Processing request from %s", r.RemoteAddr)
+
+	resources, since, err := parseEventSubscription(r)
+	if err != nil {
+		models.Log.Error("HandleEventStream: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		models.Log.Error("HandleEventStream: Streaming unsupported by response writer")
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, backlog := events.Default.Subscribe(resources, since)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	models.Log.Info("HandleEventStream: Client %s subscribed (resources=%v, since=%d)", r.RemoteAddr, resources, since)
+
+	for _, e := range backlog {
+		if err := writeSSEEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			models.Log.Info("HandleEventStream: Client %s disconnected", r.RemoteAddr)
+			return
+		case e := <-sub.C:
+			if err := writeSSEEvent(w, e); err != nil {
+				models.Log.Debug("HandleEventStream: Write failed: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent はイベントを text/event-stream 形式で書き出す
+func writeSSEEvent(w http.ResponseWriter, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		models.Log.Error("writeSSEEvent: Failed to marshal event: %v", err)
+		return nil
+	}
+	if e.ID > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", e.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Resource, data)
+	return err
+}
+
+// HandleEventWebSocket は /events/ws エンドポイント（WebSocket）のハンドラーを返す
+// ブラウザからの接続は origins が受け付ける Origin（nil の場合はループバック）のみ許可する
+// 受け付けるパラメータは /events と同じで、各イベントを1つのJSONテキストメッセージとして送信する
+func HandleEventWebSocket(origins *OriginPolicy) http.HandlerFunc {
+	upgrader := &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return origins.Allowed(r.Header.Get("Origin")) },
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveEventWebSocket(w, r, upgrader)
+	}
+}
+
+// serveEventWebSocket は WebSocket に接続し、購読したイベントを送信する
+func serveEventWebSocket(w http.ResponseWriter, r *http.Request, upgrader *websocket.Upgrader) {
+	models.Log.Debug("HandleEventWebSocket: Processing request from %s", r.RemoteAddr)
+
+	resources, since, err := parseEventSubscription(r)
+	if err != nil {
+		models.Log.Error("HandleEventWebSocket: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		models.Log.Error("HandleEventWebSocket: Upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub, backlog := events.Default.Subscribe(resources, since)
+	defer sub.Close()
+
+	models.Log.Info("HandleEventWebSocket: Client %s subscribed (resources=%v, since=%d)", r.RemoteAddr, resources, since)
+
+	// クライアントからのメッセージは読み捨て、切断を検知する
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, e := range backlog {
+		if err := conn.WriteJSON(e); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			models.Log.Info("HandleEventWebSocket: Client %s disconnected", r.RemoteAddr)
+			return
+		case e := <-sub.C:
+			if err := conn.WriteJSON(e); err != nil {
+				models.Log.Debug("HandleEventWebSocket: Write failed: %v", err)
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/fuba/iepg-server/events"
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestHandleEventStream(t *testing.T) {
+	models.InitLogger("error")
+
+	server := httptest.NewServer(http.HandlerFunc(HandleEventStream))
+	defer server.Close()
+
+	since := events.Default.LastID()
+	resp, err := http.Get(server.URL + "/events?resources=reservation")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %s", ct)
+	}
+
+	events.Publish(events.ResourceProgram, "create", map[string]int64{"id": 1})
+	events.Publish(events.ResourceReservation, "update", map[string]string{"id": "r1"})
+
+	reader := bufio.NewReader(resp.Body)
+	var id, name, data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	if name != events.ResourceReservation {
+		t.Errorf("expected reservation event, got %s", name)
+	}
+	var e events.Event
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		t.Fatalf("Failed to parse event data: %v", err)
+	}
+	if e.ID != since+2 || id == "" {
+		t.Errorf("expected event id %d, got %d (id field %q)", since+2, e.ID, id)
+	}
+}
+
+func TestHandleEventStreamInvalidResource(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events?resources=unknown", nil)
+	w := httptest.NewRecorder()
+	HandleEventStream(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleEventWebSocket(t *testing.T) {
+	models.InitLogger("error")
+
+	server := httptest.NewServer(HandleEventWebSocket(nil))
+	defer server.Close()
+
+	// 接続前に発行したイベントも再開トークンで受け取れること
+	since := events.Default.LastID()
+	events.Publish(events.ResourceAutoReservationLog, "create", map[string]string{"id": "log1"})
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/events/ws?resources=autoReservationLog&since=" +
+		strconv.FormatUint(since, 10)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var e events.Event
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatalf("Failed to read event: %v", err)
+	}
+	if e.Resource != events.ResourceAutoReservationLog || e.ID != since+1 {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestHandleEventWebSocketRejectsForeignOrigin(t *testing.T) {
+	models.InitLogger("error")
+
+	server := httptest.NewServer(HandleEventWebSocket(NewOriginPolicy([]string{"https://tv.example.com"})))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/events/ws"
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://localhost:3000", true},
+		{"https://tv.example.com", true},
+		{"https://evil.example.com", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("Origin %q: expected connection, got %v", tt.origin, err)
+				continue
+			}
+			conn.Close()
+		} else if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			if conn != nil {
+				conn.Close()
+			}
+			t.Errorf("Origin %q: expected 403, got %v", tt.origin, err)
+		}
+	}
+}
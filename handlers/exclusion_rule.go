@@ -0,0 +1,177 @@
+// handlers/exclusion_rule.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// ExclusionRuleRequest は除外ルールの作成・更新リクエスト
+type ExclusionRuleRequest struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Pattern     string `json:"pattern"`
+	ChannelType string `json:"channelType"`
+	Days        int    `json:"days"`
+	Enabled     *bool  `json:"enabled"` // 省略時は有効
+	ExpiresAt   int64  `json:"expiresAt"`
+}
+
+// validate はリクエストを検証し、エラーメッセージを返す
+func (req *ExclusionRuleRequest) validate() string {
+	if req.Name == "" {
+		return "Name is required"
+	}
+	switch req.Type {
+	case models.ExclusionRuleNameRegex:
+		if req.Pattern == "" {
+			return "Pattern is required for nameRegex rules"
+		}
+		if _, err := regexp.Compile(req.Pattern); err != nil {
+			return "Invalid pattern: " + err.Error()
+		}
+	case models.ExclusionRulePayChannel:
+	case models.ExclusionRuleNoPrograms:
+		if req.Days < 0 {
+			return "days must not be negative"
+		}
+	default:
+		return "Type must be nameRegex, payChannel or noPrograms"
+	}
+	if req.ExpiresAt != 0 && req.ExpiresAt <= time.Now().UnixMilli() {
+		return "expiresAt must be in the future"
+	}
+	return ""
+}
+
+// apply はリクエストの内容をルールに反映する
+func (req *ExclusionRuleRequest) apply(rule *models.ExclusionRule) {
+	rule.Name = req.Name
+	rule.Type = req.Type
+	rule.Pattern = req.Pattern
+	rule.ChannelType = req.ChannelType
+	rule.Days = req.Days
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	rule.ExpiresAt = req.ExpiresAt
+}
+
+// decodeExclusionRuleRequest はリクエストボディを読み込んで検証する。失敗時はエラーレスポンスを書き込む
+func decodeExclusionRuleRequest(w http.ResponseWriter, r *http.Request) (*ExclusionRuleRequest, bool) {
+	var req ExclusionRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.Log.Error("decodeExclusionRuleRequest: Invalid JSON: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return nil, false
+	}
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// HandleCreateExclusionRule は POST /services/exclusion-rules のハンドラー
+func HandleCreateExclusionRule(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeExclusionRuleRequest(w, r)
+		if !ok {
+			return
+		}
+
+		rule := &models.ExclusionRule{}
+		req.apply(rule)
+		if err := db.CreateExclusionRule(database, rule); err != nil {
+			http.Error(w, "Failed to create exclusion rule", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+// HandleGetExclusionRules は GET /services/exclusion-rules のハンドラー
+func HandleGetExclusionRules(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rules, err := db.GetExclusionRules(database)
+		if err != nil {
+			http.Error(w, "Failed to get exclusion rules", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	}
+}
+
+// HandleUpdateExclusionRule は PUT /services/exclusion-rules/{id} のハンドラー
+func HandleUpdateExclusionRule(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rule, err := db.GetExclusionRule(database, mux.Vars(r)["id"])
+		if err != nil {
+			writeExclusionRuleError(w, err)
+			return
+		}
+		req, ok := decodeExclusionRuleRequest(w, r)
+		if !ok {
+			return
+		}
+
+		req.apply(rule)
+		if err := db.UpdateExclusionRule(database, rule); err != nil {
+			writeExclusionRuleError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+// HandleDeleteExclusionRule は DELETE /services/exclusion-rules/{id} のハンドラー
+func HandleDeleteExclusionRule(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := db.DeleteExclusionRule(database, mux.Vars(r)["id"]); err != nil {
+			writeExclusionRuleError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleGetExclusionRuleServices は GET /services/exclusion-rules/{id}/services のハンドラー
+// ルールに一致するサービスを返す（ルールが無効でも一致するサービスを確認できる）
+func HandleGetExclusionRuleServices(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rule, err := db.GetExclusionRule(database, mux.Vars(r)["id"])
+		if err != nil {
+			writeExclusionRuleError(w, err)
+			return
+		}
+
+		services := db.GetExclusionRuleMatches(database, rule)
+		sortServicesForDisplay(services)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(services)
+	}
+}
+
+// writeExclusionRuleError は除外ルールの取得・更新エラーをレスポンスに変換する
+func writeExclusionRuleError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		http.Error(w, "Exclusion rule not found", http.StatusNotFound)
+		return
+	}
+	models.Log.Error("writeExclusionRuleError: %v", err)
+	http.Error(w, "Failed to process exclusion rule", http.StatusInternalServerError)
+}
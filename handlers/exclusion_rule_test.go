@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestExclusionRuleHandlers(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	models.ServiceMapInstance = models.NewServiceMap()
+	models.ServiceMapInstance.Update(&models.Service{NetworkID: 7, ServiceID: 55, Name: "ショップチャンネル", Type: 3, ChannelType: "CS"})
+	models.ServiceMapInstance.Update(&models.Service{NetworkID: 7, ServiceID: 56, Name: "映画", Type: 3, ChannelType: "CS"})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/services/exclusion-rules", HandleCreateExclusionRule(database)).Methods("POST")
+	router.HandleFunc("/services/exclusion-rules/{id}", HandleDeleteExclusionRule(database)).Methods("DELETE")
+	router.HandleFunc("/services/exclusion-rules/{id}/services", HandleGetExclusionRuleServices(database)).Methods("GET")
+	router.HandleFunc("/services/all", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetAllServices(w, r, database)
+	}).Methods("GET")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/services/exclusion-rules", bytes.NewBufferString(body)))
+		return w
+	}
+	for _, body := range []string{
+		`{"name": "bad", "type": "nameRegex", "pattern": "("}`,
+		`{"name": "bad", "type": "unknown"}`,
+		`{"name": "bad", "type": "payChannel", "expiresAt": 1}`,
+	} {
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w := post(`{"name": "通販", "type": "nameRegex", "pattern": "ショップ", "channelType": "CS"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var rule models.ExclusionRule
+	json.Unmarshal(w.Body.Bytes(), &rule)
+	if !rule.Enabled {
+		t.Errorf("Expected the rule to be enabled by default")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/services/exclusion-rules/"+rule.ID+"/services", nil))
+	var matches []models.Service
+	json.Unmarshal(w.Body.Bytes(), &matches)
+	if len(matches) != 1 || matches[0].ServiceID != 55 {
+		t.Errorf("Expected the rule to match service 55, got %+v", matches)
+	}
+
+	// 除外ルールによる除外は手動の除外と区別して表示する
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/services/all", nil))
+	var services []models.Service
+	json.Unmarshal(w.Body.Bytes(), &services)
+	for _, s := range services {
+		if s.IsExcluded || s.ExcludedByRule != (s.ServiceID == 55) {
+			t.Errorf("Unexpected exclusion flags for %d: isExcluded=%v excludedByRule=%v", s.ServiceID, s.IsExcluded, s.ExcludedByRule)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/services/exclusion-rules/"+rule.ID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+}
@@ -3,7 +3,6 @@ package handlers
 
 import (
 	"database/sql"
-	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -67,13 +66,9 @@ func HandleIEPG(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
 	// 番組に対応するサービス（テレビ局）情報を取得
 	var stationId, stationName, channelType, channelNumber string
 	var serviceId int64
-	if service, ok := models.ServiceMapInstance.Get(p.ServiceID); ok {
-		// リモコンキーIDあるいはサービスIDを文字列に変換
-		if service.RemoteControlKeyID > 0 {
-			stationId = fmt.Sprintf("%04d", service.RemoteControlKeyID)
-		} else {
-			stationId = fmt.Sprintf("%04d", service.ServiceID)
-		}
+	if service, ok := models.ServiceMapInstance.Lookup(p.NetworkID, p.ServiceID); ok {
+		// 上書き設定の局コード、リモコンキーIDあるいはサービスIDを文字列に変換
+		stationId = service.IEPGStationID()
 		stationName = service.Name
 		serviceId = service.ServiceID
 		channelType = service.ChannelType
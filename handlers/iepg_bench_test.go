@@ -0,0 +1,43 @@
+// handlers/iepg_bench_test.go
+package handlers
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/testing/epggen"
+)
+
+// BenchmarkHandleIEPG は合成した番組表の番組を IEPG として出力する
+// 番組名・説明の外字の置換と Shift_JIS への変換を含む
+func BenchmarkHandleIEPG(b *testing.B) {
+	models.InitLogger("error")
+	database, err := db.InitDB(":memory:")
+	if err != nil {
+		b.Fatalf("Failed to initialize benchmark database: %v", err)
+	}
+	defer database.Close()
+
+	data := epggen.Generate(epggen.Config{Services: 12, Days: 2, Start: time.Now().Truncate(time.Hour), Source: "bench"})
+	for _, s := range data.Services {
+		models.ServiceMapInstance.Update(s)
+	}
+	if err := db.ReplacePrograms(database, "bench", data.Programs); err != nil {
+		b.Fatalf("Failed to save programs: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := data.Programs[i%len(data.Programs)]
+		w := httptest.NewRecorder()
+		HandleIEPG(w, httptest.NewRequest("GET", fmt.Sprintf("/program/%d.tvpid", p.ID), nil), database)
+		if w.Code != 200 {
+			b.Fatalf("Unexpected status %d for program %d: %s", w.Code, p.ID, w.Body.String())
+		}
+	}
+}
@@ -2,28 +2,50 @@
 package handlers
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/fuba/iepg-server/db"
 	"github.com/fuba/iepg-server/models"
 )
 
+// JSON-RPC 2.0 のエラーコード
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000
+	rpcNotFound       = -32001
+)
+
 // JSONRPCRequest は JSON-RPC 2.0 のリクエストフォーマット
+// ID が省略された場合は通知（レスポンスを返さない）として扱う
 type JSONRPCRequest struct {
 	JSONRPC string          `json:"jsonrpc"`
 	Method  string          `json:"method"`
 	Params  json.RawMessage `json:"params"`
-	ID      interface{}     `json:"id"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// isNotification はリクエストが通知かどうかを返す
+func (req *JSONRPCRequest) isNotification() bool {
+	return req.ID == nil
 }
 
 // JSONRPCResponse は JSON-RPC 2.0 のレスポンスフォーマット
 type JSONRPCResponse struct {
-	JSONRPC string      `json:"jsonrpc"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   *RPCError   `json:"error,omitempty"`
-	ID      interface{} `json:"id"`
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
 }
 
 // RPCError は JSON-RPC のエラー構造体
@@ -34,78 +56,604 @@ type RPCError struct {
 
 // SearchParams は RPC 用の検索パラメータ
 type SearchParams struct {
-	Q          string `json:"q"`
-	ServiceID  int64  `json:"serviceId"`
-	StartFrom  int64  `json:"startFrom"`
-	StartTo    int64  `json:"startTo"`
-	ChannelType int   `json:"channelType"`
+	Q           string `json:"q" desc:"Search keywords (space separated, AND). person:NAME restricts to programs featuring the person, -person:NAME excludes them"`
+	ServiceID   int64  `json:"serviceId" desc:"Restrict to a service ID (or a Mirakurun service ID, networkId * 100000 + serviceId)"`
+	NetworkID   int64  `json:"networkId" desc:"Network ID of the service ID, to tell apart services that share a service ID"`
+	StartFrom   int64  `json:"startFrom" desc:"Earliest start time (Unix milliseconds)"`
+	StartTo     int64  `json:"startTo" desc:"Latest start time (Unix milliseconds)"`
+	ChannelType int    `json:"channelType" desc:"1: GR, 2: BS, 3: CS"`
+	Group       string `json:"group" desc:"Restrict to a channel group (ID or name)"`
+	IncludePast bool   `json:"includePast" desc:"Also search programs that have already aired"`
+
+	Flags        []string `json:"flags" desc:"Only programs whose title has all of these flags (e.g. 新, 字)"`
+	ExcludeFlags []string `json:"excludeFlags" desc:"Skip programs whose title has any of these flags (e.g. 再)"`
+	MinEpisode   int      `json:"minEpisode" desc:"Only programs whose title has an episode number of at least this value"`
+
+	Attributes        []string `json:"attributes" desc:"Only programs with all of these attributes (sd, hd, 4k, mono, stereo, 5.1, bilingual, commentary, caption)"`
+	ExcludeAttributes []string `json:"excludeAttributes" desc:"Skip programs with any of these attributes"`
 }
 
-// rpcHandler は JSON-RPC リクエストのディスパッチ処理を行う
-func rpcHandler(dbConn *sql.DB, w http.ResponseWriter, r *http.Request) {
-	models.Log.Debug("rpcHandler: Processing JSON-RPC request from %s", r.RemoteAddr)
-	
-	var req JSONRPCRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		models.Log.Error("rpcHandler: Failed to parse JSON-RPC request: %v", err)
-		writeRPCError(w, nil, -32700, "Parse error")
+// ListPeopleParams は人物索引取得のパラメータ
+type ListPeopleParams struct {
+	Q     string `json:"q" desc:"Only people whose name contains this text"`
+	Role  string `json:"role" desc:"Only people with a role containing this text (e.g. 出演, 監督)"`
+	Limit int    `json:"limit" desc:"Maximum number of people (default 100)"`
+}
+
+// ProgramIDParams は番組IDを指定するパラメータ
+type ProgramIDParams struct {
+	ID int64 `json:"id" required:"true" desc:"Program ID"`
+}
+
+// ListServicesParams はサービス一覧取得のパラメータ
+type ListServicesParams struct {
+	ChannelType     int    `json:"channelType" desc:"1: GR, 2: BS, 3: CS"`
+	IncludeExcluded bool   `json:"includeExcluded" desc:"Include excluded services"`
+	Group           string `json:"group" desc:"Only services in this channel group (ID or name), in group order"`
+}
+
+// ServiceIDParams はサービスIDを指定するパラメータ
+type ServiceIDParams struct {
+	NetworkID int64  `json:"networkId" desc:"Original network ID (omit to match the service ID on every network)"`
+	ServiceID int64  `json:"serviceId" required:"true"`
+	Name      string `json:"name" desc:"Display name stored with the exclusion (defaults to the service name)"`
+	ExpiresAt int64  `json:"expiresAt" desc:"Unix milliseconds after which the exclusion lapses (excludeService only, omit for a permanent exclusion)"`
+}
+
+// CreateReservationParams は予約作成のパラメータ
+type CreateReservationParams struct {
+	ProgramID   int64  `json:"programId" required:"true"`
+	RecorderURL string `json:"recorderUrl" desc:"Recorder API URL (defaults to the server setting)"`
+}
+
+// IDParams は文字列IDを指定するパラメータ
+type IDParams struct {
+	ID string `json:"id" required:"true"`
+}
+
+// UpdateAutoReservationRuleParams は自動予約ルール更新のパラメータ
+type UpdateAutoReservationRuleParams struct {
+	ID string `json:"id" required:"true"`
+	CreateAutoReservationRuleRequest
+}
+
+// AutoReservationLogsParams は自動予約ログ取得のパラメータ
+type AutoReservationLogsParams struct {
+	RuleID string `json:"ruleId" desc:"Restrict to a rule ID"`
+	Limit  int    `json:"limit" desc:"Maximum number of logs (default 100)"`
+}
+
+// rpcMethod は RPC メソッドの定義
+type rpcMethod struct {
+	summary  string
+	params   interface{} // パラメータ構造体のゼロ値（スキーマ生成用）、パラメータなしの場合は nil
+	result   interface{} // 結果のゼロ値（スキーマ生成用）
+	readOnly bool
+	call     func(params json.RawMessage) (interface{}, *RPCError)
+}
+
+// RPCServer は JSON-RPC 2.0 のHTTPハンドラ
+type RPCServer struct {
+	db           *sql.DB
+	reservations *ReservationHandler
+	methods      map[string]*rpcMethod
+}
+
+// NewRPCServer は JSON-RPC 用のHTTPハンドラを返す
+func NewRPCServer(dbConn *sql.DB, reservationHandler *ReservationHandler) *RPCServer {
+	models.Log.Debug("NewRPCServer: Creating new JSON-RPC server handler")
+
+	s := &RPCServer{
+		db:           dbConn,
+		reservations: reservationHandler,
+	}
+	s.registerMethods()
+	return s
+}
+
+// decodeRPCParams は名前付きパラメータを構造体にデコードする
+func decodeRPCParams(raw json.RawMessage, v interface{}) *RPCError {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil
+	}
+	if raw[0] != '{' {
+		return &RPCError{Code: rpcInvalidParams, Message: "Invalid params: params must be an object"}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &RPCError{Code: rpcInvalidParams, Message: "Invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+// rpcNotFoundOr は sql.ErrNoRows を Not found エラーに、それ以外をサーバーエラーに変換する
+func rpcNotFoundOr(err error, what string) *RPCError {
+	if err == sql.ErrNoRows {
+		return &RPCError{Code: rpcNotFound, Message: what + " not found"}
+	}
+	return &RPCError{Code: rpcServerError, Message: err.Error()}
+}
+
+// registerMethods は公開するメソッドを登録する
+func (s *RPCServer) registerMethods() {
+	s.methods = map[string]*rpcMethod{
+		"searchPrograms": {
+			summary:  "Search programs by keywords, service and start time range",
+			params:   SearchParams{},
+			result:   []models.Program{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params SearchParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				models.Log.Debug("rpcHandler: searchPrograms params - q=%s, serviceId=%d, startFrom=%d, startTo=%d, channelType=%d",
+					params.Q, params.ServiceID, params.StartFrom, params.StartTo, params.ChannelType)
+
+				result, err := searchProgramsRPC(s.db, params)
+				if err != nil {
+					return nil, rpcGroupError(err, params.Group)
+				}
+				if result == nil {
+					result = []models.Program{}
+				}
+				// REST の /search と同じく表示用の情報を付与する
+				for i := range result {
+					decorateProgram(&result[i])
+				}
+				models.Log.Info("rpcHandler: searchPrograms completed, found %d programs", len(result))
+				return result, nil
+			},
+		},
+		"getProgram": {
+			summary:  "Get a program by ID",
+			params:   ProgramIDParams{},
+			result:   models.Program{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params ProgramIDParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				program, err := db.GetProgramByID(s.db, params.ID)
+				if err != nil {
+					return nil, rpcNotFoundOr(err, "Program")
+				}
+				decorateProgram(program)
+				return program, nil
+			},
+		},
+		"listServices": {
+			summary:  "List services (channels) in display order",
+			params:   ListServicesParams{},
+			result:   []models.Service{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params ListServicesParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				var allowedTypes []int
+				if params.ChannelType > 0 {
+					allowedTypes = []int{params.ChannelType}
+				}
+				// includeExcluded の場合は除外設定を参照しない
+				var filterDB *sql.DB
+				if !params.IncludeExcluded {
+					filterDB = s.db
+				}
+				services := db.GetFilteredServices(filterDB, allowedTypes, []int{192})
+				sortServicesForDisplay(services)
+				if params.Group != "" {
+					group, err := db.GetChannelGroup(s.db, params.Group)
+					if err != nil {
+						return nil, rpcGroupError(err, params.Group)
+					}
+					services = filterServicesByGroup(services, group)
+				}
+				if services == nil {
+					services = []*models.Service{}
+				}
+				return services, nil
+			},
+		},
+		"listPeople": {
+			summary:  "List the cast and staff of upcoming programs, most frequent first",
+			params:   ListPeopleParams{},
+			result:   []models.PersonEntry{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params ListPeopleParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				if params.Limit <= 0 {
+					params.Limit = defaultPersonIndexLimit
+				}
+				people, err := db.GetPersonIndex(s.db, params.Q, params.Role, params.Limit)
+				if err != nil {
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				return people, nil
+			},
+		},
+		"listExcludedServices": {
+			summary:  "List excluded services",
+			result:   []models.ExcludedService{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				excluded, err := db.GetExcludedServices(s.db)
+				if err != nil {
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				if excluded == nil {
+					excluded = []models.ExcludedService{}
+				}
+				return excluded, nil
+			},
+		},
+		"excludeService": {
+			summary: "Exclude a service from search results",
+			params:  ServiceIDParams{},
+			result:  true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params ServiceIDParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				if params.ServiceID == 0 {
+					return nil, &RPCError{Code: rpcInvalidParams, Message: "Invalid params: serviceId is required"}
+				}
+				if params.Name == "" {
+					params.Name = resolveServiceName(params.NetworkID, params.ServiceID)
+				}
+				if params.ExpiresAt != 0 && params.ExpiresAt <= time.Now().UnixMilli() {
+					return nil, &RPCError{Code: rpcInvalidParams, Message: "Invalid params: expiresAt must be in the future"}
+				}
+				if err := db.AddExcludedServiceUntil(s.db, params.NetworkID, params.ServiceID, params.Name, params.ExpiresAt); err != nil {
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				return true, nil
+			},
+		},
+		"unexcludeService": {
+			summary: "Remove a service from the exclusion list",
+			params:  ServiceIDParams{},
+			result:  true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params ServiceIDParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				if params.ServiceID == 0 {
+					return nil, &RPCError{Code: rpcInvalidParams, Message: "Invalid params: serviceId is required"}
+				}
+				if err := db.RemoveExcludedService(s.db, params.NetworkID, params.ServiceID); err != nil {
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				return true, nil
+			},
+		},
+		"createReservation": {
+			summary: "Reserve a program on the recorder",
+			params:  CreateReservationParams{},
+			result:  models.Reservation{},
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params CreateReservationParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				reservation, err := s.reservations.Reserve(params.ProgramID, params.RecorderURL)
+				if err != nil {
+					var urlErr *invalidRecorderURLError
+					switch {
+					case err == ErrProgramNotFound:
+						return nil, &RPCError{Code: rpcNotFound, Message: "Program not found"}
+					case errors.As(err, &urlErr):
+						return nil, &RPCError{Code: rpcInvalidParams, Message: urlErr.Error()}
+					}
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				return reservation, nil
+			},
+		},
+		"listReservations": {
+			summary:  "List reservations, newest start time first",
+			result:   []models.Reservation{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				reservations, err := db.GetReservations(s.db)
+				if err != nil {
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				if reservations == nil {
+					reservations = []models.Reservation{}
+				}
+				attachReservationPrograms(s.db, reservations)
+				return reservations, nil
+			},
+		},
+		"getReservation": {
+			summary:  "Get a reservation by ID",
+			params:   IDParams{},
+			result:   models.Reservation{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params IDParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				reservation, err := db.GetReservationByID(s.db, params.ID)
+				if err != nil {
+					return nil, rpcNotFoundOr(err, "Reservation")
+				}
+				reservations := []models.Reservation{*reservation}
+				attachReservationPrograms(s.db, reservations)
+				return reservations[0], nil
+			},
+		},
+		"deleteReservation": {
+			summary: "Delete a reservation",
+			params:  IDParams{},
+			result:  true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params IDParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				if err := s.reservations.Cancel(params.ID); err != nil {
+					return nil, rpcNotFoundOr(err, "Reservation")
+				}
+				return true, nil
+			},
+		},
+		"createAutoReservationRule": {
+			summary: "Create a keyword or series auto reservation rule",
+			params:  CreateAutoReservationRuleRequest{},
+			result:  models.AutoReservationRuleWithDetails{},
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params CreateAutoReservationRuleRequest
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				if msg := params.validate(s.db, true); msg != "" {
+					return nil, &RPCError{Code: rpcInvalidParams, Message: "Invalid params: " + msg}
+				}
+				rule, err := createAutoReservationRule(s.db, &params)
+				if err != nil {
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				return rule, nil
+			},
+		},
+		"listAutoReservationRules": {
+			summary:  "List auto reservation rules",
+			result:   []models.AutoReservationRuleWithDetails{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				rules, err := db.GetAutoReservationRules(s.db)
+				if err != nil {
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				if rules == nil {
+					rules = []models.AutoReservationRuleWithDetails{}
+				}
+				return rules, nil
+			},
+		},
+		"getAutoReservationRule": {
+			summary:  "Get an auto reservation rule by ID",
+			params:   IDParams{},
+			result:   models.AutoReservationRuleWithDetails{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params IDParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				rule, err := db.GetAutoReservationRuleByID(s.db, params.ID)
+				if err != nil {
+					return nil, rpcNotFoundOr(err, "Rule")
+				}
+				return rule, nil
+			},
+		},
+		"updateAutoReservationRule": {
+			summary: "Update an auto reservation rule",
+			params:  UpdateAutoReservationRuleParams{},
+			result:  models.AutoReservationRuleWithDetails{},
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params UpdateAutoReservationRuleParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				if msg := params.validate(s.db, false); msg != "" {
+					return nil, &RPCError{Code: rpcInvalidParams, Message: "Invalid params: " + msg}
+				}
+				rule, err := updateAutoReservationRule(s.db, params.ID, &params.CreateAutoReservationRuleRequest)
+				if err != nil {
+					return nil, rpcNotFoundOr(err, "Rule")
+				}
+				return rule, nil
+			},
+		},
+		"deleteAutoReservationRule": {
+			summary: "Delete an auto reservation rule",
+			params:  IDParams{},
+			result:  true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				var params IDParams
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				if err := db.DeleteAutoReservationRule(s.db, params.ID); err != nil {
+					return nil, rpcNotFoundOr(err, "Rule")
+				}
+				return true, nil
+			},
+		},
+		"getAutoReservationLogs": {
+			summary:  "Get auto reservation logs, newest first",
+			params:   AutoReservationLogsParams{},
+			result:   []models.AutoReservationLog{},
+			readOnly: true,
+			call: func(raw json.RawMessage) (interface{}, *RPCError) {
+				params := AutoReservationLogsParams{Limit: 100}
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				if params.Limit <= 0 {
+					params.Limit = 100
+				}
+				logs, err := db.GetAutoReservationLogs(s.db, params.RuleID, params.Limit)
+				if err != nil {
+					return nil, &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				if logs == nil {
+					logs = []models.AutoReservationLog{}
+				}
+				return logs, nil
+			},
+		},
+	}
+
+	s.methods["rpc.discover"] = &rpcMethod{
+		summary:  "Return the OpenRPC document describing this API",
+		result:   map[string]interface{}{},
+		readOnly: true,
+		call: func(raw json.RawMessage) (interface{}, *RPCError) {
+			return s.openRPCDocument(), nil
+		},
+	}
+}
+
+// ServeHTTP は単一リクエストとバッチリクエストを処理する
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	models.Log.Debug("RPCServer: Received %s request from %s", r.Method, r.RemoteAddr)
+
+	if r.Method != "POST" {
+		models.Log.Error("RPCServer: Method not allowed: %s", r.Method)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		models.Log.Error("RPCServer: Failed to read request body: %v", err)
+		writeRPCResponse(w, rpcErrorResponse(nil, rpcParseError, "Parse error"))
 		return
 	}
-	
-	models.Log.Debug("rpcHandler: Received request - Method: %s, JSONRPC: %s, ID: %v", 
-		req.Method, req.JSONRPC, req.ID)
-	
-	if req.JSONRPC != "2.0" {
-		models.Log.Error("rpcHandler: Invalid JSON-RPC version: %s", req.JSONRPC)
-		writeRPCError(w, req.ID, -32600, "Invalid Request")
+
+	resp := processRPCBody(body, s.call)
+	if resp == nil {
+		// 通知のみの場合は何も返さない
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	
-	switch req.Method {
-	case "searchPrograms":
-		models.Log.Debug("rpcHandler: Handling searchPrograms method")
-		
-		var params SearchParams
-		if err := json.Unmarshal(req.Params, &params); err != nil {
-			models.Log.Error("rpcHandler: Invalid params for searchPrograms: %v", err)
-			writeRPCError(w, req.ID, -32602, "Invalid params")
-			return
+	writeRPCResponse(w, resp)
+}
+
+// rpcCallFunc はメソッド名とパラメータから結果を返す関数
+type rpcCallFunc func(method string, params json.RawMessage) (interface{}, *RPCError)
+
+// processRPCBody は単一リクエストまたはバッチリクエストを処理し、返すべきレスポンスを返す
+// 返すレスポンスがない（通知のみの）場合は nil を返す
+func processRPCBody(body []byte, call rpcCallFunc) interface{} {
+	body = bytes.TrimSpace(body)
+
+	// バッチリクエスト
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			models.Log.Error("rpcHandler: Failed to parse batch request: %v", err)
+			return rpcErrorResponse(nil, rpcParseError, "Parse error")
 		}
-		
-		models.Log.Debug("rpcHandler: searchPrograms params - q=%s, serviceId=%d, startFrom=%d, startTo=%d, channelType=%d", 
-			params.Q, params.ServiceID, params.StartFrom, params.StartTo, params.ChannelType)
-		
-		result, err := searchProgramsRPC(dbConn, params)
-		if err != nil {
-			models.Log.Error("rpcHandler: searchPrograms failed: %v", err)
-			writeRPCError(w, req.ID, -32000, err.Error())
-			return
+		if len(batch) == 0 {
+			return rpcErrorResponse(nil, rpcInvalidRequest, "Invalid Request")
 		}
-		
-		models.Log.Info("rpcHandler: searchPrograms completed, found %d programs", len(result))
-		
-		resp := JSONRPCResponse{
-			JSONRPC: "2.0",
-			Result:  result,
-			ID:      req.ID,
+
+		models.Log.Debug("rpcHandler: Processing batch of %d requests", len(batch))
+		responses := []JSONRPCResponse{}
+		for _, raw := range batch {
+			if resp := handleRPCMessage(raw, call); resp != nil {
+				responses = append(responses, *resp)
+			}
 		}
-		
-		models.Log.Debug("rpcHandler: Sending successful JSON-RPC response")
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			models.Log.Error("rpcHandler: Failed to encode JSON response: %v", err)
+		if len(responses) == 0 {
+			return nil
+		}
+		return responses
+	}
+
+	if resp := handleRPCMessage(body, call); resp != nil {
+		return resp
+	}
+	return nil
+}
+
+// handleRPCMessage は1件のリクエストを処理する。通知の場合は nil を返す
+func handleRPCMessage(raw json.RawMessage, call rpcCallFunc) *JSONRPCResponse {
+	var req JSONRPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		models.Log.Error("rpcHandler: Failed to parse JSON-RPC request: %v", err)
+		var probe interface{}
+		if json.Unmarshal(raw, &probe) != nil {
+			resp := rpcErrorResponse(nil, rpcParseError, "Parse error")
+			return &resp
+		}
+		resp := rpcErrorResponse(nil, rpcInvalidRequest, "Invalid Request")
+		return &resp
+	}
+
+	models.Log.Debug("rpcHandler: Received request - Method: %s, JSONRPC: %s, ID: %s",
+		req.Method, req.JSONRPC, string(req.ID))
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		models.Log.Error("rpcHandler: Invalid JSON-RPC request (version=%s, method=%s)", req.JSONRPC, req.Method)
+		resp := rpcErrorResponse(req.ID, rpcInvalidRequest, "Invalid Request")
+		return &resp
+	}
+
+	result, rpcErr := call(req.Method, req.Params)
+	if req.isNotification() {
+		return nil
+	}
+	if rpcErr != nil {
+		resp := JSONRPCResponse{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}
+		return &resp
+	}
+	return &JSONRPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+// call はメソッドを呼び出す
+func (s *RPCServer) call(name string, params json.RawMessage) (result interface{}, rpcErr *RPCError) {
+	method, ok := s.methods[name]
+	if !ok {
+		models.Log.Error("rpcHandler: Method not found: %s", name)
+		return nil, &RPCError{Code: rpcMethodNotFound, Message: "Method not found"}
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			models.Log.Error("rpcHandler: Method %s panicked: %v", name, rec)
+			result, rpcErr = nil, &RPCError{Code: rpcInternalError, Message: "Internal error"}
 		}
-		
-	default:
-		models.Log.Error("rpcHandler: Method not found: %s", req.Method)
-		writeRPCError(w, req.ID, -32601, "Method not found")
+	}()
+
+	models.Log.Debug("rpcHandler: Handling %s method", name)
+	result, rpcErr = method.call(params)
+	if rpcErr != nil {
+		models.Log.Error("rpcHandler: %s failed: %s", name, rpcErr.Message)
 	}
+	return result, rpcErr
 }
 
-func writeRPCError(w http.ResponseWriter, id interface{}, code int, message string) {
-	models.Log.Debug("writeRPCError: Writing RPC error - Code: %d, Message: %s", code, message)
-	
-	resp := JSONRPCResponse{
+func rpcErrorResponse(id json.RawMessage, code int, message string) JSONRPCResponse {
+	models.Log.Debug("rpcErrorResponse: RPC error - Code: %d, Message: %s", code, message)
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return JSONRPCResponse{
 		JSONRPC: "2.0",
 		Error: &RPCError{
 			Code:    code,
@@ -113,34 +661,114 @@ func writeRPCError(w http.ResponseWriter, id interface{}, code int, message stri
 		},
 		ID: id,
 	}
-	
+}
+
+func writeRPCResponse(w http.ResponseWriter, resp interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		models.Log.Error("writeRPCError: Failed to encode error response: %v", err)
+		models.Log.Error("writeRPCResponse: Failed to encode JSON response: %v", err)
 	}
 }
 
 // searchProgramsRPC は SearchParams をもとにプログラムを検索する
 func searchProgramsRPC(dbConn *sql.DB, params SearchParams) ([]models.Program, error) {
-	models.Log.Debug("searchProgramsRPC: Searching programs with params - q=%s, serviceId=%d, startFrom=%d, startTo=%d, channelType=%d", 
+	models.Log.Debug("searchProgramsRPC: Searching programs with params - q=%s, serviceId=%d, startFrom=%d, startTo=%d, channelType=%d",
 		params.Q, params.ServiceID, params.StartFrom, params.StartTo, params.ChannelType)
-	
-	return db.SearchPrograms(dbConn, params.Q, params.ServiceID, params.StartFrom, params.StartTo, params.ChannelType)
+
+	opts := db.SearchOptions{
+		Query:       params.Q,
+		ServiceID:   params.ServiceID,
+		NetworkID:   params.NetworkID,
+		StartFrom:   params.StartFrom,
+		StartTo:     params.StartTo,
+		ChannelType: params.ChannelType,
+		IncludePast: params.IncludePast,
+
+		Flags:        params.Flags,
+		ExcludeFlags: params.ExcludeFlags,
+		MinEpisode:   params.MinEpisode,
+
+		Attributes:        params.Attributes,
+		ExcludeAttributes: params.ExcludeAttributes,
+	}
+	if params.Group != "" {
+		group, err := db.GetChannelGroup(dbConn, params.Group)
+		if err != nil {
+			return nil, err
+		}
+		opts.GroupID = group.ID
+	}
+	return db.SearchProgramsWithOptions(dbConn, opts)
 }
 
-// NewRPCServer は JSON-RPC 用のHTTPハンドラを返す
-func NewRPCServer(dbConn *sql.DB) http.Handler {
-	models.Log.Debug("NewRPCServer: Creating new JSON-RPC server handler")
-	
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		models.Log.Debug("RPCServer: Received %s request from %s", r.Method, r.RemoteAddr)
-		
-		if r.Method != "POST" {
-			models.Log.Error("RPCServer: Method not allowed: %s", r.Method)
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
+// rpcGroupError はチャンネルグループの取得エラーを RPCError に変換する
+func rpcGroupError(err error, group string) *RPCError {
+	if err == db.ErrChannelGroupNotFound {
+		return &RPCError{Code: rpcInvalidParams, Message: "Invalid params: unknown group: " + group}
+	}
+	return &RPCError{Code: rpcServerError, Message: err.Error()}
+}
+
+// openRPCDocument は登録済みメソッドから OpenRPC 1.2.6 のドキュメントを生成する
+func (s *RPCServer) openRPCDocument() map[string]interface{} {
+	names := make([]string, 0, len(s.methods))
+	for name := range s.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	methods := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		m := s.methods[name]
+
+		params := []interface{}{}
+		if m.params != nil {
+			schema := jsonSchemaOf(m.params)
+			required := map[string]bool{}
+			if list, ok := schema["required"].([]string); ok {
+				for _, r := range list {
+					required[r] = true
+				}
+			}
+			properties, _ := schema["properties"].(map[string]interface{})
+			propNames := make([]string, 0, len(properties))
+			for p := range properties {
+				propNames = append(propNames, p)
+			}
+			sort.Strings(propNames)
+			for _, p := range propNames {
+				params = append(params, map[string]interface{}{
+					"name":     p,
+					"required": required[p],
+					"schema":   properties[p],
+				})
+			}
 		}
-		
-		rpcHandler(dbConn, w, r)
-	})
-}
\ No newline at end of file
+
+		methods = append(methods, map[string]interface{}{
+			"name":           name,
+			"summary":        m.summary,
+			"paramStructure": "by-name",
+			"params":         params,
+			"result":         map[string]interface{}{"name": "result", "schema": jsonSchemaOf(m.result)},
+		})
+	}
+
+	return map[string]interface{}{
+		"openrpc": "1.2.6",
+		"info": map[string]interface{}{
+			"title":   "iepg-server JSON-RPC API",
+			"version": "1.0.0",
+		},
+		"methods": methods,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Program":             jsonSchemaOf(models.Program{}),
+				"Service":             jsonSchemaOf(models.Service{}),
+				"Reservation":         jsonSchemaOf(models.Reservation{}),
+				"AutoReservationRule": jsonSchemaOf(models.AutoReservationRuleWithDetails{}),
+				"AutoReservationLog":  jsonSchemaOf(models.AutoReservationLog{}),
+			},
+		},
+	}
+}
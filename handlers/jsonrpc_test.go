@@ -0,0 +1,209 @@
+// handlers/jsonrpc_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func postRPC(t *testing.T, server http.Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/rpc", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRPCSingleRequest(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	server := NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569"))
+
+	rr := postRPC(t, server, `{"jsonrpc":"2.0","method":"getProgram","params":{"id":12345},"id":"a"}`)
+	var resp struct {
+		Result map[string]interface{} `json:"result"`
+		Error  *RPCError              `json:"error"`
+		ID     string                 `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v (%s)", err, rr.Body.String())
+	}
+	if resp.Error != nil {
+		t.Fatalf("Unexpected error: %+v", resp.Error)
+	}
+	if resp.ID != "a" || resp.Result["name"] != "Test Program" {
+		t.Errorf("Unexpected response: %s", rr.Body.String())
+	}
+
+	rr = postRPC(t, server, `{"jsonrpc":"2.0","method":"getProgram","params":{"id":1},"id":2}`)
+	if !strings.Contains(rr.Body.String(), `"code":-32001`) {
+		t.Errorf("Expected not found error, got %s", rr.Body.String())
+	}
+
+	rr = postRPC(t, server, `{"jsonrpc":"2.0","method":"getProgram","params":[12345],"id":3}`)
+	if !strings.Contains(rr.Body.String(), `"code":-32602`) {
+		t.Errorf("Expected invalid params error for positional params, got %s", rr.Body.String())
+	}
+
+	rr = postRPC(t, server, `{"jsonrpc":"2.0","method":"deleteAutoReservationRule","params":{"id":"missing"},"id":4}`)
+	if !strings.Contains(rr.Body.String(), `"code":-32001`) {
+		t.Errorf("Expected not found error for a missing rule, got %s", rr.Body.String())
+	}
+
+	rr = postRPC(t, server, `{"jsonrpc":"2.0","method":"unexcludeService","params":{},"id":5}`)
+	if !strings.Contains(rr.Body.String(), `"code":-32602`) {
+		t.Errorf("Expected invalid params error without serviceId, got %s", rr.Body.String())
+	}
+
+	rr = postRPC(t, server, `{"jsonrpc":"2.0","method":`)
+	if !strings.Contains(rr.Body.String(), `"code":-32700`) || !strings.Contains(rr.Body.String(), `"id":null`) {
+		t.Errorf("Expected parse error, got %s", rr.Body.String())
+	}
+}
+
+func TestRPCSearchProgramsDecorated(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	server := NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569"))
+
+	original := models.ServiceMapInstance
+	defer func() { models.ServiceMapInstance = original }()
+	models.ServiceMapInstance = models.NewServiceMap()
+	models.ServiceMapInstance.Update(&models.Service{NetworkID: 4, ServiceID: 1234, Name: "テスト局", ChannelType: "GR"})
+
+	// searchPrograms は REST の /search と同じく局情報を付与した番組を返す
+	rr := postRPC(t, server, `{"jsonrpc":"2.0","method":"searchPrograms","params":{"q":"test"},"id":1}`)
+	var resp struct {
+		Result []models.Program `json:"result"`
+		Error  *RPCError        `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v (%s)", err, rr.Body.String())
+	}
+	if resp.Error != nil || len(resp.Result) != 1 {
+		t.Fatalf("Unexpected response: %s", rr.Body.String())
+	}
+	if p := resp.Result[0]; p.StationName != "テスト局" || p.ChannelType != "GR" {
+		t.Errorf("Expected decorated program, got %+v", p)
+	}
+}
+
+func TestRPCNotification(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	server := NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569"))
+
+	rr := postRPC(t, server, `{"jsonrpc":"2.0","method":"excludeService","params":{"serviceId":1234}}`)
+	if rr.Code != http.StatusNoContent || rr.Body.Len() != 0 {
+		t.Errorf("Expected empty 204 for notification, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// 通知でも処理自体は実行される
+	rr = postRPC(t, server, `{"jsonrpc":"2.0","method":"listExcludedServices","id":1}`)
+	if !strings.Contains(rr.Body.String(), `"serviceId":1234`) {
+		t.Errorf("Expected notification to exclude the service, got %s", rr.Body.String())
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	server := NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569"))
+
+	rr := postRPC(t, server, `[
+		{"jsonrpc":"2.0","method":"getProgram","params":{"id":12345},"id":1},
+		{"jsonrpc":"2.0","method":"unexcludeService","params":{"serviceId":1}},
+		{"jsonrpc":"2.0","method":"noSuchMethod","id":2},
+		1
+	]`)
+
+	var responses []struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+		ID     json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("Failed to decode batch response: %v (%s)", err, rr.Body.String())
+	}
+	if len(responses) != 3 {
+		t.Fatalf("Expected 3 responses (notification omitted), got %d: %s", len(responses), rr.Body.String())
+	}
+	if responses[0].Error != nil || string(responses[0].ID) != "1" {
+		t.Errorf("Unexpected first response: %s", rr.Body.String())
+	}
+	if responses[1].Error == nil || responses[1].Error.Code != rpcMethodNotFound {
+		t.Errorf("Expected method not found, got %+v", responses[1].Error)
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != rpcInvalidRequest || string(responses[2].ID) != "null" {
+		t.Errorf("Expected invalid request with null id, got %+v", responses[2])
+	}
+
+	rr = postRPC(t, server, `[]`)
+	if !strings.Contains(rr.Body.String(), `"code":-32600`) {
+		t.Errorf("Expected invalid request for empty batch, got %s", rr.Body.String())
+	}
+
+	rr = postRPC(t, server, `[{"jsonrpc":"2.0","method":"listReservations"}]`)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for a batch of notifications, got %d", rr.Code)
+	}
+}
+
+func TestRPCDiscover(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	server := NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569"))
+
+	rr := postRPC(t, server, `{"jsonrpc":"2.0","method":"rpc.discover","id":1}`)
+	var resp struct {
+		Result struct {
+			OpenRPC string `json:"openrpc"`
+			Methods []struct {
+				Name   string `json:"name"`
+				Params []struct {
+					Name     string `json:"name"`
+					Required bool   `json:"required"`
+				} `json:"params"`
+			} `json:"methods"`
+			Components struct {
+				Schemas map[string]json.RawMessage `json:"schemas"`
+			} `json:"components"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Result.OpenRPC != "1.2.6" {
+		t.Errorf("Expected openrpc 1.2.6, got %q", resp.Result.OpenRPC)
+	}
+
+	methods := map[string]bool{}
+	for _, m := range resp.Result.Methods {
+		methods[m.Name] = true
+		if m.Name == "createReservation" {
+			found := false
+			for _, p := range m.Params {
+				if p.Name == "programId" && p.Required {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected programId to be a required param of createReservation")
+			}
+		}
+	}
+	for _, name := range []string{"searchPrograms", "getProgram", "createReservation", "createAutoReservationRule", "rpc.discover"} {
+		if !methods[name] {
+			t.Errorf("Expected method %s in discovery document", name)
+		}
+	}
+	if _, ok := resp.Result.Components.Schemas["Program"]; !ok {
+		t.Errorf("Expected Program schema in components")
+	}
+}
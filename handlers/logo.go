@@ -0,0 +1,76 @@
+// handlers/logo.go
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// HandleGetServiceLogo handles GET /services/{id}/logo
+// {id} は Mirakurun のサービスID。キャッシュに無い場合は Mirakurun から取得してキャッシュする
+// サービス一覧に無いサービスやロゴを持たないサービスは Mirakurun に問い合わせず404を返す
+func HandleGetServiceLogo(database *sql.DB, mirakurunURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid service ID", http.StatusBadRequest)
+			return
+		}
+
+		logo, err := db.GetServiceLogo(database, id)
+		// 既知でロゴを持つサービスのみ取得する（任意のIDでキャッシュが増え続けないようにする）
+		if err == sql.ErrNoRows && mirakurunURL != "" && hasServiceLogo(id) {
+			models.Log.Debug("HandleGetServiceLogo: Logo for %d not cached, fetching from Mirakurun", id)
+			logo, err = db.FetchServiceLogo(r.Context(), database, db.MirakurunURLForService(id, mirakurunURL), id)
+		}
+		if err != nil {
+			if err == sql.ErrNoRows || err == db.ErrLogoNotFound {
+				http.Error(w, "Logo not found", http.StatusNotFound)
+				return
+			}
+			models.Log.Error("HandleGetServiceLogo: Failed to get logo for %d: %v", id, err)
+			http.Error(w, "Failed to get logo", http.StatusBadGateway)
+			return
+		}
+
+		etag := `"` + logo.Hash + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", logo.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(logo.Data)))
+		w.Write(logo.Data)
+	}
+}
+
+// hasServiceLogo はサービス一覧に ID が id でロゴデータを持つサービスがあるかを返す
+func hasServiceLogo(id int64) bool {
+	for _, service := range models.ServiceMapInstance.GetAll() {
+		if service.ID == id && service.HasLogoData {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches は If-None-Match ヘッダーが ETag に一致するかを返す
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
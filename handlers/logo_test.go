@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestHandleGetServiceLogo(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	logo := &models.ServiceLogo{ID: 3273601024, ContentType: "image/png", Data: []byte("logo"), FetchedAt: 1}
+	if err := db.SaveServiceLogo(database, logo); err != nil {
+		t.Fatalf("SaveServiceLogo failed: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/services/{id:[0-9]+}/logo", HandleGetServiceLogo(database, "")).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/services/3273601024/logo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "logo" || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Unexpected response: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag header")
+	}
+
+	req = httptest.NewRequest("GET", "/services/3273601024/logo", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/services/1/logo", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an uncached logo without Mirakurun, got %d", w.Code)
+	}
+}
+
+func TestHandleGetServiceLogoUnknownService(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	fetched := 0
+	mirakurun := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("logo"))
+	}))
+	defer mirakurun.Close()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/services/{id:[0-9]+}/logo", HandleGetServiceLogo(database, mirakurun.URL)).Methods("GET")
+
+	// サービス一覧に無いIDは Mirakurun に問い合わせずキャッシュもしない
+	req := httptest.NewRequest("GET", "/services/999999999/logo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown service, got %d", w.Code)
+	}
+	if fetched != 0 {
+		t.Errorf("Expected no request to Mirakurun, got %d", fetched)
+	}
+	if _, err := db.GetServiceLogo(database, 999999999); err == nil {
+		t.Errorf("Expected no cached logo for an unknown service")
+	}
+}
@@ -0,0 +1,243 @@
+// handlers/mcp.go
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// mcpProtocolVersions はサポートする MCP のプロトコルバージョン（新しい順）
+var mcpProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+// mcpTool は MCP のツールと、それを処理する RPC メソッドの対応
+type mcpTool struct {
+	name        string
+	method      string
+	description string
+}
+
+// mcpTools は MCP で公開するツールの一覧
+var mcpTools = []mcpTool{
+	{"search_programs", "searchPrograms", "Search TV programs by keywords (AND), service ID, channel type (1: GR, 2: BS, 3: CS) and start time range in Unix milliseconds. Use person:NAME in the keywords to find programs featuring an actor or director."},
+	{"get_program", "getProgram", "Get the details of a TV program by its program ID."},
+	{"list_people", "listPeople", "List the actors, voice actors, directors and other staff of upcoming TV programs with their number of programs."},
+	{"list_services", "listServices", "List the TV services (channels) with their service IDs and channel types."},
+	{"list_reservations", "listReservations", "List recording reservations."},
+	{"list_auto_reservation_rules", "listAutoReservationRules", "List the keyword, series and person auto reservation rules."},
+	{"create_reservation", "createReservation", "Reserve a TV program for recording by its program ID."},
+	{"create_auto_reservation_rule", "createAutoReservationRule", "Create a rule that automatically reserves every program matching the keywords, the series or featuring the person."},
+}
+
+// MCPServer は Model Context Protocol のサーバー
+// RPCServer に登録されたメソッドをツールとして公開する
+type MCPServer struct {
+	rpc      *RPCServer
+	readOnly bool
+	origins  *OriginPolicy
+}
+
+// NewMCPServer は MCPServer を作成する。readOnly の場合は予約やルールを作成するツールを公開しない
+func NewMCPServer(rpc *RPCServer, readOnly bool) *MCPServer {
+	return &MCPServer{
+		rpc:      rpc,
+		readOnly: readOnly,
+	}
+}
+
+// SetAllowedOrigins は Streamable HTTP で受け付ける Origin（"https://example.com" の形式）を追加する
+// ループバックアドレス（localhost, 127.0.0.1, ::1）の Origin は指定しなくても受け付ける
+func (m *MCPServer) SetAllowedOrigins(origins []string) {
+	m.origins = NewOriginPolicy(origins)
+}
+
+// SetOriginPolicy は Streamable HTTP で受け付ける Origin の判定を設定する
+func (m *MCPServer) SetOriginPolicy(origins *OriginPolicy) {
+	m.origins = origins
+}
+
+// tools は公開中のツールを返す
+func (m *MCPServer) tools() []mcpTool {
+	var tools []mcpTool
+	for _, t := range mcpTools {
+		method, ok := m.rpc.methods[t.method]
+		if !ok || (m.readOnly && !method.readOnly) {
+			continue
+		}
+		tools = append(tools, t)
+	}
+	return tools
+}
+
+// call は MCP のメソッドを処理する
+func (m *MCPServer) call(method string, params json.RawMessage) (interface{}, *RPCError) {
+	models.Log.Debug("MCPServer: Handling %s", method)
+
+	switch method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		version := mcpProtocolVersions[0]
+		for _, v := range mcpProtocolVersions {
+			if v == p.ProtocolVersion {
+				version = v
+			}
+		}
+		return map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities": map[string]interface{}{
+				"tools": map[string]interface{}{"listChanged": false},
+			},
+			"serverInfo": map[string]interface{}{
+				"name":    "iepg-server",
+				"version": "1.0.0",
+			},
+		}, nil
+
+	case "ping", "notifications/initialized", "notifications/cancelled":
+		return map[string]interface{}{}, nil
+
+	case "tools/list":
+		tools := []interface{}{}
+		for _, t := range m.tools() {
+			method := m.rpc.methods[t.method]
+			schema := map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+			if method.params != nil {
+				schema = jsonSchemaOf(method.params)
+			}
+			tools = append(tools, map[string]interface{}{
+				"name":        t.name,
+				"description": t.description,
+				"inputSchema": schema,
+				"annotations": map[string]interface{}{
+					"readOnlyHint": method.readOnly,
+				},
+			})
+		}
+		return map[string]interface{}{"tools": tools}, nil
+
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		for _, t := range m.tools() {
+			if t.name != p.Name {
+				continue
+			}
+			result, rpcErr := m.rpc.call(t.method, p.Arguments)
+			if rpcErr != nil {
+				// ツールの実行エラーはモデルが読めるように結果として返す
+				return mcpToolResult(rpcErr.Message, true), nil
+			}
+			text, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return nil, &RPCError{Code: rpcInternalError, Message: "Internal error"}
+			}
+			return mcpToolResult(string(text), false), nil
+		}
+		return nil, &RPCError{Code: rpcInvalidParams, Message: "Unknown tool: " + p.Name}
+
+	default:
+		models.Log.Error("MCPServer: Method not found: %s", method)
+		return nil, &RPCError{Code: rpcMethodNotFound, Message: "Method not found"}
+	}
+}
+
+// mcpToolResult はツールの実行結果をテキストコンテンツとして返す
+func mcpToolResult(text string, isError bool) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": text},
+		},
+		"isError": isError,
+	}
+}
+
+// ServeHTTP は Streamable HTTP トランスポートのハンドラー
+// サーバーからのストリーミングは行わないため、POST に対して JSON で応答する
+func (m *MCPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	models.Log.Debug("MCPServer: Received %s request from %s", r.Method, r.RemoteAddr)
+
+	if origin := r.Header.Get("Origin"); !m.origins.Allowed(origin) {
+		models.Log.Error("MCPServer: Rejected request with Origin %q from %s", origin, r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		models.Log.Error("MCPServer: Failed to read request body: %v", err)
+		writeRPCResponse(w, rpcErrorResponse(nil, rpcParseError, "Parse error"))
+		return
+	}
+
+	resp := processRPCBody(body, m.call)
+	if resp == nil {
+		// 通知のみの場合は受理のみ返す
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	writeRPCResponse(w, resp)
+}
+
+// ServeStdio は改行区切りの JSON-RPC メッセージを in から読み、応答を out に書き出す
+// in が閉じられるか ctx がキャンセルされるまで処理を続ける
+func (m *MCPServer) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	models.Log.Info("MCPServer: Serving MCP over stdio (readOnly=%v)", m.readOnly)
+
+	lines := make(chan []byte)
+	errCh := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := append([]byte(nil), scanner.Bytes()...)
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		errCh <- scanner.Err()
+	}()
+
+	encoder := json.NewEncoder(out)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errCh:
+			models.Log.Info("MCPServer: Input closed")
+			return err
+		case line := <-lines:
+			if len(line) == 0 {
+				continue
+			}
+			resp := processRPCBody(line, m.call)
+			if resp == nil {
+				continue
+			}
+			if err := encoder.Encode(resp); err != nil {
+				return err
+			}
+		}
+	}
+}
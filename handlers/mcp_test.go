@@ -0,0 +1,156 @@
+// handlers/mcp_test.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMCPToolsList(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	rpc := NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569"))
+
+	toolNames := func(server *MCPServer) map[string]bool {
+		rr := postRPC(t, server, `{"jsonrpc":"2.0","method":"tools/list","id":1}`)
+		var resp struct {
+			Result struct {
+				Tools []struct {
+					Name        string                 `json:"name"`
+					InputSchema map[string]interface{} `json:"inputSchema"`
+				} `json:"tools"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		names := map[string]bool{}
+		for _, tool := range resp.Result.Tools {
+			names[tool.Name] = true
+			if tool.InputSchema["type"] != "object" {
+				t.Errorf("Expected object input schema for %s, got %v", tool.Name, tool.InputSchema)
+			}
+		}
+		return names
+	}
+
+	all := toolNames(NewMCPServer(rpc, false))
+	if !all["search_programs"] || !all["create_reservation"] || !all["create_auto_reservation_rule"] {
+		t.Errorf("Expected search and write tools, got %v", all)
+	}
+
+	readOnly := toolNames(NewMCPServer(rpc, true))
+	if !readOnly["search_programs"] || readOnly["create_reservation"] || readOnly["create_auto_reservation_rule"] {
+		t.Errorf("Expected only read tools in read-only mode, got %v", readOnly)
+	}
+
+	// 読み取り専用モードでは書き込みツールを呼び出せない
+	rr := postRPC(t, NewMCPServer(rpc, true), `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"create_reservation","arguments":{"programId":12345}},"id":2}`)
+	if !strings.Contains(rr.Body.String(), `"code":-32602`) {
+		t.Errorf("Expected unknown tool error, got %s", rr.Body.String())
+	}
+}
+
+func TestMCPToolsCall(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	server := NewMCPServer(NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569")), false)
+
+	rr := postRPC(t, server, `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"get_program","arguments":{"id":12345}},"id":1}`)
+	var resp struct {
+		Result struct {
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+			IsError bool `json:"isError"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Result.IsError || len(resp.Result.Content) != 1 || !strings.Contains(resp.Result.Content[0].Text, "Test Program") {
+		t.Errorf("Unexpected tool result: %s", rr.Body.String())
+	}
+
+	rr = postRPC(t, server, `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"get_program","arguments":{"id":1}},"id":2}`)
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Result.IsError {
+		t.Errorf("Expected isError for missing program, got %s", rr.Body.String())
+	}
+
+	// 通知は 202 を返す
+	rr = postRPC(t, server, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 for notification, got %d", rr.Code)
+	}
+}
+
+func TestMCPOriginValidation(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	server := NewMCPServer(NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569")), true)
+	server.SetAllowedOrigins([]string{" https://tv.example.com/ "})
+
+	body := `{"jsonrpc":"2.0","method":"tools/list","id":1}`
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusOK},
+		{"http://localhost:3000", http.StatusOK},
+		{"http://127.0.0.1:40870", http.StatusOK},
+		{"http://[::1]:40870", http.StatusOK},
+		{"https://tv.example.com", http.StatusOK},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"http://iepg.local:40870", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/mcp", strings.NewReader(body))
+		req.Host = "iepg.local:40870"
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("Origin %q: expected %d, got %d", tt.origin, tt.want, rr.Code)
+		}
+	}
+}
+
+func TestMCPStdio(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	server := NewMCPServer(NewRPCServer(database, NewReservationHandler(database, "http://localhost:37569")), true)
+
+	in := strings.NewReader(strings.Join([]string{
+		`{"jsonrpc":"2.0","method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"0"}},"id":1}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","method":"ping","id":2}`,
+	}, "\n") + "\n")
+	var out bytes.Buffer
+
+	if err := server.ServeStdio(context.Background(), in, &out); err != nil {
+		t.Fatalf("ServeStdio failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 responses, got %d: %s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[0], `"protocolVersion":"2024-11-05"`) {
+		t.Errorf("Expected negotiated protocol version, got %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"id":2`) {
+		t.Errorf("Expected ping response, got %s", lines[1])
+	}
+}
@@ -0,0 +1,90 @@
+// handlers/now.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// HandleNowNext は /now エンドポイントのハンドラー
+// 除外されていない各チャンネルについて、放送中の番組（経過率付き）と次の番組を返す
+func HandleNowNext(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
+	models.Log.Debug("HandleNowNext: Processing request from %s", r.RemoteAddr)
+
+	at := time.Now().UnixMilli()
+	if atStr := r.URL.Query().Get("at"); atStr != "" {
+		parsed, err := strconv.ParseInt(atStr, 10, 64)
+		if err != nil {
+			models.Log.Error("HandleNowNext: Invalid at: %s, error: %v", atStr, err)
+			http.Error(w, "invalid at", http.StatusBadRequest)
+			return
+		}
+		at = parsed
+	}
+
+	var allowedTypes []int
+	if channelTypeStr := r.URL.Query().Get("channelType"); channelTypeStr != "" {
+		channelType, err := strconv.Atoi(channelTypeStr)
+		if err != nil || channelType < 1 || channelType > 3 {
+			models.Log.Error("HandleNowNext: Invalid channelType: %s", channelTypeStr)
+			http.Error(w, "channelType must be 1, 2, or 3", http.StatusBadRequest)
+			return
+		}
+		allowedTypes = []int{channelType}
+	}
+
+	group, ok := resolveGroupParam(w, r, dbConn)
+	if !ok {
+		return
+	}
+
+	entries, err := db.GetNowNextList(dbConn, at, allowedTypes)
+	if err != nil {
+		models.Log.Error("HandleNowNext: Failed to build now/next list: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// チャンネル一覧と同じ並び順にする
+	sort.Slice(entries, func(i, j int) bool {
+		return serviceDisplayLess(entries[i].Service, entries[j].Service)
+	})
+
+	// グループ指定時はグループ内のチャンネルのみをグループの並び順で返す
+	if group != nil {
+		filtered := entries[:0]
+		for _, e := range entries {
+			if group.Contains(e.Service.NetworkID, e.Service.ServiceID) {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+		sort.SliceStable(entries, func(i, j int) bool {
+			return group.IndexOf(entries[i].Service.NetworkID, entries[i].Service.ServiceID) <
+				group.IndexOf(entries[j].Service.NetworkID, entries[j].Service.ServiceID)
+		})
+	}
+
+	for i := range entries {
+		if entries[i].Current != nil {
+			decorateProgram(entries[i].Current)
+		}
+		if entries[i].Next != nil {
+			decorateProgram(entries[i].Next)
+		}
+	}
+
+	models.Log.Info("HandleNowNext: Returning now/next for %d services", len(entries))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		models.Log.Error("HandleNowNext: Failed to encode JSON response: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+// handlers/origin.go
+package handlers
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy はブラウザからのリクエストで受け付ける Origin を判定する
+// ループバックアドレス（localhost, 127.0.0.1, ::1）と許可リストの Origin のみ受け付ける
+type OriginPolicy struct {
+	allowed []string
+}
+
+// NewOriginPolicy は許可する Origin（"https://example.com" の形式）の一覧から OriginPolicy を作成する
+func NewOriginPolicy(origins []string) *OriginPolicy {
+	p := &OriginPolicy{}
+	for _, o := range origins {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			p.allowed = append(p.allowed, o)
+		}
+	}
+	return p
+}
+
+// Allowed は Origin ヘッダーを受け付けるかを返す（nil の場合はループバックのみ）
+// ブラウザ以外のクライアントは Origin を送らないため、ヘッダーが無い場合は受け付ける
+// DNS リバインディング対策として、リクエストの Host と一致するだけの Origin は受け付けない
+func (p *OriginPolicy) Allowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	if p != nil {
+		for _, o := range p.allowed {
+			if strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
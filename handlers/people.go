@@ -0,0 +1,46 @@
+// handlers/people.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// defaultPersonIndexLimit は /people で返す人物数のデフォルト値
+const defaultPersonIndexLimit = 100
+
+// HandleGetPeople は /people エンドポイントのハンドラー
+// 放送予定の番組の拡張情報から取り出した出演者・スタッフの索引を番組数の多い順に返す
+func HandleGetPeople(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
+	models.Log.Debug("HandleGetPeople: Processing request from %s", r.RemoteAddr)
+
+	limit := defaultPersonIndexLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 0 {
+			models.Log.Error("HandleGetPeople: Invalid limit: %s", limitStr)
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	people, err := db.GetPersonIndex(dbConn, r.URL.Query().Get("q"), r.URL.Query().Get("role"), limit)
+	if err != nil {
+		models.Log.Error("HandleGetPeople: Failed to build person index: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	models.Log.Info("HandleGetPeople: Returning %d people", len(people))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(people); err != nil {
+		models.Log.Error("HandleGetPeople: Failed to encode JSON response: %v", err)
+	}
+}
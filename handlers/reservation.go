@@ -4,6 +4,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -14,7 +15,9 @@ import (
 	"github.com/gorilla/mux"
 	
 	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/events"
 	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/services"
 )
 
 // ReservationHandler handles reservation-related HTTP requests
@@ -22,6 +25,8 @@ type ReservationHandler struct {
 	DB          *sql.DB
 	RecorderURL string
 	HTTPClient  *http.Client
+	// Webhooks enqueues reservation.failed deliveries; nil disables notifications
+	Webhooks *services.WebhookDispatcher
 }
 
 // NewReservationHandler creates a new reservation handler
@@ -35,45 +40,43 @@ func NewReservationHandler(database *sql.DB, recorderURL string) *ReservationHan
 	}
 }
 
-// CreateReservation handles POST /reservations
-func (h *ReservationHandler) CreateReservation(w http.ResponseWriter, r *http.Request) {
-	models.Log.Info("CreateReservation: Processing request")
-	
-	var req models.CreateReservationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		models.Log.Error("CreateReservation: Failed to decode request: %v", err)
-		respondWithJSON(w, http.StatusBadRequest, models.ReservationResponse{
-			Success: false,
-			Error:   "Invalid request body",
-		})
-		return
-	}
-	
+// ErrProgramNotFound is returned when the program to reserve does not exist
+var ErrProgramNotFound = errors.New("program not found")
+
+// invalidRecorderURLError is returned when the requested recorder URL is rejected
+type invalidRecorderURLError struct {
+	err error
+}
+
+func (e *invalidRecorderURLError) Error() string {
+	return fmt.Sprintf("Invalid recorder URL: %v", e.err)
+}
+
+// Reserve creates a reservation for the program and calls the recorder API asynchronously
+func (h *ReservationHandler) Reserve(programID int64, recorderURL string) (*models.Reservation, error) {
 	// Get program details
-	program, err := db.GetProgramByID(h.DB, req.ProgramID)
+	program, err := db.GetProgramByID(h.DB, programID)
 	if err != nil {
-		models.Log.Error("CreateReservation: Failed to get program: %v", err)
-		respondWithJSON(w, http.StatusNotFound, models.ReservationResponse{
-			Success: false,
-			Error:   "Program not found",
-		})
-		return
+		models.Log.Error("Reserve: Failed to get program: %v", err)
+		if err == sql.ErrNoRows {
+			return nil, ErrProgramNotFound
+		}
+		return nil, err
 	}
 	
-	// Use provided recorder URL or default
-	recorderURL := req.RecorderURL
+	// Use provided recorder URL, then the recorder of the program's Mirakurun source, then the default
 	if recorderURL == "" {
-		recorderURL = h.RecorderURL
+		if source, ok := models.LookupMirakurunSource(program.Source); ok && source.RecorderURL != "" {
+			recorderURL = source.RecorderURL
+		} else {
+			recorderURL = h.RecorderURL
+		}
 	}
 	
 	// Validate recorder URL
 	if err := validateRecorderURL(recorderURL); err != nil {
-		models.Log.Error("CreateReservation: Invalid recorder URL: %v", err)
-		respondWithJSON(w, http.StatusBadRequest, models.ReservationResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Invalid recorder URL: %v", err),
-		})
-		return
+		models.Log.Error("Reserve: Invalid recorder URL: %v", err)
+		return nil, &invalidRecorderURLError{err: err}
 	}
 	
 	// Create reservation
@@ -81,41 +84,72 @@ func (h *ReservationHandler) CreateReservation(w http.ResponseWriter, r *http.Re
 		ID:                uuid.New().String(),
 		ProgramID:         program.ID,
 		ServiceID:         program.ServiceID,
+		NetworkID:         program.NetworkID,
 		Name:              program.Name,
 		StartAt:           program.StartAt,
 		Duration:          program.Duration,
 		RecorderURL:       recorderURL,
 		RecorderProgramID: fmt.Sprintf("%d", program.ID),
+		Source:            program.Source,
 		Status:            models.ReservationStatusPending,
 		CreatedAt:         time.Now().UnixMilli(),
 		UpdatedAt:         time.Now().UnixMilli(),
 	}
 	
 	// Save to database
-	_, err = h.DB.Exec(`
-		INSERT INTO reservations (id, programId, serviceId, name, startAt, duration, 
-			recorderUrl, recorderProgramId, status, createdAt, updatedAt)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		reservation.ID, reservation.ProgramID, reservation.ServiceID, reservation.Name,
-		reservation.StartAt, reservation.Duration, reservation.RecorderURL,
-		reservation.RecorderProgramID, reservation.Status, reservation.CreatedAt, reservation.UpdatedAt)
-	
-	if err != nil {
-		models.Log.Error("CreateReservation: Failed to save reservation: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, models.ReservationResponse{
-			Success: false,
-			Error:   "Failed to create reservation",
-		})
-		return
+	if err := db.InsertReservation(h.DB, reservation); err != nil {
+		return nil, err
 	}
 	
+	events.Publish(events.ResourceReservation, "create", reservation)
+	
 	// Call recorder API asynchronously
 	// The reservation is created with "pending" status.
 	// The API call will update the status to "recording" on success or "failed" on error.
 	// This ensures the reservation is tracked even if the external API call fails.
 	go h.callRecorderAPI(reservation)
 	
-	models.Log.Info("CreateReservation: Created reservation %s for program %d", reservation.ID, program.ID)
+	models.Log.Info("Reserve: Created reservation %s for program %d", reservation.ID, program.ID)
+	return reservation, nil
+}
+
+// CreateReservation handles POST /reservations
+func (h *ReservationHandler) CreateReservation(w http.ResponseWriter, r *http.Request) {
+	models.Log.Info("CreateReservation: Processing request")
+	
+	var req models.CreateReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.Log.Error("CreateReservation: Failed to decode request: %v", err)
+		respondWithJSON(w, http.StatusBadRequest, models.ReservationResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+		return
+	}
+	
+	reservation, err := h.Reserve(req.ProgramID, req.RecorderURL)
+	if err != nil {
+		var urlErr *invalidRecorderURLError
+		switch {
+		case err == ErrProgramNotFound:
+			respondWithJSON(w, http.StatusNotFound, models.ReservationResponse{
+				Success: false,
+				Error:   "Program not found",
+			})
+		case errors.As(err, &urlErr):
+			respondWithJSON(w, http.StatusBadRequest, models.ReservationResponse{
+				Success: false,
+				Error:   urlErr.Error(),
+			})
+		default:
+			respondWithJSON(w, http.StatusInternalServerError, models.ReservationResponse{
+				Success: false,
+				Error:   "Failed to create reservation",
+			})
+		}
+		return
+	}
+	
 	respondWithJSON(w, http.StatusCreated, models.ReservationResponse{
 		Success: true,
 		Data:    reservation,
@@ -126,43 +160,16 @@ func (h *ReservationHandler) CreateReservation(w http.ResponseWriter, r *http.Re
 func (h *ReservationHandler) GetReservations(w http.ResponseWriter, r *http.Request) {
 	models.Log.Info("GetReservations: Processing request")
 	
-	query := `
-		SELECT id, programId, serviceId, name, startAt, duration,
-			recorderUrl, recorderProgramId, status, createdAt, updatedAt, error
-		FROM reservations
-		ORDER BY startAt DESC
-	`
-	
-	rows, err := h.DB.Query(query)
+	reservations, err := db.GetReservations(h.DB)
 	if err != nil {
-		models.Log.Error("GetReservations: Query failed: %v", err)
 		respondWithJSON(w, http.StatusInternalServerError, models.ReservationsListResponse{
 			Success: false,
 			Error:   "Failed to fetch reservations",
 		})
 		return
 	}
-	defer rows.Close()
-	
-	var reservations []models.Reservation
-	for rows.Next() {
-		var r models.Reservation
-		var errorStr sql.NullString
-		
-		err := rows.Scan(&r.ID, &r.ProgramID, &r.ServiceID, &r.Name, &r.StartAt, &r.Duration,
-			&r.RecorderURL, &r.RecorderProgramID, &r.Status, &r.CreatedAt, &r.UpdatedAt, &errorStr)
-		if err != nil {
-			models.Log.Error("GetReservations: Scan failed: %v", err)
-			continue
-		}
-		
-		if errorStr.Valid {
-			r.Error = errorStr.String
-		}
-		
-		reservations = append(reservations, r)
-	}
 	
+	attachReservationPrograms(h.DB, reservations)
 	models.Log.Info("GetReservations: Found %d reservations", len(reservations))
 	respondWithJSON(w, http.StatusOK, models.ReservationsListResponse{
 		Success:      true,
@@ -171,6 +178,30 @@ func (h *ReservationHandler) GetReservations(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// attachReservationPrograms sets the program metadata of each reservation.
+// Programs that have already aired are looked up in the archive
+func attachReservationPrograms(database *sql.DB, reservations []models.Reservation) {
+	for i := range reservations {
+		program, err := db.GetProgramByID(database, reservations[i].ProgramID)
+		if err != nil {
+			continue
+		}
+		decorateProgram(program)
+		reservations[i].Program = program
+	}
+}
+
+// Cancel deletes a reservation. It returns sql.ErrNoRows if the reservation does not exist
+func (h *ReservationHandler) Cancel(id string) error {
+	if err := db.DeleteReservation(h.DB, id); err != nil {
+		return err
+	}
+	
+	models.Log.Info("Cancel: Deleted reservation %s", id)
+	events.Publish(events.ResourceReservation, "remove", map[string]string{"id": id})
+	return nil
+}
+
 // DeleteReservation handles DELETE /reservations/{id}
 func (h *ReservationHandler) DeleteReservation(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -178,18 +209,7 @@ func (h *ReservationHandler) DeleteReservation(w http.ResponseWriter, r *http.Re
 	
 	models.Log.Info("DeleteReservation: Processing request for ID %s", id)
 	
-	// Get reservation details first
-	var reservation models.Reservation
-	err := h.DB.QueryRow(`
-		SELECT id, programId, serviceId, name, startAt, duration,
-			recorderUrl, recorderProgramId, status, createdAt, updatedAt
-		FROM reservations WHERE id = ?`, id).Scan(
-		&reservation.ID, &reservation.ProgramID, &reservation.ServiceID,
-		&reservation.Name, &reservation.StartAt, &reservation.Duration,
-		&reservation.RecorderURL, &reservation.RecorderProgramID,
-		&reservation.Status, &reservation.CreatedAt, &reservation.UpdatedAt)
-	
-	if err != nil {
+	if err := h.Cancel(id); err != nil {
 		if err == sql.ErrNoRows {
 			respondWithJSON(w, http.StatusNotFound, models.ReservationResponse{
 				Success: false,
@@ -197,18 +217,6 @@ func (h *ReservationHandler) DeleteReservation(w http.ResponseWriter, r *http.Re
 			})
 			return
 		}
-		models.Log.Error("DeleteReservation: Query failed: %v", err)
-		respondWithJSON(w, http.StatusInternalServerError, models.ReservationResponse{
-			Success: false,
-			Error:   "Failed to fetch reservation",
-		})
-		return
-	}
-	
-	// Delete from database
-	_, err = h.DB.Exec("DELETE FROM reservations WHERE id = ?", id)
-	if err != nil {
-		models.Log.Error("DeleteReservation: Delete failed: %v", err)
 		respondWithJSON(w, http.StatusInternalServerError, models.ReservationResponse{
 			Success: false,
 			Error:   "Failed to delete reservation",
@@ -216,7 +224,6 @@ func (h *ReservationHandler) DeleteReservation(w http.ResponseWriter, r *http.Re
 		return
 	}
 	
-	models.Log.Info("DeleteReservation: Deleted reservation %s", id)
 	respondWithJSON(w, http.StatusOK, models.ReservationResponse{
 		Success: true,
 		Message: "Reservation deleted successfully",
@@ -279,6 +286,8 @@ func (h *ReservationHandler) callRecorderAPI(reservation *models.Reservation) {
 	
 	if err != nil {
 		models.Log.Error("callRecorderAPI: Failed to update status: %v", err)
+	} else {
+		publishReservationStatus(reservation.ID, models.ReservationStatusRecording, "")
 	}
 	
 	models.Log.Info("callRecorderAPI: Successfully called recorder API for reservation %s", reservation.ID)
@@ -294,9 +303,23 @@ func (h *ReservationHandler) updateReservationError(id string, errMsg string) {
 	
 	if err != nil {
 		models.Log.Error("updateReservationError: Failed to update: %v", err)
+		return
+	}
+	publishReservationStatus(id, models.ReservationStatusFailed, errMsg)
+	if h.Webhooks != nil {
+		h.Webhooks.NotifyReservationFailed(id)
 	}
 }
 
+// publishReservationStatus notifies event subscribers of a reservation status transition
+func publishReservationStatus(id string, status models.ReservationStatus, errMsg string) {
+	events.Publish(events.ResourceReservation, "update", map[string]interface{}{
+		"id":     id,
+		"status": status,
+		"error":  errMsg,
+	})
+}
+
 // validateRecorderURL validates the recorder URL format and checks against allowed hosts
 func validateRecorderURL(recorderURL string) error {
 	if recorderURL == "" {
@@ -285,4 +285,90 @@ func TestDeleteReservationNotFound(t *testing.T) {
 	if response.Error != "Reservation not found" {
 		t.Errorf("Expected 'Reservation not found' error, got %s", response.Error)
 	}
-}
\ No newline at end of file
+}
+
+func TestGetReservationsAttachesArchivedProgram(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	// 予約済みの番組が放送を終えてアーカイブに移った状態にする
+	if _, err := database.Exec(`UPDATE programs SET startAt = ?, duration = 1800000 WHERE id = 12345`,
+		time.Now().Add(-2*time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("Failed to update program: %v", err)
+	}
+	if _, err := db.ArchiveEndedPrograms(database, time.Now().UnixMilli()); err != nil {
+		t.Fatalf("Failed to archive programs: %v", err)
+	}
+	_, err := database.Exec(`
+		INSERT INTO reservations (id, programId, serviceId, name, startAt, duration,
+			recorderUrl, recorderProgramId, status, createdAt, updatedAt)
+		VALUES ('test-id-1', 12345, 1234, 'Test Program', ?, 1800000, 'http://recorder:8080', '12345', 'completed', ?, ?)`,
+		time.Now().Add(-2*time.Hour).UnixMilli(), time.Now().UnixMilli(), time.Now().UnixMilli())
+	if err != nil {
+		t.Fatalf("Failed to insert test reservation: %v", err)
+	}
+
+	handler := NewReservationHandler(database, "http://recorder:8080")
+	req, _ := http.NewRequest("GET", "/reservations", nil)
+	rr := httptest.NewRecorder()
+	handler.GetReservations(rr, req)
+
+	var response models.ReservationsListResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Reservations) != 1 || response.Reservations[0].Program == nil {
+		t.Fatalf("Expected reservation with archived program, got %s", rr.Body.String())
+	}
+	if response.Reservations[0].Program.ID != 12345 {
+		t.Errorf("Expected program 12345, got %d", response.Reservations[0].Program.ID)
+	}
+}
+
+func TestCreateReservationUsesSourceRecorder(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	if _, err := database.Exec(`UPDATE programs SET source = 'bs' WHERE id = 12345`); err != nil {
+		t.Fatalf("Failed to set program source: %v", err)
+	}
+
+	called := make(chan string, 1)
+	sourceRecorder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- r.URL.Query().Get("program_id")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer sourceRecorder.Close()
+
+	defer models.SetMirakurunSources(models.GetMirakurunSources())
+	models.SetMirakurunSources([]models.MirakurunSource{{Name: "bs", URL: "http://bs:40772/api", RecorderURL: sourceRecorder.URL}})
+
+	handler := NewReservationHandler(database, "http://default-recorder:37569")
+	reservation, err := handler.Reserve(12345, "")
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if reservation.RecorderURL != sourceRecorder.URL {
+		t.Errorf("Expected source recorder %s, got %s", sourceRecorder.URL, reservation.RecorderURL)
+	}
+	if reservation.Source != "bs" {
+		t.Errorf("Expected source bs, got %q", reservation.Source)
+	}
+
+	select {
+	case programID := <-called:
+		if programID != "12345" {
+			t.Errorf("Expected program_id=12345, got %s", programID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected source recorder to be called")
+	}
+
+	saved, err := db.GetReservationByID(database, reservation.ID)
+	if err != nil {
+		t.Fatalf("Failed to get reservation: %v", err)
+	}
+	if saved.Source != "bs" {
+		t.Errorf("Expected saved source bs, got %q", saved.Source)
+	}
+}
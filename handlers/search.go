@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fuba/iepg-server/db"
 	"github.com/fuba/iepg-server/models"
@@ -26,7 +28,7 @@ func HandleSimpleSearch(w http.ResponseWriter, r *http.Request, dbConn *sql.DB)
 	models.Log.Debug("HandleSimpleSearch: Query params - q=%s, serviceId=%s, startFrom=%s, startTo=%s, channelType=%s", 
 		q, serviceIdStr, startFromStr, startToStr, channelTypeStr)
 
-	var serviceId int64
+	var serviceId, networkId int64
 	var startFrom, startTo int64
 	var channelType int
 	var err error
@@ -39,6 +41,15 @@ func HandleSimpleSearch(w http.ResponseWriter, r *http.Request, dbConn *sql.DB)
 			return
 		}
 	}
+
+	if networkIdStr := r.URL.Query().Get("networkId"); networkIdStr != "" {
+		networkId, err = strconv.ParseInt(networkIdStr, 10, 64)
+		if err != nil {
+			models.Log.Error("HandleSimpleSearch: Invalid networkId: %s, error: %v", networkIdStr, err)
+			http.Error(w, "invalid networkId", http.StatusBadRequest)
+			return
+		}
+	}
 	
 	if channelTypeStr != "" {
 		var channelTypeInt int64
@@ -76,10 +87,56 @@ func HandleSimpleSearch(w http.ResponseWriter, r *http.Request, dbConn *sql.DB)
 		}
 	}
 	
+	// 番組名の記号（カンマ区切り）と話数による絞り込み
+	var minEpisode int
+	if minEpisodeStr := r.URL.Query().Get("minEpisode"); minEpisodeStr != "" {
+		minEpisode, err = strconv.Atoi(minEpisodeStr)
+		if err != nil || minEpisode < 0 {
+			models.Log.Error("HandleSimpleSearch: Invalid minEpisode: %s", minEpisodeStr)
+			http.Error(w, "invalid minEpisode", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 映像・音声・字幕の属性（カンマ区切り）による絞り込み
+	attributes := splitListParam(r.URL.Query().Get("attributes"))
+	excludeAttributes := splitListParam(r.URL.Query().Get("excludeAttributes"))
+	for _, attr := range append(append([]string{}, attributes...), excludeAttributes...) {
+		if !models.IsProgramAttribute(attr) {
+			models.Log.Error("HandleSimpleSearch: Unknown attribute: %s", attr)
+			http.Error(w, "unknown attribute: "+attr, http.StatusBadRequest)
+			return
+		}
+	}
+
 	models.Log.Debug("HandleSimpleSearch: Parsed params - q=%s, serviceId=%d, startFrom=%d, startTo=%d, channelType=%d", 
 		q, serviceId, startFrom, startTo, channelType)
 
-	programs, err := db.SearchPrograms(dbConn, q, serviceId, startFrom, startTo, channelType)
+	opts := db.SearchOptions{
+		Query:       q,
+		ServiceID:   serviceId,
+		NetworkID:   networkId,
+		StartFrom:   startFrom,
+		StartTo:     startTo,
+		ChannelType: channelType,
+		IncludePast: r.URL.Query().Get("includePast") == "true",
+
+		Flags:        splitListParam(r.URL.Query().Get("flags")),
+		ExcludeFlags: splitListParam(r.URL.Query().Get("excludeFlags")),
+		MinEpisode:   minEpisode,
+
+		Attributes:        attributes,
+		ExcludeAttributes: excludeAttributes,
+	}
+	group, ok := resolveGroupParam(w, r, dbConn)
+	if !ok {
+		return
+	}
+	if group != nil {
+		opts.GroupID = group.ID
+	}
+
+	programs, err := db.SearchProgramsWithOptions(dbConn, opts)
 	if err != nil {
 		models.Log.Error("HandleSimpleSearch: Search failed: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -88,30 +145,7 @@ func HandleSimpleSearch(w http.ResponseWriter, r *http.Request, dbConn *sql.DB)
 	
 	// 各プログラムに追加情報を付与
 	for i := range programs {
-		// 番組名とプログラム説明を特殊文字変換
-		programs[i].Name = normalizeSpecialCharacters(programs[i].Name)
-		programs[i].Description = normalizeSpecialCharacters(programs[i].Description)
-		
-		// サービス情報を付与
-		if service, ok := models.ServiceMapInstance.Get(programs[i].ServiceID); ok {
-			// テレビ局情報を付与
-			programs[i].StationName = service.Name
-			
-			// リモコンキーIDあるいはサービスIDを取得
-			programs[i].RemoteControlKey = service.RemoteControlKeyID
-			if service.RemoteControlKeyID > 0 {
-				programs[i].StationID = fmt.Sprintf("%04d", service.RemoteControlKeyID)
-			} else {
-				programs[i].StationID = fmt.Sprintf("%04d", service.ServiceID)
-			}
-			
-			// チャンネル情報を付与
-			programs[i].ChannelType = service.ChannelType
-			programs[i].ChannelNumber = service.ChannelNumber
-			
-			models.Log.Debug("HandleSimpleSearch: Added service info for program: %d - %s (%s)",
-				programs[i].ID, programs[i].Name, programs[i].StationName)
-		}
+		decorateProgram(&programs[i])
 	}
 	
 	models.Log.Info("HandleSimpleSearch: Search completed, found %d programs", len(programs))
@@ -123,6 +157,83 @@ func HandleSimpleSearch(w http.ResponseWriter, r *http.Request, dbConn *sql.DB)
 	}
 }
 
+// splitListParam はカンマ区切りのクエリパラメータを空の要素を除いて分割する
+func splitListParam(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// decorateProgram は表示用に番組名・説明の特殊文字を変換し、局情報を付与する
+func decorateProgram(p *models.Program) {
+	// 番組名とプログラム説明を特殊文字変換
+	p.Name = normalizeSpecialCharacters(p.Name)
+	p.Description = normalizeSpecialCharacters(p.Description)
+	p.Title = normalizeSpecialCharacters(p.Title)
+	p.Subtitle = normalizeSpecialCharacters(p.Subtitle)
+
+	// サービス情報を付与
+	if service, ok := models.ServiceMapInstance.Lookup(p.NetworkID, p.ServiceID); ok {
+		// テレビ局情報を付与
+		p.StationName = service.Name
+
+		// 上書き設定の局コード、リモコンキーIDあるいはサービスIDを取得
+		p.RemoteControlKey = service.RemoteControlKeyID
+		p.StationID = service.IEPGStationID()
+
+		// チャンネル情報を付与
+		p.ChannelType = service.ChannelType
+		p.ChannelNumber = service.ChannelNumber
+		p.LogoURL = service.LogoURL
+
+		models.Log.Debug("decorateProgram: Added service info for program: %d - %s (%s)",
+			p.ID, p.Name, p.StationName)
+	}
+}
+
+// sortServicesForDisplay はサービスを並び順の上書き設定→種別→リモコンキーID→サービスIDの順に並べる
+func sortServicesForDisplay(services []*models.Service) {
+	sort.Slice(services, func(i, j int) bool {
+		return serviceDisplayLess(services[i], services[j])
+	})
+}
+
+// serviceDisplayLess はチャンネル一覧の表示順での比較関数
+func serviceDisplayLess(a, b *models.Service) bool {
+	// 並び順が設定されたサービスを先に、設定値の昇順で並べる
+	if a.SortKey != b.SortKey {
+		if a.SortKey == 0 || b.SortKey == 0 {
+			return a.SortKey != 0
+		}
+		return a.SortKey < b.SortKey
+	}
+
+	// サービスタイプでまずソート
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	
+	// 同じサービスタイプ内ではリモコンキー順
+	if a.RemoteControlKeyID > 0 && b.RemoteControlKeyID > 0 {
+		return a.RemoteControlKeyID < b.RemoteControlKeyID
+	}
+	
+	// リモコンキーがあるほうが前
+	if a.RemoteControlKeyID > 0 {
+		return true
+	}
+	if b.RemoteControlKeyID > 0 {
+		return false
+	}
+	
+	// どちらもリモコンキーがない場合はサービスID順
+	return a.ServiceID < b.ServiceID
+}
+
 // HandleGetServices はすべてのサービス情報を返すハンドラー
 // 除外チャンネルを除いたサービス一覧を返す
 func HandleGetServices(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
@@ -142,28 +253,16 @@ func HandleGetServices(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
 	}
 	
 	// サービスをリモコンキーID順、次にサービスID順でソート
-	sort.Slice(services, func(i, j int) bool {
-		// サービスタイプでまずソート
-		if services[i].Type != services[j].Type {
-			return services[i].Type < services[j].Type
-		}
-		
-		// 同じサービスタイプ内ではリモコンキー順
-		if services[i].RemoteControlKeyID > 0 && services[j].RemoteControlKeyID > 0 {
-			return services[i].RemoteControlKeyID < services[j].RemoteControlKeyID
-		}
-		
-		// リモコンキーがあるほうが前
-		if services[i].RemoteControlKeyID > 0 {
-			return true
-		}
-		if services[j].RemoteControlKeyID > 0 {
-			return false
-		}
-		
-		// どちらもリモコンキーがない場合はサービスID順
-		return services[i].ServiceID < services[j].ServiceID
-	})
+	sortServicesForDisplay(services)
+	
+	// グループ指定時はグループ内のサービスのみをグループの並び順で返す
+	group, ok := resolveGroupParam(w, r, dbConn)
+	if !ok {
+		return
+	}
+	if group != nil {
+		services = filterServicesByGroup(services, group)
+	}
 	
 	models.Log.Info("HandleGetServices: Returning %d services", len(services))
 	
@@ -220,9 +319,12 @@ func HandleGetSearchableServices(w http.ResponseWriter, r *http.Request, dbConn
 			svc.ID, svc.ServiceID, svc.Name, svc.Type, svc.ChannelType)
 	}
 	
-	// サービスをサービスID順でソート
+	// サービスをサービスID、ネットワークID順でソート
 	sort.Slice(services, func(i, j int) bool {
-		return services[i].ServiceID < services[j].ServiceID
+		if services[i].ServiceID != services[j].ServiceID {
+			return services[i].ServiceID < services[j].ServiceID
+		}
+		return services[i].NetworkID < services[j].NetworkID
 	})
 	
 	models.Log.Info("HandleGetSearchableServices: Returning %d detailed services", len(services))
@@ -264,30 +366,38 @@ func HandleGetAllServices(w http.ResponseWriter, r *http.Request, dbConn *sql.DB
 		excludedServices = []models.ExcludedService{} // エラーの場合は空のスライスを使用
 	}
 	
-	// 除外されているサービスIDのマップを作成
-	excludedIds := make(map[int64]bool)
+	// 除外されているサービスのマップを作成（networkId が0の除外はすべてのネットワークに適用）
+	excludedKeys := make(map[models.ServiceKey]bool)
 	for _, excludedSvc := range excludedServices {
-		excludedIds[excludedSvc.ServiceID] = true
+		excludedKeys[models.ServiceKey{NetworkID: excludedSvc.NetworkID, ServiceID: excludedSvc.ServiceID}] = true
 	}
-	models.Log.Debug("HandleGetAllServices: Loaded %d excluded services", len(excludedIds))
+	models.Log.Debug("HandleGetAllServices: Loaded %d excluded services", len(excludedKeys))
+
+	// 除外ルールによる除外も含めた判定
+	isExcluded := db.ExcludedServiceFilter(dbConn)
 	
 	// 各サービスに除外フラグを追加
 	for i, service := range services {
-		if excludedIds[service.ServiceID] {
+		service.ExcludedByRule = false
+		if excludedKeys[service.Key()] || excludedKeys[models.ServiceKey{ServiceID: service.ServiceID}] {
 			service.IsExcluded = true
 			models.Log.Debug("HandleGetAllServices: Marked service as excluded: %d (%s)", 
 				service.ServiceID, service.Name)
 		} else {
 			service.IsExcluded = false
-			models.Log.Debug("HandleGetAllServices: Marked service as not excluded: %d (%s)", 
-				service.ServiceID, service.Name)
+			service.ExcludedByRule = isExcluded(service.NetworkID, service.ServiceID)
+			models.Log.Debug("HandleGetAllServices: Marked service as not excluded: %d (%s), excludedByRule=%v", 
+				service.ServiceID, service.Name, service.ExcludedByRule)
 		}
 		services[i] = service // ポインタでなくコピーなのでインデックスで更新
 	}
 	
-	// サービスをサービスID順でソート
+	// サービスをサービスID、ネットワークID順でソート
 	sort.Slice(services, func(i, j int) bool {
-		return services[i].ServiceID < services[j].ServiceID
+		if services[i].ServiceID != services[j].ServiceID {
+			return services[i].ServiceID < services[j].ServiceID
+		}
+		return services[i].NetworkID < services[j].NetworkID
 	})
 	
 	models.Log.Info("HandleGetAllServices: Returning %d services", len(services))
@@ -298,6 +408,14 @@ func HandleGetAllServices(w http.ResponseWriter, r *http.Request, dbConn *sql.DB
 	}
 }
 
+// resolveServiceName は ServiceMap からサービス名を取得する。見つからない場合は仮の名前を返す
+func resolveServiceName(networkID, serviceID int64) string {
+	if svc, ok := models.ServiceMapInstance.Lookup(networkID, serviceID); ok {
+		return svc.Name
+	}
+	return fmt.Sprintf("Service %d", serviceID)
+}
+
 // HandleGetExcludedServices は除外チャンネルの一覧を取得するハンドラー
 func HandleGetExcludedServices(w http.ResponseWriter, r *http.Request, dbConn *sql.DB) {
 	models.Log.Debug("HandleGetExcludedServices: Processing request from %s", r.RemoteAddr)
@@ -328,9 +446,13 @@ func HandleAddExcludedService(w http.ResponseWriter, r *http.Request, dbConn *sq
 	}
 	
 	// リクエストボディをパース
+	// networkId を省略した場合はすべてのネットワークの同じサービスIDを除外する
+	// expiresAt（Unixミリ秒）を指定するとその日時まで除外する
 	var service struct {
+		NetworkID int64  `json:"networkId"`
 		ServiceID int64  `json:"serviceId"`
 		Name      string `json:"name"`
+		ExpiresAt int64  `json:"expiresAt"`
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
@@ -339,27 +461,28 @@ func HandleAddExcludedService(w http.ResponseWriter, r *http.Request, dbConn *sq
 		return
 	}
 	
-	models.Log.Debug("HandleAddExcludedService: Adding service - ID=%d, Name=%s", service.ServiceID, service.Name)
+	models.Log.Debug("HandleAddExcludedService: Adding service - NetworkID=%d, ID=%d, Name=%s, ExpiresAt=%d",
+		service.NetworkID, service.ServiceID, service.Name, service.ExpiresAt)
+
+	if service.ExpiresAt != 0 && service.ExpiresAt <= time.Now().UnixMilli() {
+		http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+		return
+	}
 	
 	// サービス名が空の場合、ServiceMapから名前を取得
 	if service.Name == "" {
-		if svc, ok := models.ServiceMapInstance.Get(service.ServiceID); ok {
-			service.Name = svc.Name
-			models.Log.Debug("HandleAddExcludedService: Found service name from ServiceMap: %s", service.Name)
-		} else {
-			service.Name = fmt.Sprintf("Service %d", service.ServiceID)
-			models.Log.Debug("HandleAddExcludedService: Using generated service name: %s", service.Name)
-		}
+		service.Name = resolveServiceName(service.NetworkID, service.ServiceID)
+		models.Log.Debug("HandleAddExcludedService: Resolved service name: %s", service.Name)
 	}
 	
-	if err := db.AddExcludedService(dbConn, service.ServiceID, service.Name); err != nil {
+	if err := db.AddExcludedServiceUntil(dbConn, service.NetworkID, service.ServiceID, service.Name, service.ExpiresAt); err != nil {
 		models.Log.Error("HandleAddExcludedService: Failed to add excluded service: %v", err)
 		http.Error(w, "Failed to add excluded service", http.StatusInternalServerError)
 		return
 	}
 	
-	models.Log.Info("HandleAddExcludedService: Successfully added service %d (%s) to excluded list", 
-		service.ServiceID, service.Name)
+	models.Log.Info("HandleAddExcludedService: Successfully added service %d/%d (%s) to excluded list", 
+		service.NetworkID, service.ServiceID, service.Name)
 	
 	// 成功レスポンス
 	w.Header().Set("Content-Type", "application/json")
@@ -381,7 +504,9 @@ func HandleRemoveExcludedService(w http.ResponseWriter, r *http.Request, dbConn
 	}
 	
 	// リクエストボディをパース
+	// networkId を省略した場合はすべてのネットワークの同じサービスIDの除外を解除する
 	var service struct {
+		NetworkID int64 `json:"networkId"`
 		ServiceID int64 `json:"serviceId"`
 	}
 	
@@ -391,15 +516,15 @@ func HandleRemoveExcludedService(w http.ResponseWriter, r *http.Request, dbConn
 		return
 	}
 	
-	models.Log.Debug("HandleRemoveExcludedService: Removing service - ID=%d", service.ServiceID)
+	models.Log.Debug("HandleRemoveExcludedService: Removing service - NetworkID=%d, ID=%d", service.NetworkID, service.ServiceID)
 	
-	if err := db.RemoveExcludedService(dbConn, service.ServiceID); err != nil {
+	if err := db.RemoveExcludedService(dbConn, service.NetworkID, service.ServiceID); err != nil {
 		models.Log.Error("HandleRemoveExcludedService: Failed to remove excluded service: %v", err)
 		http.Error(w, "Failed to remove excluded service", http.StatusInternalServerError)
 		return
 	}
 	
-	models.Log.Info("HandleRemoveExcludedService: Successfully removed service %d from excluded list", service.ServiceID)
+	models.Log.Info("HandleRemoveExcludedService: Successfully removed service %d/%d from excluded list", service.NetworkID, service.ServiceID)
 	
 	// 成功レスポンス
 	w.Header().Set("Content-Type", "application/json")
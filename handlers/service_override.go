@@ -0,0 +1,131 @@
+// handlers/service_override.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// ServiceOverrideRequest は上書き設定の登録・更新リクエスト
+type ServiceOverrideRequest struct {
+	DisplayName   string `json:"displayName"`
+	StationCode   string `json:"stationCode"`
+	ChannelNumber string `json:"channelNumber"`
+	SortKey       int    `json:"sortKey"`
+}
+
+// validate はリクエストを検証し、エラーメッセージを返す
+// 値は IEPG ファイルのヘッダー行にそのまま出力されるため改行を含められない
+func (req *ServiceOverrideRequest) validate() string {
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	req.StationCode = strings.TrimSpace(req.StationCode)
+	req.ChannelNumber = strings.TrimSpace(req.ChannelNumber)
+	for _, v := range []string{req.DisplayName, req.StationCode, req.ChannelNumber} {
+		if strings.ContainsAny(v, "\r\n") {
+			return "Values must not contain line breaks"
+		}
+	}
+	if req.DisplayName == "" && req.StationCode == "" && req.ChannelNumber == "" && req.SortKey == 0 {
+		return "At least one of displayName, stationCode, channelNumber or sortKey is required"
+	}
+	if req.SortKey < 0 {
+		return "sortKey must not be negative"
+	}
+	return ""
+}
+
+// parseServiceKeyVars はパスの {networkId} と {serviceId} を取得する
+func parseServiceKeyVars(r *http.Request) (int64, int64, bool) {
+	vars := mux.Vars(r)
+	networkID, err := strconv.ParseInt(vars["networkId"], 10, 64)
+	if err != nil || networkID == 0 {
+		return 0, 0, false
+	}
+	serviceID, err := strconv.ParseInt(vars["serviceId"], 10, 64)
+	if err != nil || serviceID == 0 {
+		return 0, 0, false
+	}
+	return networkID, serviceID, true
+}
+
+// HandleGetServiceOverrides は GET /services/overrides のハンドラー
+func HandleGetServiceOverrides(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		overrides, err := db.GetServiceOverrides(database)
+		if err != nil {
+			http.Error(w, "Failed to get service overrides", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(overrides)
+	}
+}
+
+// HandlePutServiceOverride は PUT /services/overrides/{networkId}/{serviceId} のハンドラー
+// 上書き設定を登録（既存の設定は置き換え）し、適用後のサービス情報を返す
+func HandlePutServiceOverride(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		networkID, serviceID, ok := parseServiceKeyVars(r)
+		if !ok {
+			http.Error(w, "Invalid networkId or serviceId", http.StatusBadRequest)
+			return
+		}
+
+		var req ServiceOverrideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			models.Log.Error("HandlePutServiceOverride: Invalid JSON: %v", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		override := &models.ServiceOverride{
+			NetworkID:     networkID,
+			ServiceID:     serviceID,
+			DisplayName:   req.DisplayName,
+			StationCode:   req.StationCode,
+			ChannelNumber: req.ChannelNumber,
+			SortKey:       req.SortKey,
+		}
+		if err := db.SaveServiceOverride(database, override); err != nil {
+			http.Error(w, "Failed to save service override", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(override)
+	}
+}
+
+// HandleDeleteServiceOverride は DELETE /services/overrides/{networkId}/{serviceId} のハンドラー
+func HandleDeleteServiceOverride(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		networkID, serviceID, ok := parseServiceKeyVars(r)
+		if !ok {
+			http.Error(w, "Invalid networkId or serviceId", http.StatusBadRequest)
+			return
+		}
+
+		if err := db.DeleteServiceOverride(database, networkID, serviceID); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Service override not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to delete service override", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/encoding/japanese"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestServiceOverrideAppliedToIEPG(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	models.ServiceMapInstance = models.NewServiceMap()
+	models.ServiceMapInstance.Update(&models.Service{ServiceID: 1234, NetworkID: 32736, Name: "テスト局",
+		Type: 1, RemoteControlKeyID: 5, ChannelType: "GR", ChannelNumber: "27"})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/services/overrides/{networkId:[0-9]+}/{serviceId:[0-9]+}", HandlePutServiceOverride(database)).Methods("PUT")
+
+	put := func(body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", "/services/overrides/32736/1234", bytes.NewBufferString(body)))
+		return w.Code
+	}
+	if code := put(`{"displayName": "局\r\nstation: 9999"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a value with a line break, got %d", code)
+	}
+	if code := put(`{}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty override, got %d", code)
+	}
+	if code := put(`{"displayName": "表示名", "stationCode": "BSA", "channelNumber": "011"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	HandleIEPG(w, httptest.NewRequest("GET", "/program/12345.tvpid", nil), database)
+	body, _ := japanese.ShiftJIS.NewDecoder().String(w.Body.String())
+	for _, line := range []string{"station: BSA\r\n", "station-name: 表示名\r\n", "channel: 011\r\n"} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %q in IEPG output:\n%s", line, body)
+		}
+	}
+
+	p := models.Program{ServiceID: 1234, NetworkID: 32736}
+	decorateProgram(&p)
+	if p.StationName != "表示名" || p.StationID != "BSA" || p.ChannelNumber != "011" {
+		t.Errorf("Override was not applied to program: %+v", p)
+	}
+}
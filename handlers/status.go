@@ -0,0 +1,39 @@
+// handlers/status.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// HandleGetInitialLoadStatus は /status/initial-load エンドポイントのハンドラー
+// Mirakurunからの番組情報の初期ロードの進捗を取得元ごとに返す
+func HandleGetInitialLoadStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(db.GetAllInitialLoadProgress()); err != nil {
+		models.Log.Error("HandleGetInitialLoadStatus: Failed to encode JSON response: %v", err)
+	}
+}
+
+// HandleGetProgramWriterStatus は /status/program-writer エンドポイントのハンドラー
+// 番組ストリームの書き込みキューの深さと書き込み件数を返す
+func HandleGetProgramWriterStatus(writer *db.ProgramWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(writer.Stats()); err != nil {
+			models.Log.Error("HandleGetProgramWriterStatus: Failed to encode JSON response: %v", err)
+		}
+	}
+}
+
+// HandleGetStreamStatus は /status/streams エンドポイントのハンドラー
+// Mirakurunのイベントストリームごとの接続状態を返す
+func HandleGetStreamStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(db.GetStreamStates()); err != nil {
+		models.Log.Error("HandleGetStreamStatus: Failed to encode JSON response: %v", err)
+	}
+}
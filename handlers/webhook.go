@@ -0,0 +1,293 @@
+// handlers/webhook.go
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/services"
+)
+
+// WebhookRequest represents the request payload for creating or updating a webhook
+type WebhookRequest struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+	Template   string   `json:"template"`
+	Secret     *string  `json:"secret"` // 省略時は既存の値を維持、空文字で署名を無効化
+	Enabled    bool     `json:"enabled"`
+}
+
+// validate checks the webhook request and returns a user-facing error message
+func (req *WebhookRequest) validate() string {
+	if req.Name == "" {
+		return "Name is required"
+	}
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "URL must be an absolute http or https URL"
+	}
+	for _, t := range req.EventTypes {
+		known := false
+		for _, k := range models.WebhookEventTypes {
+			if t == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "Unknown event type: " + t
+		}
+	}
+	if err := services.ValidateWebhookTemplate(req.Template); err != nil {
+		return "Invalid template: " + err.Error()
+	}
+	return ""
+}
+
+// hideWebhookSecret removes the shared secret before returning a webhook to clients
+func hideWebhookSecret(w *models.Webhook) {
+	w.Secret = ""
+}
+
+// HandleCreateWebhook handles POST /webhooks
+func HandleCreateWebhook(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		models.Log.Debug("HandleCreateWebhook: Processing request")
+
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			models.Log.Error("HandleCreateWebhook: Invalid JSON: %v", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		webhook := &models.Webhook{
+			Name:       req.Name,
+			URL:        req.URL,
+			EventTypes: req.EventTypes,
+			Template:   req.Template,
+			Enabled:    req.Enabled,
+		}
+		if req.Secret != nil {
+			webhook.Secret = *req.Secret
+		}
+
+		if err := db.CreateWebhook(database, webhook); err != nil {
+			models.Log.Error("HandleCreateWebhook: Failed to create webhook: %v", err)
+			http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+
+		hideWebhookSecret(webhook)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(webhook)
+
+		models.Log.Info("HandleCreateWebhook: Created webhook %s (%s)", webhook.ID, webhook.Name)
+	}
+}
+
+// HandleGetWebhooks handles GET /webhooks
+func HandleGetWebhooks(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhooks, err := db.GetWebhooks(database)
+		if err != nil {
+			http.Error(w, "Failed to get webhooks", http.StatusInternalServerError)
+			return
+		}
+		if webhooks == nil {
+			webhooks = []models.Webhook{}
+		}
+		for i := range webhooks {
+			hideWebhookSecret(&webhooks[i])
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webhooks)
+	}
+}
+
+// HandleGetWebhook handles GET /webhooks/{id}
+func HandleGetWebhook(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		webhook, err := db.GetWebhookByID(database, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get webhook", http.StatusInternalServerError)
+			return
+		}
+
+		hideWebhookSecret(webhook)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webhook)
+	}
+}
+
+// HandleUpdateWebhook handles PUT /webhooks/{id}
+func HandleUpdateWebhook(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		models.Log.Debug("HandleUpdateWebhook: Processing request for ID: %s", id)
+
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			models.Log.Error("HandleUpdateWebhook: Invalid JSON: %v", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		existing, err := db.GetWebhookByID(database, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get webhook", http.StatusInternalServerError)
+			return
+		}
+
+		existing.Name = req.Name
+		existing.URL = req.URL
+		existing.EventTypes = req.EventTypes
+		existing.Template = req.Template
+		existing.Enabled = req.Enabled
+		if req.Secret != nil {
+			existing.Secret = *req.Secret
+		}
+
+		if err := db.UpdateWebhook(database, existing); err != nil {
+			models.Log.Error("HandleUpdateWebhook: Failed to update webhook: %v", err)
+			http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+			return
+		}
+
+		hideWebhookSecret(existing)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(existing)
+
+		models.Log.Info("HandleUpdateWebhook: Updated webhook %s", id)
+	}
+}
+
+// HandleDeleteWebhook handles DELETE /webhooks/{id}
+func HandleDeleteWebhook(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		if err := db.DeleteWebhook(database, id); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		models.Log.Info("HandleDeleteWebhook: Deleted webhook %s", id)
+	}
+}
+
+// HandleGetWebhookDeliveries handles GET /webhooks/{id}/deliveries
+func HandleGetWebhookDeliveries(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		limit := 100 // Default limit
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+				limit = parsedLimit
+			}
+		}
+
+		if _, err := db.GetWebhookByID(database, id); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get webhook", http.StatusInternalServerError)
+			return
+		}
+
+		deliveries, err := db.GetWebhookDeliveries(database, id, limit)
+		if err != nil {
+			http.Error(w, "Failed to get deliveries", http.StatusInternalServerError)
+			return
+		}
+		if deliveries == nil {
+			deliveries = []models.WebhookDelivery{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// HandleTestWebhook handles POST /webhooks/{id}/test
+// 動作確認用のイベントを配信キューに積み、配信ワーカーに即時送信を依頼する
+func HandleTestWebhook(database *sql.DB, dispatcher *services.WebhookDispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		webhook, err := db.GetWebhookByID(database, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get webhook", http.StatusInternalServerError)
+			return
+		}
+
+		payload, err := services.RenderWebhookPayload(webhook, models.WebhookEvent{
+			Event: models.WebhookEventTest,
+			Time:  time.Now(),
+			Data:  map[string]string{"message": "test notification from iepg-server"},
+		})
+		if err != nil {
+			http.Error(w, "Failed to render template: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		delivery := &models.WebhookDelivery{
+			WebhookID: webhook.ID,
+			EventType: models.WebhookEventTest,
+			Payload:   payload,
+		}
+		if err := db.CreateWebhookDelivery(database, delivery); err != nil {
+			http.Error(w, "Failed to enqueue test delivery", http.StatusInternalServerError)
+			return
+		}
+		dispatcher.Wake()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"deliveryId": delivery.ID,
+		})
+	}
+}
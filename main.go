@@ -3,9 +3,17 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/gorilla/mux"
@@ -22,11 +30,37 @@ func main() {
 	if logLevel == "" {
 		logLevel = "info" // デフォルトはinfo
 	}
-	models.InitLogger(logLevel)
+
+	// "mcp" サブコマンドは標準入出力で MCP サーバーとして動作する
+	// 標準出力はプロトコルに使うため、ログは初期化時から標準エラー出力に書き出す
+	mcpStdio := len(os.Args) > 1 && os.Args[1] == "mcp"
+	if mcpStdio {
+		models.InitLoggerWithOutput(logLevel, os.Stderr)
+	} else {
+		models.InitLogger(logLevel)
+	}
 	models.Log.Debug("Starting iepg-server with log level: %s", logLevel)
 
+	// --demo を指定した場合は Mirakurun に接続せず、生成した1週間分の番組表で起動する
+	demoMode := hasArg("--demo")
+
 	// DB_PATH環境変数、またはデフォルト値を使用
 	dbPath := os.Getenv("DB_PATH")
+	if demoMode {
+		// 合成したサービスや番組が実運用のデータベースに残らないよう、終了時に削除する一時ディレクトリに保存する
+		if !demoAvailable {
+			log.Fatal("--demo requires a build with -tags demo (go run -tags demo . --demo)")
+		}
+		if dbPath != "" {
+			log.Fatal("--demo cannot be used with DB_PATH: demo data is stored in a temporary database")
+		}
+		demoDir, err := os.MkdirTemp("", "iepg-server-demo-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(demoDir)
+		dbPath = filepath.Join(demoDir, "programs.db")
+	}
 	if dbPath == "" {
 		dbPath = "./data/programs.db"
 	}
@@ -40,47 +74,202 @@ func main() {
 	defer dbConn.Close()
 	models.Log.Info("Database initialized successfully")
 
+	// 前回保存したサービス情報を読み込む（Mirakurunに接続できない場合の局情報として使用）
+	// サービスの上書き設定（表示名・IEPG局コードなど）を読み込む
+	if _, err := db.LoadServiceOverrides(dbConn); err != nil {
+		models.Log.Error("Failed to load service overrides: %v", err)
+	}
+	if _, err := db.LoadServices(dbConn); err != nil {
+		models.Log.Error("Failed to load stored services: %v", err)
+	}
+
+	// XMLTV の取り込み設定（チャンネルIDとサービスの対応表）
+	xmltvOptions, err := xmltvImportOptions()
+	if err != nil {
+		models.Log.Error("Invalid XMLTV_CHANNEL_MAP: %v", err)
+		log.Fatal(err)
+	}
+
+	// "import-xmltv" サブコマンドは XMLTV ファイルを1回取り込んで終了する
+	if len(os.Args) > 1 && os.Args[1] == "import-xmltv" {
+		runXMLTVImport(dbConn, os.Args[2:], xmltvOptions)
+		return
+	}
+
 	// MirakurunのベースURL
 	mirakurunURL := os.Getenv("MIRAKURUN_URL")
+	mirakurunConfigured := mirakurunURL != "" || os.Getenv("MIRAKURUN_SOURCES") != ""
+	xmltvFile := os.Getenv("XMLTV_FILE")
 	if mirakurunURL == "" {
 		mirakurunURL = "http://localhost:40772/api"
 	}
-	models.Log.Debug("Using Mirakurun URL: %s", mirakurunURL)
 
-	// 起動時にMirakurunから全番組情報を取得してDBを再構築する
-	ctx := context.Background()
+	// 番組・サービス情報の取得元
+	// MIRAKURUN_SOURCES で複数のMirakurunを指定できる（"名前=URL[|録画サーバーURL]" のカンマ区切り）
+	// 省略時は MIRAKURUN_URL のみを取得元とする
+	sources := []models.MirakurunSource{{Name: models.DefaultSourceName, URL: mirakurunURL}}
+	if sourcesSpec := os.Getenv("MIRAKURUN_SOURCES"); sourcesSpec != "" {
+		sources, err = models.ParseMirakurunSources(sourcesSpec)
+		if err != nil {
+			models.Log.Error("Invalid MIRAKURUN_SOURCES: %v", err)
+			log.Fatal(err)
+		}
+		// 取得元が分からないサービスのロゴは最初の取得元から取得する
+		mirakurunURL = sources[0].URL
+	} else if !mirakurunConfigured && xmltvFile != "" {
+		// XMLTV_FILE のみを指定した場合は Mirakurun に接続せず、XMLTV を唯一の番組情報の取得元とする
+		sources = nil
+	}
+
+	// STREAM_REPLAY を指定した場合は Mirakurun に接続せず、キャプチャしたイベントストリームを再生する
+	replayPattern := os.Getenv("STREAM_REPLAY")
+	if replayPattern != "" {
+		sources = nil
+	}
+	if demoMode {
+		sources = nil
+	}
+	models.SetMirakurunSources(sources)
+	for _, source := range sources {
+		models.Log.Debug("Using Mirakurun source %s: %s", source.Name, source.URL)
+	}
+
+	// SIGINT / SIGTERM を受け取ったら ctx を終了し、ストリームの購読やバックグラウンド処理を止める
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// MCP を有効にする場合、MCP_READ_ONLY=1 で予約やルールを作成するツールを無効にできる
+	mcpReadOnlyStr := os.Getenv("MCP_READ_ONLY")
+	mcpReadOnly := mcpReadOnlyStr == "1" || mcpReadOnlyStr == "true"
+
+	if demoMode {
+		if err := seedDemoData(dbConn); err != nil {
+			models.Log.Error("Failed to seed demo data: %v", err)
+			log.Fatal(err)
+		}
+	}
+
+	if mcpStdio {
+		runMCPStdio(ctx, dbConn, sources, mcpReadOnly)
+		return
+	}
+
+	// 起動時に取得元ごとにMirakurunから全番組情報を取得してDBを更新し、その後ストリームを購読する
+	// 初期ロードは既存の番組を残したまま差分で更新するので、ロード中もHTTPサーバーは応答できる
+	// 進捗は /status/initial-load で確認できる
 	skipInitialLoad := os.Getenv("SKIP_INITIAL_LOAD")
-	if skipInitialLoad != "1" && skipInitialLoad != "true" {
-		models.Log.Info("Starting initial program data load from Mirakurun API...")
-		if err := db.InitProgramsFromAPI(ctx, dbConn, mirakurunURL); err != nil {
-			models.Log.Error("Failed to load initial program data: %v", err)
-			// 初期ロードが失敗しても継続する
-		} else {
-			models.Log.Info("Initial program data loaded successfully")
+
+	// ストリームからの番組の書き込みは1つのゴルーチンでまとめて行う
+	programWriter := db.NewProgramWriter(dbConn)
+	programWriterDone := make(chan struct{})
+	go func() {
+		programWriter.Run(ctx)
+		close(programWriterDone)
+	}()
+
+	// STREAM_CAPTURE_DIR を指定した場合は受信したイベントを NDJSON ファイルに記録する
+	if captureDir := os.Getenv("STREAM_CAPTURE_DIR"); captureDir != "" {
+		maxBytes := int64(db.DefaultStreamCaptureMaxBytes)
+		if maxMBStr := os.Getenv("STREAM_CAPTURE_MAX_MB"); maxMBStr != "" {
+			maxMB, err := strconv.Atoi(maxMBStr)
+			if err != nil || maxMB <= 0 {
+				models.Log.Error("Invalid STREAM_CAPTURE_MAX_MB: %s, using default", maxMBStr)
+			} else {
+				maxBytes = int64(maxMB) * 1024 * 1024
+			}
 		}
-	} else {
-		models.Log.Info("Skipping initial program data load (SKIP_INITIAL_LOAD=%s)", skipInitialLoad)
+		maxFiles := db.DefaultStreamCaptureMaxFiles
+		if maxFilesStr := os.Getenv("STREAM_CAPTURE_MAX_FILES"); maxFilesStr != "" {
+			n, err := strconv.Atoi(maxFilesStr)
+			if err != nil || n <= 0 {
+				models.Log.Error("Invalid STREAM_CAPTURE_MAX_FILES: %s, using default", maxFilesStr)
+			} else {
+				maxFiles = n
+			}
+		}
+		capture, err := db.NewStreamCapture(captureDir, maxBytes, maxFiles)
+		if err != nil {
+			models.Log.Error("Failed to start stream capture: %v", err)
+			log.Fatal(err)
+		}
+		db.SetStreamCapture(capture)
+		defer capture.Close()
+		models.Log.Info("Capturing stream events to %s", captureDir)
 	}
 
-	// ストリーム購読URL
-	streamURL := mirakurunURL
-	if !os.IsPathSeparator(streamURL[len(streamURL)-1]) {
-		streamURL += "/"
+	if replayPattern != "" {
+		replayFiles, err := streamReplayFiles(replayPattern)
+		if err != nil || len(replayFiles) == 0 {
+			models.Log.Error("No stream capture files found for STREAM_REPLAY=%s: %v", replayPattern, err)
+			log.Fatal("no stream capture files to replay")
+		}
+		// 再生速度の倍率（0の場合は待たずに再生する）
+		speed := 1.0
+		if speedStr := os.Getenv("STREAM_REPLAY_SPEED"); speedStr != "" {
+			parsed, err := strconv.ParseFloat(speedStr, 64)
+			if err != nil || parsed < 0 {
+				models.Log.Error("Invalid STREAM_REPLAY_SPEED: %s, using default", speedStr)
+			} else {
+				speed = parsed
+			}
+		}
+		models.Log.Info("Replaying %d stream capture files at speed %v...", len(replayFiles), speed)
+		go func() {
+			if _, err := db.ReplayStreamCapture(ctx, dbConn, programWriter, replayFiles, speed); err != nil && ctx.Err() == nil {
+				models.Log.Error("Stream replay failed: %v", err)
+			}
+		}()
 	}
-	streamURL += "events/stream?resource=program"
-	models.Log.Debug("Using stream URL: %s", streamURL)
 
-	// ストリーム購読開始（無限リトライ）
-	models.Log.Info("Starting stream fetcher...")
-	go db.StartStreamFetcher(ctx, dbConn, streamURL)
+	// 取得元ごとに独立して動作するので、1つの取得元が停止していても他の取得元の情報は更新される
+	for _, source := range sources {
+		go func() {
+			if skipInitialLoad != "1" && skipInitialLoad != "true" {
+				models.Log.Info("Starting initial program data load from %s...", source.Name)
+				if err := db.InitProgramsFromAPI(ctx, dbConn, source); err != nil {
+					models.Log.Error("Failed to load initial program data from %s: %v", source.Name, err)
+					// 初期ロードが失敗しても継続する
+				} else {
+					models.Log.Info("Initial program data loaded successfully from %s", source.Name)
+				}
+			} else {
+				models.Log.Info("Skipping initial program data load (SKIP_INITIAL_LOAD=%s)", skipInitialLoad)
+			}
+
+			// ストリーム購読開始（無限リトライ）
+			models.Log.Info("Starting stream fetcher for %s...", source.Name)
+			db.StartStreamFetcher(ctx, programWriter, source)
+		}()
 
-	// サービス情報の取得開始
-	models.Log.Info("Starting service fetcher...")
-	go db.StartServiceFetcher(ctx, mirakurunURL)
+		// サービス情報の取得開始
+		models.Log.Info("Starting service fetcher for %s...", source.Name)
+		go db.StartServiceFetcher(ctx, dbConn, source)
 
-	// サービスイベントストリームの購読開始
-	models.Log.Info("Starting service event stream...")
-	go db.StartServiceEventStream(ctx, mirakurunURL)
+		// サービスイベントストリームの購読開始
+		models.Log.Info("Starting service event stream for %s...", source.Name)
+		go db.StartServiceEventStream(ctx, dbConn, source)
+	}
+
+	// XMLTV ファイルの更新を監視し、更新されたら取り込む
+	if xmltvFile != "" {
+		watchInterval := db.DefaultXMLTVWatchInterval
+		if intervalStr := os.Getenv("XMLTV_WATCH_INTERVAL"); intervalStr != "" {
+			seconds, err := strconv.Atoi(intervalStr)
+			if err != nil || seconds <= 0 {
+				models.Log.Error("Invalid XMLTV_WATCH_INTERVAL: %s, using default", intervalStr)
+			} else {
+				watchInterval = time.Duration(seconds) * time.Second
+			}
+		}
+		models.Log.Info("Starting XMLTV watcher...")
+		go db.StartXMLTVWatcher(ctx, dbConn, xmltvFile, xmltvOptions, watchInterval)
+	}
+
+	// 局ロゴの取得・再検証を開始
+	if len(sources) > 0 {
+		models.Log.Info("Starting logo fetcher...")
+		go db.StartLogoFetcher(ctx, dbConn, mirakurunURL)
+	}
 
 	// 定期クリーンアップ処理開始
 	cleanupEnabledStr := os.Getenv("ENABLE_CLEANUP")
@@ -89,9 +278,20 @@ func main() {
 		cleanupEnabled = false
 	}
 
+	// 放送済み番組をアーカイブに保持する日数（0の場合は放送終了後に削除）
+	archiveRetention := db.DefaultArchiveRetention
+	if archiveDaysStr := os.Getenv("PROGRAM_ARCHIVE_DAYS"); archiveDaysStr != "" {
+		archiveDays, err := strconv.Atoi(archiveDaysStr)
+		if err != nil || archiveDays < 0 {
+			models.Log.Error("Invalid PROGRAM_ARCHIVE_DAYS: %s, using default", archiveDaysStr)
+		} else {
+			archiveRetention = time.Duration(archiveDays) * 24 * time.Hour
+		}
+	}
+
 	if cleanupEnabled {
 		models.Log.Info("Starting cleanup routine...")
-		go db.StartCleanupRoutine(dbConn)
+		go db.StartCleanupRoutine(dbConn, archiveRetention)
 	} else {
 		models.Log.Info("Cleanup routine disabled (ENABLE_CLEANUP=%s)", cleanupEnabledStr)
 	}
@@ -103,11 +303,16 @@ func main() {
 	}
 	models.Log.Debug("Using recorder URL: %s", recorderURL)
 
+	// Webhook配信キュー（予約失敗・自動予約の書き込み時に配信を積む）
+	webhookDispatcher := services.NewWebhookDispatcher(dbConn)
+
 	// 予約ハンドラーの初期化
 	reservationHandler := handlers.NewReservationHandler(dbConn, recorderURL)
+	reservationHandler.Webhooks = webhookDispatcher
 
 	// 自動予約エンジンの初期化と開始
 	autoReservationEngine := services.NewAutoReservationEngine(dbConn, recorderURL)
+	autoReservationEngine.SetWebhookDispatcher(webhookDispatcher)
 	autoReservationEnabledStr := os.Getenv("ENABLE_AUTO_RESERVATION")
 	autoReservationEnabled := true // デフォルトは有効
 	if autoReservationEnabledStr == "0" || autoReservationEnabledStr == "false" {
@@ -121,6 +326,10 @@ func main() {
 		models.Log.Info("Auto reservation engine disabled (ENABLE_AUTO_RESERVATION=%s)", autoReservationEnabledStr)
 	}
 
+	// Webhook配信の開始
+	models.Log.Info("Starting webhook dispatcher...")
+	go webhookDispatcher.Start(ctx)
+
 	// ルーターの設定
 	router := mux.NewRouter()
 
@@ -129,10 +338,27 @@ func main() {
 		models.Log.Debug("Handling search request: %s", r.URL.String())
 		handlers.HandleSimpleSearch(w, r, dbConn)
 	})
+	router.HandleFunc("/now", func(w http.ResponseWriter, r *http.Request) {
+		models.Log.Debug("Handling now/next request: %s", r.URL.String())
+		handlers.HandleNowNext(w, r, dbConn)
+	})
+	router.HandleFunc("/people", func(w http.ResponseWriter, r *http.Request) {
+		models.Log.Debug("Handling people request: %s", r.URL.String())
+		handlers.HandleGetPeople(w, r, dbConn)
+	})
 	router.HandleFunc("/services", func(w http.ResponseWriter, r *http.Request) {
 		models.Log.Debug("Handling services request: %s", r.URL.String())
 		handlers.HandleGetServices(w, r, dbConn)
 	})
+	router.HandleFunc("/services/{id:[0-9]+}/logo", handlers.HandleGetServiceLogo(dbConn, mirakurunURL)).Methods("GET")
+	router.HandleFunc("/services/exclusion-rules", handlers.HandleCreateExclusionRule(dbConn)).Methods("POST")
+	router.HandleFunc("/services/exclusion-rules", handlers.HandleGetExclusionRules(dbConn)).Methods("GET")
+	router.HandleFunc("/services/exclusion-rules/{id}", handlers.HandleUpdateExclusionRule(dbConn)).Methods("PUT")
+	router.HandleFunc("/services/exclusion-rules/{id}", handlers.HandleDeleteExclusionRule(dbConn)).Methods("DELETE")
+	router.HandleFunc("/services/exclusion-rules/{id}/services", handlers.HandleGetExclusionRuleServices(dbConn)).Methods("GET")
+	router.HandleFunc("/services/overrides", handlers.HandleGetServiceOverrides(dbConn)).Methods("GET")
+	router.HandleFunc("/services/overrides/{networkId:[0-9]+}/{serviceId:[0-9]+}", handlers.HandlePutServiceOverride(dbConn)).Methods("PUT")
+	router.HandleFunc("/services/overrides/{networkId:[0-9]+}/{serviceId:[0-9]+}", handlers.HandleDeleteServiceOverride(dbConn)).Methods("DELETE")
 	router.HandleFunc("/services/all", func(w http.ResponseWriter, r *http.Request) {
 		models.Log.Debug("Handling all services request: %s", r.URL.String())
 		handlers.HandleGetAllServices(w, r, dbConn)
@@ -157,7 +383,13 @@ func main() {
 		models.Log.Debug("Handling IEPG request: %s", r.URL.String())
 		handlers.HandleIEPG(w, r, dbConn)
 	})
-	router.Handle("/rpc", handlers.NewRPCServer(dbConn))
+	rpcServer := handlers.NewRPCServer(dbConn, reservationHandler)
+	router.Handle("/rpc", rpcServer)
+	// MCP と WebSocket のイベント配信は、ブラウザからはループバックと MCP_ALLOWED_ORIGINS（カンマ区切り）の Origin のみ受け付ける
+	originPolicy := handlers.NewOriginPolicy(strings.Split(os.Getenv("MCP_ALLOWED_ORIGINS"), ","))
+	mcpServer := handlers.NewMCPServer(rpcServer, mcpReadOnly)
+	mcpServer.SetOriginPolicy(originPolicy)
+	router.Handle("/mcp", mcpServer)
 
 	// 予約関連のエンドポイント
 	router.HandleFunc("/reservations", reservationHandler.CreateReservation).Methods("POST")
@@ -167,11 +399,41 @@ func main() {
 	// 自動予約関連のエンドポイント
 	router.HandleFunc("/auto-reservations/rules", handlers.HandleCreateAutoReservationRule(dbConn)).Methods("POST")
 	router.HandleFunc("/auto-reservations/rules", handlers.HandleGetAutoReservationRules(dbConn)).Methods("GET")
+	router.HandleFunc("/auto-reservations/rules/preview", handlers.HandlePreviewAutoReservationRule(dbConn, autoReservationEngine)).Methods("POST")
 	router.HandleFunc("/auto-reservations/rules/{id}", handlers.HandleGetAutoReservationRule(dbConn)).Methods("GET")
 	router.HandleFunc("/auto-reservations/rules/{id}", handlers.HandleUpdateAutoReservationRule(dbConn)).Methods("PUT")
 	router.HandleFunc("/auto-reservations/rules/{id}", handlers.HandleDeleteAutoReservationRule(dbConn)).Methods("DELETE")
 	router.HandleFunc("/auto-reservations/logs", handlers.HandleGetAutoReservationLogs(dbConn)).Methods("GET")
 
+	// Webhook関連のエンドポイント
+	router.HandleFunc("/webhooks", handlers.HandleCreateWebhook(dbConn)).Methods("POST")
+	router.HandleFunc("/webhooks", handlers.HandleGetWebhooks(dbConn)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", handlers.HandleGetWebhook(dbConn)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", handlers.HandleUpdateWebhook(dbConn)).Methods("PUT")
+	router.HandleFunc("/webhooks/{id}", handlers.HandleDeleteWebhook(dbConn)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", handlers.HandleGetWebhookDeliveries(dbConn)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}/test", handlers.HandleTestWebhook(dbConn, webhookDispatcher)).Methods("POST")
+
+	// チャンネルグループ API
+	router.HandleFunc("/channel-groups", handlers.HandleCreateChannelGroup(dbConn)).Methods("POST")
+	router.HandleFunc("/channel-groups", handlers.HandleGetChannelGroups(dbConn)).Methods("GET")
+	router.HandleFunc("/channel-groups/{id}", handlers.HandleGetChannelGroup(dbConn)).Methods("GET")
+	router.HandleFunc("/channel-groups/{id}", handlers.HandleUpdateChannelGroup(dbConn)).Methods("PUT")
+	router.HandleFunc("/channel-groups/{id}", handlers.HandleDeleteChannelGroup(dbConn)).Methods("DELETE")
+	router.HandleFunc("/channel-groups/{id}/services", handlers.HandleAddChannelGroupService(dbConn)).Methods("POST")
+	router.HandleFunc("/channel-groups/{id}/services/{serviceId:[0-9]+}", handlers.HandleRemoveChannelGroupService(dbConn)).Methods("DELETE")
+
+	// 番組情報の初期ロードの進捗
+	router.HandleFunc("/status/initial-load", handlers.HandleGetInitialLoadStatus).Methods("GET")
+	// 番組ストリームの書き込みキューの状態
+	router.HandleFunc("/status/program-writer", handlers.HandleGetProgramWriterStatus(programWriter)).Methods("GET")
+	// Mirakurunのイベントストリームの接続状態
+	router.HandleFunc("/status/streams", handlers.HandleGetStreamStatus).Methods("GET")
+
+	// 変更通知イベントのエンドポイント
+	router.HandleFunc("/events", handlers.HandleEventStream).Methods("GET")
+	router.HandleFunc("/events/ws", handlers.HandleEventWebSocket(originPolicy)).Methods("GET")
+
 	// 静的ファイルの提供
 	fs := http.FileServer(http.Dir("./static"))
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
@@ -210,9 +472,105 @@ func main() {
 	if port == "" {
 		port = "40870" // default port when PORT is unset
 	}
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		<-ctx.Done()
+		models.Log.Info("Shutting down server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			models.Log.Error("Server shutdown error: %v", err)
+		}
+	}()
+
 	models.Log.Info("Listening on :%s", port)
-	if err := http.ListenAndServe(":"+port, router); err != nil {
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		models.Log.Error("Server error: %v", err)
 		log.Fatal(err)
 	}
+
+	// キューに残っている番組の書き込みを待ってからDBを閉じる
+	<-programWriterDone
+	models.Log.Info("Server stopped")
+}
+
+// runMCPStdio は標準入出力で MCP サーバーを実行する
+// 番組データは稼働中のサーバーと同じDBを参照し、局情報の表示用にサービス情報のみ取得する
+func runMCPStdio(ctx context.Context, dbConn *sql.DB, sources []models.MirakurunSource, readOnly bool) {
+	for _, source := range sources {
+		go db.StartServiceFetcher(ctx, dbConn, source)
+	}
+
+	recorderURL := os.Getenv("RECORDER_URL")
+	if recorderURL == "" {
+		recorderURL = "http://localhost:37569" // デフォルト値
+	}
+
+	// 予約失敗の通知は共有DBの配信キューに積み、稼働中のサーバーのワーカーが送信する
+	reservationHandler := handlers.NewReservationHandler(dbConn, recorderURL)
+	reservationHandler.Webhooks = services.NewWebhookDispatcher(dbConn)
+
+	rpcServer := handlers.NewRPCServer(dbConn, reservationHandler)
+	mcpServer := handlers.NewMCPServer(rpcServer, readOnly)
+	if err := mcpServer.ServeStdio(ctx, os.Stdin, os.Stdout); err != nil {
+		models.Log.Error("MCP server error: %v", err)
+		os.Exit(1)
+	}
+}
+
+// xmltvImportOptions は環境変数 XMLTV_CHANNEL_MAP から XMLTV の取り込み設定を作る
+func xmltvImportOptions() (db.XMLTVImportOptions, error) {
+	var opts db.XMLTVImportOptions
+	if spec := os.Getenv("XMLTV_CHANNEL_MAP"); spec != "" {
+		channelMap, err := models.ParseXMLTVChannelMap(spec)
+		if err != nil {
+			return opts, err
+		}
+		opts.ChannelMap = channelMap
+	}
+	return opts, nil
+}
+
+// runXMLTVImport は引数（省略時は XMLTV_FILE）の XMLTV ファイルを取り込む
+func runXMLTVImport(dbConn *sql.DB, args []string, opts db.XMLTVImportOptions) {
+	path := os.Getenv("XMLTV_FILE")
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		log.Fatal("usage: iepg-server import-xmltv <file> (or set XMLTV_FILE)")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := db.ImportXMLTVFile(ctx, dbConn, path, opts); err != nil {
+		models.Log.Error("Failed to import XMLTV: %v", err)
+		os.Exit(1)
+	}
+	progress := db.GetInitialLoadProgress(models.XMLTVSourceName)
+	models.Log.Info("Imported %d programs from %s (removed %d, archived %d)",
+		progress.Received, path, progress.Removed, progress.Archived)
+}
+
+// hasArg はコマンドライン引数に arg が含まれているかを返す
+func hasArg(arg string) bool {
+	for _, a := range os.Args[1:] {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// streamReplayFiles は STREAM_REPLAY に指定したディレクトリまたはパターンのキャプチャファイルを古い順に返す
+func streamReplayFiles(pattern string) ([]string, error) {
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		return db.StreamCaptureFiles(pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
 }
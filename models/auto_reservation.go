@@ -6,7 +6,7 @@ import "time"
 // AutoReservationRule は自動予約の基本ルールを保持する構造体
 type AutoReservationRule struct {
 	ID          string    `json:"id"`
-	Type        string    `json:"type"`        // "keyword" or "series"
+	Type        string    `json:"type"`        // "keyword", "series" or "person"
 	Name        string    `json:"name"`
 	Enabled     bool      `json:"enabled"`
 	Priority    int       `json:"priority"`
@@ -22,6 +22,10 @@ type KeywordRule struct {
 	Genres       []int    `json:"genres,omitempty"`      // ジャンルフィルタ
 	ServiceIDs   []int64  `json:"serviceIds,omitempty"`   // チャンネルフィルタ
 	ExcludeWords []string `json:"excludeWords,omitempty"` // 除外キーワード
+	GroupID      string   `json:"groupId,omitempty"`      // チャンネルグループフィルタ
+	TitleFilter                                             // 番組名の記号・話数フィルタ
+	AttributeFilter                                         // 映像・音声・字幕の属性フィルタ（5.1ch・二か国語など）
+	ScheduleFilter                                          // 放送時間帯・曜日・長さ・放送種別・有効期間のフィルタ
 }
 
 // SeriesRule はシリーズIDによる自動予約ルールを保持する構造体
@@ -30,6 +34,19 @@ type SeriesRule struct {
 	SeriesID    string `json:"seriesId"`    // MirakurunのシリーズID
 	ProgramName string `json:"programName"` // 番組名（参考表示用）
 	ServiceID   int64  `json:"serviceId,omitempty"`   // チャンネル（オプション）
+	TitleFilter                                        // 番組名の記号・話数フィルタ（再放送を除くなど）
+	ScheduleFilter                                     // 放送時間帯・曜日・長さ・放送種別・有効期間のフィルタ
+}
+
+// PersonRule は出演者・スタッフによる自動予約ルールを保持する構造体
+type PersonRule struct {
+	RuleID     string  `json:"ruleId"`
+	Person     string  `json:"person"`               // 人物名（空白や全角英数字の違いは無視して完全一致）
+	Role       string  `json:"role,omitempty"`       // 役割（"出演"、"監督" など、役割にこの語を含む場合のみ一致）
+	ServiceIDs []int64 `json:"serviceIds,omitempty"` // チャンネルフィルタ
+	GroupID    string  `json:"groupId,omitempty"`    // チャンネルグループフィルタ
+	TitleFilter                                    // 番組名の記号・話数フィルタ
+	ScheduleFilter                                 // 放送時間帯・曜日・長さ・放送種別・有効期間のフィルタ
 }
 
 // AutoReservationLog は自動予約の実行履歴を保持する構造体
@@ -48,4 +65,31 @@ type AutoReservationRuleWithDetails struct {
 	AutoReservationRule
 	KeywordRule *KeywordRule `json:"keywordRule,omitempty"`
 	SeriesRule  *SeriesRule  `json:"seriesRule,omitempty"`
-}
\ No newline at end of file
+	PersonRule  *PersonRule  `json:"personRule,omitempty"`
+}
+
+// 自動予約ルールのプレビューでの番組の扱い
+const (
+	PreviewStatusMatched         = "matched"          // 予約される
+	PreviewStatusExcluded        = "excluded"         // 除外キーワード・チャンネルなどの条件で除外される
+	PreviewStatusAlreadyReserved = "already_reserved" // 既に予約がある
+	PreviewStatusDuplicate       = "duplicate"        // 同じ回を既に予約済み・処理済み
+	PreviewStatusConflict        = "conflict"         // 他の予約と放送時間が重なる
+)
+
+// AutoReservationPreviewItem はプレビューで候補となった番組1件分
+type AutoReservationPreviewItem struct {
+	Program         Program  `json:"program"`
+	Matched         bool     `json:"matched"`                   // 自動予約エンジンが予約する番組か（重複・時間の重なりがあっても予約される）
+	Status          string   `json:"status"`                    // "matched", "excluded", "already_reserved", "duplicate", "conflict"
+	Reasons         []string `json:"reasons"`                   // 判定の理由
+	MatchedKeywords []string `json:"matchedKeywords,omitempty"` // 一致したキーワード（キーワードルールのみ）
+}
+
+// AutoReservationPreview は保存前のルールが一致する番組の一覧
+type AutoReservationPreview struct {
+	From     int64                        `json:"from"`    // 対象期間の開始（Unix ミリ秒）
+	To       int64                        `json:"to"`      // 対象期間の終了（Unix ミリ秒）
+	Matched  int                          `json:"matched"` // 自動予約エンジンが予約する番組の数
+	Programs []AutoReservationPreviewItem `json:"programs"`
+}
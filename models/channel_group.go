@@ -0,0 +1,41 @@
+// models/channel_group.go
+package models
+
+import "time"
+
+// FavoritesGroupID はお気に入りチャンネルのグループID（削除できない組み込みグループ）
+const FavoritesGroupID = "favorites"
+
+// ChannelGroup はユーザー定義のチャンネルグループを保持する構造体
+type ChannelGroup struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	Position  int                  `json:"position"` // グループ一覧での並び順（昇順）
+	Services  []ChannelGroupMember `json:"services"` // グループ内の並び順で格納
+	CreatedAt time.Time            `json:"createdAt"`
+	UpdatedAt time.Time            `json:"updatedAt"`
+}
+
+// ChannelGroupMember はチャンネルグループに属するサービス
+type ChannelGroupMember struct {
+	NetworkID int64 `json:"networkId"` // 0の場合はすべてのネットワークの同じサービスID
+	ServiceID int64 `json:"serviceId"`
+}
+
+// IndexOf はサービスのグループ内での位置を返す。属していない場合は -1
+func (g *ChannelGroup) IndexOf(networkID, serviceID int64) int {
+	for i, m := range g.Services {
+		if m.ServiceID != serviceID {
+			continue
+		}
+		if m.NetworkID == 0 || networkID == 0 || m.NetworkID == networkID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Contains はサービスがグループに属しているかを返す
+func (g *ChannelGroup) Contains(networkID, serviceID int64) bool {
+	return g.IndexOf(networkID, serviceID) >= 0
+}
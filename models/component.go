@@ -0,0 +1,165 @@
+// models/component.go
+package models
+
+// ProgramVideo は Mirakurun から取得する番組の映像コンポーネント
+type ProgramVideo struct {
+	Type          string `json:"type,omitempty"`       // mpeg2 / h.264 / h.265
+	Resolution    string `json:"resolution,omitempty"` // 480i / 720p / 1080i / 2160p など
+	StreamContent int    `json:"streamContent,omitempty"`
+	ComponentType int    `json:"componentType,omitempty"`
+}
+
+// ProgramAudio は Mirakurun から取得する番組の音声コンポーネント
+type ProgramAudio struct {
+	ComponentType int      `json:"componentType"` // ARIB の音声コンポーネント種別（0x02: 二重モノラル、0x03: ステレオ、0x09: 5.1ch など）
+	ComponentTag  int      `json:"componentTag,omitempty"`
+	IsMain        bool     `json:"isMain,omitempty"`
+	SamplingRate  int      `json:"samplingRate,omitempty"`
+	Langs         []string `json:"langs,omitempty"`
+}
+
+// 番組の映像・音声・字幕の属性（検索や自動予約ルールで指定する値）
+const (
+	AttributeSD         = "sd"         // 標準画質
+	AttributeHD         = "hd"         // ハイビジョン（720p・1080i・1080p）
+	Attribute4K         = "4k"         // 4K・8K
+	AttributeMono       = "mono"       // モノラル
+	AttributeStereo     = "stereo"     // ステレオ
+	AttributeSurround51 = "5.1"        // 5.1ch サラウンド
+	AttributeBilingual  = "bilingual"  // 二か国語（二重音声）
+	AttributeCommentary = "commentary" // 解説放送
+	AttributeCaption    = "caption"    // 字幕放送
+)
+
+// ProgramAttributes は指定できる属性の一覧
+var ProgramAttributes = []string{
+	AttributeSD, AttributeHD, Attribute4K,
+	AttributeMono, AttributeStereo, AttributeSurround51,
+	AttributeBilingual, AttributeCommentary, AttributeCaption,
+}
+
+// IsProgramAttribute は attr が指定できる属性かを返す
+func IsProgramAttribute(attr string) bool {
+	for _, a := range ProgramAttributes {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// videoAttribute は映像コンポーネントの画質を返す（分からない場合は空文字列）
+func videoAttribute(v *ProgramVideo) string {
+	if v == nil {
+		return ""
+	}
+	switch v.Resolution {
+	case "240p", "480i", "480p":
+		return AttributeSD
+	case "720p", "1080i", "1080p":
+		return AttributeHD
+	case "2160p", "4320p":
+		return Attribute4K
+	}
+	// 解像度が無い場合は ARIB の映像コンポーネント種別の上位4ビットで判定する
+	switch v.ComponentType >> 4 {
+	case 0x0, 0xA, 0xD:
+		if v.ComponentType != 0 {
+			return AttributeSD
+		}
+	case 0xB, 0xC, 0xE:
+		return AttributeHD
+	case 0x8, 0x9:
+		return Attribute4K
+	}
+	return ""
+}
+
+// ComputeAttributes は映像・音声コンポーネントと番組名の記号から属性を求める
+// 字幕・解説放送は Mirakurun がコンポーネントを返さないため番組名の記号（[字]・[解]）で判定する
+func (p *Program) ComputeAttributes() []string {
+	set := make(map[string]bool)
+	if attr := videoAttribute(p.Video); attr != "" {
+		set[attr] = true
+	}
+
+	langs := make(map[string]bool)
+	for _, a := range p.Audios {
+		switch a.ComponentType {
+		case 0x01:
+			set[AttributeMono] = true
+		case 0x02:
+			set[AttributeBilingual] = true
+		case 0x03:
+			set[AttributeStereo] = true
+		case 0x09:
+			set[AttributeSurround51] = true
+		}
+		for _, lang := range a.Langs {
+			langs[lang] = true
+		}
+	}
+	// 言語の異なる音声が複数ある場合も二か国語とする
+	if len(langs) >= 2 {
+		set[AttributeBilingual] = true
+	}
+
+	title := p.ParsedTitle()
+	for flag, attr := range map[string]string{
+		"字": AttributeCaption, "解": AttributeCommentary, "二": AttributeBilingual,
+		"5.1": AttributeSurround51, "HV": AttributeHD,
+	} {
+		if title.HasFlag(flag) {
+			set[attr] = true
+		}
+	}
+
+	attrs := make([]string, 0, len(set))
+	for _, a := range ProgramAttributes {
+		if set[a] {
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+}
+
+// ApplyAttributes は Attributes を映像・音声コンポーネントと番組名から設定する
+func (p *Program) ApplyAttributes() {
+	p.Attributes = p.ComputeAttributes()
+}
+
+// HasAttribute は番組が属性 attr を持つかを返す（未設定の場合は求めてから判定する）
+func (p *Program) HasAttribute(attr string) bool {
+	attrs := p.Attributes
+	if attrs == nil {
+		attrs = p.ComputeAttributes()
+	}
+	for _, a := range attrs {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// AttributeFilter は映像・音声・字幕の属性による絞り込み条件
+// 例: 5.1ch かつ二か国語の放送のみ（Attributes: ["5.1", "bilingual"]）
+type AttributeFilter struct {
+	Attributes        []string `json:"attributes,omitempty"`        // すべて持つ属性
+	ExcludeAttributes []string `json:"excludeAttributes,omitempty"` // 持たない属性
+}
+
+// Match は番組が条件を満たすかを返す
+func (f AttributeFilter) Match(p *Program) bool {
+	for _, attr := range f.Attributes {
+		if !p.HasAttribute(attr) {
+			return false
+		}
+	}
+	for _, attr := range f.ExcludeAttributes {
+		if p.HasAttribute(attr) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+// models/component_test.go
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeAttributes(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  Program
+		expected []string
+	}{
+		{
+			name: "HD stereo with captions",
+			program: Program{Name: "ニュース[字]", Video: &ProgramVideo{Resolution: "1080i"},
+				Audios: []ProgramAudio{{ComponentType: 0x03, Langs: []string{"jpn"}}}},
+			expected: []string{AttributeHD, AttributeStereo, AttributeCaption},
+		},
+		{
+			name: "4K 5.1ch bilingual movie",
+			program: Program{Name: "映画", Video: &ProgramVideo{Resolution: "2160p"},
+				Audios: []ProgramAudio{{ComponentType: 0x09, Langs: []string{"jpn"}}, {ComponentType: 0x03, Langs: []string{"eng"}}}},
+			expected: []string{Attribute4K, AttributeStereo, AttributeSurround51, AttributeBilingual},
+		},
+		{
+			name:     "Dual mono with commentary",
+			program:  Program{Name: "ドラマ🈖", Video: &ProgramVideo{ComponentType: 0x01}, Audios: []ProgramAudio{{ComponentType: 0x02}}},
+			expected: []string{AttributeSD, AttributeBilingual, AttributeCommentary},
+		},
+		{
+			name:     "No components",
+			program:  Program{Name: "アニメ[二]"},
+			expected: []string{AttributeBilingual},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.program.ComputeAttributes(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAttributeFilter(t *testing.T) {
+	movie := Program{Name: "映画[二]", Audios: []ProgramAudio{{ComponentType: 0x09}}}
+	stereo := Program{Name: "映画[二]", Audios: []ProgramAudio{{ComponentType: 0x03}}}
+
+	filter := AttributeFilter{Attributes: []string{AttributeSurround51, AttributeBilingual}}
+	if !filter.Match(&movie) || filter.Match(&stereo) {
+		t.Errorf("Expected only the 5.1ch program to match %+v", filter)
+	}
+	noCaption := AttributeFilter{ExcludeAttributes: []string{AttributeCaption}}
+	if !noCaption.Match(&movie) {
+		t.Errorf("Expected a program without captions to match %+v", noCaption)
+	}
+}
@@ -0,0 +1,180 @@
+// models/credit.go
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Credit は番組の出演者・スタッフの1人
+type Credit struct {
+	Role string `json:"role"` // 出演、声、司会、原作、脚本、監督、演出、音楽 など
+	Name string `json:"name"`
+}
+
+// PersonEntry は人物索引の1人分
+type PersonEntry struct {
+	Name     string   `json:"name"`
+	Roles    []string `json:"roles"`    // 番組での役割（出演、監督 など）
+	Programs int      `json:"programs"` // 出演・担当する番組の数
+}
+
+// creditSectionKeywords は拡張情報のうち人物を含む項目名に含まれる語
+var creditSectionKeywords = []string{
+	"出演", "声", "司会", "ナレ", "語り", "ゲスト", "キャスト", "原作", "脚本", "監督", "演出",
+	"音楽", "作詞", "作曲", "編曲", "主題歌", "歌", "プロデューサ", "制作", "スタッフ", "解説", "実況",
+}
+
+// castRoleKeywords は出演者として扱う役割に含まれる語（それ以外はスタッフ）
+var castRoleKeywords = []string{"出演", "声", "司会", "ナレ", "語り", "ゲスト", "キャスト", "解説", "実況"}
+
+var (
+	// creditLabelPattern は行頭の役割の見出し（【脚本】、脚本：、◇脚本 など）
+	creditLabelPattern = regexp.MustCompile(`^[◇◆■□▽▼★☆・\s]*(?:【([^】]{1,10})】|([^\s：:…【】（）()]{1,8})[：:])\s*`)
+	// creditSeparatorPattern は人物の区切り（読点、カンマ、スラッシュ）
+	// 全角空白は「佐藤　健」のように姓名の間にも使われるため区切りにしない
+	creditSeparatorPattern = regexp.MustCompile(`[、，,／/\n]+`)
+	// creditParenPattern は役名などの括弧書き
+	creditParenPattern = regexp.MustCompile(`[（(][^）)]*[）)]`)
+)
+
+// NormalizePersonName は人物名の表記ゆれ（全角英数字・空白）を揃える
+func NormalizePersonName(name string) string {
+	name = norm.NFKC.String(name)
+	return strings.Join(strings.Fields(name), "")
+}
+
+// isCreditSection は拡張情報の項目名が人物を含む項目かを返す
+func isCreditSection(key string) bool {
+	for _, k := range creditSectionKeywords {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCastRole は役割が出演者（出演・声・司会など）かを返す
+func IsCastRole(role string) bool {
+	for _, k := range castRoleKeywords {
+		if strings.Contains(role, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCredits は Mirakurun の拡張情報（extended）から出演者とスタッフを取り出す
+// 「出演者」「原作・脚本」「監督・演出」「音楽」などの項目を対象にし、
+// 値の中の【脚本】や「監督：」の見出しがあればその役割とする
+func ParseCredits(extended map[string]string) (cast, staff []Credit) {
+	// 項目の順序を固定する
+	keys := make([]string, 0, len(extended))
+	for key := range extended {
+		if isCreditSection(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	seen := make(map[Credit]bool)
+	for _, key := range keys {
+		sectionRole := strings.Trim(strings.TrimSpace(key), "◇◆■□▽▼★☆【】")
+		if sectionRole == "出演者" {
+			sectionRole = "出演"
+		}
+		for _, line := range strings.Split(extended[key], "\n") {
+			role := sectionRole
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			// 1行に複数の見出しがある場合（【原作】A【脚本】B）は見出しごとに分ける
+			for _, part := range splitCreditLabels(line) {
+				if m := creditLabelPattern.FindStringSubmatch(part); m != nil && (m[1] != "" || isCreditSection(m[2])) {
+					role = m[1] + m[2]
+					part = part[len(m[0]):]
+				}
+				for _, name := range splitCreditNames(part) {
+					c := Credit{Role: role, Name: name}
+					if seen[c] {
+						continue
+					}
+					seen[c] = true
+					if IsCastRole(role) {
+						cast = append(cast, c)
+					} else {
+						staff = append(staff, c)
+					}
+				}
+			}
+		}
+	}
+	return cast, staff
+}
+
+// splitCreditLabels は行を【見出し】の位置で分ける
+func splitCreditLabels(line string) []string {
+	var parts []string
+	for {
+		i := strings.Index(line[1:], "【")
+		if i < 0 {
+			break
+		}
+		parts = append(parts, line[:i+1])
+		line = line[i+1:]
+	}
+	return append(parts, line)
+}
+
+// splitCreditNames は区切られた人物名を取り出す
+// 「役名…俳優名」「役名：俳優名」の形式は俳優名を、括弧書きの役名は除いて返す
+func splitCreditNames(s string) []string {
+	var names []string
+	for _, item := range creditSeparatorPattern.Split(s, -1) {
+		item = creditParenPattern.ReplaceAllString(item, "")
+		for _, sep := range []string{"…", "‥", "：", ":", "＝", "="} {
+			if i := strings.LastIndex(item, sep); i >= 0 {
+				item = item[i+len(sep):]
+			}
+		}
+		item = strings.Trim(item, " \t◇◆■□▽▼★☆・【】「」『』")
+		for _, suffix := range []string{"ほか", "他", "ら"} {
+			if strings.HasSuffix(item, suffix) && utf8.RuneCountInString(item) > 3 {
+				item = strings.TrimSuffix(item, suffix)
+			}
+		}
+		name := NormalizePersonName(item)
+		if n := utf8.RuneCountInString(name); n < 2 || n > 20 || strings.ContainsAny(name, "。！？!?") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// ApplyCredits は拡張情報から Cast と Staff を設定する
+func (p *Program) ApplyCredits() {
+	p.Cast, p.Staff = ParseCredits(p.Extended)
+}
+
+// Credits は番組の出演者とスタッフをまとめて返す
+func (p *Program) Credits() []Credit {
+	return append(append([]Credit{}, p.Cast...), p.Staff...)
+}
+
+// HasPerson は番組に name の人物が出演・担当しているかを返す
+// role を指定した場合は役割にその語を含む場合のみ一致する（"監督" は "監督・演出" にも一致）
+func (p *Program) HasPerson(name, role string) bool {
+	name = NormalizePersonName(name)
+	for _, c := range p.Credits() {
+		if c.Name == name && (role == "" || strings.Contains(c.Role, role)) {
+			return true
+		}
+	}
+	return false
+}
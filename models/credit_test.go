@@ -0,0 +1,91 @@
+// models/credit_test.go
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCredits(t *testing.T) {
+	extended := map[string]string{
+		"番組内容":  "刑事たちが事件の真相に迫る。",
+		"出演者":   "田中一郎…佐藤　健，山本花子…長澤まさみ\n鈴木次郎（ゲスト）\nナレーション：Ｊｏｈｎ　Ｓｍｉｔｈ ほか",
+		"原作・脚本": "【原作】東野圭吾【脚本】橋部敦子",
+		"監督・演出": "山田太郎／中村一",
+		"音楽":    "久石譲",
+		"声の出演":  "高橋みなみ(ナレーター)",
+		"おしらせ◇": "この番組は字幕放送です。",
+	}
+
+	cast, staff := ParseCredits(extended)
+
+	expectedCast := []Credit{
+		{Role: "出演", Name: "佐藤健"},
+		{Role: "出演", Name: "長澤まさみ"},
+		{Role: "出演", Name: "鈴木次郎"},
+		{Role: "ナレーション", Name: "JohnSmith"},
+		{Role: "声の出演", Name: "高橋みなみ"},
+	}
+	expectedStaff := []Credit{
+		{Role: "原作", Name: "東野圭吾"},
+		{Role: "脚本", Name: "橋部敦子"},
+		{Role: "監督・演出", Name: "山田太郎"},
+		{Role: "監督・演出", Name: "中村一"},
+		{Role: "音楽", Name: "久石譲"},
+	}
+	if !sameCredits(cast, expectedCast) {
+		t.Errorf("Unexpected cast:\n got %v\nwant %v", cast, expectedCast)
+	}
+	if !sameCredits(staff, expectedStaff) {
+		t.Errorf("Unexpected staff:\n got %v\nwant %v", staff, expectedStaff)
+	}
+}
+
+func TestParseCreditsBlankLines(t *testing.T) {
+	// 空行や空の値を含む項目でも落ちない
+	cast, staff := ParseCredits(map[string]string{
+		"出演者": "山田太郎\n\n佐藤花子\n",
+		"音楽":  "",
+	})
+
+	expectedCast := []Credit{
+		{Role: "出演", Name: "山田太郎"},
+		{Role: "出演", Name: "佐藤花子"},
+	}
+	if !sameCredits(cast, expectedCast) {
+		t.Errorf("Unexpected cast:\n got %v\nwant %v", cast, expectedCast)
+	}
+	if len(staff) != 0 {
+		t.Errorf("Expected no staff, got %v", staff)
+	}
+}
+
+// sameCredits は順序を問わずに比較する
+func sameCredits(a, b []Credit) bool {
+	set := func(credits []Credit) map[Credit]bool {
+		m := make(map[Credit]bool)
+		for _, c := range credits {
+			m[c] = true
+		}
+		return m
+	}
+	return len(a) == len(b) && reflect.DeepEqual(set(a), set(b))
+}
+
+func TestProgramHasPerson(t *testing.T) {
+	p := Program{Extended: map[string]string{
+		"出演者":   "佐藤　健",
+		"監督・演出": "山田太郎",
+	}}
+	p.ApplyCredits()
+
+	if !p.HasPerson("佐藤 健", "") || !p.HasPerson("佐藤健", "出演") {
+		t.Error("Expected the actor to match")
+	}
+	if !p.HasPerson("山田太郎", "監督") || p.HasPerson("山田太郎", "出演") {
+		t.Error("Expected the director to match only as director")
+	}
+	if p.HasPerson("佐藤", "") {
+		t.Error("Expected a partial name not to match")
+	}
+}
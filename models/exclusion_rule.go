@@ -0,0 +1,31 @@
+// models/exclusion_rule.go
+package models
+
+// 除外ルールの種類
+const (
+	ExclusionRuleNameRegex  = "nameRegex"  // サービス名が正規表現に一致するサービスを除外
+	ExclusionRulePayChannel = "payChannel" // 有料番組（isFree=false）のみを放送するサービスを除外
+	ExclusionRuleNoPrograms = "noPrograms" // 一定期間番組が無いサービスを除外
+)
+
+// DefaultNoProgramsDays は noPrograms ルールで Days を省略した場合の日数
+const DefaultNoProgramsDays = 7
+
+// ExclusionRule は条件に一致するサービスをまとめて除外するルール
+type ExclusionRule struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`                  // nameRegex, payChannel, noPrograms
+	Pattern     string `json:"pattern,omitempty"`     // nameRegex の正規表現
+	ChannelType string `json:"channelType,omitempty"` // "GR", "BS", "CS" など。指定時はその放送種別のサービスのみ対象
+	Days        int    `json:"days,omitempty"`        // noPrograms で番組の有無を調べる日数（現在から）
+	Enabled     bool   `json:"enabled"`
+	ExpiresAt   int64  `json:"expiresAt,omitempty"` // ルールの有効期限（Unixミリ秒、0は無期限）
+	CreatedAt   int64  `json:"createdAt"`
+	UpdatedAt   int64  `json:"updatedAt"`
+}
+
+// IsActive はルールが指定時刻（Unixミリ秒）に有効かを返す
+func (r *ExclusionRule) IsActive(now int64) bool {
+	return r.Enabled && (r.ExpiresAt == 0 || r.ExpiresAt > now)
+}
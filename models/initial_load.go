@@ -0,0 +1,24 @@
+// models/initial_load.go
+package models
+
+// 初期ロードの状態
+const (
+	InitialLoadIdle      = "idle"
+	InitialLoadRunning   = "running"
+	InitialLoadCompleted = "completed"
+	InitialLoadFailed    = "failed"
+	InitialLoadCanceled  = "canceled"
+)
+
+// InitialLoadProgress はMirakurunからの番組情報の初期ロードの進捗を表す構造体
+type InitialLoadProgress struct {
+	Source     string `json:"source"`               // 取得元のMirakurunの名前
+	State      string `json:"state"`                // "idle", "running", "completed", "failed", "canceled"
+	Received   int    `json:"received"`             // 受信して保存した番組数
+	Removed    int    `json:"removed"`              // Mirakurunから消えたため削除した番組数
+	Archived   int    `json:"archived"`             // 放送済みのためアーカイブに移した番組数
+	StartedAt  int64  `json:"startedAt,omitempty"`  // 開始時刻（UNIXタイムスタンプ、ミリ秒）
+	UpdatedAt  int64  `json:"updatedAt,omitempty"`  // 最終更新時刻（UNIXタイムスタンプ、ミリ秒）
+	FinishedAt int64  `json:"finishedAt,omitempty"` // 終了時刻（UNIXタイムスタンプ、ミリ秒）
+	Error      string `json:"error,omitempty"`
+}
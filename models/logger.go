@@ -3,6 +3,7 @@ package models
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 )
@@ -38,6 +39,11 @@ func (l *Logger) SetLevel(level LogLevel) {
 	l.level = level
 }
 
+// SetOutput はログの出力先を設定
+func (l *Logger) SetOutput(w io.Writer) {
+	l.logger.SetOutput(w)
+}
+
 // Error はエラーレベルのログを出力
 func (l *Logger) Error(format string, v ...interface{}) {
 	l.logger.Printf("[ERROR] "+format, v...)
@@ -62,6 +68,12 @@ var Log *Logger
 
 // InitLogger はグローバルロガーを初期化
 func InitLogger(levelStr string) {
+	InitLoggerWithOutput(levelStr, os.Stdout)
+}
+
+// InitLoggerWithOutput は出力先を指定してグローバルロガーを初期化
+// 標準出力をプロトコルに使う場合（MCP の stdio トランスポート）は初期化時のログから出力先を変える必要がある
+func InitLoggerWithOutput(levelStr string, w io.Writer) {
 	level := LogLevelInfo // デフォルトはInfo
 
 	switch levelStr {
@@ -74,6 +86,7 @@ func InitLogger(levelStr string) {
 	}
 
 	Log = NewLogger(level)
+	Log.SetOutput(w)
 	Log.Debug("Logger initialized with level: %s", levelStr)
 }
 
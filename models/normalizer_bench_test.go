@@ -0,0 +1,28 @@
+// models/normalizer_bench_test.go
+package models_test
+
+import (
+	"testing"
+
+	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/testing/epggen"
+)
+
+// epggen は models に依存するので、外部テストパッケージからベンチマークする
+
+func BenchmarkNormalizeForSearch(b *testing.B) {
+	data := epggen.Generate(epggen.Config{Services: 4, Days: 1})
+
+	b.Run("Name", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			models.NormalizeForSearch(data.Programs[i%len(data.Programs)].Name)
+		}
+	})
+	b.Run("Description", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			models.NormalizeForSearch(data.Programs[i%len(data.Programs)].Description)
+		}
+	})
+}
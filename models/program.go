@@ -16,10 +16,13 @@ type Series struct {
 type Program struct {
 	ID                int64  `json:"id"`
 	ServiceID         int64  `json:"serviceId"`
+	NetworkID         int64  `json:"networkId,omitempty"`
 	StartAt           int64  `json:"startAt"`
 	Duration          int64  `json:"duration"`
 	Name              string `json:"name"`
 	Description       string `json:"description"`
+	IsFree            *bool  `json:"isFree,omitempty"` // 無料放送かどうか（不明な場合は nil）
+	Source            string `json:"source,omitempty"` // 取得元のMirakurunの名前
 	NameForSearch     string `json:"-"` // 検索用に正規化された番組名（JSONには含めない）
 	DescForSearch     string `json:"-"` // 検索用に正規化された説明（JSONには含めない）
 	
@@ -29,7 +32,32 @@ type Program struct {
 	ChannelType       string `json:"channelType,omitempty"`
 	ChannelNumber     string `json:"channelNumber,omitempty"`
 	RemoteControlKey  int    `json:"remoteControlKey,omitempty"`
+	LogoURL           string `json:"logoUrl,omitempty"`
 	
 	// Series information from Mirakurun
 	Series            *Series `json:"series,omitempty"`
-}
\ No newline at end of file
+
+	// 番組名から解析した情報（ParseTitle）
+	Title         string   `json:"title,omitempty"`         // 記号・話数・サブタイトルを除いた番組名
+	Flags         []string `json:"flags,omitempty"`         // 放送の記号（"新"、"再"、"字" など）
+	EpisodeNumber int      `json:"episodeNumber,omitempty"` // 番組名の話数
+	Subtitle      string   `json:"subtitle,omitempty"`      // サブタイトル
+
+	// Mirakurun の拡張情報（"出演者"、"原作・脚本" などの項目）と、そこから取り出した人物
+	Extended map[string]string `json:"extended,omitempty"`
+	Cast     []Credit          `json:"cast,omitempty"`  // 出演者（出演・声・司会など）
+	Staff    []Credit          `json:"staff,omitempty"` // スタッフ（原作・脚本・監督・音楽など）
+
+	// Mirakurun の映像・音声コンポーネントと、そこから求めた属性（"hd"、"5.1"、"caption" など）
+	Video      *ProgramVideo  `json:"video,omitempty"`
+	Audios     []ProgramAudio `json:"audios,omitempty"`
+	Attributes []string       `json:"attributes,omitempty"`
+}
+
+// NowNext はチャンネルごとの放送中番組と次番組の組を保持する構造体
+type NowNext struct {
+	Service        *Service `json:"service"`
+	Current        *Program `json:"current,omitempty"`
+	Next           *Program `json:"next,omitempty"`
+	ElapsedPercent float64  `json:"elapsedPercent"` // 放送中番組の経過率（0〜100）
+}
@@ -21,6 +21,7 @@ type Reservation struct {
 	ID                string            `json:"id"`
 	ProgramID         int64             `json:"programId"`
 	ServiceID         int64             `json:"serviceId"`
+	NetworkID         int64             `json:"networkId,omitempty"`
 	Name              string            `json:"name"`
 	StartAt           int64             `json:"startAt"`
 	Duration          int64             `json:"duration"`
@@ -30,6 +31,8 @@ type Reservation struct {
 	CreatedAt         int64             `json:"createdAt"`
 	UpdatedAt         int64             `json:"updatedAt"`
 	Error             string            `json:"error,omitempty"`
+	Source            string            `json:"source,omitempty"` // Mirakurun source the program was received from
+	Program           *Program          `json:"program,omitempty"` // Program metadata, resolved from the archive after broadcast
 }
 
 // CreateReservationRequest represents a request to create a reservation
@@ -0,0 +1,170 @@
+// models/schedule_filter.go
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleFilter は放送時間帯・曜日・番組の長さ・放送種別・有効期間による自動予約ルールの条件
+// 例: 深夜アニメのみ（StartTime: "24:00", EndTime: "29:00", MinDuration: 20）で昼の再放送や5分の番宣を除く
+type ScheduleFilter struct {
+	StartTime   string         `json:"startTime,omitempty"`   // 開始時刻の範囲の始まり（"23:30"、24時以降は "25:30" のようにも書ける）
+	EndTime     string         `json:"endTime,omitempty"`     // 開始時刻の範囲の終わり（この時刻ちょうどに始まる番組は含まない）
+	Weekdays    []time.Weekday `json:"weekdays,omitempty"`    // 曜日（0: 日曜〜6: 土曜）。24時以降の時刻として一致した番組は前日の曜日とする
+	MinDuration int            `json:"minDuration,omitempty"` // 番組の長さの下限（分）
+	MaxDuration int            `json:"maxDuration,omitempty"` // 番組の長さの上限（分）
+	ChannelType string         `json:"channelType,omitempty"` // 放送種別（"GR", "BS", "CS"）
+	ValidFrom   int64          `json:"validFrom,omitempty"`   // 有効期間の始まり（Unixミリ秒、この時刻以降に始まる番組のみ）
+	ValidUntil  int64          `json:"validUntil,omitempty"`  // 有効期間の終わり（Unixミリ秒、この時刻より前に始まる番組のみ）
+}
+
+// ScheduleFilter の条件の名前（Mismatches が返す値）
+const (
+	ScheduleConditionTime        = "time"
+	ScheduleConditionWeekday     = "weekday"
+	ScheduleConditionDuration    = "duration"
+	ScheduleConditionChannelType = "channelType"
+	ScheduleConditionValidity    = "validity"
+)
+
+const minutesPerDay = 24 * 60
+
+// ParseBroadcastTime は "HH:MM" 形式の時刻を0時からの分数に変換する
+// 深夜番組の表記に合わせて "25:30"（翌日1時30分）のような48時までの時刻を受け付ける
+func ParseBroadcastTime(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", s)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid time %q: expected two-digit minutes", s)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 2*minutesPerDay {
+		return 0, fmt.Errorf("invalid time %q: must be between 00:00 and 48:00", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// window は開始時刻の範囲を0時からの分数で返す（指定が無い場合は ok=false）
+// 終わりが始まりより前の場合（"23:00" から "02:00"）は翌日の時刻とする
+func (f ScheduleFilter) window() (start, end int, ok bool) {
+	if f.StartTime == "" || f.EndTime == "" {
+		return 0, 0, false
+	}
+	start, err := ParseBroadcastTime(f.StartTime)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err = ParseBroadcastTime(f.EndTime)
+	if err != nil {
+		return 0, 0, false
+	}
+	if end < start {
+		end += minutesPerDay
+	}
+	return start, end, true
+}
+
+// Validate は条件の指定に誤りが無いかを調べる
+func (f ScheduleFilter) Validate() error {
+	if (f.StartTime == "") != (f.EndTime == "") {
+		return fmt.Errorf("startTime and endTime must be specified together")
+	}
+	if f.StartTime != "" {
+		for _, t := range []string{f.StartTime, f.EndTime} {
+			if _, err := ParseBroadcastTime(t); err != nil {
+				return err
+			}
+		}
+		start, end, _ := f.window()
+		if end == start || end-start > minutesPerDay {
+			return fmt.Errorf("time window %s-%s must be longer than 0 and at most 24 hours", f.StartTime, f.EndTime)
+		}
+	}
+	for _, d := range f.Weekdays {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid weekday %d: must be between 0 (Sunday) and 6 (Saturday)", d)
+		}
+	}
+	if f.MinDuration < 0 || f.MaxDuration < 0 {
+		return fmt.Errorf("duration must not be negative")
+	}
+	if f.MaxDuration > 0 && f.MaxDuration < f.MinDuration {
+		return fmt.Errorf("maxDuration must not be less than minDuration")
+	}
+	if f.ChannelType != "" && ChannelTypeNumber(f.ChannelType) == 0 {
+		return fmt.Errorf("invalid channelType %q: must be GR, BS or CS", f.ChannelType)
+	}
+	if f.ValidFrom > 0 && f.ValidUntil > 0 && f.ValidUntil <= f.ValidFrom {
+		return fmt.Errorf("validUntil must be after validFrom")
+	}
+	return nil
+}
+
+// programChannelType は番組の放送種別を返す（番組に無い場合はサービス情報から求める）
+func programChannelType(p *Program) string {
+	if p.ChannelType != "" {
+		return p.ChannelType
+	}
+	if service, ok := ServiceMapInstance.Lookup(p.NetworkID, p.ServiceID); ok {
+		return service.ChannelType
+	}
+	return ""
+}
+
+// Mismatches は番組が満たさない条件の名前（ScheduleCondition*）を返す
+func (f ScheduleFilter) Mismatches(p *Program) []string {
+	var mismatches []string
+
+	start := time.UnixMilli(p.StartAt)
+	weekday := start.Weekday()
+	if from, to, ok := f.window(); ok {
+		m := start.Hour()*60 + start.Minute()
+		switch {
+		case m >= from && m < to:
+		case m+minutesPerDay >= from && m+minutesPerDay < to:
+			// 25:30 のように前日の24時以降の時刻として一致した番組は前日の曜日とする
+			weekday = (weekday + 6) % 7
+		default:
+			mismatches = append(mismatches, ScheduleConditionTime)
+		}
+	}
+	if len(f.Weekdays) > 0 {
+		found := false
+		for _, d := range f.Weekdays {
+			if d == weekday {
+				found = true
+				break
+			}
+		}
+		if !found {
+			mismatches = append(mismatches, ScheduleConditionWeekday)
+		}
+	}
+
+	const minute = int64(time.Minute / time.Millisecond)
+	if (f.MinDuration > 0 && p.Duration < int64(f.MinDuration)*minute) ||
+		(f.MaxDuration > 0 && p.Duration > int64(f.MaxDuration)*minute) {
+		mismatches = append(mismatches, ScheduleConditionDuration)
+	}
+	if f.ChannelType != "" && programChannelType(p) != f.ChannelType {
+		mismatches = append(mismatches, ScheduleConditionChannelType)
+	}
+	if (f.ValidFrom > 0 && p.StartAt < f.ValidFrom) || (f.ValidUntil > 0 && p.StartAt >= f.ValidUntil) {
+		mismatches = append(mismatches, ScheduleConditionValidity)
+	}
+	return mismatches
+}
+
+// Match は番組が条件を満たすかを返す
+func (f ScheduleFilter) Match(p *Program) bool {
+	return len(f.Mismatches(p)) == 0
+}
@@ -0,0 +1,111 @@
+// models/schedule_filter_test.go
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBroadcastTime(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"00:00", 0, false},
+		{"23:30", 23*60 + 30, false},
+		{"25:30", 25*60 + 30, false},
+		{"48:00", 48 * 60, false},
+		{"48:01", 0, true},
+		{"12:60", 0, true},
+		{"1230", 0, true},
+		{"12:5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBroadcastTime(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBroadcastTime(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestScheduleFilterMismatches(t *testing.T) {
+	// 2025-06-02 は月曜日
+	at := func(day, hour, minute int) int64 {
+		return time.Date(2025, 6, day, hour, minute, 0, 0, time.Local).UnixMilli()
+	}
+	const minute = int64(time.Minute / time.Millisecond)
+	lateNight := ScheduleFilter{StartTime: "24:00", EndTime: "29:00", Weekdays: []time.Weekday{time.Monday}, MinDuration: 20}
+
+	tests := []struct {
+		name    string
+		filter  ScheduleFilter
+		program Program
+		want    []string
+	}{
+		{"monday 25:30 is tuesday 01:30", lateNight, Program{StartAt: at(3, 1, 30), Duration: 30 * minute}, nil},
+		{"daytime rerun", lateNight, Program{StartAt: at(2, 14, 0), Duration: 30 * minute},
+			[]string{ScheduleConditionTime}},
+		{"tuesday 25:30 is another weekday", lateNight, Program{StartAt: at(4, 1, 30), Duration: 30 * minute},
+			[]string{ScheduleConditionWeekday}},
+		{"promo spot", lateNight, Program{StartAt: at(3, 2, 0), Duration: 5 * minute},
+			[]string{ScheduleConditionDuration}},
+		{"window across midnight", ScheduleFilter{StartTime: "23:00", EndTime: "02:00"},
+			Program{StartAt: at(3, 0, 30), Duration: 30 * minute}, nil},
+		{"end of window is excluded", ScheduleFilter{StartTime: "23:00", EndTime: "02:00"},
+			Program{StartAt: at(3, 2, 0), Duration: 30 * minute}, []string{ScheduleConditionTime}},
+		{"too long", ScheduleFilter{MaxDuration: 60}, Program{StartAt: at(2, 20, 0), Duration: 120 * minute},
+			[]string{ScheduleConditionDuration}},
+		{"channel type", ScheduleFilter{ChannelType: "BS"}, Program{StartAt: at(2, 20, 0), ChannelType: "GR"},
+			[]string{ScheduleConditionChannelType}},
+		{"before validity", ScheduleFilter{ValidFrom: at(5, 0, 0), ValidUntil: at(10, 0, 0)}, Program{StartAt: at(2, 20, 0)},
+			[]string{ScheduleConditionValidity}},
+		{"after validity", ScheduleFilter{ValidFrom: at(1, 0, 0), ValidUntil: at(2, 0, 0)}, Program{StartAt: at(2, 20, 0)},
+			[]string{ScheduleConditionValidity}},
+		{"no conditions", ScheduleFilter{}, Program{StartAt: at(2, 20, 0)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.filter.Mismatches(&tt.program)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mismatches() = %v, want %v", got, tt.want)
+			}
+			if tt.filter.Match(&tt.program) != (len(tt.want) == 0) {
+				t.Errorf("Match() disagrees with Mismatches()")
+			}
+		})
+	}
+}
+
+func TestScheduleFilterValidate(t *testing.T) {
+	valid := []ScheduleFilter{
+		{},
+		{StartTime: "24:00", EndTime: "29:00"},
+		{StartTime: "23:00", EndTime: "02:00"},
+		{StartTime: "05:00", EndTime: "29:00"},
+		{Weekdays: []time.Weekday{time.Sunday, time.Saturday}, MinDuration: 20, MaxDuration: 60, ChannelType: "GR"},
+	}
+	for _, f := range valid {
+		if err := f.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v, want nil", f, err)
+		}
+	}
+
+	invalid := []ScheduleFilter{
+		{StartTime: "24:00"},
+		{StartTime: "25:00", EndTime: "25:00"},
+		{StartTime: "00:00", EndTime: "30:00"},
+		{StartTime: "9:00pm", EndTime: "23:00"},
+		{Weekdays: []time.Weekday{7}},
+		{MinDuration: 60, MaxDuration: 30},
+		{MinDuration: -1},
+		{ChannelType: "SKY"},
+		{ValidFrom: 2000, ValidUntil: 1000},
+	}
+	for _, f := range invalid {
+		if err := f.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want error", f)
+		}
+	}
+}
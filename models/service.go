@@ -2,6 +2,7 @@
 package models
 
 import (
+	"fmt"
 	"sync"
 )
 
@@ -14,6 +15,7 @@ type Service struct {
 	Type               int    `json:"type"`
 	LogoID             int    `json:"logoId,omitempty"`
 	HasLogoData        bool   `json:"hasLogoData,omitempty"`
+	LogoURL            string `json:"logoUrl,omitempty"` // このサーバーが配信するロゴのURL
 	RemoteControlKeyID int    `json:"remoteControlKeyId,omitempty"`
 
 	// Channel情報
@@ -24,6 +26,81 @@ type Service struct {
 	
 	// 除外フラグ（UI表示用）
 	IsExcluded     bool   `json:"isExcluded,omitempty"`
+	ExcludedByRule bool   `json:"excludedByRule,omitempty"` // 除外ルールにより除外されている
+
+	// 取得元のMirakurunの名前（複数の取得元で重複する場合は最後に受信した取得元）
+	Source string `json:"source,omitempty"`
+
+	// Mirakurun で最初・最後に確認した日時（Unixミリ秒）
+	FirstSeenAt int64 `json:"firstSeenAt,omitempty"`
+	LastSeenAt  int64 `json:"lastSeenAt,omitempty"`
+
+	// サービスごとの上書き設定（ServiceOverride）を適用した値
+	OriginalName string `json:"originalName,omitempty"` // 表示名を上書きした場合の Mirakurun 上の名前
+	StationCode  string `json:"stationCode,omitempty"`  // IEPG の station に出力する局コード
+	SortKey      int    `json:"sortKey,omitempty"`      // チャンネル一覧での並び順（0は未指定）
+}
+
+// IEPGStationID は IEPG の station に出力する局コードを返す
+// 上書き設定が無い場合はリモコンキーID、それも無い場合はサービスIDを4桁で返す
+func (s *Service) IEPGStationID() string {
+	if s.StationCode != "" {
+		return s.StationCode
+	}
+	if s.RemoteControlKeyID > 0 {
+		return fmt.Sprintf("%04d", s.RemoteControlKeyID)
+	}
+	return fmt.Sprintf("%04d", s.ServiceID)
+}
+
+// ServiceOverride はサービスごとの表示名・IEPG局コード・チャンネル番号・並び順の上書き設定
+// 空の項目（SortKey は0）は Mirakurun から取得した値をそのまま使う
+type ServiceOverride struct {
+	NetworkID     int64  `json:"networkId"`
+	ServiceID     int64  `json:"serviceId"`
+	DisplayName   string `json:"displayName,omitempty"`
+	StationCode   string `json:"stationCode,omitempty"`
+	ChannelNumber string `json:"channelNumber,omitempty"`
+	SortKey       int    `json:"sortKey,omitempty"`
+	UpdatedAt     int64  `json:"updatedAt"`
+}
+
+// Key はサービスの識別キーを返す
+func (o *ServiceOverride) Key() ServiceKey {
+	return ServiceKey{NetworkID: o.NetworkID, ServiceID: o.ServiceID}
+}
+
+// Apply は上書き設定を適用したサービスのコピーを返す
+func (o *ServiceOverride) Apply(service *Service) *Service {
+	applied := *service
+	if o.DisplayName != "" && o.DisplayName != service.Name {
+		applied.OriginalName = service.Name
+		applied.Name = o.DisplayName
+	}
+	if o.ChannelNumber != "" {
+		applied.ChannelNumber = o.ChannelNumber
+	}
+	applied.StationCode = o.StationCode
+	applied.SortKey = o.SortKey
+	return &applied
+}
+
+// SetLogoURL はロゴデータがある場合に、このサーバーでロゴを配信するURLを設定する
+func (s *Service) SetLogoURL() {
+	s.LogoURL = ""
+	if s.HasLogoData && s.ID != 0 {
+		s.LogoURL = fmt.Sprintf("/services/%d/logo", s.ID)
+	}
+}
+
+// ServiceLogo はキャッシュした局ロゴ画像
+type ServiceLogo struct {
+	ID          int64  // Mirakurun のサービスID
+	ETag        string // Mirakurun が返した ETag（再検証用）
+	Hash        string // 画像データのハッシュ（クライアントへの ETag に使用）
+	ContentType string
+	Data        []byte
+	FetchedAt   int64 // 最後に取得・再検証した日時（Unixミリ秒）
 }
 
 // ChannelInfo はMirakurunから取得するChannel情報の構造体
@@ -34,46 +111,184 @@ type ChannelInfo struct {
 	TSMFRel int    `json:"tsmfRelTs,omitempty"`
 }
 
-// ServiceMap はサービスIDをキーとしてServiceの参照を保持するマップ
+// ServiceKey はネットワークIDとサービスIDの組でサービスを識別する
+// 異なるネットワーク（地上波の各局、BS、CS）で同じサービスIDが使われることがあるため、
+// サービスIDだけではサービスを一意に特定できない
+type ServiceKey struct {
+	NetworkID int64
+	ServiceID int64
+}
+
+// MirakurunServiceID は Mirakurun のサービスID（networkId * 100000 + serviceId）を返す
+func (k ServiceKey) MirakurunServiceID() int64 {
+	return k.NetworkID*100000 + k.ServiceID
+}
+
+// ServiceKeyFromID は自動予約ルールなどで指定されたサービスIDをサービスキーに変換する
+// Mirakurun のサービスID（networkId * 100000 + serviceId）の場合はネットワークIDも取り出し、
+// 100000 未満の場合はサービスIDのみ（NetworkID は0）とする
+func ServiceKeyFromID(id int64) ServiceKey {
+	if id < 100000 {
+		return ServiceKey{ServiceID: id}
+	}
+	return ServiceKey{NetworkID: id / 100000, ServiceID: id % 100000}
+}
+
+// Matches はサービスキーが (networkID, serviceID) のサービスに一致するかを返す
+// NetworkID が0の場合、または相手のネットワークIDが不明の場合はサービスIDのみで照合する
+func (k ServiceKey) Matches(networkID, serviceID int64) bool {
+	return k.ServiceID == serviceID && (k.NetworkID == 0 || networkID == 0 || k.NetworkID == networkID)
+}
+
+// MatchServiceIDs は ids（ServiceKeyFromID の形式）のいずれかが (networkID, serviceID) のサービスに一致するかを返す
+func MatchServiceIDs(ids []int64, networkID, serviceID int64) bool {
+	for _, id := range ids {
+		if ServiceKeyFromID(id).Matches(networkID, serviceID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Key はサービスの識別キーを返す
+func (s *Service) Key() ServiceKey {
+	return ServiceKey{NetworkID: s.NetworkID, ServiceID: s.ServiceID}
+}
+
+// ServiceMap は (networkId, serviceId) をキーとしてServiceの参照を保持するマップ
+// 上書き設定があるサービスは、設定を適用したコピーを返す
 type ServiceMap struct {
-	mu       sync.RWMutex
-	services map[int64]*Service // サービスIDをキーにしたマップ
+	mu        sync.RWMutex
+	services  map[ServiceKey]*Service // 上書き設定を適用済みのサービス
+	originals map[ServiceKey]*Service // Mirakurun から取得したままのサービス
+	overrides map[ServiceKey]*ServiceOverride
 }
 
 // NewServiceMap は新しいServiceMapを作成する
 func NewServiceMap() *ServiceMap {
 	return &ServiceMap{
-		services: make(map[int64]*Service),
+		services:  make(map[ServiceKey]*Service),
+		originals: make(map[ServiceKey]*Service),
+		overrides: make(map[ServiceKey]*ServiceOverride),
 	}
 }
 
 // Add はサービス情報をマップに追加する
 func (sm *ServiceMap) Add(service *Service) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	sm.services[service.ServiceID] = service
+	sm.Update(service)
 }
 
 // Update はサービス情報を更新する
 func (sm *ServiceMap) Update(service *Service) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
-	sm.services[service.ServiceID] = service
+	key := service.Key()
+	sm.originals[key] = service
+	sm.services[key] = sm.apply(service)
+}
+
+// Remove はサービス情報を削除する（上書き設定は残す）
+func (sm *ServiceMap) Remove(networkID, serviceID int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	key := ServiceKey{NetworkID: networkID, ServiceID: serviceID}
+	delete(sm.services, key)
+	delete(sm.originals, key)
 }
 
-// Remove はサービス情報を削除する
-func (sm *ServiceMap) Remove(serviceID int64) {
+// RemoveFromSource は取得元 source のサービス情報を削除し、削除したかを返す
+// 別の取得元のサービスは削除しない（取得元が記録されていない旧データは削除する）
+func (sm *ServiceMap) RemoveFromSource(networkID, serviceID int64, source string) bool {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
-	delete(sm.services, serviceID)
+	key := ServiceKey{NetworkID: networkID, ServiceID: serviceID}
+	original, ok := sm.originals[key]
+	if !ok || (original.Source != "" && original.Source != source) {
+		return false
+	}
+	delete(sm.services, key)
+	delete(sm.originals, key)
+	return true
+}
+
+// apply はサービスに上書き設定を適用する。呼び出し側でロックを取得していること
+func (sm *ServiceMap) apply(service *Service) *Service {
+	if override, ok := sm.overrides[service.Key()]; ok {
+		return override.Apply(service)
+	}
+	return service
+}
+
+// SetOverride はサービスの上書き設定を登録し、既存のサービス情報に適用する
+func (sm *ServiceMap) SetOverride(override *ServiceOverride) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	key := override.Key()
+	sm.overrides[key] = override
+	if original, ok := sm.originals[key]; ok {
+		sm.services[key] = override.Apply(original)
+	}
+}
+
+// RemoveOverride はサービスの上書き設定を削除し、Mirakurun から取得した値に戻す
+func (sm *ServiceMap) RemoveOverride(networkID, serviceID int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	key := ServiceKey{NetworkID: networkID, ServiceID: serviceID}
+	delete(sm.overrides, key)
+	if original, ok := sm.originals[key]; ok {
+		sm.services[key] = original
+	}
+}
+
+// Original は上書き設定を適用する前のサービス情報を取得する
+func (sm *ServiceMap) Original(networkID, serviceID int64) (*Service, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	service, ok := sm.originals[ServiceKey{NetworkID: networkID, ServiceID: serviceID}]
+	return service, ok
+}
+
+// Lookup はネットワークIDとサービスIDからサービス情報を取得する
+// networkID が0（ネットワークID不明の旧データ）の場合は Get と同じくサービスIDのみで検索する
+func (sm *ServiceMap) Lookup(networkID, serviceID int64) (*Service, bool) {
+	if networkID == 0 {
+		return sm.Get(serviceID)
+	}
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	service, ok := sm.services[ServiceKey{NetworkID: networkID, ServiceID: serviceID}]
+	return service, ok
 }
 
 // Get はサービスIDからサービス情報を取得する
+// 同じサービスIDが複数のネットワークに存在する場合はネットワークIDが最小のものを返す
 func (sm *ServiceMap) Get(serviceID int64) (*Service, bool) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-	service, ok := sm.services[serviceID]
-	return service, ok
+	var found *Service
+	for key, service := range sm.services {
+		if key.ServiceID != serviceID {
+			continue
+		}
+		if found == nil || service.NetworkID < found.NetworkID {
+			found = service
+		}
+	}
+	return found, found != nil
+}
+
+// FindByServiceID はサービスIDが一致するサービスをすべて返す
+func (sm *ServiceMap) FindByServiceID(serviceID int64) []*Service {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	var result []*Service
+	for key, service := range sm.services {
+		if key.ServiceID == serviceID {
+			result = append(result, service)
+		}
+	}
+	return result
 }
 
 // GetAll はすべてのサービス情報を取得する
@@ -94,10 +309,11 @@ type ExcludedService struct {
 	Name               string `json:"name"`
 	CreatedAt          int64  `json:"createdAt"`
 	Type               int    `json:"type"`               // 1=地上波、2=BS、3=CS
-	NetworkID          int64  `json:"networkId"`          // ネットワークID
+	NetworkID          int64  `json:"networkId"`          // ネットワークID（0はすべてのネットワーク）
 	RemoteControlKeyID int    `json:"remoteControlKeyId"` // リモコンキーID
 	ChannelType        string `json:"channelType"`        // "GR", "BS", "CS"など
 	ChannelNumber      string `json:"channelNumber"`      // チャンネル番号
+	ExpiresAt          int64  `json:"expiresAt,omitempty"` // 除外の有効期限（Unixミリ秒、0は無期限）
 }
 
 // SearchableService は検索対象となるチャンネルの簡易情報を表す構造体
@@ -0,0 +1,87 @@
+// models/source.go
+package models
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultSourceName は MIRAKURUN_URL だけを指定した場合の取得元の名前
+const DefaultSourceName = "default"
+
+// MirakurunSource は番組・サービス情報の取得元となるMirakurunの設定
+type MirakurunSource struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	RecorderURL string `json:"recorderUrl,omitempty"` // この取得元のチャンネルを録画する録画サーバー（省略時は RECORDER_URL）
+}
+
+// ParseMirakurunSources は "名前=URL" をカンマで区切った取得元の一覧を解析する
+// URL の後ろに "|録画サーバーURL" を付けると、その取得元の番組の予約を指定した録画サーバーに送る
+// 例: "gr=http://gr:40772/api|http://gr:37569,bs=http://bs:40772/api"
+func ParseMirakurunSources(spec string) ([]MirakurunSource, error) {
+	var sources []MirakurunSource
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, urls, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid source %q: expected name=url", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate source name: %s", name)
+		}
+		seen[name] = true
+
+		mirakurunURL, recorderURL, _ := strings.Cut(urls, "|")
+		source := MirakurunSource{
+			Name:        name,
+			URL:         strings.TrimSpace(mirakurunURL),
+			RecorderURL: strings.TrimSpace(recorderURL),
+		}
+		if source.URL == "" {
+			return nil, fmt.Errorf("invalid source %q: url is required", entry)
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no sources specified")
+	}
+	return sources, nil
+}
+
+var (
+	sourcesMu sync.RWMutex
+	sources   []MirakurunSource
+)
+
+// SetMirakurunSources は稼働中の取得元の一覧を設定する
+func SetMirakurunSources(list []MirakurunSource) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources = append([]MirakurunSource(nil), list...)
+}
+
+// GetMirakurunSources は稼働中の取得元の一覧を返す
+func GetMirakurunSources() []MirakurunSource {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	return append([]MirakurunSource(nil), sources...)
+}
+
+// LookupMirakurunSource は名前から取得元を返す
+func LookupMirakurunSource(name string) (MirakurunSource, bool) {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	for _, s := range sources {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return MirakurunSource{}, false
+}
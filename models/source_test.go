@@ -0,0 +1,41 @@
+// models/source_test.go
+package models
+
+import "testing"
+
+func TestParseMirakurunSources(t *testing.T) {
+	sources, err := ParseMirakurunSources("gr=http://gr:40772/api|http://gr-recorder:37569, bs=http://bs:40772/api")
+	if err != nil {
+		t.Fatalf("ParseMirakurunSources failed: %v", err)
+	}
+	expected := []MirakurunSource{
+		{Name: "gr", URL: "http://gr:40772/api", RecorderURL: "http://gr-recorder:37569"},
+		{Name: "bs", URL: "http://bs:40772/api"},
+	}
+	if len(sources) != len(expected) {
+		t.Fatalf("Expected %d sources, got %+v", len(expected), sources)
+	}
+	for i := range expected {
+		if sources[i] != expected[i] {
+			t.Errorf("Expected source %d to be %+v, got %+v", i, expected[i], sources[i])
+		}
+	}
+
+	for _, spec := range []string{"", "http://gr:40772/api", "gr=", "gr=http://a,gr=http://b"} {
+		if _, err := ParseMirakurunSources(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestLookupMirakurunSource(t *testing.T) {
+	defer SetMirakurunSources(GetMirakurunSources())
+	SetMirakurunSources([]MirakurunSource{{Name: "gr", URL: "http://gr:40772/api", RecorderURL: "http://gr-recorder:37569"}})
+
+	if source, ok := LookupMirakurunSource("gr"); !ok || source.RecorderURL != "http://gr-recorder:37569" {
+		t.Errorf("Expected gr source, got %+v (found=%v)", source, ok)
+	}
+	if _, ok := LookupMirakurunSource("bs"); ok {
+		t.Error("Expected unknown source not to be found")
+	}
+}
@@ -0,0 +1,29 @@
+// models/stream_status.go
+package models
+
+// ProgramWriterStats は番組ストリームの書き込みキューの状態を表す構造体
+type ProgramWriterStats struct {
+	QueueDepth    int   `json:"queueDepth"`            // キューに溜まっている書き込みの数
+	QueueCapacity int   `json:"queueCapacity"`         // キューの容量
+	MaxQueueDepth int   `json:"maxQueueDepth"`         // 起動以降のキューの最大の深さ
+	Written       int64 `json:"written"`               // 書き込んだイベント数
+	Failed        int64 `json:"failed"`                // 書き込みに失敗したイベント数
+	Batches       int64 `json:"batches"`               // コミットしたトランザクション数
+	LastBatchSize int   `json:"lastBatchSize"`         // 直近のトランザクションでまとめたイベント数
+	LastFlushAt   int64 `json:"lastFlushAt,omitempty"` // 直近の書き込み時刻（UNIXタイムスタンプ、ミリ秒）
+}
+
+// StreamState はMirakurunのイベントストリームとの接続状態を表す構造体
+type StreamState struct {
+	Name        string `json:"name"`   // ストリーム名（"program", "service" など）
+	Source      string `json:"source"` // 取得元のMirakurunの名前
+	URL         string `json:"url"`
+	Connected   bool   `json:"connected"`
+	ConnectedAt int64  `json:"connectedAt,omitempty"` // 直近の接続時刻（UNIXタイムスタンプ、ミリ秒）
+	LastEventAt int64  `json:"lastEventAt,omitempty"` // 直近のイベント受信時刻（UNIXタイムスタンプ、ミリ秒）
+	Events      int64  `json:"events"`                // 起動以降に受信したイベント数
+	Reconnects  int    `json:"reconnects"`            // 起動以降の再接続回数
+	LastError   string `json:"lastError,omitempty"`
+	LastErrorAt int64  `json:"lastErrorAt,omitempty"` // 直近のエラー発生時刻（UNIXタイムスタンプ、ミリ秒）
+	NextRetryAt int64  `json:"nextRetryAt,omitempty"` // 切断中の場合、次に再接続する時刻（UNIXタイムスタンプ、ミリ秒）
+}
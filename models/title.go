@@ -0,0 +1,161 @@
+// models/title.go
+package models
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+// ParsedTitle は番組名を記号・シリーズ名・話数・サブタイトルに分解した結果
+//
+//	[新][字]アニメ「タイトル」#5「サブタイトル」[再]
+//	→ Title: アニメ「タイトル」, Flags: [新 字 再], Episode: 5, Subtitle: サブタイトル
+type ParsedTitle struct {
+	Title    string   `json:"title"`              // 記号・話数・サブタイトルを除いた番組名
+	Flags    []string `json:"flags,omitempty"`    // 放送の記号（角括弧を除いた "新"、"字" など）
+	Episode  int      `json:"episode,omitempty"`  // 話数（分からない場合は0）
+	Subtitle string   `json:"subtitle,omitempty"` // サブタイトル
+}
+
+// TitleFlags は番組名から取り出す放送の記号
+// これ以外の角括弧（[映画] など）は番組名の一部として残す
+var TitleFlags = []string{
+	"新", "終", "再", "字", "二", "多", "解", "デ", "生", "天", "交", "映", "無", "料", "無料",
+	"前", "後", "初", "手", "双", "吹", "声", "演", "販", "Ｐ", "Ｗ", "Ｓ", "Ｂ", "Ｎ", "SS",
+	"HV", "SD", "MV", "5.1", "ほか", "ココ", "サ",
+}
+
+var titleFlagSet = func() map[string]bool {
+	set := make(map[string]bool, len(TitleFlags))
+	for _, f := range TitleFlags {
+		set[f] = true
+	}
+	return set
+}()
+
+var (
+	// titleFlagPattern は角括弧で囲まれた記号（全角の角括弧も含む）
+	titleFlagPattern = regexp.MustCompile(`[\[［]([^\]］\[［]{1,3})[\]］]`)
+	// episodePattern は話数の表記（#5、第5話、第5回、(5)）
+	// 括弧だけの表記は "(2019)" のような年と区別するため3桁までとする
+	episodePattern = regexp.MustCompile(`[#＃]\s*([0-9０-９]+)|第\s*([0-9０-９]+)\s*[話回]|[(（]([0-9０-９]{1,3})[)）]`)
+	// subtitlePattern は話数の後に続くかぎ括弧のサブタイトル
+	subtitlePattern = regexp.MustCompile(`^\s*[「『]([^」』]*)[」』]`)
+	spacesPattern   = regexp.MustCompile(`[\s　]+`)
+)
+
+// ParseTitle は番組名から放送の記号、シリーズ名、話数、サブタイトルを取り出す
+// 記号は囲み文字（🈟 など）と角括弧（[新]）のどちらの表記にも対応する
+func ParseTitle(name string) ParsedTitle {
+	var parsed ParsedTitle
+	seen := make(map[string]bool)
+	addFlag := func(flag string) {
+		if !seen[flag] {
+			seen[flag] = true
+			parsed.Flags = append(parsed.Flags, flag)
+		}
+	}
+
+	// 囲み文字の記号を角括弧の表記に揃える
+	var b strings.Builder
+	b.Grow(len(name))
+	for _, r := range name {
+		if r >= 0x1F100 && r <= 0x1F2FF {
+			if replacement, ok := UnicodeEmojiMap[r]; ok {
+				b.WriteString(replacement)
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+
+	rest := titleFlagPattern.ReplaceAllStringFunc(b.String(), func(m string) string {
+		flag := titleFlagPattern.FindStringSubmatch(m)[1]
+		if !titleFlagSet[flag] {
+			return m
+		}
+		addFlag(flag)
+		return " "
+	})
+
+	if loc := episodePattern.FindStringSubmatchIndex(rest); loc != nil {
+		for i := 2; i < len(loc); i += 2 {
+			if loc[i] >= 0 {
+				parsed.Episode, _ = strconv.Atoi(width.Narrow.String(rest[loc[i]:loc[i+1]]))
+				break
+			}
+		}
+		after := rest[loc[1]:]
+		if m := subtitlePattern.FindStringSubmatch(after); m != nil {
+			parsed.Subtitle = strings.TrimSpace(m[1])
+		} else {
+			parsed.Subtitle = strings.Trim(cleanTitle(after), "　 ▽・-－")
+		}
+		rest = rest[:loc[0]]
+	}
+
+	parsed.Title = cleanTitle(rest)
+	if parsed.Title == "" {
+		// 話数しか無い番組名（"#5「…」" など）はサブタイトルを番組名にする
+		parsed.Title = parsed.Subtitle
+	}
+	return parsed
+}
+
+// HasFlag は番組名に記号 flag（"新" など）が含まれているかを返す
+func (t ParsedTitle) HasFlag(flag string) bool {
+	for _, f := range t.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanTitle は連続する空白をまとめて前後の空白を除く
+func cleanTitle(s string) string {
+	return strings.TrimSpace(spacesPattern.ReplaceAllString(s, " "))
+}
+
+// ApplyParsedTitle は番組名を解析して Title・Flags・EpisodeNumber・Subtitle を設定する
+func (p *Program) ApplyParsedTitle() {
+	parsed := ParseTitle(p.Name)
+	p.Title = parsed.Title
+	p.Flags = parsed.Flags
+	p.EpisodeNumber = parsed.Episode
+	p.Subtitle = parsed.Subtitle
+}
+
+// ParsedTitle は番組の解析済みの番組名を返す（未解析の場合は番組名を解析する）
+func (p *Program) ParsedTitle() ParsedTitle {
+	if p.Title == "" && p.Name != "" {
+		return ParseTitle(p.Name)
+	}
+	return ParsedTitle{Title: p.Title, Flags: p.Flags, Episode: p.EpisodeNumber, Subtitle: p.Subtitle}
+}
+
+// TitleFilter は番組名から解析した記号・話数による自動予約ルールの条件
+// 例: 新番組のみ（Flags: ["新"]）、再放送を除く（ExcludeFlags: ["再"]）、第1話以降（MinEpisode: 1）
+type TitleFilter struct {
+	Flags        []string `json:"flags,omitempty"`        // すべて含む記号
+	ExcludeFlags []string `json:"excludeFlags,omitempty"` // 含まない記号
+	MinEpisode   int      `json:"minEpisode,omitempty"`   // 話数の下限（話数の分からない番組は一致しない）
+}
+
+// Match は解析した番組名が条件を満たすかを返す
+func (f TitleFilter) Match(t ParsedTitle) bool {
+	for _, flag := range f.Flags {
+		if !t.HasFlag(flag) {
+			return false
+		}
+	}
+	for _, flag := range f.ExcludeFlags {
+		if t.HasFlag(flag) {
+			return false
+		}
+	}
+	return f.MinEpisode <= 0 || t.Episode >= f.MinEpisode
+}
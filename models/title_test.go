@@ -0,0 +1,68 @@
+// models/title_test.go
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTitle(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected ParsedTitle
+	}{
+		{
+			name:     "[新][字]アニメ「タイトル」#5「サブタイトル」[再]",
+			expected: ParsedTitle{Title: "アニメ「タイトル」", Flags: []string{"新", "字", "再"}, Episode: 5, Subtitle: "サブタイトル"},
+		},
+		{
+			name:     "連続ドラマ　第１２話「最後の選択」🈡🈑",
+			expected: ParsedTitle{Title: "連続ドラマ", Flags: []string{"終", "字"}, Episode: 12, Subtitle: "最後の選択"},
+		},
+		{
+			name:     "おかあさんといっしょ(3)［字］",
+			expected: ParsedTitle{Title: "おかあさんといっしょ", Flags: []string{"字"}, Episode: 3},
+		},
+		{
+			name:     "日曜劇場 第3回 約束の場所[デ]",
+			expected: ParsedTitle{Title: "日曜劇場", Flags: []string{"デ"}, Episode: 3, Subtitle: "約束の場所"},
+		},
+		{
+			name:     "[映画]ローマの休日[二][字]",
+			expected: ParsedTitle{Title: "[映画]ローマの休日", Flags: []string{"二", "字"}},
+		},
+		{
+			name:     "ニュース７🈢",
+			expected: ParsedTitle{Title: "ニュース７", Flags: []string{"生"}},
+		},
+		{
+			name:     "映画 ジョーカー(2019)[字]",
+			expected: ParsedTitle{Title: "映画 ジョーカー(2019)", Flags: []string{"字"}},
+		},
+		{
+			name:     "天気予報",
+			expected: ParsedTitle{Title: "天気予報"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseTitle(tt.name)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseTitle(%q) = %+v, expected %+v", tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProgramParsedTitle(t *testing.T) {
+	p := Program{Name: "[新]ドラマ #1「始まり」"}
+	if parsed := p.ParsedTitle(); !parsed.HasFlag("新") || parsed.HasFlag("再") || parsed.Episode != 1 {
+		t.Errorf("Unexpected parsed title: %+v", parsed)
+	}
+
+	p.ApplyParsedTitle()
+	if p.Title != "ドラマ" || p.Subtitle != "始まり" || p.EpisodeNumber != 1 || !reflect.DeepEqual(p.Flags, []string{"新"}) {
+		t.Errorf("Unexpected program fields: %+v", p)
+	}
+}
@@ -0,0 +1,82 @@
+// models/webhook.go
+package models
+
+import "time"
+
+// Webhook の通知イベント種別
+const (
+	// WebhookEventReservationAutoCreated は自動予約ルールにより予約が作成されたとき
+	WebhookEventReservationAutoCreated = "reservation.autoCreated"
+	// WebhookEventReservationFailed は録画予約が失敗したとき
+	WebhookEventReservationFailed = "reservation.failed"
+	// WebhookEventRuleIdle は自動予約ルールが一定期間何にもマッチしなかったとき
+	WebhookEventRuleIdle = "rule.idle"
+	// WebhookEventTest は動作確認用の通知
+	WebhookEventTest = "webhook.test"
+)
+
+// WebhookEventTypes は購読可能なイベント種別の一覧
+var WebhookEventTypes = []string{
+	WebhookEventReservationAutoCreated,
+	WebhookEventReservationFailed,
+	WebhookEventRuleIdle,
+}
+
+// Webhook の配信ステータス
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySending   = "sending" // 送信中（ワーカーが取得済み）
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook は外部への通知先の購読設定を保持する構造体
+type Webhook struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"eventTypes"`         // 空の場合はすべてのイベントを通知
+	Template   string    `json:"template,omitempty"` // text/template形式のペイロード（空ならJSON）
+	Secret     string    `json:"secret,omitempty"`   // HMAC-SHA256署名用の共有鍵
+	HasSecret  bool      `json:"hasSecret"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// Subscribes は指定されたイベント種別を購読しているかを返す
+func (w *Webhook) Subscribes(eventType string) bool {
+	if eventType == WebhookEventTest || len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery はWebhookの配信1件分の状態と履歴を保持する構造体
+type WebhookDelivery struct {
+	ID             string    `json:"id"`
+	WebhookID      string    `json:"webhookId"`
+	EventType      string    `json:"eventType"`
+	Subject        string    `json:"subject,omitempty"` // 通知対象（ルールIDなど）
+	Payload        string    `json:"payload"`
+	Status         string    `json:"status"` // "pending", "sending", "succeeded", "failed"
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"nextAttemptAt"`
+	ResponseStatus int       `json:"responseStatus,omitempty"`
+	LastError      string    `json:"lastError,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// WebhookEvent はペイロードテンプレートに渡されるデータ
+type WebhookEvent struct {
+	Event   string      `json:"event"`
+	Subject string      `json:"subject,omitempty"`
+	Time    time.Time   `json:"time"`
+	Data    interface{} `json:"data"`
+}
@@ -0,0 +1,71 @@
+// models/xmltv.go
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// XMLTVSourceName は XMLTV から取り込んだ番組・サービスの取得元の名前
+const XMLTVSourceName = "xmltv"
+
+// XMLTVChannelMapping は XMLTV のチャンネルIDを割り当てるサービス
+type XMLTVChannelMapping struct {
+	NetworkID   int64
+	ServiceID   int64
+	ChannelType string // GR / BS / CS（省略時は既存のサービスの値、無ければ GR）
+}
+
+// ParseXMLTVChannelMap は "チャンネルID=networkId:serviceId[:GR|BS|CS]" をカンマで区切った対応表を解析する
+// 例: "NHK1.jp=32736:1024:GR,BS1.jp=4:101:BS"
+func ParseXMLTVChannelMap(spec string) (map[string]XMLTVChannelMapping, error) {
+	mapping := make(map[string]XMLTVChannelMapping)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// チャンネルIDに "=" が含まれることは無いが、念のため最後の "=" で区切る
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid channel mapping %q: expected channelId=networkId:serviceId", entry)
+		}
+		channelID := strings.TrimSpace(entry[:i])
+		parts := strings.Split(strings.TrimSpace(entry[i+1:]), ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid channel mapping %q: expected channelId=networkId:serviceId", entry)
+		}
+		networkID, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || networkID < 0 {
+			return nil, fmt.Errorf("invalid network id in %q", entry)
+		}
+		serviceID, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || serviceID <= 0 || serviceID >= 100000 {
+			return nil, fmt.Errorf("invalid service id in %q", entry)
+		}
+		m := XMLTVChannelMapping{NetworkID: networkID, ServiceID: serviceID}
+		if len(parts) == 3 {
+			m.ChannelType = strings.ToUpper(parts[2])
+			if ChannelTypeNumber(m.ChannelType) == 0 {
+				return nil, fmt.Errorf("invalid channel type in %q: must be GR, BS or CS", entry)
+			}
+		}
+		mapping[channelID] = m
+	}
+	return mapping, nil
+}
+
+// ChannelTypeNumber はチャンネルタイプ（GR/BS/CS）をサービスのタイプ（1/2/3）に変換する
+// 不明なチャンネルタイプの場合は0を返す
+func ChannelTypeNumber(channelType string) int {
+	switch channelType {
+	case "GR":
+		return 1
+	case "BS":
+		return 2
+	case "CS":
+		return 3
+	}
+	return 0
+}
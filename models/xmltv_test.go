@@ -0,0 +1,23 @@
+// models/xmltv_test.go
+package models
+
+import "testing"
+
+func TestParseXMLTVChannelMap(t *testing.T) {
+	mapping, err := ParseXMLTVChannelMap("NHK1.jp=32736:1024:gr, BS1.jp=4:101")
+	if err != nil {
+		t.Fatalf("ParseXMLTVChannelMap failed: %v", err)
+	}
+	if m := mapping["NHK1.jp"]; m != (XMLTVChannelMapping{NetworkID: 32736, ServiceID: 1024, ChannelType: "GR"}) {
+		t.Errorf("Unexpected mapping for NHK1.jp: %+v", m)
+	}
+	if m := mapping["BS1.jp"]; m != (XMLTVChannelMapping{NetworkID: 4, ServiceID: 101}) {
+		t.Errorf("Unexpected mapping for BS1.jp: %+v", m)
+	}
+
+	for _, spec := range []string{"NHK1.jp", "NHK1.jp=1024", "NHK1.jp=a:1024", "NHK1.jp=1:0", "NHK1.jp=1:1024:SKY"} {
+		if _, err := ParseXMLTVChannelMap(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// services/auto_reservation_bench_test.go
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/testing/epggen"
+)
+
+// BenchmarkCheckRuleMatch evaluates rules against a generated week of programs,
+// the same work processAutoReservations does for each enabled rule
+func BenchmarkCheckRuleMatch(b *testing.B) {
+	models.InitLogger("error")
+	database, err := db.InitDB(":memory:")
+	if err != nil {
+		b.Fatalf("Failed to init benchmark database: %v", err)
+	}
+	defer database.Close()
+
+	data := epggen.Generate(epggen.Config{Services: 12, Start: time.Now().Truncate(time.Hour)})
+	programs := data.Programs
+
+	var seriesID string
+	for _, p := range programs {
+		if p.Series != nil && p.Series.Pattern == 2 {
+			seriesID = fmt.Sprintf("%d", p.Series.ID)
+			break
+		}
+	}
+
+	rules := []models.AutoReservationRuleWithDetails{
+		{
+			AutoReservationRule: models.AutoReservationRule{ID: "keyword", Type: "keyword"},
+			KeywordRule:         &models.KeywordRule{Keywords: []string{"ドラマ"}},
+		},
+		{
+			AutoReservationRule: models.AutoReservationRule{ID: "keyword-exclude", Type: "keyword"},
+			KeywordRule:         &models.KeywordRule{Keywords: []string{"アニメ", "主人公"}, ExcludeWords: []string{"[再]", "🈞"}},
+		},
+		{
+			AutoReservationRule: models.AutoReservationRule{ID: "keyword-services", Type: "keyword"},
+			KeywordRule:         &models.KeywordRule{Keywords: []string{"ニュース"}, ServiceIDs: []int64{1024, 1032}},
+		},
+		{
+			AutoReservationRule: models.AutoReservationRule{ID: "series", Type: "series"},
+			SeriesRule:          &models.SeriesRule{SeriesID: seriesID},
+		},
+	}
+
+	engine := NewAutoReservationEngine(database, "http://localhost:37569")
+	for _, rule := range rules {
+		b.Run(rule.ID, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, p := range programs {
+					engine.checkRuleMatch(rule, p)
+				}
+			}
+			b.ReportMetric(float64(len(programs)), "programs/op")
+		})
+	}
+}
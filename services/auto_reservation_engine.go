@@ -19,6 +19,15 @@ type AutoReservationEngine struct {
 	database    *sql.DB
 	recorderURL string
 	interval    time.Duration
+
+	// reservationsURL is the reservation API endpoint that creates reservations
+	reservationsURL string
+
+	// channelGroups caches the channel groups referenced by keyword rules during a processing pass
+	channelGroups map[string]*models.ChannelGroup
+
+	// webhooks enqueues reservation.autoCreated deliveries; nil disables notifications
+	webhooks *WebhookDispatcher
 }
 
 // NewAutoReservationEngine creates a new auto reservation engine
@@ -27,9 +36,21 @@ func NewAutoReservationEngine(database *sql.DB, recorderURL string) *AutoReserva
 		database:    database,
 		recorderURL: recorderURL,
 		interval:    5 * time.Minute, // Check every 5 minutes
+
+		reservationsURL: "http://localhost:40870/reservations",
 	}
 }
 
+// SetWebhookDispatcher sets the dispatcher notified when a rule creates a reservation
+func (e *AutoReservationEngine) SetWebhookDispatcher(d *WebhookDispatcher) {
+	e.webhooks = d
+}
+
+// RecorderURL returns the default recorder URL of the engine
+func (e *AutoReservationEngine) RecorderURL() string {
+	return e.recorderURL
+}
+
 // Start begins the auto reservation monitoring process
 func (e *AutoReservationEngine) Start(ctx context.Context) {
 	models.Log.Info("AutoReservationEngine: Starting auto reservation monitoring")
@@ -69,6 +90,9 @@ func (e *AutoReservationEngine) processAutoReservations() {
 
 	models.Log.Debug("AutoReservationEngine: Processing %d enabled rules", len(rules))
 
+	// Reload channel groups so that edits since the last pass take effect
+	e.channelGroups = nil
+
 	// Get programs that might be candidates for auto reservation
 	// Look for programs starting in the next 24 hours that don't have reservations yet
 	now := time.Now()
@@ -123,6 +147,8 @@ func (e *AutoReservationEngine) checkRuleMatch(rule models.AutoReservationRuleWi
 		return e.checkKeywordMatch(rule.KeywordRule, program)
 	case "series":
 		return e.checkSeriesMatch(rule.SeriesRule, program)
+	case "person":
+		return e.checkPersonMatch(rule.PersonRule, program)
 	default:
 		models.Log.Error("AutoReservationEngine: Unknown rule type: %s", rule.Type)
 		return false
@@ -135,20 +161,35 @@ func (e *AutoReservationEngine) checkKeywordMatch(keywordRule *models.KeywordRul
 		return false
 	}
 
-	// Check service ID filter
-	if len(keywordRule.ServiceIDs) > 0 {
-		serviceMatched := false
-		for _, serviceID := range keywordRule.ServiceIDs {
-			if program.ServiceID == serviceID {
-				serviceMatched = true
-				break
-			}
-		}
-		if !serviceMatched {
+	// Check service filter; Mirakurun service IDs also compare the network ID
+	if len(keywordRule.ServiceIDs) > 0 && !models.MatchServiceIDs(keywordRule.ServiceIDs, program.NetworkID, program.ServiceID) {
+		return false
+	}
+
+	// Check channel group filter
+	if keywordRule.GroupID != "" {
+		group := e.channelGroup(keywordRule.GroupID)
+		if group == nil || !group.Contains(program.NetworkID, program.ServiceID) {
 			return false
 		}
 	}
 
+	// Check title flags and episode number parsed from the program name
+	if !keywordRule.TitleFilter.Match(program.ParsedTitle()) {
+		return false
+	}
+
+	// Check video/audio/caption attributes, e.g. only 5.1ch bilingual broadcasts
+	if !keywordRule.AttributeFilter.Match(&program) {
+		return false
+	}
+
+	// Check airtime window, weekdays, duration, channel type and validity period,
+	// e.g. only late-night broadcasts of at least 20 minutes
+	if !keywordRule.ScheduleFilter.Match(&program) {
+		return false
+	}
+
 	// Normalize program text for search
 	programText := strings.ToLower(program.Name + " " + program.Description)
 	
@@ -169,6 +210,23 @@ func (e *AutoReservationEngine) checkKeywordMatch(keywordRule *models.KeywordRul
 	return true
 }
 
+// channelGroup returns the channel group with the given ID, or nil when it no longer exists
+func (e *AutoReservationEngine) channelGroup(id string) *models.ChannelGroup {
+	if group, ok := e.channelGroups[id]; ok {
+		return group
+	}
+	group, err := db.GetChannelGroup(e.database, id)
+	if err != nil {
+		models.Log.Error("AutoReservationEngine: Failed to load channel group %s: %v", id, err)
+		group = nil
+	}
+	if e.channelGroups == nil {
+		e.channelGroups = make(map[string]*models.ChannelGroup)
+	}
+	e.channelGroups[id] = group
+	return group
+}
+
 // checkSeriesMatch checks if a program matches series rule criteria
 func (e *AutoReservationEngine) checkSeriesMatch(seriesRule *models.SeriesRule, program models.Program) bool {
 	if seriesRule == nil {
@@ -186,11 +244,52 @@ func (e *AutoReservationEngine) checkSeriesMatch(seriesRule *models.SeriesRule,
 	}
 
 	// Check service ID filter if specified
-	if seriesRule.ServiceID != 0 && program.ServiceID != seriesRule.ServiceID {
+	if seriesRule.ServiceID != 0 && !models.ServiceKeyFromID(seriesRule.ServiceID).Matches(program.NetworkID, program.ServiceID) {
 		return false
 	}
 
-	return true
+	// Check airtime window, weekdays, duration, channel type and validity period
+	if !seriesRule.ScheduleFilter.Match(&program) {
+		return false
+	}
+
+	// Check title flags and episode number, e.g. to skip reruns of the series
+	return seriesRule.TitleFilter.Match(program.ParsedTitle())
+}
+
+// checkPersonMatch checks if a program features the person of the rule,
+// using the cast and staff parsed from the program's extended description
+func (e *AutoReservationEngine) checkPersonMatch(personRule *models.PersonRule, program models.Program) bool {
+	if personRule == nil || personRule.Person == "" {
+		return false
+	}
+
+	// Check service filter; Mirakurun service IDs also compare the network ID
+	if len(personRule.ServiceIDs) > 0 && !models.MatchServiceIDs(personRule.ServiceIDs, program.NetworkID, program.ServiceID) {
+		return false
+	}
+
+	// Check channel group filter
+	if personRule.GroupID != "" {
+		group := e.channelGroup(personRule.GroupID)
+		if group == nil || !group.Contains(program.NetworkID, program.ServiceID) {
+			return false
+		}
+	}
+
+	if !personRule.TitleFilter.Match(program.ParsedTitle()) {
+		return false
+	}
+
+	if !personRule.ScheduleFilter.Match(&program) {
+		return false
+	}
+
+	// Programs decoded without going through the database have no parsed credits yet
+	if len(program.Cast) == 0 && len(program.Staff) == 0 && len(program.Extended) > 0 {
+		program.ApplyCredits()
+	}
+	return program.HasPerson(personRule.Person, personRule.Role)
 }
 
 // hasExistingReservation checks if a reservation already exists for the program
@@ -237,7 +336,7 @@ func (e *AutoReservationEngine) createReservationForProgram(rule models.AutoRese
 	}
 
 	// Make HTTP request to create reservation
-	resp, err := http.Post("http://localhost:40870/reservations", "application/json", strings.NewReader(string(jsonData)))
+	resp, err := http.Post(e.reservationsURL, "application/json", strings.NewReader(string(jsonData)))
 	if err != nil {
 		e.logAutoReservation(rule.ID, program.ID, "", "failed", fmt.Sprintf("HTTP request error: %v", err))
 		return
@@ -250,16 +349,13 @@ func (e *AutoReservationEngine) createReservationForProgram(rule models.AutoRese
 	}
 
 	// Parse response to get reservation ID
-	var reservationResponse map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&reservationResponse); err != nil {
+	var reservationResponse models.ReservationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&reservationResponse); err != nil || reservationResponse.Data == nil {
 		e.logAutoReservation(rule.ID, program.ID, "", "reserved", "Reservation created but failed to parse response")
 		return
 	}
 
-	reservationID := ""
-	if id, ok := reservationResponse["id"].(string); ok {
-		reservationID = id
-	}
+	reservationID := reservationResponse.Data.ID
 
 	e.logAutoReservation(rule.ID, program.ID, reservationID, "reserved", "")
 	models.Log.Info("AutoReservationEngine: Successfully created reservation %s for program %d", reservationID, program.ID)
@@ -277,5 +373,9 @@ func (e *AutoReservationEngine) logAutoReservation(ruleID string, programID int6
 
 	if err := db.CreateAutoReservationLog(e.database, log); err != nil {
 		models.Log.Error("AutoReservationEngine: Failed to create log: %v", err)
+		return
+	}
+	if e.webhooks != nil {
+		e.webhooks.NotifyAutoReservation(log)
 	}
 }
\ No newline at end of file
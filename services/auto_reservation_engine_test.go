@@ -107,6 +107,34 @@ func TestCheckKeywordMatch(t *testing.T) {
 			},
 			expected: false,
 		},
+		{
+			name: "Mirakurun service ID match",
+			keywordRule: &models.KeywordRule{
+				Keywords:   []string{"anime"},
+				ServiceIDs: []int64{400101},
+			},
+			program: models.Program{
+				NetworkID:   4,
+				ServiceID:   101,
+				Name:        "Great Anime Show",
+				Description: "An exciting anime series",
+			},
+			expected: true,
+		},
+		{
+			name: "Mirakurun service ID on another network",
+			keywordRule: &models.KeywordRule{
+				Keywords:   []string{"anime"},
+				ServiceIDs: []int64{400101},
+			},
+			program: models.Program{
+				NetworkID:   6,
+				ServiceID:   101,
+				Name:        "Great Anime Show",
+				Description: "An exciting anime series",
+			},
+			expected: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -119,6 +147,155 @@ func TestCheckKeywordMatch(t *testing.T) {
 	}
 }
 
+func TestCheckKeywordMatchGroup(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	group := &models.ChannelGroup{
+		Name:     "Kids",
+		Services: []models.ChannelGroupMember{{NetworkID: 4, ServiceID: 101}},
+	}
+	if err := db.CreateChannelGroup(database, group); err != nil {
+		t.Fatalf("CreateChannelGroup failed: %v", err)
+	}
+
+	engine := NewAutoReservationEngine(database, "http://localhost:37569")
+	rule := &models.KeywordRule{Keywords: []string{"anime"}, GroupID: group.ID}
+
+	inGroup := models.Program{ServiceID: 101, NetworkID: 4, Name: "Anime"}
+	otherNetwork := models.Program{ServiceID: 101, NetworkID: 6, Name: "Anime"}
+	if !engine.checkKeywordMatch(rule, inGroup) {
+		t.Errorf("Expected a program in the group to match")
+	}
+	if engine.checkKeywordMatch(rule, otherNetwork) {
+		t.Errorf("Expected a program outside the group not to match")
+	}
+
+	// 削除されたグループを参照するルールは何にもマッチしない
+	engine.channelGroups = nil
+	db.DeleteChannelGroup(database, group.ID)
+	if engine.checkKeywordMatch(rule, inGroup) {
+		t.Errorf("Expected no match once the group is deleted")
+	}
+}
+
+func TestCheckTitleFilter(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	engine := NewAutoReservationEngine(database, "http://localhost:37569")
+	first := models.Program{ServiceID: 1024, Name: "[新][字]アニメ「タイトル」#1「始まり」", Series: &models.Series{ID: 7}}
+	rerun := models.Program{ServiceID: 1024, Name: "アニメ「タイトル」#1「始まり」[再]", Series: &models.Series{ID: 7}}
+	special := models.Program{ServiceID: 1024, Name: "アニメ「タイトル」総集編", Series: &models.Series{ID: 7}}
+
+	onlyNew := &models.KeywordRule{Keywords: []string{"アニメ"}, TitleFilter: models.TitleFilter{Flags: []string{"新"}}}
+	if !engine.checkKeywordMatch(onlyNew, first) || engine.checkKeywordMatch(onlyNew, rerun) {
+		t.Errorf("Expected only the new program to match %+v", onlyNew.TitleFilter)
+	}
+
+	skipReruns := &models.SeriesRule{SeriesID: "7", TitleFilter: models.TitleFilter{ExcludeFlags: []string{"再"}}}
+	if !engine.checkSeriesMatch(skipReruns, first) || engine.checkSeriesMatch(skipReruns, rerun) || !engine.checkSeriesMatch(skipReruns, special) {
+		t.Errorf("Expected reruns to be skipped by %+v", skipReruns.TitleFilter)
+	}
+
+	episodes := &models.SeriesRule{SeriesID: "7", TitleFilter: models.TitleFilter{MinEpisode: 1}}
+	if !engine.checkSeriesMatch(episodes, first) || engine.checkSeriesMatch(episodes, special) {
+		t.Errorf("Expected only numbered episodes to match %+v", episodes.TitleFilter)
+	}
+}
+
+func TestCheckAttributeFilter(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	engine := NewAutoReservationEngine(database, "http://localhost:37569")
+	surround := models.Program{ServiceID: 1024, Name: "映画「タイトル」[二]", Video: &models.ProgramVideo{Resolution: "1080i"},
+		Audios: []models.ProgramAudio{{ComponentType: 0x09}, {ComponentType: 0x03}}}
+	stereo := models.Program{ServiceID: 1024, Name: "映画「タイトル」[二]", Video: &models.ProgramVideo{Resolution: "1080i"},
+		Audios: []models.ProgramAudio{{ComponentType: 0x03}}}
+
+	rule := &models.KeywordRule{Keywords: []string{"映画"},
+		AttributeFilter: models.AttributeFilter{Attributes: []string{models.AttributeSurround51, models.AttributeBilingual}}}
+	if !engine.checkKeywordMatch(rule, surround) || engine.checkKeywordMatch(rule, stereo) {
+		t.Errorf("Expected only the 5.1ch bilingual broadcast to match %+v", rule.AttributeFilter)
+	}
+
+	notHD := &models.KeywordRule{Keywords: []string{"映画"},
+		AttributeFilter: models.AttributeFilter{ExcludeAttributes: []string{models.AttributeHD}}}
+	if engine.checkKeywordMatch(notHD, surround) {
+		t.Errorf("Expected the HD broadcast to be skipped by %+v", notHD.AttributeFilter)
+	}
+}
+
+func TestCheckScheduleFilter(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	engine := NewAutoReservationEngine(database, "http://localhost:37569")
+	// Late Monday night (Tuesday 01:30) broadcast, its daytime rerun and a promo spot
+	lateNight := time.Date(2025, 6, 3, 1, 30, 0, 0, time.Local).UnixMilli()
+	daytime := time.Date(2025, 6, 4, 14, 0, 0, 0, time.Local).UnixMilli()
+	const minute = int64(time.Minute / time.Millisecond)
+	programs := map[string]models.Program{
+		"late night": {ServiceID: 1024, Name: "深夜アニメ #1", StartAt: lateNight, Duration: 30 * minute},
+		"rerun":      {ServiceID: 1024, Name: "深夜アニメ #1", StartAt: daytime, Duration: 30 * minute},
+		"promo":      {ServiceID: 1024, Name: "深夜アニメ 番宣", StartAt: lateNight + 30*minute, Duration: 5 * minute},
+	}
+	schedule := models.ScheduleFilter{StartTime: "24:00", EndTime: "29:00", Weekdays: []time.Weekday{time.Monday}, MinDuration: 20}
+
+	keywordRule := &models.KeywordRule{Keywords: []string{"深夜アニメ"}, ScheduleFilter: schedule}
+	seriesRule := &models.SeriesRule{SeriesID: "100", ScheduleFilter: schedule}
+	for name, program := range programs {
+		expected := name == "late night"
+		if got := engine.checkKeywordMatch(keywordRule, program); got != expected {
+			t.Errorf("Keyword rule on %s: expected %v, got %v", name, expected, got)
+		}
+		program.Series = &models.Series{ID: 100}
+		if got := engine.checkSeriesMatch(seriesRule, program); got != expected {
+			t.Errorf("Series rule on %s: expected %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestCheckPersonMatch(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	engine := NewAutoReservationEngine(database, "http://localhost:37569")
+	drama := models.Program{ServiceID: 1024, Name: "[新]ドラマ", Extended: map[string]string{
+		"出演者":   "佐藤　健，長澤まさみ",
+		"監督・演出": "山田太郎",
+	}}
+	rerun := models.Program{ServiceID: 1024, Name: "ドラマ[再]", Extended: drama.Extended}
+	bsDrama := models.Program{NetworkID: 4, ServiceID: 1024, Name: "ドラマ", Extended: drama.Extended}
+	other := models.Program{ServiceID: 1024, Name: "ニュース", Description: "佐藤健"}
+
+	tests := []struct {
+		name       string
+		personRule *models.PersonRule
+		program    models.Program
+		expected   bool
+	}{
+		{"Actor", &models.PersonRule{Person: "佐藤健"}, drama, true},
+		{"Name with spaces", &models.PersonRule{Person: "佐藤 健"}, drama, true},
+		{"Name only in description", &models.PersonRule{Person: "佐藤健"}, other, false},
+		{"Director role", &models.PersonRule{Person: "山田太郎", Role: "監督"}, drama, true},
+		{"Wrong role", &models.PersonRule{Person: "佐藤健", Role: "監督"}, drama, false},
+		{"Service filter", &models.PersonRule{Person: "佐藤健", ServiceIDs: []int64{2048}}, drama, false},
+		{"Service on another network", &models.PersonRule{Person: "佐藤健", ServiceIDs: []int64{3273601024}}, bsDrama, false},
+		{"Service on the same network", &models.PersonRule{Person: "佐藤健", ServiceIDs: []int64{401024}}, bsDrama, true},
+		{"Skip reruns", &models.PersonRule{Person: "佐藤健", TitleFilter: models.TitleFilter{ExcludeFlags: []string{"再"}}}, rerun, false},
+		{"Nil rule", nil, drama, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := engine.checkPersonMatch(tt.personRule, tt.program); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
 func TestCheckSeriesMatch(t *testing.T) {
 	database := setupEngineTestDB(t)
 	defer database.Close()
@@ -170,6 +347,23 @@ func TestCheckSeriesMatch(t *testing.T) {
 			},
 			expected: false,
 		},
+		{
+			name: "Mirakurun service ID on another network",
+			seriesRule: &models.SeriesRule{
+				SeriesID:  "12345",
+				ServiceID: 401032,
+			},
+			program: models.Program{
+				NetworkID: 6,
+				ServiceID: 1032,
+				Name:      "Test Series Episode 1",
+				Series: &models.Series{
+					ID:   12345,
+					Name: "Test Series",
+				},
+			},
+			expected: false,
+		},
 		{
 			name: "Service ID filter match",
 			seriesRule: &models.SeriesRule{
@@ -0,0 +1,297 @@
+// services/auto_reservation_preview.go
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// PreviewRule evaluates a rule, which need not be saved, against the programs starting between from and to.
+// Nothing is reserved or logged. Whether a program would be reserved is decided by checkRuleMatch exactly as
+// in a processing pass; the preview only adds the reason for each program the rule is looking for.
+func (e *AutoReservationEngine) PreviewRule(rule models.AutoReservationRuleWithDetails, from, to time.Time) (*models.AutoReservationPreview, error) {
+	// Use a separate engine so that the channel group cache of a running processing pass is not shared
+	preview := &AutoReservationEngine{database: e.database, recorderURL: e.recorderURL}
+
+	programs, err := db.SearchPrograms(e.database, "", 0, from.UnixMilli(), to.UnixMilli(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get programs: %w", err)
+	}
+
+	reservations, err := db.GetReservations(e.database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reservations: %w", err)
+	}
+	var active []models.Reservation
+	for _, r := range reservations {
+		if r.Status == models.ReservationStatusPending || r.Status == models.ReservationStatusRecording {
+			active = append(active, r)
+		}
+	}
+
+	result := &models.AutoReservationPreview{
+		From:     from.UnixMilli(),
+		To:       to.UnixMilli(),
+		Programs: []models.AutoReservationPreviewItem{},
+	}
+
+	var matched []models.Program
+	for _, program := range programs {
+		if !isPreviewCandidate(rule, program) {
+			continue
+		}
+
+		item := models.AutoReservationPreviewItem{Program: program}
+		switch {
+		case preview.checkRuleMatch(rule, program):
+			item.Matched = true
+			item.Status = models.PreviewStatusMatched
+			item.Reasons, item.MatchedKeywords = matchReasons(rule, program)
+			if reasons := duplicateReasons(program, active, matched); len(reasons) > 0 {
+				item.Status = models.PreviewStatusDuplicate
+				item.Reasons = append(item.Reasons, reasons...)
+			} else if reasons := conflictReasons(program, active, matched); len(reasons) > 0 {
+				item.Status = models.PreviewStatusConflict
+				item.Reasons = append(item.Reasons, reasons...)
+			}
+			matched = append(matched, program)
+			result.Matched++
+		case preview.hasExistingReservation(program.ID):
+			item.Status = models.PreviewStatusAlreadyReserved
+			item.Reasons = []string{"program is already reserved"}
+		case rule.ID != "" && preview.hasExistingLog(rule.ID, program.ID):
+			item.Status = models.PreviewStatusDuplicate
+			item.Reasons = []string{"program was already processed by this rule"}
+		default:
+			item.Status = models.PreviewStatusExcluded
+			item.Reasons = preview.exclusionReasons(rule, program)
+		}
+		result.Programs = append(result.Programs, item)
+	}
+
+	models.Log.Debug("AutoReservationEngine: Preview of rule %s checked %d programs, %d candidates, %d matched",
+		rule.Name, len(programs), len(result.Programs), result.Matched)
+	return result, nil
+}
+
+// isPreviewCandidate reports whether the program is what the rule is looking for before its filters apply:
+// it contains every keyword, belongs to the series or credits the person
+func isPreviewCandidate(rule models.AutoReservationRuleWithDetails, program models.Program) bool {
+	switch rule.Type {
+	case "keyword":
+		return rule.KeywordRule != nil && len(rule.KeywordRule.Keywords) > 0 &&
+			len(matchedKeywords(rule.KeywordRule, program)) == len(rule.KeywordRule.Keywords)
+	case "series":
+		return rule.SeriesRule != nil && program.Series != nil &&
+			fmt.Sprintf("%d", program.Series.ID) == rule.SeriesRule.SeriesID
+	case "person":
+		if rule.PersonRule == nil || rule.PersonRule.Person == "" {
+			return false
+		}
+		program = withCredits(program)
+		return program.HasPerson(rule.PersonRule.Person, "")
+	}
+	return false
+}
+
+// matchedKeywords returns the keywords of the rule found in the program name or description
+func matchedKeywords(keywordRule *models.KeywordRule, program models.Program) []string {
+	programText := strings.ToLower(program.Name + " " + program.Description)
+	var keywords []string
+	for _, keyword := range keywordRule.Keywords {
+		if strings.Contains(programText, strings.ToLower(keyword)) {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// withCredits returns the program with the cast and staff parsed, as checkPersonMatch does
+func withCredits(program models.Program) models.Program {
+	if len(program.Cast) == 0 && len(program.Staff) == 0 && len(program.Extended) > 0 {
+		program.ApplyCredits()
+	}
+	return program
+}
+
+// matchReasons explains why a matched program was picked up by the rule
+func matchReasons(rule models.AutoReservationRuleWithDetails, program models.Program) (reasons, keywords []string) {
+	switch rule.Type {
+	case "keyword":
+		keywords = matchedKeywords(rule.KeywordRule, program)
+		reasons = append(reasons, "matched keywords: "+strings.Join(keywords, ", "))
+	case "series":
+		reasons = append(reasons, "episode of series "+rule.SeriesRule.SeriesID)
+	case "person":
+		program = withCredits(program)
+		name := models.NormalizePersonName(rule.PersonRule.Person)
+		for _, c := range program.Credits() {
+			if c.Name == name && (rule.PersonRule.Role == "" || strings.Contains(c.Role, rule.PersonRule.Role)) {
+				reasons = append(reasons, fmt.Sprintf("%s credited as %s", c.Name, c.Role))
+			}
+		}
+	}
+	return reasons, keywords
+}
+
+// exclusionReasons lists the filters of the rule that reject a candidate program
+func (e *AutoReservationEngine) exclusionReasons(rule models.AutoReservationRuleWithDetails, program models.Program) []string {
+	var (
+		reasons        []string
+		serviceIDs     []int64
+		groupID        string
+		titleFilter    models.TitleFilter
+		scheduleFilter models.ScheduleFilter
+	)
+	switch rule.Type {
+	case "keyword":
+		serviceIDs, groupID, titleFilter = rule.KeywordRule.ServiceIDs, rule.KeywordRule.GroupID, rule.KeywordRule.TitleFilter
+		scheduleFilter = rule.KeywordRule.ScheduleFilter
+	case "series":
+		if rule.SeriesRule.ServiceID != 0 {
+			serviceIDs = []int64{rule.SeriesRule.ServiceID}
+		}
+		titleFilter, scheduleFilter = rule.SeriesRule.TitleFilter, rule.SeriesRule.ScheduleFilter
+	case "person":
+		serviceIDs, groupID, titleFilter = rule.PersonRule.ServiceIDs, rule.PersonRule.GroupID, rule.PersonRule.TitleFilter
+		scheduleFilter = rule.PersonRule.ScheduleFilter
+	}
+
+	if len(serviceIDs) > 0 && !models.MatchServiceIDs(serviceIDs, program.NetworkID, program.ServiceID) {
+		reasons = append(reasons, fmt.Sprintf("excluded by channel: service %d/%d is not one of the rule's channels",
+			program.NetworkID, program.ServiceID))
+	}
+	if groupID != "" {
+		if group := e.channelGroup(groupID); group == nil {
+			reasons = append(reasons, fmt.Sprintf("excluded by channel: group %s no longer exists", groupID))
+		} else if !group.Contains(program.NetworkID, program.ServiceID) {
+			reasons = append(reasons, fmt.Sprintf("excluded by channel: service %d is not in group %s", program.ServiceID, group.Name))
+		}
+	}
+
+	reasons = append(reasons, scheduleReasons(scheduleFilter, &program)...)
+
+	title := program.ParsedTitle()
+	for _, flag := range titleFilter.Flags {
+		if !title.HasFlag(flag) {
+			reasons = append(reasons, fmt.Sprintf("title has no [%s] flag", flag))
+		}
+	}
+	for _, flag := range titleFilter.ExcludeFlags {
+		if title.HasFlag(flag) {
+			reasons = append(reasons, fmt.Sprintf("excluded by flag [%s]", flag))
+		}
+	}
+	if titleFilter.MinEpisode > 0 && title.Episode < titleFilter.MinEpisode {
+		if title.Episode == 0 {
+			reasons = append(reasons, fmt.Sprintf("episode number is unknown (minimum %d)", titleFilter.MinEpisode))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("episode %d is before %d", title.Episode, titleFilter.MinEpisode))
+		}
+	}
+
+	switch rule.Type {
+	case "keyword":
+		for _, attr := range rule.KeywordRule.Attributes {
+			if !program.HasAttribute(attr) {
+				reasons = append(reasons, "program has no attribute "+attr)
+			}
+		}
+		for _, attr := range rule.KeywordRule.ExcludeAttributes {
+			if program.HasAttribute(attr) {
+				reasons = append(reasons, "excluded by attribute "+attr)
+			}
+		}
+		programText := strings.ToLower(program.Name + " " + program.Description)
+		for _, excludeWord := range rule.KeywordRule.ExcludeWords {
+			if strings.Contains(programText, strings.ToLower(excludeWord)) {
+				reasons = append(reasons, fmt.Sprintf("excluded by word %q", excludeWord))
+			}
+		}
+	case "person":
+		program = withCredits(program)
+		if rule.PersonRule.Role != "" && !program.HasPerson(rule.PersonRule.Person, rule.PersonRule.Role) {
+			reasons = append(reasons, fmt.Sprintf("%s is not credited as %s", rule.PersonRule.Person, rule.PersonRule.Role))
+		}
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, "program does not match the rule")
+	}
+	return reasons
+}
+
+// scheduleReasons explains the airtime, weekday, duration, channel type and validity conditions the program fails
+func scheduleReasons(f models.ScheduleFilter, program *models.Program) []string {
+	var reasons []string
+	start := time.UnixMilli(program.StartAt)
+	for _, condition := range f.Mismatches(program) {
+		switch condition {
+		case models.ScheduleConditionTime:
+			reasons = append(reasons, fmt.Sprintf("starts at %s, outside %s-%s", start.Format("15:04"), f.StartTime, f.EndTime))
+		case models.ScheduleConditionWeekday:
+			reasons = append(reasons, "broadcast weekday is not one of the rule's weekdays")
+		case models.ScheduleConditionDuration:
+			reasons = append(reasons, fmt.Sprintf("duration of %d minutes is outside the rule's range", program.Duration/60000))
+		case models.ScheduleConditionChannelType:
+			reasons = append(reasons, "excluded by channel: not a "+f.ChannelType+" channel")
+		case models.ScheduleConditionValidity:
+			reasons = append(reasons, "starts outside the rule's validity period")
+		}
+	}
+	return reasons
+}
+
+// episodeKey identifies an episode by its parsed title and episode number.
+// Programs without an episode number return an empty key, since they cannot be told apart from reruns.
+func episodeKey(title models.ParsedTitle) string {
+	if title.Episode <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s#%d", title.Title, title.Episode)
+}
+
+// duplicateReasons reports when the same episode is already reserved or matched earlier in the preview
+func duplicateReasons(program models.Program, reservations []models.Reservation, matched []models.Program) []string {
+	key := episodeKey(program.ParsedTitle())
+	if key == "" {
+		return nil
+	}
+	var reasons []string
+	for _, r := range reservations {
+		if r.ProgramID != program.ID && episodeKey(models.ParseTitle(r.Name)) == key {
+			reasons = append(reasons, fmt.Sprintf("same episode as reservation %q at %s", r.Name, formatPreviewTime(r.StartAt)))
+		}
+	}
+	for _, m := range matched {
+		if episodeKey(m.ParsedTitle()) == key {
+			reasons = append(reasons, fmt.Sprintf("same episode as matched program %d at %s", m.ID, formatPreviewTime(m.StartAt)))
+		}
+	}
+	return reasons
+}
+
+// conflictReasons reports reservations and earlier matches whose airtime overlaps the program
+func conflictReasons(program models.Program, reservations []models.Reservation, matched []models.Program) []string {
+	end := program.StartAt + program.Duration
+	var reasons []string
+	for _, r := range reservations {
+		if r.ProgramID != program.ID && r.StartAt < end && program.StartAt < r.StartAt+r.Duration {
+			reasons = append(reasons, fmt.Sprintf("overlaps reservation %q at %s", r.Name, formatPreviewTime(r.StartAt)))
+		}
+	}
+	for _, m := range matched {
+		if m.StartAt < end && program.StartAt < m.StartAt+m.Duration {
+			reasons = append(reasons, fmt.Sprintf("overlaps matched program %d at %s", m.ID, formatPreviewTime(m.StartAt)))
+		}
+	}
+	return reasons
+}
+
+func formatPreviewTime(ms int64) string {
+	return time.UnixMilli(ms).Format("2006-01-02 15:04")
+}
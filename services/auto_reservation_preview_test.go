@@ -0,0 +1,134 @@
+// services/auto_reservation_preview_test.go
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+func TestPreviewRule(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	engine := NewAutoReservationEngine(database, "http://localhost:37569")
+
+	base := time.Now().Add(time.Hour).Truncate(time.Minute).UnixMilli()
+	const halfHour = int64(30 * time.Minute / time.Millisecond)
+	programs := []models.Program{
+		{ID: 1, ServiceID: 1032, Name: "Great Anime #3", StartAt: base, Duration: halfHour},
+		{ID: 2, ServiceID: 1032, Name: "Great Anime #3", StartAt: base + 10*halfHour, Duration: halfHour},
+		{ID: 3, ServiceID: 1032, Name: "Anime Rerun", StartAt: base + 2*halfHour, Duration: halfHour},
+		{ID: 4, ServiceID: 1040, Name: "Another Anime", StartAt: base + halfHour/2, Duration: halfHour},
+		{ID: 5, ServiceID: 1040, Name: "Reserved Anime", StartAt: base + 20*halfHour, Duration: halfHour},
+		{ID: 6, ServiceID: 1040, Name: "Cooking", StartAt: base, Duration: halfHour},
+	}
+	if err := db.ReplacePrograms(database, "test", programs); err != nil {
+		t.Fatalf("Failed to save programs: %v", err)
+	}
+	_, err := database.Exec(`
+		INSERT INTO reservations (id, programId, serviceId, name, startAt, duration, recorderUrl, recorderProgramId, status, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, "test-reservation", 5, 1040, "Reserved Anime", base+20*halfHour, halfHour,
+		"http://localhost:37569", "rec-123", "pending", time.Now().UnixMilli(), time.Now().UnixMilli())
+	if err != nil {
+		t.Fatalf("Failed to create test reservation: %v", err)
+	}
+
+	rule := models.AutoReservationRuleWithDetails{
+		AutoReservationRule: models.AutoReservationRule{Type: "keyword", Name: "Anime"},
+		KeywordRule: &models.KeywordRule{
+			Keywords:     []string{"anime"},
+			ExcludeWords: []string{"rerun"},
+		},
+	}
+	preview, err := engine.PreviewRule(rule, time.Now(), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("PreviewRule failed: %v", err)
+	}
+
+	expected := map[int64]string{
+		1: models.PreviewStatusMatched,
+		2: models.PreviewStatusDuplicate,
+		3: models.PreviewStatusExcluded,
+		4: models.PreviewStatusConflict,
+		5: models.PreviewStatusAlreadyReserved,
+	}
+	if len(preview.Programs) != len(expected) {
+		t.Fatalf("Expected %d candidates, got %d: %+v", len(expected), len(preview.Programs), preview.Programs)
+	}
+	for _, item := range preview.Programs {
+		if item.Status != expected[item.Program.ID] {
+			t.Errorf("Program %d: expected status %s, got %s (%v)", item.Program.ID, expected[item.Program.ID], item.Status, item.Reasons)
+		}
+		if len(item.Reasons) == 0 {
+			t.Errorf("Program %d: expected reasons", item.Program.ID)
+		}
+	}
+	if preview.Matched != 3 {
+		t.Errorf("Expected 3 programs the engine would reserve, got %d", preview.Matched)
+	}
+
+	for _, item := range preview.Programs {
+		switch item.Program.ID {
+		case 1:
+			if len(item.MatchedKeywords) != 1 || item.MatchedKeywords[0] != "anime" {
+				t.Errorf("Expected matched keyword anime, got %v", item.MatchedKeywords)
+			}
+		case 3:
+			if !strings.Contains(strings.Join(item.Reasons, "\n"), `excluded by word "rerun"`) {
+				t.Errorf("Expected exclude word reason, got %v", item.Reasons)
+			}
+		}
+	}
+
+	// The preview must not reserve or log anything
+	var logs int
+	if err := database.QueryRow("SELECT COUNT(*) FROM auto_reservation_logs").Scan(&logs); err != nil {
+		t.Fatalf("Failed to count logs: %v", err)
+	}
+	if logs != 0 {
+		t.Errorf("Expected no logs after preview, got %d", logs)
+	}
+}
+
+func TestPreviewRuleScheduleReasons(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	engine := NewAutoReservationEngine(database, "http://localhost:37569")
+
+	start := time.Now().Add(time.Hour).Truncate(time.Minute)
+	const minute = int64(time.Minute / time.Millisecond)
+	programs := []models.Program{
+		{ID: 1, ServiceID: 1032, Name: "Anime", StartAt: start.UnixMilli(), Duration: 30 * minute},
+		{ID: 2, ServiceID: 1032, Name: "Anime promo", StartAt: start.UnixMilli() + 30*minute, Duration: 5 * minute},
+	}
+	if err := db.ReplacePrograms(database, "test", programs); err != nil {
+		t.Fatalf("Failed to save programs: %v", err)
+	}
+
+	rule := models.AutoReservationRuleWithDetails{
+		AutoReservationRule: models.AutoReservationRule{Type: "keyword", Name: "Anime"},
+		KeywordRule: &models.KeywordRule{
+			Keywords:       []string{"anime"},
+			ScheduleFilter: models.ScheduleFilter{MinDuration: 20},
+		},
+	}
+	preview, err := engine.PreviewRule(rule, time.Now(), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("PreviewRule failed: %v", err)
+	}
+	if preview.Matched != 1 || len(preview.Programs) != 2 {
+		t.Fatalf("Expected 1 match out of 2 candidates, got %+v", preview)
+	}
+	for _, item := range preview.Programs {
+		if item.Program.ID == 2 && (item.Status != models.PreviewStatusExcluded ||
+			!strings.Contains(strings.Join(item.Reasons, "\n"), "duration of 5 minutes")) {
+			t.Errorf("Expected the promo spot to be excluded by duration, got %s %v", item.Status, item.Reasons)
+		}
+	}
+}
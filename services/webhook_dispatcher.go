@@ -0,0 +1,332 @@
+// services/webhook_dispatcher.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// WebhookSignatureHeader is the header carrying the HMAC-SHA256 signature of the body
+const WebhookSignatureHeader = "X-IEPG-Signature"
+
+// WebhookDispatcher queues webhook deliveries and sends them with retries.
+// Deliveries are enqueued by the code that writes the reservation or log, and
+// a single worker (Start) drains the queue.
+type WebhookDispatcher struct {
+	database      *sql.DB
+	client        *http.Client
+	interval      time.Duration // how often pending deliveries are processed
+	maxAttempts   int
+	retryBase     time.Duration // first retry delay, doubled on every attempt
+	idleThreshold time.Duration // a rule is reported idle after matching nothing for this long
+	idleInterval  time.Duration
+	wake          chan struct{} // signals the worker that new deliveries were enqueued
+}
+
+// NewWebhookDispatcher creates a new webhook dispatcher
+func NewWebhookDispatcher(database *sql.DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		database: database,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		interval:      10 * time.Second,
+		maxAttempts:   8,
+		retryBase:     30 * time.Second,
+		idleThreshold: 7 * 24 * time.Hour,
+		idleInterval:  1 * time.Hour,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// Start processes the delivery queue until ctx is cancelled.
+// It must run in a single goroutine; it is the only caller of ProcessPending.
+func (d *WebhookDispatcher) Start(ctx context.Context) {
+	models.Log.Info("WebhookDispatcher: Starting webhook dispatcher")
+
+	// Deliveries claimed by a previous run that stopped mid-send are sent again
+	db.ResetSendingWebhookDeliveries(d.database)
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	idleTicker := time.NewTicker(d.idleInterval)
+	defer idleTicker.Stop()
+
+	// Deliveries left over from a previous run are retried immediately
+	d.ProcessPending()
+
+	for {
+		select {
+		case <-ctx.Done():
+			models.Log.Info("WebhookDispatcher: Stopping webhook dispatcher")
+			return
+		case <-d.wake:
+			d.ProcessPending()
+		case <-ticker.C:
+			d.ProcessPending()
+		case <-idleTicker.C:
+			d.CheckIdleRules(time.Now())
+		}
+	}
+}
+
+// Wake asks the worker to process the queue without waiting for the next tick
+func (d *WebhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NotifyAutoReservation enqueues a reservation.autoCreated event for a "reserved" auto reservation log
+func (d *WebhookDispatcher) NotifyAutoReservation(log *models.AutoReservationLog) {
+	if log.Status != "reserved" {
+		return
+	}
+	data := map[string]interface{}{"log": log}
+	if log.ReservationID != "" {
+		if reservation, err := db.GetReservationByID(d.database, log.ReservationID); err == nil {
+			data["reservation"] = reservation
+		} else {
+			models.Log.Error("WebhookDispatcher: Failed to load reservation %s: %v", log.ReservationID, err)
+		}
+	}
+	if rule, err := db.GetAutoReservationRuleByID(d.database, log.RuleID); err == nil {
+		data["rule"] = rule
+	}
+	if program, err := db.GetProgramByID(d.database, log.ProgramID); err == nil {
+		data["program"] = program
+	}
+	d.Enqueue(models.WebhookEventReservationAutoCreated, log.ReservationID, data)
+}
+
+// NotifyReservationFailed enqueues a reservation.failed event for the reservation
+func (d *WebhookDispatcher) NotifyReservationFailed(id string) {
+	reservation, err := db.GetReservationByID(d.database, id)
+	if err != nil {
+		models.Log.Error("WebhookDispatcher: Failed to load failed reservation %s: %v", id, err)
+		return
+	}
+	d.Enqueue(models.WebhookEventReservationFailed, reservation.ID, map[string]interface{}{"reservation": reservation})
+}
+
+// CheckIdleRules enqueues rule.idle events for enabled rules that have matched nothing within the threshold
+func (d *WebhookDispatcher) CheckIdleRules(now time.Time) {
+	rules, err := db.GetEnabledAutoReservationRules(d.database)
+	if err != nil {
+		return
+	}
+
+	threshold := now.Add(-d.idleThreshold)
+	for _, rule := range rules {
+		if rule.CreatedAt.After(threshold) {
+			continue
+		}
+		last, err := db.GetLastAutoReservationLogTime(d.database, rule.ID)
+		if err != nil || last.After(threshold) {
+			continue
+		}
+		// Notify at most once per threshold period
+		if db.HasRecentWebhookDelivery(d.database, models.WebhookEventRuleIdle, rule.ID, threshold) {
+			continue
+		}
+
+		data := map[string]interface{}{"rule": rule}
+		if !last.IsZero() {
+			data["lastMatchedAt"] = last
+		}
+		models.Log.Info("WebhookDispatcher: Rule %s (%s) matched nothing since %v", rule.ID, rule.Name, threshold)
+		d.Enqueue(models.WebhookEventRuleIdle, rule.ID, data)
+	}
+}
+
+// Enqueue renders the payload for every subscribed webhook, stores it in the delivery queue
+// and wakes the worker
+func (d *WebhookDispatcher) Enqueue(eventType, subject string, data interface{}) {
+	webhooks, err := db.GetWebhooksForEvent(d.database, eventType)
+	if err != nil {
+		return
+	}
+	defer d.Wake()
+
+	event := models.WebhookEvent{
+		Event:   eventType,
+		Subject: subject,
+		Time:    time.Now(),
+		Data:    data,
+	}
+
+	for _, w := range webhooks {
+		payload, err := RenderWebhookPayload(&w, event)
+		if err != nil {
+			models.Log.Error("WebhookDispatcher: Failed to render payload for webhook %s: %v", w.ID, err)
+			payload = ""
+		}
+
+		delivery := &models.WebhookDelivery{
+			WebhookID: w.ID,
+			EventType: eventType,
+			Subject:   subject,
+			Payload:   payload,
+		}
+		if err != nil {
+			delivery.Status = models.WebhookDeliveryFailed
+			delivery.LastError = fmt.Sprintf("template error: %v", err)
+		}
+		if err := db.CreateWebhookDelivery(d.database, delivery); err != nil {
+			continue
+		}
+		models.Log.Debug("WebhookDispatcher: Enqueued %s delivery %s for webhook %s", eventType, delivery.ID, w.ID)
+	}
+}
+
+// ProcessPending sends all deliveries whose next attempt time has come.
+// Each delivery is claimed before sending, so it is sent once even if another process drains the same queue.
+func (d *WebhookDispatcher) ProcessPending() {
+	deliveries, err := db.GetDueWebhookDeliveries(d.database, time.Now(), 100)
+	if err != nil {
+		return
+	}
+
+	for i := range deliveries {
+		d.attempt(&deliveries[i])
+	}
+}
+
+// attempt sends a single delivery and schedules a retry on failure
+func (d *WebhookDispatcher) attempt(delivery *models.WebhookDelivery) {
+	webhook, err := db.GetWebhookByID(d.database, delivery.WebhookID)
+	if err != nil {
+		return
+	}
+	if claimed, err := db.ClaimWebhookDelivery(d.database, delivery.ID); err != nil || !claimed {
+		return
+	}
+
+	delivery.Attempts++
+	status, err := d.send(webhook, delivery)
+	delivery.ResponseStatus = status
+
+	if err == nil {
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.LastError = ""
+		models.Log.Info("WebhookDispatcher: Delivered %s to webhook %s (%s)", delivery.EventType, webhook.ID, webhook.Name)
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.maxAttempts {
+			delivery.Status = models.WebhookDeliveryFailed
+			models.Log.Error("WebhookDispatcher: Giving up delivery %s after %d attempts: %v", delivery.ID, delivery.Attempts, err)
+		} else {
+			delay := d.retryBase << uint(delivery.Attempts-1)
+			delivery.Status = models.WebhookDeliveryPending
+			delivery.NextAttemptAt = time.Now().Add(delay)
+			models.Log.Info("WebhookDispatcher: Delivery %s failed (attempt %d), retrying in %v: %v",
+				delivery.ID, delivery.Attempts, delay, err)
+		}
+	}
+
+	db.UpdateWebhookDelivery(d.database, delivery)
+}
+
+// send posts the payload to the webhook URL
+func (d *WebhookDispatcher) send(webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if json.Valid(body) {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	req.Header.Set("User-Agent", "iepg-server-webhook")
+	req.Header.Set("X-IEPG-Event", delivery.EventType)
+	req.Header.Set("X-IEPG-Delivery", delivery.ID)
+	if webhook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload returns the signature header value ("sha256=<hex>") for the body
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookTemplateFuncs are the helper functions available in payload templates.
+// Templates receive the event as decoded JSON, e.g. {{.event}}, {{.data.program.name}}.
+var webhookTemplateFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. {"content": {{json .data.program.name}}}
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// formatMillis formats a Unix millisecond timestamp such as .data.program.startAt
+	"formatMillis": func(layout string, ms float64) string {
+		return time.UnixMilli(int64(ms)).Format(layout)
+	},
+}
+
+// ValidateWebhookTemplate checks that the payload template can be parsed
+func ValidateWebhookTemplate(text string) error {
+	if text == "" {
+		return nil
+	}
+	_, err := template.New("payload").Funcs(webhookTemplateFuncs).Parse(text)
+	return err
+}
+
+// RenderWebhookPayload renders the webhook's template, or plain JSON when no template is set
+func RenderWebhookPayload(webhook *models.Webhook, event models.WebhookEvent) (string, error) {
+	if webhook.Template == "" {
+		b, err := json.Marshal(event)
+		return string(b), err
+	}
+
+	tmpl, err := template.New("payload").Funcs(webhookTemplateFuncs).Parse(webhook.Template)
+	if err != nil {
+		return "", err
+	}
+
+	// Round-trip through JSON so templates see the same field names as the JSON payload
+	var generic interface{}
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	json.Unmarshal(raw, &generic)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, generic); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,318 @@
+// services/webhook_dispatcher_test.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuba/iepg-server/db"
+	"github.com/fuba/iepg-server/models"
+)
+
+// webhookReceiver is a local httptest receiver that records requests
+type webhookReceiver struct {
+	mu       sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	failures int // number of requests to reject with 500 before succeeding
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.bodies = append(rcv.bodies, string(body))
+	rcv.headers = append(rcv.headers, r.Header.Clone())
+	if rcv.failures > 0 {
+		rcv.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestWebhookDeliverySignedTemplate(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := &models.Webhook{
+		Name:       "discord",
+		URL:        server.URL,
+		EventTypes: []string{models.WebhookEventReservationFailed},
+		Template:   `{"content": {{json (printf "録画失敗: %s" .data.reservation.name)}}}`,
+		Secret:     "s3cret",
+		Enabled:    true,
+	}
+	if err := db.CreateWebhook(database, webhook); err != nil {
+		t.Fatalf("CreateWebhook returned error: %v", err)
+	}
+
+	_, err := database.Exec(`INSERT INTO reservations (id, programId, serviceId, name, startAt, duration,
+		recorderUrl, recorderProgramId, status, createdAt, updatedAt, error)
+		VALUES ('r1', 1, 1, 'ニュース', 0, 0, 'http://recorder', '1', 'failed', 0, 0, 'boom')`)
+	if err != nil {
+		t.Fatalf("Failed to insert reservation: %v", err)
+	}
+
+	dispatcher := NewWebhookDispatcher(database)
+	dispatcher.NotifyReservationFailed("r1")
+	// Events the webhook did not subscribe to are ignored
+	dispatcher.Enqueue(models.WebhookEventRuleIdle, "rule", nil)
+	dispatcher.ProcessPending()
+
+	if len(receiver.bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(receiver.bodies))
+	}
+	body := receiver.bodies[0]
+	if body != `{"content": "録画失敗: ニュース"}` {
+		t.Errorf("unexpected payload: %s", body)
+	}
+	if got, want := receiver.headers[0].Get(WebhookSignatureHeader), SignWebhookPayload("s3cret", []byte(body)); got != want {
+		t.Errorf("signature mismatch: got %s want %s", got, want)
+	}
+	if got := receiver.headers[0].Get("X-IEPG-Event"); got != models.WebhookEventReservationFailed {
+		t.Errorf("unexpected event header: %s", got)
+	}
+
+	deliveries, err := db.GetWebhookDeliveries(database, webhook.ID, 10)
+	if err != nil {
+		t.Fatalf("GetWebhookDeliveries returned error: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != models.WebhookDeliverySucceeded || deliveries[0].Attempts != 1 {
+		t.Errorf("unexpected delivery log: %+v", deliveries)
+	}
+}
+
+func TestWebhookDeliveryRetry(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	receiver := &webhookReceiver{failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := &models.Webhook{Name: "ntfy", URL: server.URL, Enabled: true}
+	if err := db.CreateWebhook(database, webhook); err != nil {
+		t.Fatalf("CreateWebhook returned error: %v", err)
+	}
+
+	dispatcher := NewWebhookDispatcher(database)
+	dispatcher.retryBase = 0
+	dispatcher.Enqueue(models.WebhookEventRuleIdle, "rule-1", map[string]string{"name": "test"})
+
+	// 1回目は失敗し、再試行が予約される
+	dispatcher.ProcessPending()
+	deliveries, _ := db.GetWebhookDeliveries(database, webhook.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != models.WebhookDeliveryPending || deliveries[0].Attempts != 1 {
+		t.Fatalf("expected pending delivery after first failure, got %+v", deliveries)
+	}
+	if deliveries[0].ResponseStatus != http.StatusInternalServerError {
+		t.Errorf("expected response status 500, got %d", deliveries[0].ResponseStatus)
+	}
+
+	// 2回目で成功する
+	dispatcher.ProcessPending()
+	deliveries, _ = db.GetWebhookDeliveries(database, webhook.ID, 10)
+	if deliveries[0].Status != models.WebhookDeliverySucceeded || deliveries[0].Attempts != 2 {
+		t.Fatalf("expected succeeded delivery after retry, got %+v", deliveries[0])
+	}
+
+	// テンプレート未指定時はイベント全体のJSON
+	var payload models.WebhookEvent
+	if err := json.Unmarshal([]byte(receiver.bodies[1]), &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	if payload.Event != models.WebhookEventRuleIdle || payload.Subject != "rule-1" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestWebhookDeliveryClaimedOnce(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhook := &models.Webhook{Name: "hook", URL: server.URL, Enabled: true}
+	if err := db.CreateWebhook(database, webhook); err != nil {
+		t.Fatalf("CreateWebhook returned error: %v", err)
+	}
+
+	dispatcher := NewWebhookDispatcher(database)
+	dispatcher.Enqueue(models.WebhookEventRuleIdle, "rule-1", nil)
+
+	// 同じ配信を並行して処理しても送信は1回だけ
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dispatcher.ProcessPending()
+		}()
+	}
+	wg.Wait()
+
+	if len(receiver.bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(receiver.bodies))
+	}
+	deliveries, _ := db.GetWebhookDeliveries(database, webhook.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != models.WebhookDeliverySucceeded || deliveries[0].Attempts != 1 {
+		t.Errorf("unexpected delivery log: %+v", deliveries)
+	}
+}
+
+func TestAutoReservationWakesWebhookWorker(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-IEPG-Event")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	webhook := &models.Webhook{Name: "hook", URL: server.URL, Enabled: true}
+	if err := db.CreateWebhook(database, webhook); err != nil {
+		t.Fatalf("CreateWebhook returned error: %v", err)
+	}
+
+	rule := &models.AutoReservationRule{Type: "keyword", Name: "news", Enabled: true, RecorderURL: "http://recorder"}
+	if err := db.CreateAutoReservationRule(database, rule); err != nil {
+		t.Fatalf("CreateAutoReservationRule returned error: %v", err)
+	}
+
+	dispatcher := NewWebhookDispatcher(database)
+	dispatcher.interval = time.Hour // 定期処理ではなく書き込み時の起床で送信されること
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Start(ctx)
+
+	engine := NewAutoReservationEngine(database, "http://recorder")
+	engine.SetWebhookDispatcher(dispatcher)
+	engine.logAutoReservation(rule.ID, 1, "res-1", "skipped", "duplicate")
+	engine.logAutoReservation(rule.ID, 1, "res-1", "reserved", "")
+
+	select {
+	case event := <-received:
+		if event != models.WebhookEventReservationAutoCreated {
+			t.Errorf("unexpected event: %s", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered after the log was written")
+	}
+
+	deliveries, _ := db.GetWebhookDeliveries(database, webhook.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Subject != "res-1" {
+		t.Errorf("expected a single autoCreated delivery, got %+v", deliveries)
+	}
+}
+
+func TestAutoReservationWebhookIncludesReservation(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	_, err := database.Exec(`INSERT INTO reservations (id, programId, serviceId, name, startAt, duration,
+		recorderUrl, recorderProgramId, status, createdAt, updatedAt)
+		VALUES ('res-auto', 1, 1, 'ニュース', 0, 0, 'http://recorder', '1', 'pending', 0, 0)`)
+	if err != nil {
+		t.Fatalf("Failed to insert reservation: %v", err)
+	}
+
+	// 予約APIは {success, data} の形式で作成した予約を返す
+	reservationAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.ReservationResponse{
+			Success: true,
+			Data:    &models.Reservation{ID: "res-auto", ProgramID: 1, Name: "ニュース"},
+		})
+	}))
+	defer reservationAPI.Close()
+
+	webhook := &models.Webhook{Name: "hook", URL: "http://127.0.0.1:1", Enabled: true}
+	if err := db.CreateWebhook(database, webhook); err != nil {
+		t.Fatalf("CreateWebhook returned error: %v", err)
+	}
+
+	rule := &models.AutoReservationRule{Type: "keyword", Name: "news", Enabled: true, RecorderURL: "http://recorder"}
+	if err := db.CreateAutoReservationRule(database, rule); err != nil {
+		t.Fatalf("CreateAutoReservationRule returned error: %v", err)
+	}
+
+	engine := NewAutoReservationEngine(database, "http://recorder")
+	engine.reservationsURL = reservationAPI.URL
+	engine.SetWebhookDispatcher(NewWebhookDispatcher(database))
+	engine.createReservationForProgram(models.AutoReservationRuleWithDetails{AutoReservationRule: *rule},
+		models.Program{ID: 1, ServiceID: 1, Name: "ニュース"})
+
+	deliveries, _ := db.GetWebhookDeliveries(database, webhook.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].Subject != "res-auto" {
+		t.Fatalf("expected an autoCreated delivery for res-auto, got %+v", deliveries)
+	}
+
+	var payload struct {
+		Event string `json:"event"`
+		Data  struct {
+			Log         models.AutoReservationLog `json:"log"`
+			Reservation *models.Reservation       `json:"reservation"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(deliveries[0].Payload), &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	if payload.Data.Log.ReservationID != "res-auto" {
+		t.Errorf("expected log.reservationId res-auto, got %q", payload.Data.Log.ReservationID)
+	}
+	if payload.Data.Reservation == nil || payload.Data.Reservation.ID != "res-auto" || payload.Data.Reservation.Name != "ニュース" {
+		t.Errorf("expected reservation in payload, got %+v", payload.Data.Reservation)
+	}
+}
+
+func TestCheckIdleRules(t *testing.T) {
+	database := setupEngineTestDB(t)
+	defer database.Close()
+
+	webhook := &models.Webhook{Name: "hook", URL: "http://127.0.0.1:1", Enabled: true}
+	if err := db.CreateWebhook(database, webhook); err != nil {
+		t.Fatalf("CreateWebhook returned error: %v", err)
+	}
+
+	rule := &models.AutoReservationRule{Type: "keyword", Name: "idle", Enabled: true, RecorderURL: "http://recorder"}
+	if err := db.CreateAutoReservationRule(database, rule); err != nil {
+		t.Fatalf("CreateAutoReservationRule returned error: %v", err)
+	}
+
+	dispatcher := NewWebhookDispatcher(database)
+
+	// 作成直後のルールは通知しない
+	dispatcher.CheckIdleRules(time.Now())
+	if db.HasRecentWebhookDelivery(database, models.WebhookEventRuleIdle, rule.ID, time.Time{}) {
+		t.Fatalf("fresh rule should not be reported idle")
+	}
+
+	// 8日間何にもマッチしなかったルールは通知し、同じ期間内で重複通知しない
+	_, err := database.Exec("UPDATE auto_reservation_rules SET createdAt = ? WHERE id = ?",
+		time.Now().Add(-8*24*time.Hour).UnixMilli(), rule.ID)
+	if err != nil {
+		t.Fatalf("Failed to backdate rule: %v", err)
+	}
+	dispatcher.CheckIdleRules(time.Now())
+	dispatcher.CheckIdleRules(time.Now())
+	deliveries, _ := db.GetWebhookDeliveries(database, webhook.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].EventType != models.WebhookEventRuleIdle || deliveries[0].Subject != rule.ID {
+		t.Errorf("expected a single rule.idle delivery, got %+v", deliveries)
+	}
+}
@@ -0,0 +1,482 @@
+// testing/epggen/generator.go
+package epggen
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+)
+
+// 番組表の実データに近い合成データを生成する
+// ベンチマークやデモ用のサーバーで、Mirakurun に接続せずに1週間分の番組表を用意するために使う
+// 同じ Config からは常に同じデータを生成する
+
+// Config は生成する番組表の規模
+type Config struct {
+	Services int       // サービス数（0の場合は12）
+	Days     int       // 生成する日数（0の場合は7）
+	Start    time.Time // 番組表の開始日時（ゼロ値の場合は今日の5時（日本時間））
+	Seed     int64     // 乱数の種（0の場合は1）
+	Source   string    // 番組・サービスに記録する取得元の名前
+}
+
+// Data は生成したサービスと番組
+type Data struct {
+	Services []*models.Service
+	Programs []models.Program
+}
+
+// JST は番組表の時刻の基準にする日本標準時
+var JST = time.FixedZone("JST", 9*60*60)
+
+const (
+	defaultServices = 12
+	defaultDays     = 7
+	broadcastDay    = 24 * 60 // 番組表の1日（5時から翌29時）の分数
+	dayStartHour    = 5
+)
+
+// Generate は Config に従ってサービスと番組を生成する
+func Generate(cfg Config) *Data {
+	if cfg.Services <= 0 {
+		cfg.Services = defaultServices
+	}
+	if cfg.Days <= 0 {
+		cfg.Days = defaultDays
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = 1
+	}
+	if cfg.Start.IsZero() {
+		now := time.Now().In(JST)
+		cfg.Start = time.Date(now.Year(), now.Month(), now.Day(), dayStartHour, 0, 0, 0, JST)
+		if now.Hour() < dayStartHour {
+			cfg.Start = cfg.Start.AddDate(0, 0, -1)
+		}
+	}
+
+	g := &generator{
+		cfg:      cfg,
+		rand:     rand.New(rand.NewSource(cfg.Seed)),
+		seriesID: 1000,
+	}
+	data := &Data{}
+	for i := 0; i < cfg.Services; i++ {
+		service := g.service(i)
+		data.Services = append(data.Services, service)
+		data.Programs = append(data.Programs, g.programs(service)...)
+	}
+	return data
+}
+
+type generator struct {
+	cfg      Config
+	rand     *rand.Rand
+	seriesID int
+}
+
+// 局名。全角英数字や記号を含め、検索時の正規化が必要な名前にしている
+var (
+	grStationNames = []string{"ＮＨＫ総合１・東京", "ＮＨＫＥテレ１東京", "日本テレビ", "テレビ朝日", "ＴＢＳ１", "テレビ東京１", "フジテレビ", "ＴＯＫＹＯ　ＭＸ１", "tvk", "テレ玉"}
+	bsStationNames = []string{"ＮＨＫ　ＢＳ", "ＢＳ日テレ", "ＢＳ朝日１", "ＢＳ－ＴＢＳ", "ＢＳテレ東", "ＢＳフジ・１８１", "ＢＳ１１イレブン", "ＢＳ１２トゥエルビ"}
+	csStationNames = []string{"アニマックス", "キッズステーション", "時代劇専門チャンネル", "ＡＴ－Ｘ", "スカイＡ", "日テレジータス", "ファミリー劇場", "ホームドラマチャンネル"}
+)
+
+// service は i 番目のサービスを返す。地上波、BS、CS の順に割り当てる
+func (g *generator) service(i int) *models.Service {
+	var s models.Service
+	switch {
+	case i < len(grStationNames):
+		s = models.Service{
+			NetworkID:          int64(32736 + i),
+			ServiceID:          int64(1024 + i*8),
+			Name:               grStationNames[i],
+			Type:               1,
+			RemoteControlKeyID: i + 1,
+			ChannelType:        "GR",
+			ChannelNumber:      fmt.Sprintf("%d", 27-i),
+		}
+	case i < len(grStationNames)+len(bsStationNames):
+		n := i - len(grStationNames)
+		s = models.Service{
+			NetworkID:          4,
+			ServiceID:          int64(101 + n*10),
+			Name:               bsStationNames[n],
+			Type:               2,
+			RemoteControlKeyID: n + 1,
+			ChannelType:        "BS",
+			ChannelNumber:      fmt.Sprintf("BS%02d_%d", 1+n*2, n%3),
+		}
+	default:
+		n := i - len(grStationNames) - len(bsStationNames)
+		name := csStationNames[n%len(csStationNames)]
+		if n >= len(csStationNames) {
+			name = fmt.Sprintf("%s%d", name, n/len(csStationNames)+1)
+		}
+		s = models.Service{
+			NetworkID:     int64(6 + n%2),
+			ServiceID:     int64(200 + n),
+			Name:          name,
+			Type:          3,
+			ChannelType:   "CS",
+			ChannelNumber: fmt.Sprintf("CS%d", 2+n*2),
+		}
+	}
+	s.ID = s.Key().MirakurunServiceID()
+	s.Source = g.cfg.Source
+	return &s
+}
+
+// band は時間帯ごとの番組の傾向
+type band struct {
+	kind      string // 番組の種類（titlePrefixes・descriptions のキー）
+	from, to  int    // 5時からの経過分
+	durations []int  // 番組の長さ（分）の候補
+	strip     bool   // 平日は毎日同じ番組（帯番組）
+}
+
+var bands = []band{
+	{"news", 0, 180, []int{30, 60}, true},
+	{"wide", 180, 480, []int{60, 120}, true},
+	{"drama", 480, 660, []int{30, 60}, false}, // 昼の再放送枠
+	{"wide", 660, 780, []int{60}, true},
+	{"news", 780, 840, []int{30, 60}, true},
+	{"drama", 840, 1080, []int{60}, false},
+	{"variety", 1080, 1140, []int{30, 60}, false},
+	{"anime", 1140, broadcastDay, []int{30}, false},
+}
+
+// dayKind は曜日の種類（平日・土曜・日曜）
+func dayKind(wd time.Weekday) string {
+	switch wd {
+	case time.Saturday:
+		return "sat"
+	case time.Sunday:
+		return "sun"
+	}
+	return "weekday"
+}
+
+// slot は番組表の枠
+type slot struct {
+	at, duration int
+	band         *band
+}
+
+// show は同じ枠で放送されるシリーズ
+type show struct {
+	title       string
+	kind        string
+	seriesID    int
+	pattern     int // ARIB の番組パターン（1: 帯番組, 2: 週1回）
+	episode     int // 次に放送する話数
+	lastEpisode int // 最終話（0は不明）
+	cast        []string
+	aired       []int // 放送済みの話数（再放送に使う）
+}
+
+// timetable は曜日の種類ごとの番組枠を作る。平日は同じ枠にして帯番組を成立させる
+func (g *generator) timetable() map[string][]slot {
+	tables := make(map[string][]slot)
+	for _, kind := range []string{"weekday", "sat", "sun"} {
+		var slots []slot
+		for i := range bands {
+			b := &bands[i]
+			for at := b.from; at < b.to; {
+				d := b.durations[g.rand.Intn(len(b.durations))]
+				if at+d > b.to {
+					d = b.to - at
+				}
+				slots = append(slots, slot{at: at, duration: d, band: b})
+				at += d
+			}
+		}
+		tables[kind] = slots
+	}
+	return tables
+}
+
+// newShow は時間帯の種類に合わせたシリーズを作る
+func (g *generator) newShow(kind string, strip bool) *show {
+	s := &show{kind: kind, pattern: 2, episode: 1 + g.rand.Intn(8)}
+	if strip {
+		s.pattern = 1
+		s.episode = 1 + g.rand.Intn(500)
+	}
+	prefixes, suffixes := titlePrefixes[kind], titleSuffixes[kind]
+	s.title = prefixes[g.rand.Intn(len(prefixes))] + suffixes[g.rand.Intn(len(suffixes))]
+	switch kind {
+	case "drama":
+		s.lastEpisode = 10 + g.rand.Intn(3)
+		s.episode = 1 + g.rand.Intn(s.lastEpisode-2)
+	case "anime":
+		s.lastEpisode = []int{12, 13, 24}[g.rand.Intn(3)]
+		s.episode = 1 + g.rand.Intn(s.lastEpisode-2)
+	}
+	// ニュースにはシリーズ情報を付けない
+	if kind != "news" {
+		g.seriesID++
+		s.seriesID = g.seriesID
+	}
+	for i := 0; i < 2+g.rand.Intn(4); i++ {
+		s.cast = append(s.cast, familyNames[g.rand.Intn(len(familyNames))]+givenNames[g.rand.Intn(len(givenNames))])
+	}
+	return s
+}
+
+// programs はサービスの番組を cfg.Days 日分生成する
+func (g *generator) programs(service *models.Service) []models.Program {
+	tables := g.timetable()
+	shows := make(map[string]*show)
+	var primeShows []*show // 再放送の候補
+
+	eventID := int64(1 + g.rand.Intn(20000))
+	isFree := service.ChannelType != "CS"
+	var programs []models.Program
+	end := g.cfg.Start.AddDate(0, 0, g.cfg.Days)
+	for day := 0; day < g.cfg.Days; day++ {
+		date := g.cfg.Start.AddDate(0, 0, day)
+		wd := date.Weekday()
+		for _, sl := range tables[dayKind(wd)] {
+			key := fmt.Sprintf("%s/%d", dayKind(wd), sl.at)
+			if !sl.band.strip || wd == time.Saturday || wd == time.Sunday {
+				key = fmt.Sprintf("%d/%d", wd, sl.at)
+			}
+
+			// 昼のドラマ枠は、放送済みのドラマの再放送にすることがある
+			var sh *show
+			rerun := false
+			if sl.band.from == 480 && len(primeShows) > 0 && g.rand.Intn(3) == 0 {
+				sh = primeShows[g.rand.Intn(len(primeShows))]
+				rerun = len(sh.aired) > 0
+			}
+			if !rerun {
+				if sh = shows[key]; sh == nil {
+					sh = g.newShow(sl.band.kind, sl.band.strip)
+					shows[key] = sh
+					if sh.kind == "drama" && sl.band.from != 480 {
+						primeShows = append(primeShows, sh)
+					}
+				}
+			}
+
+			p := g.program(service, sh, rerun)
+			p.ID = service.ID*100000 + eventID
+			p.StartAt = date.Add(time.Duration(sl.at) * time.Minute).UnixMilli()
+			p.Duration = int64(sl.duration) * int64(time.Minute/time.Millisecond)
+			p.IsFree = &isFree
+			if p.Series != nil {
+				p.Series.ExpiresAt = end.UnixMilli()
+			}
+			programs = append(programs, p)
+			eventID = eventID%65535 + 1
+		}
+	}
+	return programs
+}
+
+// program は show の次の回（rerun の場合は放送済みの回）の番組を作る
+func (g *generator) program(service *models.Service, sh *show, rerun bool) models.Program {
+	episode := sh.episode
+	if rerun {
+		episode = sh.aired[g.rand.Intn(len(sh.aired))]
+	} else {
+		sh.aired = append(sh.aired, episode)
+		sh.episode++
+		if sh.lastEpisode > 0 && sh.episode > sh.lastEpisode {
+			// 最終回の翌週からは新しいシーズンにする
+			sh.episode = 1
+		}
+	}
+
+	var marks []string
+	if rerun {
+		marks = append(marks, g.mark("再"))
+	} else if episode == 1 && sh.lastEpisode > 0 {
+		marks = append(marks, g.mark("新"))
+	} else if episode == sh.lastEpisode {
+		marks = append(marks, g.mark("終"))
+	}
+	if sh.kind == "news" && g.rand.Intn(2) == 0 {
+		marks = append(marks, g.mark("生"))
+	}
+	if sh.kind != "news" || g.rand.Intn(2) == 0 {
+		marks = append(marks, g.mark("字"))
+	}
+	if service.ChannelType == "GR" && g.rand.Intn(3) == 0 {
+		marks = append(marks, g.mark("デ"))
+	}
+	if sh.kind == "drama" && g.rand.Intn(4) == 0 {
+		marks = append(marks, g.mark("解"))
+	}
+	bilingual := sh.kind == "anime" && g.rand.Intn(4) == 0
+	if bilingual {
+		marks = append(marks, g.mark("二"))
+	}
+
+	name := sh.title
+	if sh.kind == "drama" || sh.kind == "anime" {
+		subtitles := subtitleWords
+		name += fmt.Sprintf("　第%d話「%s」", episode, subtitles[(sh.seriesID+episode)%len(subtitles)])
+	} else if sh.kind == "variety" && g.rand.Intn(2) == 0 {
+		name += "▽" + varietyTopics[g.rand.Intn(len(varietyTopics))]
+	}
+	name += strings.Join(marks, "")
+
+	p := models.Program{
+		ServiceID:   service.ServiceID,
+		NetworkID:   service.NetworkID,
+		Name:        name,
+		Description: g.description(sh),
+		Extended:    g.extended(sh),
+		Video:       video(service),
+		Audios:      audios(sh, episode, bilingual),
+		Source:      g.cfg.Source,
+	}
+	if sh.seriesID != 0 {
+		p.Series = &models.Series{
+			ID:          sh.seriesID,
+			Episode:     episode,
+			LastEpisode: sh.lastEpisode,
+			Name:        sh.title,
+			Pattern:     sh.pattern,
+		}
+		if rerun {
+			p.Series.Repeat = 1
+		}
+	}
+	return p
+}
+
+// aribMarks は番組名に付く記号の、外字（角括弧）表記と Unicode の囲み文字
+var aribMarks = map[string][2]string{
+	"字": {"[字]", "🈑"},
+	"デ": {"[デ]", "🈓"},
+	"二": {"[二]", "🈔"},
+	"解": {"[解]", "🈖"},
+	"再": {"[再]", "🈞"},
+	"新": {"[新]", "🈟"},
+	"終": {"[終]", "🈡"},
+	"生": {"[生]", "🈢"},
+}
+
+// mark は番組名に付ける記号を返す。Mirakurun と同じく大半は囲み文字にする
+func (g *generator) mark(name string) string {
+	forms := aribMarks[name]
+	if g.rand.Intn(4) == 0 {
+		return forms[0]
+	}
+	return forms[1]
+}
+
+// description は200〜400文字程度の番組説明を作る
+func (g *generator) description(sh *show) string {
+	sentences := descriptions[sh.kind]
+	var b strings.Builder
+	// 同じ文が続かないよう、並べ替えた文を順に使う
+	order := g.rand.Perm(len(sentences))
+	for i := 0; b.Len() < 600+g.rand.Intn(600); i++ { // UTF-8 で1文字3バイト
+		b.WriteString(sentences[order[i%len(order)]])
+	}
+	b.WriteString("\n【出演】")
+	b.WriteString(strings.Join(sh.cast, "，"))
+	return b.String()
+}
+
+// video は Mirakurun と同じ形式で映像コンポーネントを返す（CS はSD画質の局を混ぜる）
+func video(service *models.Service) *models.ProgramVideo {
+	if service.ChannelType == "CS" && service.ServiceID%2 == 1 {
+		return &models.ProgramVideo{Type: "mpeg2", Resolution: "480i", StreamContent: 1, ComponentType: 0x01}
+	}
+	return &models.ProgramVideo{Type: "mpeg2", Resolution: "1080i", StreamContent: 1, ComponentType: 0xB3}
+}
+
+// audios は音声コンポーネントを返す。二か国語の番組は英語の副音声を付け、ドラマの最終回は5.1chにする
+func audios(sh *show, episode int, bilingual bool) []models.ProgramAudio {
+	main := models.ProgramAudio{ComponentType: 0x03, ComponentTag: 0x10, IsMain: true, SamplingRate: 48000, Langs: []string{"jpn"}}
+	if sh.kind == "drama" && episode == sh.lastEpisode {
+		main.ComponentType = 0x09
+	}
+	if bilingual {
+		return []models.ProgramAudio{main, {ComponentType: 0x03, ComponentTag: 0x11, SamplingRate: 48000, Langs: []string{"eng"}}}
+	}
+	return []models.ProgramAudio{main}
+}
+
+// extended は Mirakurun の拡張情報と同じ形式で出演者を返す
+func (g *generator) extended(sh *show) map[string]string {
+	key := "出演者"
+	if sh.kind == "anime" {
+		key = "声の出演"
+	}
+	return map[string]string{key: strings.Join(sh.cast, "，")}
+}
+
+var titlePrefixes = map[string][]string{
+	"news":    {"ＮＥＷＳ", "ニュース", "首都圏", "列島", "おはよう", "イブニング"},
+	"wide":    {"ひるまえ", "情報ライブ", "スッキリ", "ワイド", "グッド", "ＺＩＰ"},
+	"drama":   {"相棒", "科捜研の", "ドクター", "月曜ドラマ", "日曜劇場", "連続テレビ小説"},
+	"variety": {"世界の果てまで", "ぶらり", "出没！", "笑って", "クイズ！", "有吉の"},
+	"anime":   {"魔法少女", "機動戦士", "異世界", "とある", "ソードアート・", "銀河"},
+}
+
+var titleSuffixes = map[string][]string{
+	"news":    {"７", "９", "ウオッチ", "ｅｖｅｒｙ．", "プラス", "ステーション"},
+	"wide":    {"！", "ＴＯＤＡＹ", "ひるおび", "ワイドショー", "モーニング", "サタデー"},
+	"drama":   {"女", "刑事", "Ｘ", "ガイア", "・スペシャル", "物語"},
+	"variety": {"イッテＱ！", "途中下車の旅", "アド街ック天国", "いいとも", "ミリオネア", "壁"},
+	"anime":   {"まどか☆マギカ", "ガンダム", "食堂", "科学の超電磁砲", "オンライン", "鉄道の夜"},
+}
+
+var subtitleWords = []string{
+	"始まりの日", "消えた手紙", "約束の場所", "嘘と真実", "雨の日の決断", "最後の選択",
+	"遠い記憶", "新たな仲間", "裏切りの夜", "再会", "夏の終わりに", "未来への扉",
+}
+
+var varietyTopics = []string{"絶景温泉ＳＰ", "話題のグルメ大集合", "最新家電ランキング", "春の２時間ＳＰ", "ご当地パン選手権"}
+
+var familyNames = []string{"佐藤", "鈴木", "高橋", "田中", "伊藤", "渡辺", "山本", "中村", "小林", "加藤", "吉田", "山田"}
+
+var givenNames = []string{"翔太", "美咲", "大輔", "陽菜", "健一", "さくら", "拓也", "彩", "誠", "結衣", "直樹", "真央"}
+
+var descriptions = map[string][]string{
+	"news": {
+		"最新のニュースをお伝えします。",
+		"国内外の動きをわかりやすく解説します。",
+		"各地の気象情報と交通情報もお届けします。",
+		"経済の最新動向を専門家とともに読み解きます。",
+		"スポーツの結果とハイライトをまとめてお伝えします。",
+	},
+	"wide": {
+		"話題のニュースから暮らしに役立つ情報まで、生放送でお届けします。",
+		"今日の特集は季節の食材を使った簡単レシピ。",
+		"街の人の声を集めた人気コーナーも。",
+		"芸能ニュースや最新トレンドを紹介します。",
+		"視聴者からの質問に専門家が答えます。",
+	},
+	"drama": {
+		"捜査一課の刑事たちが、ある事件の真相に迫る。",
+		"一通の手紙をきっかけに、主人公は故郷へ戻ることを決意する。",
+		"しかし、そこには思いもよらない過去が隠されていた。",
+		"病院に運び込まれた患者を前に、医師たちは難しい判断を迫られる。",
+		"家族の絆と、それぞれの選択を描く感動のヒューマンドラマ。",
+		"すれ違う二人の思いは、やがて大きな決断へとつながっていく。",
+	},
+	"variety": {
+		"人気芸人たちが体を張った企画に挑戦！",
+		"日本全国の知られざる名所をめぐる旅。",
+		"スタジオには豪華ゲストが集結。",
+		"視聴者から寄せられた驚きの映像を一挙公開。",
+		"意外な結末に出演者も思わず絶句！？",
+	},
+	"anime": {
+		"平凡な高校生だった主人公は、ある日不思議な力に目覚める。",
+		"仲間とともに世界を救う旅に出た一行の前に、新たな敵が立ちはだかる。",
+		"失われた記憶の手がかりを求め、少女は遠い街を目指す。",
+		"激しい戦いの中で、主人公は自分の本当の気持ちに気づく。",
+		"原作の人気エピソードをアニメ化。",
+	},
+}
@@ -0,0 +1,109 @@
+// testing/epggen/generator_test.go
+package epggen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestGenerate(t *testing.T) {
+	start := time.Date(2024, 4, 1, 5, 0, 0, 0, JST)
+	data := Generate(Config{Services: 20, Days: 7, Start: start, Source: "demo"})
+
+	if len(data.Services) != 20 {
+		t.Fatalf("Expected 20 services, got %d", len(data.Services))
+	}
+	types := make(map[string]int)
+	for _, s := range data.Services {
+		types[s.ChannelType]++
+		if s.ID != s.Key().MirakurunServiceID() || s.Source != "demo" {
+			t.Errorf("Unexpected service: %+v", s)
+		}
+	}
+	if types["GR"] == 0 || types["BS"] == 0 || types["CS"] == 0 {
+		t.Errorf("Expected GR, BS and CS services, got %v", types)
+	}
+
+	ids := make(map[int64]bool)
+	lastEnd := make(map[int64]int64)
+	var reruns, gaiji, series int
+	for _, p := range data.Programs {
+		if ids[p.ID] {
+			t.Fatalf("Duplicate program ID %d", p.ID)
+		}
+		ids[p.ID] = true
+
+		// 同じサービスの番組は隙間なく並ぶ
+		if end, ok := lastEnd[p.ServiceID]; ok && end != p.StartAt {
+			t.Fatalf("Program %d (%s) starts at %d, previous program ends at %d", p.ID, p.Name, p.StartAt, end)
+		}
+		lastEnd[p.ServiceID] = p.StartAt + p.Duration
+
+		if p.StartAt < start.UnixMilli() || p.StartAt >= start.AddDate(0, 0, 7).UnixMilli() {
+			t.Errorf("Program %d starts out of range: %d", p.ID, p.StartAt)
+		}
+		if n := utf8.RuneCountInString(p.Description); n < 200 {
+			t.Errorf("Program %d has short description (%d chars)", p.ID, n)
+		}
+		if len(p.Extended) != 1 {
+			t.Errorf("Program %d has no cast in extended: %v", p.ID, p.Extended)
+		}
+		if p.Video == nil || len(p.Audios) == 0 {
+			t.Errorf("Program %d has no video or audio components", p.ID)
+		}
+		if p.Series != nil {
+			series++
+			if p.Series.Repeat > 0 {
+				reruns++
+			}
+		}
+		if strings.ContainsAny(p.Name, "🈑🈓🈞🈟🈡") || strings.Contains(p.Name, "[字]") {
+			gaiji++
+		}
+	}
+	if len(data.Programs) < 20*7*30 {
+		t.Errorf("Expected a week of programs, got %d", len(data.Programs))
+	}
+	if series == 0 || reruns == 0 || gaiji == 0 {
+		t.Errorf("Expected series, reruns and ARIB marks, got series=%d reruns=%d gaiji=%d", series, reruns, gaiji)
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	cfg := Config{Services: 3, Days: 2, Start: time.Date(2024, 4, 1, 5, 0, 0, 0, JST), Seed: 42}
+	a, b := Generate(cfg), Generate(cfg)
+	if !reflect.DeepEqual(a, b) {
+		t.Error("Expected the same data for the same config")
+	}
+
+	cfg.Seed = 43
+	if c := Generate(cfg); reflect.DeepEqual(a.Programs, c.Programs) {
+		t.Error("Expected different programs for a different seed")
+	}
+}
+
+func TestGenerateSeriesEpisodes(t *testing.T) {
+	data := Generate(Config{Services: 1, Days: 14, Start: time.Date(2024, 4, 1, 5, 0, 0, 0, JST)})
+
+	// 初回放送の話数はシリーズごとに増えていく
+	episodes := make(map[int][]int)
+	for _, p := range data.Programs {
+		if p.Series != nil && p.Series.Repeat == 0 && p.Series.Pattern == 2 {
+			episodes[p.Series.ID] = append(episodes[p.Series.ID], p.Series.Episode)
+		}
+	}
+	if len(episodes) == 0 {
+		t.Fatal("Expected weekly series")
+	}
+	for id, eps := range episodes {
+		for i := 1; i < len(eps); i++ {
+			if eps[i] != eps[i-1]+1 && eps[i] != 1 {
+				t.Errorf("Series %d episodes are not sequential: %v", id, eps)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// testing/fakemirakurun/cmd/fakemirakurun/main.go
+//
+// fakemirakurun はローカル開発用の Mirakurun の代わりになるサーバー
+//
+//	go run ./testing/fakemirakurun/cmd/fakemirakurun -addr :40772 -fixture fixture.json
+//
+// -fixture を省略した場合は fakemirakurun.DefaultFixture を使う
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fuba/iepg-server/models"
+	"github.com/fuba/iepg-server/testing/fakemirakurun"
+)
+
+func main() {
+	addr := flag.String("addr", ":40772", "listen address")
+	fixturePath := flag.String("fixture", "", "fixture JSON file (default: built-in fixture)")
+	logLevel := flag.String("log-level", "info", "log level (debug/info/warn/error)")
+	flag.Parse()
+	models.InitLogger(*logLevel)
+
+	fixture := fakemirakurun.DefaultFixture()
+	if *fixturePath != "" {
+		loaded, err := fakemirakurun.LoadFixtureFile(*fixturePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fixture = loaded
+	}
+
+	fake := fakemirakurun.New()
+	fake.LoadFixture(fixture)
+	models.Log.Info("Loaded %d services and %d programs", len(fixture.Services), len(fixture.Programs))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 台本のイベントを配信する
+	go func() {
+		if err := fake.RunScript(ctx, fixture); err != nil && ctx.Err() == nil {
+			models.Log.Error("Script failed: %v", err)
+		} else if ctx.Err() == nil {
+			models.Log.Info("Script finished (%d steps)", len(fixture.Script))
+		}
+	}()
+
+	server := &http.Server{Addr: *addr, Handler: fake}
+	go func() {
+		<-ctx.Done()
+		fake.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	models.Log.Info("Fake Mirakurun listening on %s (API: http://localhost%s/api)", *addr, *addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
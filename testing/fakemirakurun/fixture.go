@@ -0,0 +1,169 @@
+// testing/fakemirakurun/fixture.go
+package fakemirakurun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Fixture はサーバーに読み込むサービス・番組・チューナーと、配信するイベントの台本
+type Fixture struct {
+	// RelativeTimes が true の場合、番組の startAt と台本の番組の startAt を
+	// 読み込んだ時刻からのミリ秒として扱う（いつ起動しても番組表が現在付近になる）
+	RelativeTimes bool         `json:"relativeTimes,omitempty"`
+	Services      []Service    `json:"services"`
+	Programs      []Program    `json:"programs"`
+	Tuners        []Tuner      `json:"tuners,omitempty"`
+	Script        []ScriptStep `json:"script,omitempty"`
+}
+
+// ScriptStep は台本の1ステップ。Delay 待ってから番組・サービスを変更してイベントを配信する
+//
+//	{"delay": "2s", "resource": "program", "type": "update", "data": {...}}
+//
+// remove の data は番組の場合 {"id": ...}、サービスの場合 {"networkId": ..., "serviceId": ...}
+type ScriptStep struct {
+	Delay    string          `json:"delay,omitempty"` // 前のステップからの待ち時間（"500ms"、"2s" など）
+	Resource string          `json:"resource"`        // program / service
+	Type     string          `json:"type"`            // create / update / remove
+	Data     json.RawMessage `json:"data"`
+}
+
+// LoadFixtureFile は JSON ファイルからフィクスチャを読み込む
+func LoadFixtureFile(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// LoadFixture はフィクスチャのサービス・番組・チューナーをサーバーに追加する
+// 台本は RunScript で実行する
+func (s *Server) LoadFixture(f *Fixture) {
+	base := time.Now().UnixMilli()
+	if len(f.Tuners) > 0 {
+		s.SetTuners(f.Tuners)
+	}
+	for _, service := range f.Services {
+		s.AddService(service)
+	}
+	for _, p := range f.Programs {
+		if f.RelativeTimes {
+			p.StartAt += base
+		}
+		s.AddProgram(p)
+	}
+}
+
+// RunScript はフィクスチャの台本を順に実行する。ctx が終了した場合は途中で止める
+func (s *Server) RunScript(ctx context.Context, f *Fixture) error {
+	base := time.Now().UnixMilli()
+	for i, step := range f.Script {
+		if step.Delay != "" {
+			delay, err := time.ParseDuration(step.Delay)
+			if err != nil {
+				return fmt.Errorf("script step %d: invalid delay %q", i, step.Delay)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		if err := s.applyStep(step, f.RelativeTimes, base); err != nil {
+			return fmt.Errorf("script step %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// applyStep は台本の1ステップを実行する
+func (s *Server) applyStep(step ScriptStep, relativeTimes bool, base int64) error {
+	switch step.Resource + "/" + step.Type {
+	case "program/create", "program/update":
+		var p Program
+		if err := json.Unmarshal(step.Data, &p); err != nil {
+			return err
+		}
+		if relativeTimes {
+			p.StartAt += base
+		}
+		s.AddProgram(p)
+	case "program/remove":
+		var p struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(step.Data, &p); err != nil {
+			return err
+		}
+		s.RemoveProgram(p.ID)
+	case "service/create", "service/update":
+		var service Service
+		if err := json.Unmarshal(step.Data, &service); err != nil {
+			return err
+		}
+		s.AddService(service)
+	case "service/remove":
+		var service Service
+		if err := json.Unmarshal(step.Data, &service); err != nil {
+			return err
+		}
+		s.RemoveService(service.NetworkID, service.ServiceID)
+	default:
+		return fmt.Errorf("unsupported step %s/%s", step.Resource, step.Type)
+	}
+	return nil
+}
+
+// DefaultFixture は地上波2局・BS1局と、各局の現在から6時間分の番組を持つフィクスチャを返す
+// 台本では1分後に番組の更新、2分後に番組の削除を配信する
+func DefaultFixture() *Fixture {
+	f := &Fixture{
+		RelativeTimes: true,
+		Services: []Service{
+			{ServiceID: 1024, NetworkID: 32736, Name: "テスト総合", Type: 1, RemoteControlKeyID: 1, Channel: &Channel{Type: "GR", Channel: "27"}},
+			{ServiceID: 1032, NetworkID: 32737, Name: "テスト教育", Type: 1, RemoteControlKeyID: 2, Channel: &Channel{Type: "GR", Channel: "26"}},
+			{ServiceID: 101, NetworkID: 4, Name: "テストBS", Type: 1, RemoteControlKeyID: 1, Channel: &Channel{Type: "BS", Channel: "BS15_0"}},
+		},
+	}
+
+	// 30分番組を、現在放送中の番組から並べる
+	const slot = int64(30 * time.Minute / time.Millisecond)
+	now := time.Now().UnixMilli()
+	first := now/slot*slot - now
+	titles := []string{"ニュース", "天気予報", "ドラマ「テスト」", "アニメ テスト", "映画 テスト", "ドキュメンタリー"}
+	for _, service := range f.Services {
+		for i := 0; i < 12; i++ {
+			f.Programs = append(f.Programs, Program{
+				EventID:     int64(i + 1),
+				ServiceID:   service.ServiceID,
+				NetworkID:   service.NetworkID,
+				StartAt:     first + int64(i)*slot,
+				Duration:    slot,
+				IsFree:      true,
+				Name:        fmt.Sprintf("%s #%d", titles[i%len(titles)], i+1),
+				Description: service.Name + "の番組です",
+			})
+		}
+	}
+
+	updated, _ := json.Marshal(Program{
+		EventID: 2, ServiceID: 1024, NetworkID: 32736,
+		StartAt: first + slot, Duration: slot, IsFree: true,
+		Name: "天気予報 #2（内容変更）", Description: "番組内容が変更されました",
+	})
+	removed, _ := json.Marshal(map[string]int64{"id": ProgramItemID(32736, 1024, 12)})
+	f.Script = []ScriptStep{
+		{Delay: "1m", Resource: "program", Type: "update", Data: updated},
+		{Delay: "1m", Resource: "program", Type: "remove", Data: removed},
+	}
+	return f
+}
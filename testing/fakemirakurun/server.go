@@ -0,0 +1,570 @@
+// testing/fakemirakurun/server.go
+package fakemirakurun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxHistory は /api/events で返すイベントの数
+	maxHistory = 100
+	// subscriberBuffer はイベントストリームの購読者ごとに溜めておけるイベントの数
+	// 溢れた購読者は切断する（読み込みが遅いクライアントで他の購読者を止めないため）
+	subscriberBuffer = 1024
+	// tsPacketSize は MPEG-TS のパケットの大きさ
+	tsPacketSize = 188
+)
+
+// subscriber は /api/events/stream の購読者
+type subscriber struct {
+	resource  string
+	eventType string
+	ch        chan Event
+}
+
+// Server は Mirakurun の API を模倣する HTTP サーバー
+// 番組・サービス・チューナーを保持し、変更はイベントストリームの購読者に配信する
+// httptest.NewServer(fakemirakurun.New()) のように http.Handler として使う
+type Server struct {
+	mu          sync.Mutex
+	programs    map[int64]*Program
+	services    map[int64]*Service
+	logos       map[int64][]byte
+	tuners      []*Tuner
+	history     []Event
+	subscribers map[*subscriber]struct{}
+	unavailable bool
+	done        chan struct{}
+	closed      bool
+
+	router *mux.Router
+}
+
+// New は番組・サービスが空で、地上波・BS/CS のチューナーを2つずつ持つサーバーを作成する
+func New() *Server {
+	s := &Server{
+		programs:    make(map[int64]*Program),
+		services:    make(map[int64]*Service),
+		logos:       make(map[int64][]byte),
+		subscribers: make(map[*subscriber]struct{}),
+		done:        make(chan struct{}),
+	}
+	s.SetTuners([]Tuner{
+		{Name: "PX-GR0", Types: []string{"GR"}},
+		{Name: "PX-GR1", Types: []string{"GR"}},
+		{Name: "PX-S0", Types: []string{"BS", "CS"}},
+		{Name: "PX-S1", Types: []string{"BS", "CS"}},
+	})
+
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(s.availability)
+	api.HandleFunc("/programs", s.handleGetPrograms).Methods("GET")
+	api.HandleFunc("/programs/{id:[0-9]+}", s.handleGetProgram).Methods("GET")
+	api.HandleFunc("/programs/{id:[0-9]+}/stream", s.handleProgramStream).Methods("GET")
+	api.HandleFunc("/services", s.handleGetServices).Methods("GET")
+	api.HandleFunc("/services/{id:[0-9]+}", s.handleGetService).Methods("GET")
+	api.HandleFunc("/services/{id:[0-9]+}/logo", s.handleGetServiceLogo).Methods("GET")
+	api.HandleFunc("/services/{id:[0-9]+}/stream", s.handleServiceStream).Methods("GET")
+	api.HandleFunc("/channels/{type}/{channel}/stream", s.handleChannelStream).Methods("GET")
+	api.HandleFunc("/tuners", s.handleGetTuners).Methods("GET")
+	api.HandleFunc("/events", s.handleGetEvents).Methods("GET")
+	api.HandleFunc("/events/stream", s.handleEventStream).Methods("GET")
+	s.router = r
+	return s
+}
+
+// ServeHTTP は http.Handler を実装する
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Close はイベントストリームと TS ストリームをすべて終了する
+// httptest.Server の Close は応答中のリクエストを待つので、その前に呼ぶ
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	for sub := range s.subscribers {
+		close(sub.ch)
+		delete(s.subscribers, sub)
+	}
+}
+
+// SetUnavailable を true にすると、すべての API が 503 を返す（接続障害の再現用）
+func (s *Server) SetUnavailable(unavailable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unavailable = unavailable
+}
+
+func (s *Server) availability(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		unavailable := s.unavailable
+		s.mu.Unlock()
+		if unavailable {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AddService はサービスを追加または更新し、create / update イベントを配信する
+// ID が0の場合は networkId と serviceId から設定する
+func (s *Server) AddService(service Service) {
+	if service.ID == 0 {
+		service.ID = ServiceItemID(service.NetworkID, service.ServiceID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	eventType := "create"
+	if _, ok := s.services[service.ID]; ok {
+		eventType = "update"
+	}
+	s.services[service.ID] = &service
+	s.emitLocked(Event{Resource: "service", Type: eventType, Data: service})
+}
+
+// RemoveService はサービスを削除し、remove イベントを配信する
+func (s *Server) RemoveService(networkID, serviceID int64) {
+	id := ServiceItemID(networkID, serviceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	service, ok := s.services[id]
+	if !ok {
+		return
+	}
+	delete(s.services, id)
+	delete(s.logos, id)
+	s.emitLocked(Event{Resource: "service", Type: "remove", Data: *service})
+}
+
+// SetLogo はサービスのロゴ画像を設定する
+func (s *Server) SetLogo(networkID, serviceID int64, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logos[ServiceItemID(networkID, serviceID)] = data
+}
+
+// AddProgram は番組を追加または更新し、create / update イベントを配信する
+// ID が0の場合は networkId・serviceId・eventId から設定する
+func (s *Server) AddProgram(p Program) {
+	if p.ID == 0 {
+		p.ID = ProgramItemID(p.NetworkID, p.ServiceID, p.EventID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	eventType := "create"
+	if _, ok := s.programs[p.ID]; ok {
+		eventType = "update"
+	}
+	s.programs[p.ID] = &p
+	s.emitLocked(Event{Resource: "program", Type: eventType, Data: p})
+}
+
+// RemoveProgram は番組を削除し、remove イベントを配信する
+func (s *Server) RemoveProgram(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.programs[id]; !ok {
+		return
+	}
+	delete(s.programs, id)
+	s.emitLocked(Event{Resource: "program", Type: "remove", Data: map[string]int64{"id": id}})
+}
+
+// Emit は番組・サービスを変更せずにイベントだけを配信する（不正なイベントの再現用）
+func (s *Server) Emit(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emitLocked(e)
+}
+
+// SetTuners はチューナーの一覧を置き換える
+func (s *Server) SetTuners(tuners []Tuner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tuners = make([]*Tuner, len(tuners))
+	for i := range tuners {
+		t := tuners[i]
+		t.Index = i
+		if t.Command == "" {
+			t.Command = "fake-tuner"
+		}
+		t.IsAvailable = true
+		t.IsFree = len(t.Users) == 0
+		t.IsUsing = len(t.Users) > 0
+		s.tuners[i] = &t
+	}
+}
+
+// Programs は保持している番組を ID 順に返す
+func (s *Server) Programs() []Program {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.programsLocked(func(*Program) bool { return true })
+}
+
+// Services は保持しているサービスを ID 順に返す
+func (s *Server) Services() []Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	services := make([]Service, 0, len(s.services))
+	for _, service := range s.services {
+		services = append(services, *service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+	return services
+}
+
+// Tuners はチューナーの状態を返す
+func (s *Server) Tuners() []Tuner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tuners := make([]Tuner, len(s.tuners))
+	for i, t := range s.tuners {
+		tuners[i] = *t
+		tuners[i].Users = append([]TunerUser{}, t.Users...)
+	}
+	return tuners
+}
+
+// Subscribers は resource（空の場合はすべて）のイベントを購読しているクライアントの数を返す
+func (s *Server) Subscribers(resource string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for sub := range s.subscribers {
+		if resource == "" || sub.resource == "" || sub.resource == resource {
+			n++
+		}
+	}
+	return n
+}
+
+// WaitForSubscribers は resource のイベントの購読者が n 以上になるまで待つ
+// 購読前に配信したイベントは届かないので、テストではイベントを送る前に呼ぶ
+func (s *Server) WaitForSubscribers(ctx context.Context, resource string, n int) error {
+	for s.Subscribers(resource) < n {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d %s subscribers: %w", n, resource, ctx.Err())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// emitLocked はイベントを履歴に追加し、購読者に配信する。s.mu を保持して呼ぶ
+func (s *Server) emitLocked(e Event) {
+	if e.Time == 0 {
+		e.Time = time.Now().UnixMilli()
+	}
+	s.history = append(s.history, e)
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+	}
+	for sub := range s.subscribers {
+		if (sub.resource != "" && sub.resource != e.Resource) || (sub.eventType != "" && sub.eventType != e.Type) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			close(sub.ch)
+			delete(s.subscribers, sub)
+		}
+	}
+}
+
+func (s *Server) programsLocked(match func(*Program) bool) []Program {
+	programs := make([]Program, 0, len(s.programs))
+	for _, p := range s.programs {
+		if match(p) {
+			programs = append(programs, *p)
+		}
+	}
+	sort.Slice(programs, func(i, j int) bool { return programs[i].ID < programs[j].ID })
+	return programs
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func pathID(r *http.Request) int64 {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	return id
+}
+
+// queryInt は数値のクエリパラメータを返す。指定されていない場合は ok が false になる
+func queryInt(r *http.Request, name string) (value int64, ok bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
+
+func (s *Server) handleGetPrograms(w http.ResponseWriter, r *http.Request) {
+	networkID, hasNetwork := queryInt(r, "networkId")
+	serviceID, hasService := queryInt(r, "serviceId")
+	eventID, hasEvent := queryInt(r, "eventId")
+
+	s.mu.Lock()
+	programs := s.programsLocked(func(p *Program) bool {
+		return (!hasNetwork || p.NetworkID == networkID) &&
+			(!hasService || p.ServiceID == serviceID) &&
+			(!hasEvent || p.EventID == eventID)
+	})
+	s.mu.Unlock()
+	writeJSON(w, programs)
+}
+
+func (s *Server) handleGetProgram(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	p, ok := s.programs[pathID(r)]
+	var program Program
+	if ok {
+		program = *p
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "program not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, program)
+}
+
+func (s *Server) handleGetServices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Services())
+}
+
+func (s *Server) handleGetService(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	service, ok := s.services[pathID(r)]
+	var found Service
+	if ok {
+		found = *service
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "service not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, found)
+}
+
+func (s *Server) handleGetServiceLogo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	logo, ok := s.logos[pathID(r)]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "logo not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(logo)
+}
+
+func (s *Server) handleGetTuners(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Tuners())
+}
+
+func (s *Server) handleGetEvents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	events := append([]Event{}, s.history...)
+	s.mu.Unlock()
+	writeJSON(w, events)
+}
+
+// handleEventStream は Mirakurun と同じく、閉じない JSON 配列としてイベントを配信する
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	sub := &subscriber{
+		resource:  r.URL.Query().Get("resource"),
+		eventType: r.URL.Query().Get("type"),
+		ch:        make(chan Event, subscriberBuffer),
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		http.Error(w, "server closed", http.StatusServiceUnavailable)
+		return
+	}
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.subscribers[sub]; ok {
+			close(sub.ch)
+			delete(s.subscribers, sub)
+		}
+		s.mu.Unlock()
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "[\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	first := true
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.ch:
+			if !ok {
+				// サーバーの終了または読み込みが遅れた購読者の切断
+				io.WriteString(w, "]\n")
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if !first {
+				io.WriteString(w, ",")
+			}
+			first = false
+			w.Write(data)
+			io.WriteString(w, "\n")
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func (s *Server) handleProgramStream(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	p, ok := s.programs[pathID(r)]
+	var service *Service
+	if ok {
+		service = s.services[ServiceItemID(p.NetworkID, p.ServiceID)]
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "program not found", http.StatusNotFound)
+		return
+	}
+	s.serveTS(w, r, channelTypeOf(service))
+}
+
+func (s *Server) handleServiceStream(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	service, ok := s.services[pathID(r)]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "service not found", http.StatusNotFound)
+		return
+	}
+	s.serveTS(w, r, channelTypeOf(service))
+}
+
+func (s *Server) handleChannelStream(w http.ResponseWriter, r *http.Request) {
+	s.serveTS(w, r, mux.Vars(r)["type"])
+}
+
+func channelTypeOf(service *Service) string {
+	if service == nil || service.Channel == nil {
+		return "GR"
+	}
+	return service.Channel.Type
+}
+
+// serveTS は空いているチューナーを使用中にして、クライアントが切断するまで NULL パケットの TS を送る
+func (s *Server) serveTS(w http.ResponseWriter, r *http.Request, channelType string) {
+	priority, _ := strconv.Atoi(r.Header.Get("X-Mirakurun-Priority"))
+	user := TunerUser{ID: r.RemoteAddr, Priority: priority, Agent: r.UserAgent()}
+	tuner := s.acquireTuner(channelType, user)
+	if tuner == nil {
+		http.Error(w, "no available tuner", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.releaseTuner(tuner, user)
+
+	packet := make([]byte, tsPacketSize)
+	packet[0], packet[1], packet[2], packet[3] = 0x47, 0x1F, 0xFF, 0x10
+	for i := 4; i < tsPacketSize; i++ {
+		packet[i] = 0xFF
+	}
+	chunk := make([]byte, 0, tsPacketSize*7)
+	for i := 0; i < 7; i++ {
+		chunk = append(chunk, packet...)
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "video/MP2T")
+	w.WriteHeader(http.StatusOK)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) acquireTuner(channelType string, user TunerUser) *Tuner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tuners {
+		if !t.IsAvailable || t.IsFault || t.IsUsing {
+			continue
+		}
+		for _, typ := range t.Types {
+			if typ == channelType {
+				t.Users = append(t.Users, user)
+				t.IsUsing, t.IsFree = true, false
+				t.PID = 10000 + t.Index
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) releaseTuner(t *Tuner, user TunerUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, u := range t.Users {
+		if u == user {
+			t.Users = append(t.Users[:i], t.Users[i+1:]...)
+			break
+		}
+	}
+	if len(t.Users) == 0 {
+		t.IsUsing, t.IsFree, t.PID = false, true, 0
+	}
+}
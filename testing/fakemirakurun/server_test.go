@@ -0,0 +1,163 @@
+// testing/fakemirakurun/server_test.go
+package fakemirakurun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode %s: %v", url, err)
+	}
+}
+
+func TestServerServesFixture(t *testing.T) {
+	fake := New()
+	fake.LoadFixture(DefaultFixture())
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	defer fake.Close()
+
+	var services []Service
+	getJSON(t, ts.URL+"/api/services", &services)
+	if len(services) != 3 || services[0].ID != ServiceItemID(services[0].NetworkID, services[0].ServiceID) {
+		t.Errorf("Unexpected services: %+v", services)
+	}
+
+	var programs []Program
+	getJSON(t, ts.URL+"/api/programs?networkId=32736&serviceId=1024", &programs)
+	if len(programs) != 12 {
+		t.Fatalf("Expected 12 programs for one service, got %d", len(programs))
+	}
+	// 最初の番組は現在放送中
+	now := time.Now().UnixMilli()
+	if p := programs[0]; p.StartAt > now || p.StartAt+p.Duration <= now {
+		t.Errorf("Expected first program to be on air, got %+v", p)
+	}
+
+	var program Program
+	getJSON(t, fmt.Sprintf("%s/api/programs/%d", ts.URL, ProgramItemID(32736, 1024, 1)), &program)
+	if program.EventID != 1 {
+		t.Errorf("Unexpected program: %+v", program)
+	}
+	if resp, err := http.Get(ts.URL + "/api/programs/1"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown program, got %v (%v)", resp.StatusCode, err)
+	}
+
+	fake.SetUnavailable(true)
+	if resp, err := http.Get(ts.URL + "/api/services"); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while unavailable, got %v (%v)", resp.StatusCode, err)
+	}
+}
+
+func TestServerEventStream(t *testing.T) {
+	fake := New()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	defer fake.Close()
+
+	resp, err := http.Get(ts.URL + "/api/events/stream?resource=program")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := fake.WaitForSubscribers(ctx, "program", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 台本のイベントを配信する（サービスのイベントは購読していないので届かない）
+	created, _ := json.Marshal(Program{EventID: 1, ServiceID: 1024, NetworkID: 32736, StartAt: 1000, Duration: 1000, Name: "before"})
+	updated, _ := json.Marshal(Program{EventID: 1, ServiceID: 1024, NetworkID: 32736, StartAt: 1000, Duration: 1000, Name: "after"})
+	service, _ := json.Marshal(Service{ServiceID: 1024, NetworkID: 32736, Name: "テスト"})
+	removed, _ := json.Marshal(map[string]int64{"id": ProgramItemID(32736, 1024, 1)})
+	f := &Fixture{Script: []ScriptStep{
+		{Resource: "program", Type: "create", Data: created},
+		{Resource: "service", Type: "create", Data: service},
+		{Delay: "1ms", Resource: "program", Type: "update", Data: updated},
+		{Resource: "program", Type: "remove", Data: removed},
+	}}
+	if err := fake.RunScript(ctx, f); err != nil {
+		t.Fatalf("RunScript failed: %v", err)
+	}
+	fake.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	var events []Event
+	if err := json.Unmarshal(body, &events); err != nil {
+		t.Fatalf("Stream is not a JSON array after close: %v\n%s", err, body)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Resource+"/"+e.Type)
+	}
+	if len(types) != 3 || types[0] != "program/create" || types[1] != "program/update" || types[2] != "program/remove" {
+		t.Errorf("Unexpected events: %v", types)
+	}
+	if len(fake.Programs()) != 0 || len(fake.Services()) != 1 {
+		t.Errorf("Expected script to update state, got %d programs and %d services", len(fake.Programs()), len(fake.Services()))
+	}
+
+	if err := fake.RunScript(ctx, &Fixture{Script: []ScriptStep{{Resource: "tuner", Type: "update"}}}); err == nil {
+		t.Error("Expected unsupported step to fail")
+	}
+}
+
+func TestServerTunerStream(t *testing.T) {
+	fake := New()
+	fake.SetTuners([]Tuner{{Name: "gr", Types: []string{"GR"}}})
+	fake.AddService(Service{ServiceID: 1024, NetworkID: 32736, Name: "テスト", Channel: &Channel{Type: "GR", Channel: "27"}})
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	defer fake.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/api/services/3273601024/stream", nil)
+	req.Header.Set("X-Mirakurun-Priority", "2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to open TS stream: %v %v", resp, err)
+	}
+	packet := make([]byte, tsPacketSize)
+	if _, err := io.ReadFull(resp.Body, packet); err != nil || packet[0] != 0x47 {
+		t.Fatalf("Expected TS packet, got %x (%v)", packet[:4], err)
+	}
+
+	tuners := fake.Tuners()
+	if !tuners[0].IsUsing || len(tuners[0].Users) != 1 || tuners[0].Users[0].Priority != 2 {
+		t.Errorf("Expected tuner to be in use, got %+v", tuners[0])
+	}
+	// 空いているチューナーが無い場合は 503
+	if busy, err := http.Get(ts.URL + "/api/channels/GR/27/stream"); err != nil || busy.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without free tuner, got %v (%v)", busy.StatusCode, err)
+	}
+
+	resp.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for fake.Tuners()[0].IsUsing {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected tuner to be released after disconnect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,119 @@
+// testing/fakemirakurun/types.go
+package fakemirakurun
+
+// Mirakurun の API と同じ JSON 形式の型
+// iepg-server の内部の型とは独立させ、実際の Mirakurun の応答に近い形を返す
+
+// Series は番組のシリーズ情報
+type Series struct {
+	ID          int    `json:"id"`
+	Repeat      int    `json:"repeat"`
+	Pattern     int    `json:"pattern"`
+	ExpiresAt   int64  `json:"expiresAt"`
+	Episode     int    `json:"episode"`
+	LastEpisode int    `json:"lastEpisode"`
+	Name        string `json:"name"`
+}
+
+// Genre は番組のジャンル
+type Genre struct {
+	Lv1 int `json:"lv1"`
+	Lv2 int `json:"lv2"`
+	Un1 int `json:"un1"`
+	Un2 int `json:"un2"`
+}
+
+// Video は番組の映像情報
+type Video struct {
+	Type          string `json:"type,omitempty"`       // mpeg2 / h.264 / h.265
+	Resolution    string `json:"resolution,omitempty"` // 480i / 720p / 1080i / 2160p など
+	StreamContent int    `json:"streamContent,omitempty"`
+	ComponentType int    `json:"componentType,omitempty"`
+}
+
+// Audio は番組の音声情報
+type Audio struct {
+	ComponentType int      `json:"componentType"`
+	ComponentTag  int      `json:"componentTag,omitempty"`
+	IsMain        bool     `json:"isMain,omitempty"`
+	SamplingRate  int      `json:"samplingRate,omitempty"`
+	Langs         []string `json:"langs,omitempty"`
+}
+
+// Program は /api/programs が返す番組
+type Program struct {
+	ID          int64             `json:"id"`
+	EventID     int64             `json:"eventId"`
+	ServiceID   int64             `json:"serviceId"`
+	NetworkID   int64             `json:"networkId"`
+	StartAt     int64             `json:"startAt"`
+	Duration    int64             `json:"duration"`
+	IsFree      bool              `json:"isFree"`
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Extended    map[string]string `json:"extended,omitempty"`
+	Genres      []Genre           `json:"genres,omitempty"`
+	Video       *Video            `json:"video,omitempty"`
+	Audios      []Audio           `json:"audios,omitempty"`
+	Series      *Series           `json:"series,omitempty"`
+}
+
+// Channel はサービスのチャンネル情報
+type Channel struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Name    string `json:"name,omitempty"`
+}
+
+// Service は /api/services が返すサービス
+type Service struct {
+	ID                 int64    `json:"id"`
+	ServiceID          int64    `json:"serviceId"`
+	NetworkID          int64    `json:"networkId"`
+	Name               string   `json:"name"`
+	Type               int      `json:"type"`
+	LogoID             int      `json:"logoId,omitempty"`
+	HasLogoData        bool     `json:"hasLogoData,omitempty"`
+	RemoteControlKeyID int      `json:"remoteControlKeyId,omitempty"`
+	Channel            *Channel `json:"channel,omitempty"`
+}
+
+// TunerUser はチューナーを使用しているクライアント
+type TunerUser struct {
+	ID       string `json:"id"`
+	Priority int    `json:"priority"`
+	Agent    string `json:"agent,omitempty"`
+}
+
+// Tuner は /api/tuners が返すチューナー
+type Tuner struct {
+	Index       int         `json:"index"`
+	Name        string      `json:"name"`
+	Types       []string    `json:"types"`
+	Command     string      `json:"command"`
+	PID         int         `json:"pid"`
+	Users       []TunerUser `json:"users"`
+	IsAvailable bool        `json:"isAvailable"`
+	IsRemote    bool        `json:"isRemote"`
+	IsFree      bool        `json:"isFree"`
+	IsUsing     bool        `json:"isUsing"`
+	IsFault     bool        `json:"isFault"`
+}
+
+// Event は /api/events/stream で配信するイベント
+type Event struct {
+	Resource string      `json:"resource"` // program / service / tuner
+	Type     string      `json:"type"`     // create / update / remove
+	Data     interface{} `json:"data"`
+	Time     int64       `json:"time"`
+}
+
+// ServiceItemID は Mirakurun のサービスID（networkId * 100000 + serviceId）を返す
+func ServiceItemID(networkID, serviceID int64) int64 {
+	return networkID*100000 + serviceID
+}
+
+// ProgramItemID は Mirakurun の番組ID（サービスID * 100000 + eventId）を返す
+func ProgramItemID(networkID, serviceID, eventID int64) int64 {
+	return ServiceItemID(networkID, serviceID)*100000 + eventID
+}